@@ -3,12 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
 	"strconv"
 
 	"github.com/avitaltamir/cyphernetes/pkg/core"
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
 	"github.com/avitaltamir/cyphernetes/pkg/provider/apiserver"
 	"github.com/gin-gonic/gin"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -53,16 +55,8 @@ func handleQuery(c *gin.Context) {
 		return
 	}
 
-	// Create the API server provider
-	p, err := apiserver.NewAPIServerProviderWithOptions(&apiserver.APIServerProviderConfig{})
-	if err != nil {
-		fmt.Printf("Provider error: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error creating provider: %v", err)})
-		return
-	}
-
-	// Initialize the executor instance with the provider
-	executor := core.GetQueryExecutorInstance(p)
+	// Reuse the executor created by runWeb, so its provider (and informer
+	// cache, with --cache) is shared across requests
 	if executor == nil {
 		fmt.Printf("Failed to initialize executor\n")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize query executor"})
@@ -77,11 +71,18 @@ func handleQuery(c *gin.Context) {
 		return
 	}
 
-	// Execute the query
-	result, err := executor.Execute(ast, core.Namespace, core.WithDryRun(DryRun))
+	// Execute the query, aborting it if the client disconnects or it runs
+	// past --timeout
+	ctx, cancel := newQueryContext(c.Request.Context())
+	defer cancel()
+	result, err := executor.Execute(ast, core.Namespace, executeOptions(ctx)...)
 	if err != nil {
 		fmt.Printf("Execution error: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error executing query: %v", err)})
+		status := http.StatusInternalServerError
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		c.JSON(status, gin.H{"error": fmt.Sprintf("Error executing query: %v", err)})
 		return
 	}
 
@@ -157,8 +158,10 @@ func handleConvertResourceName(c *gin.Context) {
 		return
 	}
 
-	p, err := apiserver.NewAPIServerProviderWithOptions(&apiserver.APIServerProviderConfig{
-		Context: core.KubeContext,
+	p, err := apiserver.NewAPIServerProviderContext(c.Request.Context(), &apiserver.APIServerProviderConfig{
+		Context:  core.KubeContext,
+		Identity: kubeIdentity(),
+		CacheDir: apiserver.DefaultCacheDir(),
 	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create API server provider"})
@@ -175,7 +178,7 @@ func handleConvertResourceName(c *gin.Context) {
 
 func handleGetContext(c *gin.Context) {
 	// Get the kubeconfig loader
-	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules := kubeIdentity().LoadingRules()
 	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 		rules,
 		&clientcmd.ConfigOverrides{},
@@ -239,7 +242,7 @@ func handleGetNamespaces(c *gin.Context) {
 	}
 
 	// Get the API server provider directly
-	apiProvider, ok := executor.Provider().(*apiserver.APIServerProvider)
+	apiProvider, ok := provider.Base(executor.Provider()).(*apiserver.APIServerProvider)
 	if !ok {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Provider is not an APIServerProvider"})
 		return
@@ -7,7 +7,7 @@ import (
 	"strings"
 
 	"github.com/avitaltamir/cyphernetes/pkg/core"
-	"github.com/avitaltamir/cyphernetes/pkg/provider/apiserver"
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
@@ -179,12 +179,12 @@ func getKindForIdentifier(line string, identifier string) string {
 		if match[1] == identifier {
 			kind := match[2]
 			if executor != nil {
-				apiProvider, ok := executor.Provider().(*apiserver.APIServerProvider)
+				discovery, ok := provider.Base(executor.Provider()).(provider.Discovery)
 				if !ok {
 					return kind
 				}
 
-				cache := apiProvider.GetGVRCacheSnapshot()
+				cache := discovery.GetGVRCacheSnapshot()
 				if gvr, ok := cache[strings.ToLower(kind)]; ok {
 					return findCanonicalKind(cache, gvr)
 				}
@@ -221,13 +221,13 @@ func getResourceKinds(identifier string) []string {
 
 	var kinds []string
 
-	apiProvider, ok := executor.Provider().(*apiserver.APIServerProvider)
+	discovery, ok := provider.Base(executor.Provider()).(provider.Discovery)
 	if !ok {
-		fmt.Printf("Error: provider is not an APIServerProvider\n")
+		fmt.Printf("Error: provider does not support discovery\n")
 		return kinds
 	}
 
-	cache := apiProvider.GetGVRCacheSnapshot()
+	cache := discovery.GetGVRCacheSnapshot()
 
 	for _, gvr := range cache {
 		if strings.HasPrefix(gvr.GroupResource().Resource, identifier) {
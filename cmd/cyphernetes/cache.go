@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+	"github.com/avitaltamir/cyphernetes/pkg/provider/apiserver"
+	"github.com/avitaltamir/cyphernetes/pkg/provider/informer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	useInformerCache  bool
+	cacheMaxStaleness time.Duration
+)
+
+// addCacheFlags registers the informer cache flags of long-running commands.
+func addCacheFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&useInformerCache, "cache", false, "Serve reads from informer caches instead of listing resources on every query")
+	cmd.Flags().DurationVar(&cacheMaxStaleness, "cache-max-staleness", 10*time.Minute, "Re-list cached resources older than this duration (0 to keep them until written to)")
+}
+
+// withInformerCache wraps p in an informer cache when --cache is set.
+func withInformerCache(p provider.Provider) (provider.Provider, error) {
+	if !useInformerCache {
+		return p, nil
+	}
+	apiProvider, ok := p.(*apiserver.APIServerProvider)
+	if !ok {
+		return nil, fmt.Errorf("--cache requires an API server provider, got %T", p)
+	}
+	client, err := apiProvider.GetDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	return informer.NewProvider(p, client, informer.Config{MaxStaleness: cacheMaxStaleness})
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the discovery and schema cache",
+	Long: `Cyphernetes caches each cluster's API discovery, OpenAPI field specs and inferred
+relationships in ~/.cyphernetes/cache. Entries are refreshed automatically when the
+cluster's server version or set of CustomResourceDefinitions changes.`,
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove the cached discovery and schema data of every cluster",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		dir := apiserver.DefaultCacheDir()
+		if dir == "" {
+			return fmt.Errorf("unable to locate the cache directory: unknown home directory")
+		}
+		if err := apiserver.ClearDiskCache(dir); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Cleared %s\n", dir)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+}
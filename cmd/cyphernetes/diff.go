@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/avitaltamir/cyphernetes/pkg/core"
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+	"github.com/avitaltamir/cyphernetes/pkg/provider/apiserver"
+	"github.com/avitaltamir/cyphernetes/pkg/provider/manifest"
+	"github.com/avitaltamir/cyphernetes/pkg/provider/snapshot"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// liveState is the diff argument naming the cluster itself.
+const liveState = "live"
+
+var diffFormat string
+
+// diffState is one side of a diff.
+type diffState struct {
+	provider provider.Provider
+	// kinds are the kinds the state holds. It is nil for the live cluster,
+	// which is compared by the kinds of the other state.
+	kinds []string
+	// namespace is the namespace a snapshot was captured from.
+	namespace string
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <stateA> <stateB|live> [query]",
+	Short: "Show what changed between two states of a cluster",
+	Long: `Compare two states of a cluster and report the resources that were added, removed
+or modified, with the fields that changed.
+
+Each state is a snapshot saved by 'cyphernetes snapshot save', a YAML or JSON dump of
+resources (a file or a directory), or 'live' for the current cluster. Without a query
+every resource the states hold is compared. With a MATCH query only the resources its
+patterns match are compared, and relationships that appeared or disappeared between
+them are reported too.`,
+	Example: `  cyphernetes diff before.json.gz live
+  cyphernetes diff before.json.gz after.json.gz 'MATCH (d:Deployment)->(s:Service)->(p:Pod) RETURN p'`,
+	Args: cobra.RangeArgs(2, 3),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		core.CleanOutput = true
+		if diffFormat != "human" && diffFormat != "json" {
+			return fmt.Errorf("invalid value for --format: must be 'human' or 'json'")
+		}
+		if args[0] == liveState {
+			return fmt.Errorf("the first state must be a file, compare it against 'live' instead")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// The arguments are valid; errors from here on are not usage errors.
+		cmd.SilenceUsage = true
+		before, err := loadDiffState(args[0])
+		if err != nil {
+			return err
+		}
+		after, err := loadDiffState(args[1])
+		if err != nil {
+			return err
+		}
+
+		namespace := core.Namespace
+		if !cmd.Flags().Changed("namespace") && before.namespace != "" {
+			namespace = before.namespace
+		}
+		if core.AllNamespaces {
+			namespace = ""
+		}
+
+		ctx, cancel := newQueryContext(context.Background())
+		defer cancel()
+
+		var diff *core.StateDiff
+		if len(args) == 3 {
+			diff, err = diffQuery(ctx, before, after, args[2], namespace)
+		} else {
+			diff, err = diffKinds(ctx, before, after, namespace, os.Stderr)
+		}
+		if err != nil {
+			return err
+		}
+		return printDiff(os.Stdout, diff, diffFormat)
+	},
+}
+
+// loadDiffState loads a snapshot, a manifest dump or, for "live", the
+// cluster.
+func loadDiffState(arg string) (*diffState, error) {
+	if arg == liveState {
+		p, err := apiserver.NewAPIServerProviderWithOptions(&apiserver.APIServerProviderConfig{
+			QuietMode: true,
+			Context:   core.KubeContext,
+			Identity:  kubeIdentity(),
+			CacheDir:  apiserver.DefaultCacheDir(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating provider: %w", err)
+		}
+		return &diffState{provider: p}, nil
+	}
+
+	info, err := os.Stat(arg)
+	if err != nil {
+		return nil, err
+	}
+	var snapshotErr error
+	if !info.IsDir() {
+		s, err := snapshot.Load(arg)
+		if err == nil {
+			state := &diffState{provider: snapshot.NewProvider(s), namespace: s.Namespace}
+			for _, list := range s.Resources {
+				state.kinds = append(state.kinds, diffKindName(list.GVR))
+			}
+			return state, nil
+		}
+		snapshotErr = err
+	}
+
+	p, err := manifest.Load(arg, manifest.Config{Namespace: core.Namespace})
+	if err != nil {
+		if snapshotErr != nil {
+			return nil, fmt.Errorf("%s is neither a snapshot (%v) nor a resource dump (%v)", arg, snapshotErr, err)
+		}
+		return nil, fmt.Errorf("error loading %s: %w", arg, err)
+	}
+	state := &diffState{provider: p}
+	for _, gvr := range p.ResourceTypes() {
+		state.kinds = append(state.kinds, diffKindName(gvr))
+	}
+	return state, nil
+}
+
+// diffKindName names gvr unambiguously: by plural for the core group and
+// by resource.group otherwise.
+func diffKindName(gvr schema.GroupVersionResource) string {
+	if gvr.Group == "" {
+		return gvr.Resource
+	}
+	return gvr.Resource + "." + gvr.Group
+}
+
+// diffQuery compares the resources and relationships query matches in the
+// two states.
+func diffQuery(ctx context.Context, before, after *diffState, query, namespace string) (*core.StateDiff, error) {
+	ast, err := core.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing query: %w", err)
+	}
+	// The shared executor loads relationships and kind data for the first
+	// state; the second state reuses them.
+	beforeExecutor := core.GetQueryExecutorInstance(before.provider)
+	if beforeExecutor == nil {
+		return nil, fmt.Errorf("error creating query executor")
+	}
+	afterExecutor, err := core.NewQueryExecutor(after.provider)
+	if err != nil {
+		return nil, err
+	}
+	return core.DiffQuery(beforeExecutor, afterExecutor, ast, namespace, core.WithContext(ctx))
+}
+
+// diffKinds compares every resource of the kinds either state holds. Kinds
+// one of the states cannot list are reported to warnings and left out.
+func diffKinds(ctx context.Context, before, after *diffState, namespace string, warnings io.Writer) (*core.StateDiff, error) {
+	seen := make(map[string]bool)
+	var kinds []string
+	for _, kind := range append(append([]string{}, before.kinds...), after.kinds...) {
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+
+	var beforeResources, afterResources []map[string]interface{}
+	for _, kind := range kinds {
+		old, err := listDiffKind(ctx, before, kind, namespace)
+		if err != nil {
+			fmt.Fprintf(warnings, "Skipping %s: %v\n", kind, err)
+			continue
+		}
+		current, err := listDiffKind(ctx, after, kind, namespace)
+		if err != nil {
+			fmt.Fprintf(warnings, "Skipping %s: %v\n", kind, err)
+			continue
+		}
+		beforeResources = append(beforeResources, old...)
+		afterResources = append(afterResources, current...)
+	}
+	return &core.StateDiff{Resources: core.DiffResources(beforeResources, afterResources)}, nil
+}
+
+func listDiffKind(ctx context.Context, state *diffState, kind, namespace string) ([]map[string]interface{}, error) {
+	list, err := provider.GetK8sResources(ctx, state.provider, kind, "", "", namespace)
+	if err != nil {
+		return nil, err
+	}
+	resources, ok := list.([]map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("provider returned %T, expected []map[string]interface{}", list)
+	}
+	return resources, nil
+}
+
+// printDiff writes diff as JSON or as a listing with one line per change,
+// prefixed by +, - or ~.
+func printDiff(w io.Writer, diff *core.StateDiff, format string) error {
+	if format == "json" {
+		if diff.Resources == nil {
+			diff.Resources = []core.ResourceDiff{}
+		}
+		if diff.Edges == nil {
+			diff.Edges = []core.EdgeDiff{}
+		}
+		out, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(out))
+		return err
+	}
+
+	if diff.Empty() {
+		_, err := fmt.Fprintln(w, "No differences")
+		return err
+	}
+	for _, resource := range diff.Resources {
+		name := resource.Name
+		if resource.Namespace != "" {
+			name = resource.Namespace + "/" + name
+		}
+		fmt.Fprintf(w, "%s %s %s\n", diffMarker(resource.Change), resource.Kind, name)
+		for _, field := range resource.Fields {
+			switch {
+			case field.Before == nil:
+				fmt.Fprintf(w, "    + %s: %s\n", field.Path, diffValue(field.After))
+			case field.After == nil:
+				fmt.Fprintf(w, "    - %s: %s\n", field.Path, diffValue(field.Before))
+			default:
+				fmt.Fprintf(w, "    ~ %s: %s -> %s\n", field.Path, diffValue(field.Before), diffValue(field.After))
+			}
+		}
+	}
+	if len(diff.Edges) > 0 {
+		if len(diff.Resources) > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, "Relationships:")
+		for _, edge := range diff.Edges {
+			fmt.Fprintf(w, "%s %s -[%s]- %s\n", diffMarker(edge.Change), edge.From, edge.Type, edge.To)
+		}
+	}
+	return nil
+}
+
+func diffMarker(change core.DiffChange) string {
+	switch change {
+	case core.DiffAdded:
+		return "+"
+	case core.DiffRemoved:
+		return "-"
+	}
+	return "~"
+}
+
+func diffValue(v interface{}) string {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringVar(&diffFormat, "format", "human", "Output format (human or json)")
+	diffCmd.Flags().DurationVar(&queryTimeout, "timeout", 0, "Abort the diff after this duration (e.g. 5m, 0 for no timeout)")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avitaltamir/cyphernetes/pkg/core"
+)
+
+const diffBefore = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: web
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: default
+spec:
+  selector:
+    app: web
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: web-1
+  namespace: default
+  labels:
+    app: web
+`
+
+const diffAfter = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: web-v2
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: default
+spec:
+  selector:
+    app: web
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: web-1
+  namespace: default
+  labels:
+    app: web-v2
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web-config
+  namespace: default
+`
+
+func writeDiffStates(t *testing.T) (*diffState, *diffState) {
+	t.Helper()
+	dir := t.TempDir()
+	var states []*diffState
+	for name, content := range map[string]string{"before.yaml": diffBefore, "after.yaml": diffAfter} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"before.yaml", "after.yaml"} {
+		state, err := loadDiffState(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("loadDiffState(%s) error = %v", name, err)
+		}
+		states = append(states, state)
+	}
+	return states[0], states[1]
+}
+
+func TestDiffKinds(t *testing.T) {
+	before, after := writeDiffStates(t)
+
+	var warnings bytes.Buffer
+	diff, err := diffKinds(t.Context(), before, after, "default", &warnings)
+	if err != nil {
+		t.Fatalf("diffKinds() error = %v", err)
+	}
+	if warnings.Len() != 0 {
+		t.Errorf("unexpected warnings: %s", warnings.String())
+	}
+
+	var out bytes.Buffer
+	if err := printDiff(&out, diff, "human"); err != nil {
+		t.Fatalf("printDiff() error = %v", err)
+	}
+	want := `+ ConfigMap default/web-config
+~ Deployment default/web
+    ~ /spec/replicas: 2 -> 3
+    ~ /spec/selector/matchLabels/app: "web" -> "web-v2"
+~ Pod default/web-1
+    ~ /metadata/labels/app: "web" -> "web-v2"
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestDiffQueryReportsRelationships(t *testing.T) {
+	before, after := writeDiffStates(t)
+
+	diff, err := diffQuery(t.Context(), before, after, `MATCH (s:Service)->(p:Pod) RETURN p.metadata.name`, "default")
+	if err != nil {
+		t.Fatalf("diffQuery() error = %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := printDiff(&out, diff, "json"); err != nil {
+		t.Fatalf("printDiff() error = %v", err)
+	}
+	var decoded core.StateDiff
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON output %s: %v", out.String(), err)
+	}
+	if len(decoded.Resources) != 1 || decoded.Resources[0].Kind != "Pod" {
+		t.Errorf("expected only the pod to be compared, got %+v", decoded.Resources)
+	}
+	if len(decoded.Edges) != 1 || decoded.Edges[0].Change != core.DiffRemoved || !strings.Contains(decoded.Edges[0].From+decoded.Edges[0].To, "Pod/web-1") {
+		t.Errorf("expected the service to lose its pod, got %+v", decoded.Edges)
+	}
+}
+
+func TestPrintDiffWithoutChanges(t *testing.T) {
+	var out bytes.Buffer
+	if err := printDiff(&out, &core.StateDiff{}, "human"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "No differences\n" {
+		t.Errorf("got %q", out.String())
+	}
+
+	out.Reset()
+	if err := printDiff(&out, &core.StateDiff{}, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"resources": []`) {
+		t.Errorf("expected empty lists in JSON output, got %s", out.String())
+	}
+}
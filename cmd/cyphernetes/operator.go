@@ -11,6 +11,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/avitaltamir/cyphernetes/pkg/core"
+	"github.com/avitaltamir/cyphernetes/pkg/provider/apiserver"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v2"
 	rbacv1 "k8s.io/api/rbac/v1"
@@ -25,20 +26,14 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 
 	corev1 "k8s.io/api/core/v1"
 )
 
 // buildOperatorKubeConfig loads the kubernetes rest.Config for operator commands,
-// honoring the KUBECONFIG env var and the global --context flag.
+// honoring the KUBECONFIG env var and the global --context and identity flags.
 func buildOperatorKubeConfig() (*rest.Config, error) {
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	overrides := &clientcmd.ConfigOverrides{}
-	if core.KubeContext != "" {
-		overrides.CurrentContext = core.KubeContext
-	}
-	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	return apiserver.BuildRestConfig(core.KubeContext, kubeIdentity())
 }
 
 //go:embed manifests/*.yaml
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/avitaltamir/cyphernetes/pkg/core"
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
 	"github.com/avitaltamir/cyphernetes/pkg/provider/apiserver"
+	"github.com/avitaltamir/cyphernetes/pkg/provider/manifest"
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 	"gopkg.in/yaml.v3"
@@ -18,8 +22,56 @@ var (
 	parseQuery       = core.ParseQuery
 	newQueryExecutor = core.NewQueryExecutor
 	executeMethod    = (*core.QueryExecutor).Execute
+	// queryTimeout bounds the execution of each query; zero means no limit.
+	// Set via the --timeout flag of the query, shell and web commands.
+	queryTimeout time.Duration
+	// fromFiles and manifestOut are set via the --from-files and --out flags
+	// of the query command.
+	fromFiles   string
+	manifestOut string
+	// manifestProvider holds the manifests loaded for --from-files.
+	manifestProvider *manifest.Provider
 )
 
+// newQueryProvider returns the provider queries run against: the manifests
+// loaded for --from-files, the snapshot loaded for --snapshot, or the API
+// server.
+func newQueryProvider() (provider.Provider, error) {
+	if manifestProvider != nil {
+		return manifestProvider, nil
+	}
+	if snapshotProvider != nil {
+		return snapshotProvider, nil
+	}
+	return apiserver.NewAPIServerProviderWithOptions(&apiserver.APIServerProviderConfig{
+		QuietMode:       true,
+		Context:         core.KubeContext,
+		Identity:        kubeIdentity(),
+		CacheDir:        apiserver.DefaultCacheDir(),
+		ServerSideApply: ServerSideApply,
+		ForceConflicts:  ForceConflicts,
+	})
+}
+
+// newQueryContext derives the context a query executes under from parent,
+// bounded by --timeout when it is set.
+func newQueryContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if queryTimeout > 0 {
+		return context.WithTimeout(parent, queryTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// executeOptions returns the options a query executes with under ctx, from
+// the global mutation flags.
+func executeOptions(ctx context.Context) []core.ExecuteOption {
+	opts := []core.ExecuteOption{core.WithDryRun(DryRun), core.WithContext(ctx)}
+	if OptimisticConcurrency {
+		opts = append(opts, core.WithOptimisticConcurrency(ConflictRetries))
+	}
+	return opts
+}
+
 var queryCmd = &cobra.Command{
 	Use:   "query [Cypher-inspired query]",
 	Short: "Execute a Cypher-inspired query against Kubernetes",
@@ -34,14 +86,33 @@ var queryCmd = &cobra.Command{
 		if f != "yaml" && f != "json" {
 			return fmt.Errorf("invalid value for --format: must be 'json' or 'yaml'")
 		}
+		if manifestOut != "" && fromFiles == "" {
+			return fmt.Errorf("--out requires --from-files")
+		}
+		if manifestOut == manifest.Stdin {
+			return fmt.Errorf("--out must be a directory, writing manifests to stdout would mix them with the query results")
+		}
+		if fromFiles != "" && snapshotFile != "" {
+			return fmt.Errorf("--from-files and --snapshot cannot be used together")
+		}
+		if snapshotFile != "" {
+			if err := loadSnapshot(cmd); err != nil {
+				return err
+			}
+		}
 		// Initialize kubernetes before running the command
 		return initializeKubernetes()
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		provider, err := apiserver.NewAPIServerProviderWithOptions(&apiserver.APIServerProviderConfig{
-			QuietMode: true,
-			Context:   core.KubeContext,
-		})
+		if fromFiles != "" {
+			var err error
+			manifestProvider, err = manifest.Load(fromFiles, manifest.Config{Namespace: core.Namespace})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error loading manifests: ", err)
+				os.Exit(1)
+			}
+		}
+		provider, err := newQueryProvider()
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "Error creating provider: ", err)
 			os.Exit(1)
@@ -55,15 +126,19 @@ var queryCmd = &cobra.Command{
 			fmt.Printf("Error initializing resource specs: %v\n", err)
 		}
 		runQuery(args, os.Stdout)
+
+		if manifestOut != "" {
+			if err := manifestProvider.WriteDir(manifestOut); err != nil {
+				fmt.Fprintln(os.Stderr, "Error writing manifests: ", err)
+				os.Exit(1)
+			}
+		}
 	},
 }
 
 func runQuery(args []string, w io.Writer) {
-	// Create the API server provider
-	p, err := apiserver.NewAPIServerProviderWithOptions(&apiserver.APIServerProviderConfig{
-		QuietMode: true,
-		Context:   core.KubeContext,
-	})
+	// Create the API server provider, or the manifest provider for --from-files
+	p, err := newQueryProvider()
 	if err != nil {
 		fmt.Fprintln(w, "Error creating provider: ", err)
 		return
@@ -103,7 +178,9 @@ func runQuery(args []string, w io.Writer) {
 	}
 
 	// Execute the query against the Kubernetes API.
-	results, err := executeMethod(executor, ast, core.Namespace, core.WithDryRun(DryRun))
+	ctx, cancel := newQueryContext(context.Background())
+	defer cancel()
+	results, err := executeMethod(executor, ast, core.Namespace, executeOptions(ctx)...)
 	if err != nil {
 		fmt.Fprintln(w, "Error executing query: ", err)
 		return
@@ -135,4 +212,8 @@ func init() {
 	rootCmd.AddCommand(queryCmd)
 	queryCmd.Flags().StringVar(&core.OutputFormat, "format", "json", "Output format (json or yaml)")
 	queryCmd.PersistentFlags().BoolVarP(&returnRawJsonOutput, "raw-output", "r", false, "Disable JSON output formatting")
+	queryCmd.Flags().DurationVar(&queryTimeout, "timeout", 0, "Abort the query after this duration (e.g. 30s, 0 for no timeout)")
+	queryCmd.Flags().StringVar(&fromFiles, "from-files", "", "Query manifests in a directory or file ('-' for stdin) instead of a cluster")
+	addSnapshotFlag(queryCmd)
+	queryCmd.Flags().StringVar(&manifestOut, "out", "", "Directory to write the manifests to after the query, with its changes applied (requires --from-files)")
 }
@@ -8,12 +8,19 @@ import (
 	"os"
 
 	"github.com/avitaltamir/cyphernetes/pkg/core"
+	"github.com/avitaltamir/cyphernetes/pkg/provider/apiserver"
 	"github.com/spf13/cobra"
 )
 
 var (
-	Version = "dev"
-	DryRun  = false
+	Version         = "dev"
+	DryRun          = false
+	ServerSideApply = false
+	ForceConflicts  = false
+	// OptimisticConcurrency makes SET patches conditional on the
+	// resourceVersion each resource was matched at.
+	OptimisticConcurrency = false
+	ConflictRetries       = 3
 )
 
 func getVersionInfo() string {
@@ -87,9 +94,17 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&core.Namespace, "namespace", "n", "default", "The namespace to query against")
 	rootCmd.PersistentFlags().BoolVarP(&core.AllNamespaces, "all-namespaces", "A", false, "Query all namespaces")
 	rootCmd.PersistentFlags().StringVar(&core.KubeContext, "context", "", "The kubeconfig context to use (defaults to the current context)")
+	rootCmd.PersistentFlags().StringVar(&core.Kubeconfig, "kubeconfig", "", "Path to the kubeconfig file to use (defaults to KUBECONFIG or ~/.kube/config)")
+	rootCmd.PersistentFlags().StringVar(&core.BearerToken, "token", "", "Bearer token to authenticate with instead of the kubeconfig's credentials")
+	rootCmd.PersistentFlags().StringVar(&core.ImpersonateUser, "as", "", "Username to impersonate, such as system:serviceaccount:<namespace>:<name>")
+	rootCmd.PersistentFlags().StringArrayVar(&core.ImpersonateGroups, "as-group", nil, "Group to impersonate, can be repeated")
 	rootCmd.PersistentFlags().BoolVar(&core.NoColor, "no-color", false, "Disable colored output in shell and query results")
 	rootCmd.PersistentFlags().BoolP("version", "v", false, "Show version and exit")
 	rootCmd.PersistentFlags().BoolVar(&DryRun, "dry-run", false, "Enable dry-run mode for all operations")
+	rootCmd.PersistentFlags().BoolVar(&ServerSideApply, "server-side", false, "Send CREATE and SET as server-side apply requests with field manager \"cyphernetes\"")
+	rootCmd.PersistentFlags().BoolVar(&ForceConflicts, "force-conflicts", false, "With --server-side, take ownership of fields managed by others instead of failing")
+	rootCmd.PersistentFlags().BoolVar(&OptimisticConcurrency, "optimistic-concurrency", false, "Only SET resources unchanged since they were matched, re-matching and retrying those that changed")
+	rootCmd.PersistentFlags().IntVar(&ConflictRetries, "conflict-retries", 3, "With --optimistic-concurrency, how often to retry a resource that changed before failing")
 
 	// Add version command
 	rootCmd.AddCommand(&cobra.Command{
@@ -144,9 +159,29 @@ func validateGlobalFlags(cmd *cobra.Command, args []string) error {
 	LogLevel = cmd.Flag("loglevel").Value.String()
 	core.LogLevel = LogLevel
 
+	if ForceConflicts && !ServerSideApply {
+		return fmt.Errorf("--force-conflicts requires --server-side")
+	}
+	if ConflictRetries < 0 {
+		return fmt.Errorf("--conflict-retries must not be negative")
+	}
+	if len(core.ImpersonateGroups) > 0 && core.ImpersonateUser == "" {
+		return fmt.Errorf("--as-group requires --as")
+	}
 	return nil
 }
 
+// kubeIdentity returns the kubeconfig and identity selected by the global
+// --kubeconfig, --token, --as and --as-group flags.
+func kubeIdentity() apiserver.Identity {
+	return apiserver.Identity{
+		Kubeconfig: core.Kubeconfig,
+		Token:      core.BearerToken,
+		As:         core.ImpersonateUser,
+		AsGroups:   core.ImpersonateGroups,
+	}
+}
+
 // initializeKubernetes initializes kubernetes context - call this in commands that need k8s
 func initializeKubernetes() error {
 	// Add any kubernetes initialization code here
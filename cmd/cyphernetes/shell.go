@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"encoding/json"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"os/signal"
@@ -16,6 +18,7 @@ import (
 
 	colorjson "github.com/TylerBrock/colorjson"
 	"github.com/avitaltamir/cyphernetes/pkg/core"
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
 	"github.com/avitaltamir/cyphernetes/pkg/provider/apiserver"
 	cobra "github.com/spf13/cobra"
 	"github.com/wader/readline"
@@ -39,6 +42,17 @@ var ShellCmd = &cobra.Command{
 		if f != "yaml" && f != "json" {
 			return fmt.Errorf("invalid value for --format: must be 'json' or 'yaml'")
 		}
+		if snapshotFile != "" {
+			if useInformerCache {
+				return fmt.Errorf("--cache and --snapshot cannot be used together")
+			}
+			if err := loadSnapshot(cmd); err != nil {
+				return err
+			}
+			ctx = snapshotContextName()
+			return initializeKubernetes()
+		}
+
 		// Get the name of the current Kubernetes context
 		contextName, namespace, err := getCurrentContext()
 		if err != nil {
@@ -56,10 +70,7 @@ var ShellCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		showSplash()
 
-		// Create provider with context config
-		provider, err := apiserver.NewAPIServerProviderWithOptions(&apiserver.APIServerProviderConfig{
-			Context: core.KubeContext,
-		})
+		provider, err := newShellProvider()
 		if err != nil {
 			fmt.Printf("Error creating provider: %v\n", err)
 			return
@@ -74,6 +85,25 @@ var ShellCmd = &cobra.Command{
 	},
 }
 
+// newShellProvider returns the snapshot loaded for --snapshot, or an API
+// server provider for the current context, cached when --cache is set.
+func newShellProvider() (provider.Provider, error) {
+	if snapshotProvider != nil {
+		return snapshotProvider, nil
+	}
+	p, err := apiserver.NewAPIServerProviderWithOptions(&apiserver.APIServerProviderConfig{
+		Context:         core.KubeContext,
+		Identity:        kubeIdentity(),
+		CacheDir:        apiserver.DefaultCacheDir(),
+		ServerSideApply: ServerSideApply,
+		ForceConflicts:  ForceConflicts,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return withInformerCache(p)
+}
+
 var executor *core.QueryExecutor
 var execTime time.Duration
 var completer = &CyphernetesCompleter{}
@@ -134,7 +164,7 @@ func getCurrentContext() (string, string, error) {
 }
 
 func getCurrentContextFromConfig() (string, string, error) {
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules := kubeIdentity().LoadingRules()
 	configOverrides := &clientcmd.ConfigOverrides{}
 	if core.KubeContext != "" {
 		configOverrides.CurrentContext = core.KubeContext
@@ -372,10 +402,8 @@ type Listener interface {
 }
 
 func initAndRunShell(_ *cobra.Command, _ []string) {
-	// Create the API server provider
-	p, err := apiserver.NewAPIServerProviderWithOptions(&apiserver.APIServerProviderConfig{
-		Context: core.KubeContext,
-	})
+	// Create the provider
+	p, err := newShellProvider()
 	if err != nil {
 		fmt.Println("Error creating provider:", err)
 		os.Exit(1)
@@ -389,12 +417,16 @@ func initAndRunShell(_ *cobra.Command, _ []string) {
 	}
 
 	// Get current context
-	currentContext, _, err := getCurrentContext()
-	if err != nil {
-		fmt.Println("Error getting current context:", err)
-		os.Exit(1)
+	if snapshotProvider != nil {
+		ctx = snapshotContextName()
+	} else {
+		currentContext, _, err := getCurrentContext()
+		if err != nil {
+			fmt.Println("Error getting current context:", err)
+			os.Exit(1)
+		}
+		ctx = currentContext
 	}
-	ctx = currentContext
 
 	// Initialize shell environment
 	if core.AllNamespaces {
@@ -634,6 +666,11 @@ func initAndRunShell(_ *cobra.Command, _ []string) {
 			// (see WithDryRun below), so we only need to flip the flag here.
 			DryRun = !DryRun
 			fmt.Printf("Dry-run mode: %t\n\n", DryRun)
+		} else if input == "\\oc" {
+			// Toggle optimistic concurrency for SET, applied per query execution
+			// like dry-run.
+			OptimisticConcurrency = !OptimisticConcurrency
+			fmt.Printf("Optimistic concurrency: %t\n\n", OptimisticConcurrency)
 		} else if input == "\\rr" {
 			// Fetch all configured relationship rules
 			rules, err := listRelationshipRules()
@@ -822,7 +859,14 @@ func executeStatement(query string) (string, error) {
 		return "", fmt.Errorf("error parsing query >> %s", err)
 	}
 
-	results, err := executor.Execute(ast, core.Namespace, core.WithDryRun(DryRun))
+	ctx, cancel := newQueryContext(context.Background())
+	setRunningQueryCancel(cancel)
+	defer func() {
+		setRunningQueryCancel(nil)
+		cancel()
+	}()
+
+	results, err := executor.Execute(ast, core.Namespace, executeOptions(ctx)...)
 	if err != nil {
 		return "", fmt.Errorf("error executing query >> %s", err)
 	}
@@ -897,11 +941,36 @@ func init() {
 
 	// Add format flag to shell command
 	ShellCmd.Flags().StringVar(&core.OutputFormat, "format", "json", "Output format (json or yaml)")
+	ShellCmd.Flags().DurationVar(&queryTimeout, "timeout", 0, "Abort each query after this duration (e.g. 30s, 0 for no timeout)")
+	addCacheFlags(ShellCmd)
+	addSnapshotFlag(ShellCmd)
+}
+
+var (
+	runningQueryMu     sync.Mutex
+	runningQueryCancel context.CancelFunc
+)
+
+// setRunningQueryCancel registers the cancel function of the statement the
+// shell is executing, so that Ctrl-C can abort it.
+func setRunningQueryCancel(cancel context.CancelFunc) {
+	runningQueryMu.Lock()
+	defer runningQueryMu.Unlock()
+	runningQueryCancel = cancel
+}
+
+func cancelRunningQuery() {
+	runningQueryMu.Lock()
+	defer runningQueryMu.Unlock()
+	if runningQueryCancel != nil {
+		runningQueryCancel()
+	}
 }
 
 func handleInterrupt(rl *readline.Instance, cmds *[]string, executing *bool, highlighter *syntaxHighlighter) {
 	if *executing {
-		// If we're executing a query, do nothing
+		// If we're executing a query, abort it
+		cancelRunningQuery()
 		return
 	}
 
@@ -1007,6 +1076,7 @@ func printHelp() {
 %s
 %s
 %s
+%s
 
 %s
 %s
@@ -1035,6 +1105,7 @@ func printHelp() {
 		formatCmd("\\v", "Toggle Vi keybindings"),
 		formatCmd("\\r, \\raw", "Toggle raw JSON output"),
 		formatCmd("\\dr, \\dry-run", "Toggle dry-run mode"),
+		formatCmd("\\oc", "Toggle optimistic concurrency for SET"),
 		formatCmd("\\rr, \\relationship-rules", "List available relationship rules"),
 		formatCmd("\\rl, \\relationship-rule <rule-name>", "Describe relationship rule"),
 
@@ -465,7 +465,7 @@ func Test_describeRelationshipRule(t *testing.T) {
 		{
 			name:    string(core.GetRelationshipRules()[0].Relationship),
 			args:    args{input: string(core.GetRelationshipRules()[0].Relationship)},
-			want:    "{\"KindA\":\"pods\",\"KindB\":\"replicasets\",\"Relationship\":\"REPLICASET_OWN_POD\",\"MatchCriteria\":[{\"FieldA\":\"$.metadata.ownerReferences[].name\",\"FieldB\":\"$.metadata.name\",\"ComparisonType\":\"ExactMatch\",\"DefaultProps\":null}]}",
+			want:    "{\"KindA\":\"pods\",\"KindB\":\"replicasets\",\"Relationship\":\"REPLICASET_OWN_POD\",\"MatchCriteria\":[{\"FieldA\":\"$.metadata.ownerReferences\",\"FieldB\":\"$.metadata.uid\",\"ComparisonType\":\"OwnerReference\",\"DefaultProps\":null}]}",
 			wantErr: false,
 		},
 		{
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/avitaltamir/cyphernetes/pkg/core"
+	"github.com/avitaltamir/cyphernetes/pkg/provider/apiserver"
+	"github.com/avitaltamir/cyphernetes/pkg/provider/snapshot"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// snapshotFile is set via the --snapshot flag of the query and shell
+	// commands.
+	snapshotFile string
+	// snapshotProvider serves the snapshot loaded for --snapshot.
+	snapshotProvider *snapshot.Provider
+	snapshotKinds    []string
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Capture cluster state to query it offline",
+	Long: `Use the 'snapshot' subcommands to capture the state of a cluster into a file.
+Pass the file to 'query --snapshot' or 'shell --snapshot' to query it without a cluster.`,
+}
+
+var snapshotSaveCmd = &cobra.Command{
+	Use:   "save <file>",
+	Short: "Capture resources, discovery and schema data into a snapshot file",
+	Long: `Capture the resources of the selected kinds, together with the discovery and OpenAPI
+data queries need, into a JSON file (gzip-compressed when the name ends in .gz).
+Without --kinds every listable kind is captured, skipping kinds that cannot be listed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		p, err := apiserver.NewAPIServerProviderWithOptions(&apiserver.APIServerProviderConfig{
+			Context:  core.KubeContext,
+			Identity: kubeIdentity(),
+			CacheDir: apiserver.DefaultCacheDir(),
+		})
+		if err != nil {
+			return fmt.Errorf("error creating provider: %w", err)
+		}
+
+		contextName := core.KubeContext
+		if current, _, err := getCurrentContext(); err == nil {
+			contextName = current
+		}
+		namespace := core.Namespace
+		if core.AllNamespaces {
+			namespace = ""
+		}
+
+		ctx, cancel := newQueryContext(context.Background())
+		defer cancel()
+		s, err := snapshot.Capture(ctx, p, snapshot.CaptureOptions{
+			Kinds:     snapshotKinds,
+			Namespace: namespace,
+			Context:   contextName,
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.Save(args[0]); err != nil {
+			return fmt.Errorf("error saving snapshot: %w", err)
+		}
+
+		var skipped []string
+		for kind := range s.Skipped {
+			skipped = append(skipped, kind)
+		}
+		sort.Strings(skipped)
+		for _, kind := range skipped {
+			fmt.Fprintf(os.Stderr, "Skipped %s: %s\n", kind, s.Skipped[kind])
+		}
+		fmt.Printf("Saved %d resources of %d kinds to %s\n", s.Count(), len(s.Resources), args[0])
+		return nil
+	},
+}
+
+// addSnapshotFlag registers --snapshot on commands that can run against a
+// snapshot instead of a cluster.
+func addSnapshotFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&snapshotFile, "snapshot", "", "Query a snapshot file saved by 'cyphernetes snapshot save' instead of a cluster")
+}
+
+// loadSnapshot loads --snapshot and, unless --namespace was given, switches
+// to the namespace the snapshot was captured from.
+func loadSnapshot(cmd *cobra.Command) error {
+	s, err := snapshot.Load(snapshotFile)
+	if err != nil {
+		return fmt.Errorf("error loading snapshot: %w", err)
+	}
+	snapshotProvider = snapshot.NewProvider(s)
+	if s.Namespace != "" && !cmd.Flags().Changed("namespace") && !core.AllNamespaces {
+		core.Namespace = s.Namespace
+	}
+	return nil
+}
+
+// snapshotContextName is shown in the shell prompt in place of the
+// kubeconfig context.
+func snapshotContextName() string {
+	s := snapshotProvider.Snapshot()
+	name := "snapshot " + s.CreatedAt.Local().Format("2006-01-02 15:04")
+	if s.Context != "" {
+		name = s.Context + " " + name
+	}
+	return name
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotSaveCmd)
+	snapshotSaveCmd.Flags().StringSliceVar(&snapshotKinds, "kinds", nil, "Kinds to capture, e.g. pods,deployments (default all listable kinds)")
+	snapshotSaveCmd.Flags().DurationVar(&queryTimeout, "timeout", 0, "Abort the capture after this duration (e.g. 5m, 0 for no timeout)")
+}
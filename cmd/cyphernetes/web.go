@@ -38,6 +38,8 @@ var (
 
 func init() {
 	WebCmd.Flags().StringVarP(&webPort, "port", "p", "8080", "Port to run the web interface on")
+	WebCmd.Flags().DurationVar(&queryTimeout, "timeout", 0, "Abort each query after this duration (e.g. 30s, 0 for no timeout)")
+	addCacheFlags(WebCmd)
 }
 
 // checkPort attempts to listen on a port to check if it's available
@@ -85,13 +87,22 @@ func runWeb(cmd *cobra.Command, args []string) {
 
 	// Create the API server provider
 	providerConfig := &apiserver.APIServerProviderConfig{
-		Context: core.KubeContext,
+		Context:         core.KubeContext,
+		Identity:        kubeIdentity(),
+		CacheDir:        apiserver.DefaultCacheDir(),
+		ServerSideApply: ServerSideApply,
+		ForceConflicts:  ForceConflicts,
 	}
 	provider, err := apiserver.NewAPIServerProviderWithOptions(providerConfig)
 	if err != nil {
 		fmt.Printf("Error creating provider: %v\n", err)
 		os.Exit(1)
 	}
+	provider, err = withInformerCache(provider)
+	if err != nil {
+		fmt.Printf("Error creating provider: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Initialize the executor instance with the provider
 	executor = core.GetQueryExecutorInstance(provider)
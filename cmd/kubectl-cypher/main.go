@@ -64,6 +64,13 @@ func runQuery(args []string, w io.Writer) {
 	p, err := apiserver.NewAPIServerProviderWithOptions(&apiserver.APIServerProviderConfig{
 		QuietMode: true,
 		Context:   core.KubeContext,
+		Identity: apiserver.Identity{
+			Kubeconfig: core.Kubeconfig,
+			Token:      core.BearerToken,
+			As:         core.ImpersonateUser,
+			AsGroups:   core.ImpersonateGroups,
+		},
+		CacheDir: apiserver.DefaultCacheDir(),
 	})
 	if err != nil {
 		fmt.Fprintln(w, "Error creating provider: ", err)
@@ -185,6 +192,10 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&core.Namespace, "namespace", "n", "default", "The namespace to query against")
 	rootCmd.PersistentFlags().BoolVarP(&core.AllNamespaces, "all-namespaces", "A", false, "Query all namespaces")
 	rootCmd.PersistentFlags().StringVar(&core.KubeContext, "context", "", "The kubeconfig context to use (defaults to the current context)")
+	rootCmd.PersistentFlags().StringVar(&core.Kubeconfig, "kubeconfig", "", "Path to the kubeconfig file to use (defaults to KUBECONFIG or ~/.kube/config)")
+	rootCmd.PersistentFlags().StringVar(&core.BearerToken, "token", "", "Bearer token to authenticate with instead of the kubeconfig's credentials")
+	rootCmd.PersistentFlags().StringVar(&core.ImpersonateUser, "as", "", "Username to impersonate, such as system:serviceaccount:<namespace>:<name>")
+	rootCmd.PersistentFlags().StringArrayVar(&core.ImpersonateGroups, "as-group", nil, "Group to impersonate, can be repeated")
 	rootCmd.PersistentFlags().BoolVar(&core.NoColor, "no-color", false, "Disable colored output")
 	rootCmd.PersistentFlags().BoolVar(&DryRun, "dry-run", false, "Enable dry-run mode for all operations")
 
@@ -1,6 +1,9 @@
 package core
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
@@ -36,6 +39,106 @@ func InitResourceSpecs(p provider.Provider) error {
 	return nil
 }
 
+// relationshipsCacheEntry names the inferred relationships in a provider's
+// spec cache. The suffix changes whenever inference does.
+const relationshipsCacheEntry = "relationships.v3"
+
+// cachedRelationships are the results of inferRelationships, stored as the
+// changes it made to the rules it started from.
+type cachedRelationships struct {
+	// Builtin identifies the rules inference started from
+	Builtin string `json:"builtin"`
+	// Criteria are the criteria added to those rules, by index
+	Criteria map[int][]MatchCriterion `json:"criteria,omitempty"`
+	// Rules are the rules added after them
+	Rules          []RelationshipRule  `json:"rules"`
+	PotentialKinds map[string][]string `json:"potentialKinds"`
+	Count          int                 `json:"count"`
+}
+
+// loadCachedRelationships applies the relationships inferred for p's
+// resource types on an earlier run, if p caches them and they were inferred
+// from the current rules. It returns the number of inferred rules.
+func loadCachedRelationships(p provider.Provider) (int, bool) {
+	cache, ok := provider.Base(p).(provider.SpecCache)
+	if !ok {
+		return 0, false
+	}
+	var cached cachedRelationships
+	if !cache.LoadCached(relationshipsCacheEntry, &cached) || cached.Builtin == "" || cached.Builtin != rulesSignature(relationshipRules) {
+		return 0, false
+	}
+
+	for i, criteria := range cached.Criteria {
+		relationshipRules[i].MatchCriteria = append(relationshipRules[i].MatchCriteria, criteria...)
+	}
+	relationshipRules = append(relationshipRules, cached.Rules...)
+	potentialKindsMutex.Lock()
+	for kind, kinds := range cached.PotentialKinds {
+		potentialKindsCache[kind] = kinds
+	}
+	potentialKindsMutex.Unlock()
+	debugLog("Loaded %d cached relationships", cached.Count)
+	return cached.Count, true
+}
+
+// storeCachedRelationships caches what inferRelationships added to builtin,
+// a copy of the rules it started from, if p caches relationships.
+func storeCachedRelationships(p provider.Provider, builtin []RelationshipRule, count int) {
+	cache, ok := provider.Base(p).(provider.SpecCache)
+	if !ok {
+		return
+	}
+	cached := cachedRelationships{
+		Builtin:        rulesSignature(builtin),
+		Criteria:       make(map[int][]MatchCriterion),
+		Rules:          cloneRelationshipRules(relationshipRules[len(builtin):]),
+		PotentialKinds: make(map[string][]string),
+		Count:          count,
+	}
+	for i, rule := range builtin {
+		if added := relationshipRules[i].MatchCriteria[len(rule.MatchCriteria):]; len(added) > 0 {
+			cached.Criteria[i] = added
+		}
+	}
+	potentialKindsMutex.RLock()
+	for kind, kinds := range potentialKindsCache {
+		cached.PotentialKinds[kind] = kinds
+	}
+	potentialKindsMutex.RUnlock()
+	if err := cache.StoreCached(relationshipsCacheEntry, cached); err != nil {
+		debugLog("Error caching relationships: %v", err)
+	}
+}
+
+// rulesSignature identifies a list of relationship rules
+func rulesSignature(rules []RelationshipRule) string {
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func cloneRelationshipRules(rules []RelationshipRule) []RelationshipRule {
+	cloned := make([]RelationshipRule, len(rules))
+	for i, rule := range rules {
+		cloned[i] = rule
+		cloned[i].MatchCriteria = append([]MatchCriterion{}, rule.MatchCriteria...)
+	}
+	return cloned
+}
+
+// knownResourceKinds returns the listable kinds of providers that support
+// discovery, and nil for others
+func knownResourceKinds(p provider.Provider) []string {
+	if discovery, ok := provider.Base(p).(provider.Discovery); ok {
+		return discovery.GetKnownResourceKinds()
+	}
+	return nil
+}
+
 func (q *QueryExecutor) resourceFetchKey(n *NodePattern, namespace, fieldSelector, labelSelector string, extraFilters []*Filter) (string, error) {
 	gvr, err := tryResolveGVR(q.provider, n.ResourceProperties.Kind)
 	if err != nil {
@@ -45,7 +148,7 @@ func (q *QueryExecutor) resourceFetchKey(n *NodePattern, namespace, fieldSelecto
 	properties := []string{}
 	if n.ResourceProperties.Properties != nil {
 		for _, prop := range n.ResourceProperties.Properties.PropertyList {
-			properties = append(properties, fmt.Sprintf("%s=%#v", prop.Key, prop.Value))
+			properties = append(properties, fmt.Sprintf("%s%s=%#v", prop.Key, prop.Operator, prop.Value))
 		}
 	}
 	sort.Strings(properties)
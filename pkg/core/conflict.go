@@ -0,0 +1,161 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// ConflictError reports the resources a SET with optimistic concurrency left
+// unpatched because they kept changing between being read and being patched.
+type ConflictError struct {
+	// Attempts is how often each resource was patched.
+	Attempts  int
+	Resources []ConflictedResource
+}
+
+// ConflictedResource is a resource a ConflictError reports.
+type ConflictedResource struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// ResourceVersion is the version the last patch was conditional on.
+	ResourceVersion string
+}
+
+func (e *ConflictError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d resource(s) kept changing and were not patched after %d attempt(s):", len(e.Resources), e.Attempts)
+	for _, r := range e.Resources {
+		fmt.Fprintf(&b, "\n  %s/%s", strings.ToLower(r.Kind), r.Name)
+		if r.Namespace != "" {
+			fmt.Fprintf(&b, " in namespace %s", r.Namespace)
+		}
+		fmt.Fprintf(&b, " (last read at resourceVersion %s)", r.ResourceVersion)
+	}
+	return b.String()
+}
+
+func (e *ConflictError) Unwrap() error {
+	return provider.ErrConflict
+}
+
+// conditionalSet is what a SET clause sets on one resource under optimistic
+// concurrency. Its pairs are sent as one patch so that a single
+// resourceVersion precondition covers them all.
+type conditionalSet struct {
+	node     *NodePattern
+	kind     string
+	resource map[string]interface{}
+	pairs    []*KeyValuePair
+}
+
+// applyConditionalSets patches the resources of sets, each conditional on
+// the version it was read at, and reports those still conflicting after
+// state.conflictRetries retries.
+func (q *QueryExecutor) applyConditionalSets(sets []*conditionalSet, state *executionState) error {
+	var conflicts []ConflictedResource
+	for _, set := range sets {
+		conflict, err := q.applyConditionalSet(set, state)
+		if err != nil {
+			return err
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, *conflict)
+		}
+	}
+	if len(conflicts) > 0 {
+		return &ConflictError{Attempts: state.conflictRetries + 1, Resources: conflicts}
+	}
+	return nil
+}
+
+func (q *QueryExecutor) applyConditionalSet(set *conditionalSet, state *executionState) (*ConflictedResource, error) {
+	resource := set.resource
+	for attempt := 0; ; attempt++ {
+		metadata, err := getResourceMetadata(resource)
+		if err != nil {
+			return nil, err
+		}
+		name, err := getResourceName(metadata)
+		if err != nil {
+			return nil, err
+		}
+		namespace := getNamespaceName(metadata)
+		resourceVersion, _ := metadata["resourceVersion"].(string)
+
+		var patches []interface{}
+		if resourceVersion != "" {
+			patches = append(patches, provider.ResourceVersionPrecondition(resourceVersion))
+		}
+		for _, kvp := range set.pairs {
+			pairPatches, err := createSetPatch(resource, setPath(kvp.Key), kvp.Value)
+			if err != nil {
+				return nil, fmt.Errorf("error setting %s: %v", kvp.Key, err)
+			}
+			patches = append(patches, pairPatches...)
+		}
+		patchJSON, err := json.Marshal(patches)
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling patches: %s", err)
+		}
+		debugLog("Patching %s/%s at resourceVersion %q, attempt %d: %s", set.kind, name, resourceVersion, attempt+1, patchJSON)
+
+		err = provider.PatchK8sResource(state.ctx, q.provider, set.kind, name, namespace, patchJSON, state.dryRun)
+		if err == nil {
+			state.recordMutation()
+			return nil, nil
+		}
+		if !errors.Is(err, provider.ErrConflict) {
+			return nil, fmt.Errorf("error patching resource: %w", err)
+		}
+		if attempt >= state.conflictRetries {
+			return &ConflictedResource{Kind: set.kind, Namespace: namespace, Name: name, ResourceVersion: resourceVersion}, nil
+		}
+
+		current, err := q.refetchForSet(set, name, namespace, state)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			debugLog("%s/%s no longer matches %s, not patching it", set.kind, name, set.node.ResourceProperties.Name)
+			return nil, nil
+		}
+		// Later clauses see the resource as it was patched.
+		clear(resource)
+		for k, v := range current {
+			resource[k] = v
+		}
+	}
+}
+
+// refetchForSet fetches a resource again after a conflict. It returns nil
+// when the resource is gone or no longer matches its node: its label
+// properties and the WHERE comparisons on it. Relationships and sub-matches
+// are not evaluated again.
+func (q *QueryExecutor) refetchForSet(set *conditionalSet, name, namespace string, state *executionState) (map[string]interface{}, error) {
+	plan, err := q.planNodeFetch(set.node, state.matchFilters, namespace)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := provider.GetK8sResources(state.ctx, q.provider, set.kind, fmt.Sprintf("metadata.name=%s", name), plan.LabelSelector, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s/%s after a conflict: %v", set.kind, name, err)
+	}
+	list, ok := resources.([]map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("provider returned %T for %s, expected []map[string]interface{}", resources, set.kind)
+	}
+	if !state.projections[set.node.ResourceProperties.Name].managedFields {
+		list = stripManagedFields(list)
+	}
+	for _, resource := range list {
+		if matchesFilters(resource, set.node.ResourceProperties.Name, state.matchFilters) {
+			return resource, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// cancellingProvider is a context-aware hardeningProvider that cancels the
+// execution once it has deleted cancelAfter resources.
+type cancellingProvider struct {
+	*hardeningProvider
+	cancel      context.CancelFunc
+	cancelAfter int
+}
+
+func (p *cancellingProvider) GetK8sResourcesContext(ctx context.Context, kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p.GetK8sResources(kind, fieldSelector, labelSelector, namespace)
+}
+
+func (p *cancellingProvider) DeleteK8sResourcesContext(ctx context.Context, kind, name, namespace string, dryRun bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := p.DeleteK8sResources(kind, name, namespace, dryRun); err != nil {
+		return err
+	}
+	if len(p.deletes) == p.cancelAfter {
+		p.cancel()
+	}
+	return nil
+}
+
+func (p *cancellingProvider) CreateK8sResourceContext(ctx context.Context, kind, name, namespace string, body interface{}, dryRun bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.CreateK8sResource(kind, name, namespace, body, dryRun)
+}
+
+func (p *cancellingProvider) PatchK8sResourceContext(ctx context.Context, kind, name, namespace string, patchJSON []byte, dryRun bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.PatchK8sResource(kind, name, namespace, patchJSON, dryRun)
+}
+
+func (p *cancellingProvider) FindGVRContext(ctx context.Context, kind string) (schema.GroupVersionResource, error) {
+	return p.FindGVR(kind)
+}
+
+func (p *cancellingProvider) GetOpenAPIResourceSpecsContext(ctx context.Context) (map[string][]string, error) {
+	return p.GetOpenAPIResourceSpecs()
+}
+
+func (p *cancellingProvider) CreateProviderForKubeContext(ctx context.Context, kubeContext string) (provider.Provider, error) {
+	return p.CreateProviderForContext(kubeContext)
+}
+
+func TestExecuteWithCancelledContext(t *testing.T) {
+	executor, _ := NewQueryExecutor(newHardeningProvider())
+	ast, err := ParseQuery(`MATCH (p:Pod) RETURN p.metadata.name`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = executor.Execute(ast, "default", WithContext(ctx))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Execute() error = %v, want context.Canceled", err)
+	}
+	if !strings.Contains(err.Error(), "aborted after 0 of 2 clauses") {
+		t.Fatalf("expected progress in error, got %v", err)
+	}
+}
+
+func TestExecuteReportsPartialProgressWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := &cancellingProvider{hardeningProvider: newHardeningProvider(), cancel: cancel, cancelAfter: 1}
+	executor, _ := NewQueryExecutor(p)
+	ast, err := ParseQuery(`MATCH (p:Pod) DELETE p`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+
+	_, err = executor.Execute(ast, "default", WithContext(ctx))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Execute() error = %v, want context.Canceled", err)
+	}
+	if !strings.Contains(err.Error(), "1 of 2 clauses, 1 resources changed") {
+		t.Fatalf("expected partial progress in error, got %v", err)
+	}
+	if len(p.deletes) != 1 {
+		t.Fatalf("expected deletion to stop after the first pod, got %v", p.deletes)
+	}
+}
@@ -0,0 +1,347 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// DiffChange says how a resource or relationship differs between two states.
+type DiffChange string
+
+const (
+	DiffAdded    DiffChange = "added"
+	DiffRemoved  DiffChange = "removed"
+	DiffModified DiffChange = "modified"
+)
+
+// FieldDiff is a field that differs between two versions of a resource,
+// addressed by JSON pointer. Before is nil for added fields and After is nil
+// for removed ones.
+type FieldDiff struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// ResourceDiff is a resource that was added, removed or modified.
+type ResourceDiff struct {
+	Change     DiffChange  `json:"change"`
+	APIVersion string      `json:"apiVersion"`
+	Kind       string      `json:"kind"`
+	Namespace  string      `json:"namespace,omitempty"`
+	Name       string      `json:"name"`
+	Fields     []FieldDiff `json:"fields,omitempty"`
+}
+
+// EdgeDiff is a relationship that exists in only one of the two states.
+type EdgeDiff struct {
+	Change DiffChange `json:"change"`
+	From   string     `json:"from"`
+	To     string     `json:"to"`
+	Type   string     `json:"type"`
+}
+
+// StateDiff is the difference between two states of a set of resources.
+type StateDiff struct {
+	Resources []ResourceDiff `json:"resources"`
+	Edges     []EdgeDiff     `json:"edges"`
+}
+
+// Empty reports whether the two states are the same.
+func (d *StateDiff) Empty() bool {
+	return len(d.Resources) == 0 && len(d.Edges) == 0
+}
+
+// ignoredDiffFields change on every write and would make every touched
+// resource show up as modified.
+var ignoredDiffFields = map[string]bool{
+	"/metadata/resourceVersion": true,
+	"/metadata/managedFields":   true,
+}
+
+// DiffQuery runs a read-only query against the before and after executors
+// and compares the resources its patterns matched and the relationships
+// between them.
+func DiffQuery(before, after *QueryExecutor, ast *Expression, namespace string, opts ...ExecuteOption) (*StateDiff, error) {
+	if ast == nil {
+		return nil, fmt.Errorf("empty query: ast cannot be nil")
+	}
+	if len(ast.Contexts) > 0 || ast.Explain || ast.Profile {
+		return nil, fmt.Errorf("diff queries cannot use IN, EXPLAIN or PROFILE")
+	}
+	for _, clause := range ast.Clauses {
+		switch clause.(type) {
+		case *CreateClause, *SetClause, *DeleteClause:
+			return nil, fmt.Errorf("diff queries cannot use CREATE, SET or DELETE")
+		}
+	}
+
+	var graphs [2]Graph
+	for i, executor := range []*QueryExecutor{before, after} {
+		result, err := executor.Execute(ast, namespace, opts...)
+		if err != nil {
+			return nil, err
+		}
+		graphs[i] = result.Graph
+	}
+
+	// A resource that stopped matching still exists, so the resources
+	// matched in either state are compared in both.
+	nodes := append(append([]Node{}, graphs[0].Nodes...), graphs[1].Nodes...)
+	var resources [2][]map[string]interface{}
+	for i, executor := range []*QueryExecutor{before, after} {
+		var err error
+		resources[i], err = executor.nodeResources(nodes, opts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &StateDiff{
+		Resources: DiffResources(resources[0], resources[1]),
+		Edges:     DiffGraphs(graphs[0], graphs[1]),
+	}, nil
+}
+
+// nodeResources fetches the resources behind graph nodes that exist, listing
+// each kind once per namespace.
+func (q *QueryExecutor) nodeResources(nodes []Node, opts ...ExecuteOption) ([]map[string]interface{}, error) {
+	ctx := resolveExecuteOptions(opts).ctx
+
+	type listKey struct{ kind, namespace string }
+	wanted := make(map[listKey]map[string]bool)
+	var keys []listKey
+	for _, node := range nodes {
+		key := listKey{node.Kind, node.Namespace}
+		if wanted[key] == nil {
+			wanted[key] = make(map[string]bool)
+			keys = append(keys, key)
+		}
+		wanted[key][node.Name] = true
+	}
+
+	var resources []map[string]interface{}
+	for _, key := range keys {
+		providerKind, err := q.providerKind(key.kind)
+		if err != nil {
+			return nil, err
+		}
+		list, err := provider.GetK8sResources(ctx, q.provider, providerKind, "", "", key.namespace)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching %s: %w", key.kind, err)
+		}
+		items, ok := list.([]map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("provider returned %T for %s, expected []map[string]interface{}", list, key.kind)
+		}
+		for _, item := range items {
+			metadata, err := getResourceMetadata(item)
+			if err != nil {
+				continue
+			}
+			name, _ := metadata["name"].(string)
+			if !wanted[key][name] {
+				continue
+			}
+			// Cluster-scoped nodes carry the default namespace, like the
+			// graph built for query results.
+			if key.kind != "Namespace" && getNamespaceName(metadata) != key.namespace {
+				continue
+			}
+			resources = append(resources, item)
+		}
+	}
+	return resources, nil
+}
+
+// DiffResources compares two sets of resources, matching them by API group,
+// kind, namespace and name, and reports the resources only one set has and
+// the fields that changed in the rest.
+func DiffResources(before, after []map[string]interface{}) []ResourceDiff {
+	beforeByKey := indexDiffResources(before)
+	afterByKey := indexDiffResources(after)
+
+	var diffs []ResourceDiff
+	for key, old := range beforeByKey {
+		current, ok := afterByKey[key]
+		if !ok {
+			diffs = append(diffs, newResourceDiff(DiffRemoved, old))
+			continue
+		}
+		var fields []FieldDiff
+		diffFields("", old, current, &fields)
+		if len(fields) > 0 {
+			diff := newResourceDiff(DiffModified, current)
+			diff.Fields = fields
+			diffs = append(diffs, diff)
+		}
+	}
+	for key, current := range afterByKey {
+		if _, ok := beforeByKey[key]; !ok {
+			diffs = append(diffs, newResourceDiff(DiffAdded, current))
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		a, b := diffs[i], diffs[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return diffs
+}
+
+func indexDiffResources(resources []map[string]interface{}) map[string]map[string]interface{} {
+	index := make(map[string]map[string]interface{}, len(resources))
+	for _, resource := range resources {
+		apiVersion, _ := resource["apiVersion"].(string)
+		group := ""
+		if i := strings.Index(apiVersion, "/"); i >= 0 {
+			group = apiVersion[:i]
+		}
+		kind, _ := resource["kind"].(string)
+		metadata, _ := resource["metadata"].(map[string]interface{})
+		namespace, _ := metadata["namespace"].(string)
+		name, _ := metadata["name"].(string)
+		index[group+"/"+kind+"/"+namespace+"/"+name] = resource
+	}
+	return index
+}
+
+func newResourceDiff(change DiffChange, resource map[string]interface{}) ResourceDiff {
+	metadata, _ := resource["metadata"].(map[string]interface{})
+	diff := ResourceDiff{Change: change}
+	diff.APIVersion, _ = resource["apiVersion"].(string)
+	diff.Kind, _ = resource["kind"].(string)
+	diff.Namespace, _ = metadata["namespace"].(string)
+	diff.Name, _ = metadata["name"].(string)
+	return diff
+}
+
+// diffFields appends the differences between before and after under path.
+// Maps are compared key by key and lists element by element when their
+// lengths match; a list that grew or shrank is reported as a whole.
+func diffFields(path string, before, after interface{}, out *[]FieldDiff) {
+	if ignoredDiffFields[path] {
+		return
+	}
+	switch old := before.(type) {
+	case map[string]interface{}:
+		current, ok := after.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(old)+len(current))
+		for key := range old {
+			keys = append(keys, key)
+		}
+		for key := range current {
+			if _, ok := old[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			diffFields(path+"/"+escapePointerToken(key), old[key], current[key], out)
+		}
+		return
+	case []interface{}:
+		current, ok := after.([]interface{})
+		if !ok || len(old) != len(current) {
+			break
+		}
+		for i := range old {
+			diffFields(path+"/"+strconv.Itoa(i), old[i], current[i], out)
+		}
+		return
+	}
+	if !equalDiffValues(before, after) {
+		*out = append(*out, FieldDiff{Path: path, Before: before, After: after})
+	}
+}
+
+// equalDiffValues compares leaf values. Numbers are compared by value, as
+// the same resource decodes to int64 from the API server and to float64
+// from JSON files.
+func equalDiffValues(a, b interface{}) bool {
+	if x, ok := diffNumber(a); ok {
+		y, ok := diffNumber(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func diffNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func escapePointerToken(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// DiffGraphs reports the relationships only one of two graphs has. Edges are
+// undirected: the same relationship found from either end is one edge.
+func DiffGraphs(before, after Graph) []EdgeDiff {
+	beforeEdges := indexDiffEdges(before.Edges)
+	afterEdges := indexDiffEdges(after.Edges)
+
+	var diffs []EdgeDiff
+	for key, edge := range beforeEdges {
+		if _, ok := afterEdges[key]; !ok {
+			diffs = append(diffs, EdgeDiff{Change: DiffRemoved, From: edge.From, To: edge.To, Type: edge.Type})
+		}
+	}
+	for key, edge := range afterEdges {
+		if _, ok := beforeEdges[key]; !ok {
+			diffs = append(diffs, EdgeDiff{Change: DiffAdded, From: edge.From, To: edge.To, Type: edge.Type})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		a, b := diffs[i], diffs[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return diffs
+}
+
+func indexDiffEdges(edges []Edge) map[string]Edge {
+	index := make(map[string]Edge, len(edges))
+	for _, edge := range edges {
+		from, to := edge.From, edge.To
+		if from > to {
+			from, to = to, from
+		}
+		key := edge.Type + "|" + from + "|" + to
+		if _, ok := index[key]; !ok {
+			index[key] = edge
+		}
+	}
+	return index
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiffResources(t *testing.T) {
+	before := []map[string]interface{}{
+		testDeployment("deploy-a", "default", "a", 3),
+		testService("svc-a", "default", "a"),
+		testPod("pod-a", "default", "a", 1),
+	}
+	after := []map[string]interface{}{
+		cloneResourceMap(testDeployment("deploy-a", "default", "a", 3)),
+		testService("svc-a", "default", "a"),
+		testPod("pod-b", "default", "b", 1),
+	}
+	deployment := after[0]
+	deployment["metadata"].(map[string]interface{})["resourceVersion"] = "42"
+	deployment["spec"].(map[string]interface{})["selector"] = map[string]interface{}{
+		"matchLabels": map[string]interface{}{"app": "a", "track": "canary"},
+	}
+	delete(deployment["metadata"].(map[string]interface{})["labels"].(map[string]interface{}), "app")
+
+	diffs := DiffResources(before, after)
+	var got []string
+	for _, diff := range diffs {
+		got = append(got, fmt.Sprintf("%s %s/%s", diff.Change, diff.Kind, diff.Name))
+	}
+	if want := "[modified Deployment/deploy-a removed Pod/pod-a added Pod/pod-b]"; fmt.Sprint(got) != want {
+		t.Fatalf("got %v, want %s", got, want)
+	}
+
+	// The replica count went through JSON as a float64 and must still
+	// compare equal, and resourceVersion is ignored.
+	fields := diffs[0].Fields
+	if len(fields) != 2 {
+		t.Fatalf("expected 2 changed fields, got %+v", fields)
+	}
+	if fields[0].Path != "/metadata/labels/app" || fields[0].Before != "a" || fields[0].After != nil {
+		t.Errorf("unexpected removed field %+v", fields[0])
+	}
+	if fields[1].Path != "/spec/selector/matchLabels/track" || fields[1].Before != nil || fields[1].After != "canary" {
+		t.Errorf("unexpected added field %+v", fields[1])
+	}
+}
+
+func TestDiffFieldsComparesLists(t *testing.T) {
+	before := map[string]interface{}{
+		"ports":      []interface{}{map[string]interface{}{"port": int64(80)}, map[string]interface{}{"port": int64(443)}},
+		"finalizers": []interface{}{"a"},
+		"a/b":        "x",
+	}
+	after := map[string]interface{}{
+		"ports":      []interface{}{map[string]interface{}{"port": float64(80)}, map[string]interface{}{"port": float64(8443)}},
+		"finalizers": []interface{}{"a", "b"},
+		"a/b":        "y",
+	}
+	var fields []FieldDiff
+	diffFields("", before, after, &fields)
+
+	var got []string
+	for _, field := range fields {
+		got = append(got, fmt.Sprintf("%s:%v->%v", field.Path, field.Before, field.After))
+	}
+	if want := "[/a~1b:x->y /finalizers:[a]->[a b] /ports/1/port:443->8443]"; fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestDiffGraphsIgnoresEdgeDirection(t *testing.T) {
+	before := Graph{Edges: []Edge{
+		{From: "Service/svc-a", To: "Pod/pod-a", Type: "SERVICE_EXPOSE_POD"},
+		{From: "Service/svc-b", To: "Pod/pod-b", Type: "SERVICE_EXPOSE_POD"},
+	}}
+	after := Graph{Edges: []Edge{
+		{From: "Pod/pod-b", To: "Service/svc-b", Type: "SERVICE_EXPOSE_POD"},
+		{From: "Service/svc-b", To: "Pod/pod-c", Type: "SERVICE_EXPOSE_POD"},
+	}}
+
+	var got []string
+	for _, edge := range DiffGraphs(before, after) {
+		got = append(got, fmt.Sprintf("%s %s-%s", edge.Change, edge.From, edge.To))
+	}
+	if want := "[removed Service/svc-a-Pod/pod-a added Service/svc-b-Pod/pod-c]"; fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestDiffQuery(t *testing.T) {
+	beforeProvider := newHardeningProvider()
+	afterProvider := newHardeningProvider()
+	// pod-a is relabelled away from svc-a and pod-c is deleted.
+	podA := cloneResourceMap(afterProvider.resources["Pod"][0])
+	podA["metadata"].(map[string]interface{})["labels"] = map[string]interface{}{"app": "a2"}
+	afterProvider.resources["Pod"] = []map[string]interface{}{podA, afterProvider.resources["Pod"][1]}
+
+	before, _ := NewQueryExecutor(beforeProvider)
+	after, _ := NewQueryExecutor(afterProvider)
+	ast, err := ParseQuery(`MATCH (s:Service)->(p:Pod) RETURN p.metadata.name`)
+	if err != nil {
+		t.Fatalf("parse query: %v", err)
+	}
+	diff, err := DiffQuery(before, after, ast, "default")
+	if err != nil {
+		t.Fatalf("DiffQuery() error = %v", err)
+	}
+
+	// svc-a stopped matching but still exists, so only pod-a's label shows
+	// up; pod-c never matched the pattern.
+	if len(diff.Resources) != 1 {
+		t.Fatalf("expected only pod-a to change, got %+v", diff.Resources)
+	}
+	if r := diff.Resources[0]; r.Change != DiffModified || r.Name != "pod-a" || len(r.Fields) != 1 || r.Fields[0].Path != "/metadata/labels/app" {
+		t.Errorf("unexpected resource diff %+v", r)
+	}
+	if len(diff.Edges) != 1 || diff.Edges[0].Change != DiffRemoved || diff.Edges[0].Type != "SERVICE_EXPOSE_POD" {
+		t.Errorf("expected the svc-a to pod-a edge to be removed, got %+v", diff.Edges)
+	}
+
+	ast, err = ParseQuery(`MATCH (p:Pod) DELETE p`)
+	if err != nil {
+		t.Fatalf("parse query: %v", err)
+	}
+	if _, err := DiffQuery(before, after, ast, "default"); err == nil {
+		t.Errorf("expected mutating queries to be rejected")
+	}
+	if len(afterProvider.deletes) != 0 {
+		t.Errorf("expected nothing to be deleted, got %v", afterProvider.deletes)
+	}
+}
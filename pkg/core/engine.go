@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -47,7 +48,14 @@ var (
 	Namespace        string
 	// KubeContext is the kubeconfig context to use. When empty, the kubeconfig's
 	// current-context is used. Set via the global --context CLI flag.
-	KubeContext       string
+	KubeContext string
+	// Kubeconfig is the kubeconfig file to use, and BearerToken,
+	// ImpersonateUser and ImpersonateGroups the identity to use. Set via the
+	// global --kubeconfig, --token, --as and --as-group CLI flags.
+	Kubeconfig        string
+	BearerToken       string
+	ImpersonateUser   string
+	ImpersonateGroups []string
 	LogLevel          string
 	OutputFormat      string
 	AllNamespaces     bool
@@ -72,7 +80,12 @@ func NewQueryExecutor(p provider.Provider) (*QueryExecutor, error) {
 type ExecuteOption func(*executeOptions)
 
 type executeOptions struct {
+	ctx    context.Context
 	dryRun bool
+	// conflictRetries is how often a conflicting SET patch is retried; it is
+	// -1 unless optimistic concurrency is enabled.
+	conflictRetries int
+	parallelFetch   bool
 }
 
 // WithDryRun runs the execution's mutations (CREATE/SET/DELETE) in Kubernetes
@@ -83,8 +96,32 @@ func WithDryRun(dryRun bool) ExecuteOption {
 	return func(o *executeOptions) { o.dryRun = dryRun }
 }
 
+// WithContext bounds the execution's provider calls by ctx. Once ctx is done,
+// in-flight calls are cancelled and the execution stops with an error saying
+// how far it got.
+func WithContext(ctx context.Context) ExecuteOption {
+	return func(o *executeOptions) { o.ctx = ctx }
+}
+
+// WithOptimisticConcurrency makes each SET patch conditional on the
+// resourceVersion its resource was matched at. A resource that changed in
+// the meantime is fetched again and, if it still matches its node's labels
+// and WHERE comparisons, patched again, up to retries times. Resources still
+// conflicting after that are left unpatched and reported in a
+// *ConflictError once the others are patched.
+func WithOptimisticConcurrency(retries int) ExecuteOption {
+	return func(o *executeOptions) { o.conflictRetries = max(retries, 0) }
+}
+
+// WithParallelFetch fetches the independent nodes of a MATCH clause
+// concurrently instead of one after another. How many lists are in flight
+// at once is still bounded by the provider.
+func WithParallelFetch() ExecuteOption {
+	return func(o *executeOptions) { o.parallelFetch = true }
+}
+
 func resolveExecuteOptions(opts []ExecuteOption) executeOptions {
-	var o executeOptions
+	o := executeOptions{ctx: context.Background(), conflictRetries: -1}
 	for _, fn := range opts {
 		fn(&o)
 	}
@@ -96,8 +133,14 @@ func (q *QueryExecutor) Execute(ast *Expression, namespace string, opts ...Execu
 		return QueryResult{}, fmt.Errorf("empty query: ast cannot be nil")
 	}
 	if len(ast.Contexts) > 0 {
+		if ast.Explain || ast.Profile {
+			return QueryResult{}, fmt.Errorf("EXPLAIN and PROFILE are not supported for multi-context queries")
+		}
 		return ExecuteMultiContextQuery(ast, namespace, opts...)
 	}
+	if ast.Profile {
+		return q.profile(ast, namespace, opts...)
+	}
 
 	// First, check for kindless nodes and rewrite the query if needed
 	rewrittenAst, err := q.rewriteQueryForKindlessNodes(ast)
@@ -108,6 +151,10 @@ func (q *QueryExecutor) Execute(ast *Expression, namespace string, opts ...Execu
 		ast = rewrittenAst
 	}
 
+	if ast.Explain {
+		return q.explain(ast, namespace)
+	}
+
 	result, err := q.ExecuteSingleQuery(ast, namespace, opts...)
 	if err != nil {
 		return result, err
@@ -8,19 +8,33 @@ import (
 	"strings"
 
 	"github.com/AvitalTamir/jsonpath"
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
 )
 
 func (q *QueryExecutor) ExecuteSingleQuery(ast *Expression, namespace string, opts ...ExecuteOption) (QueryResult, error) {
+	options := resolveExecuteOptions(opts)
 	state := newExecutionState()
-	state.dryRun = resolveExecuteOptions(opts).dryRun
+	state.ctx = provider.WithRetryObserver(options.ctx, func(retry provider.Retry) {
+		debugLog("Retrying %s of %s after attempt %d failed, waiting %v: %v", retry.Verb, retryTarget(retry), retry.Attempt, retry.Delay, retry.Err)
+	})
+	state.dryRun = options.dryRun
+	state.conflictRetries = options.conflictRetries
+	state.parallelFetch = options.parallelFetch
 	return q.executeSingleQuery(ast, namespace, state)
 }
 
-func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, state *executionState) (QueryResult, error) {
+func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, state *executionState) (result QueryResult, err error) {
 	if ast == nil {
 		return QueryResult{}, fmt.Errorf("empty query: ast cannot be nil")
 	}
 
+	completedClauses := 0
+	defer func() {
+		if err != nil && state.ctx.Err() != nil {
+			err = state.abortError(completedClauses, len(ast.Clauses))
+		}
+	}()
+
 	state.namespace = namespace
 	executionConfigMu.Lock()
 	if AllNamespaces {
@@ -28,6 +42,8 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 		AllNamespaces = false // to reset value
 	}
 	executionConfigMu.Unlock()
+	state.limitNode, state.listLimit = queryListLimit(ast)
+	state.projections = q.queryProjections(ast)
 
 	results := &QueryResult{
 		Data: make(map[string]interface{}),
@@ -38,11 +54,16 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 	}
 
 	// Iterate over the clauses in the AST.
-	for _, clause := range ast.Clauses {
+	for i, clause := range ast.Clauses {
+		completedClauses = i
+		if err := state.ctx.Err(); err != nil {
+			return *results, err
+		}
 		switch c := clause.(type) {
 		case *MatchClause:
 			// Store the nodes from the match clause
 			state.matchNodes = c.Nodes
+			state.matchFilters = c.ExtraFilters
 
 			var filteringOccurred bool
 			filteredResults := make(map[string][]map[string]interface{})
@@ -65,12 +86,25 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 				}
 			}
 
+			// Resolve shortest path patterns before nodes are added to the
+			// graph, since they narrow down their start and end nodes
+			for _, sp := range c.ShortestPaths {
+				if err := q.processShortestPath(sp, c, results, state); err != nil {
+					return *results, err
+				}
+			}
+
 			// Process nodes
 			err := q.processNodes(c, results, state)
 			if err != nil {
 				return *results, err
 			}
 
+			// Trace path variables now that every node has been narrowed down
+			if err := bindPathVariables(c, state); err != nil {
+				return *results, err
+			}
+
 		case *SetClause:
 			err := q.handleSetClause(c, state)
 			if err != nil {
@@ -117,10 +151,11 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 					if err != nil {
 						return *results, fmt.Errorf("error resolving resource kind %s: %v", nodeKind, err)
 					}
-					err = q.provider.DeleteK8sResources(providerKind, name, namespace, state.dryRun)
+					err = provider.DeleteK8sResources(state.ctx, q.provider, providerKind, name, namespace, state.dryRun)
 					if err != nil {
 						return *results, fmt.Errorf("error deleting resource %s/%s: %v", nodeKind, name, err)
 					}
+					state.recordMutation()
 				}
 
 				state.deleteResources(nodeId)
@@ -192,21 +227,28 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 					return *results, fmt.Errorf("error finding API resource >> %s", err)
 				}
 
-				for _, resourceRelationship := range relationshipRules {
-					if (strings.EqualFold(targetGVR.Resource, resourceRelationship.KindA) && strings.EqualFold(foreignGVR.Resource, resourceRelationship.KindB)) ||
-						(strings.EqualFold(foreignGVR.Resource, resourceRelationship.KindA) && strings.EqualFold(targetGVR.Resource, resourceRelationship.KindB)) {
-						relType = resourceRelationship.Relationship
+				if !isOwnsRelationship(rel) {
+					for _, resourceRelationship := range relationshipRules {
+						if (strings.EqualFold(targetGVR.Resource, resourceRelationship.KindA) && strings.EqualFold(foreignGVR.Resource, resourceRelationship.KindB)) ||
+							(strings.EqualFold(foreignGVR.Resource, resourceRelationship.KindA) && strings.EqualFold(targetGVR.Resource, resourceRelationship.KindB)) {
+							relType = resourceRelationship.Relationship
+						}
 					}
 				}
 
-				if relType == "" {
-					// no relationship type found, error out
-					return *results, fmt.Errorf("relationship type not found between %s and %s", targetGVR.Resource, foreignGVR.Resource)
-				}
+				// Relationships labeled OWNS make the created resources owned
+				// by the foreign ones
+				rule := ownsRule(targetGVR.Resource, foreignGVR.Resource)
+				if !isOwnsRelationship(rel) {
+					if relType == "" {
+						// no relationship type found, error out
+						return *results, fmt.Errorf("relationship type not found between %s and %s", targetGVR.Resource, foreignGVR.Resource)
+					}
 
-				rule, err := findRuleByRelationshipType(relType)
-				if err != nil {
-					return *results, fmt.Errorf("error determining relationship type >> %s", err)
+					rule, err = findRuleByRelationshipType(relType)
+					if err != nil {
+						return *results, fmt.Errorf("error determining relationship type >> %s", err)
+					}
 				}
 
 				// Now according to which is the node that needs to be created, we'll construct the spec from the node properties and from the relevant part of the spec that's defined in the relationship
@@ -260,6 +302,14 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 
 					fields := append([]string{criteriaField}, defaultPropFields...)
 					foreignFields := append([]string{foreignCriteriaField}, foreignDefaultPropFields...)
+					if rule.MatchCriteria[0].ComparisonType == OwnerReference {
+						// The owner reference is set as a whole rather than
+						// copied field by field
+						if err := setOwnerReference(resourceTemplate, foreignResource); err != nil {
+							return *results, fmt.Errorf("error referencing owner %s: %w", foreignNode.ResourceProperties.Name, err)
+						}
+						fields = nil
+					}
 
 					for i, jsonpath := range fields {
 						var value interface{}
@@ -298,6 +348,10 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 						// create the nested structure in the spec if it doesn't exist
 						// assign the value to the last part of the jsonPath
 
+						if i == 0 && rule.MatchCriteria[0].ComparisonType == LabelSelector {
+							value = labelSelectorValue(value, rule.KindA != targetGVR.Resource)
+						}
+
 						if value != nil && value != "" {
 							targetField := strings.TrimPrefix(jsonpath, "$.")
 							path := strings.Split(targetField, ".")
@@ -346,7 +400,9 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 					if err != nil {
 						return *results, fmt.Errorf("error resolving resource kind %s: %v", node.ResourceProperties.Kind, err)
 					}
-					err = q.provider.CreateK8sResource(
+					err = provider.CreateK8sResource(
+						state.ctx,
+						q.provider,
 						providerKind,
 						name,
 						state.namespace,
@@ -356,6 +412,7 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 					if err != nil {
 						return *results, fmt.Errorf("error creating resource >> %v", err)
 					}
+					state.recordMutation()
 				}
 			}
 			// Iterate over the nodes in the create clause.
@@ -389,7 +446,9 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 					if err != nil {
 						return *results, fmt.Errorf("error resolving resource kind %s: %v", node.ResourceProperties.Kind, err)
 					}
-					err = q.provider.CreateK8sResource(
+					err = provider.CreateK8sResource(
+						state.ctx,
+						q.provider,
 						providerKind,
 						name,
 						state.namespace,
@@ -399,12 +458,16 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 					if err != nil {
 						return *results, fmt.Errorf("error creating resource >> %v", err)
 					}
+					state.recordMutation()
 				}
 			}
 
 		case *ReturnClause:
 			nodeIds := []string{}
 			for _, item := range c.Items {
+				if isPathReturnItem(item, state) {
+					continue
+				}
 				// generate a unique list of nodeIds
 				nodeId := strings.Split(item.JsonPath, ".")[0]
 				if !slices.Contains(nodeIds, nodeId) {
@@ -419,6 +482,9 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 			}
 
 			for _, item := range c.Items {
+				if isPathReturnItem(item, state) {
+					continue
+				}
 				nodeId := strings.Split(item.JsonPath, ".")[0]
 				resources, ok := state.getResources(nodeId)
 				if !ok {
@@ -759,6 +825,10 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 				}
 			}
 
+			if err := returnPathItems(c, results, state); err != nil {
+				return *results, err
+			}
+
 			// Apply ORDER BY, LIMIT, and SKIP if specified
 			if len(c.OrderBy) > 0 || c.Limit != nil || c.Skip != nil {
 				err := q.applyColumnarOperations(c, results, state)
@@ -771,6 +841,7 @@ func (q *QueryExecutor) executeSingleQuery(ast *Expression, namespace string, st
 			return *results, fmt.Errorf("unknown clause type: %T", c)
 		}
 	}
+	completedClauses = len(ast.Clauses)
 	// build the graph
 	q.buildGraph(results)
 	return *results, nil
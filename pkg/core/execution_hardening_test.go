@@ -92,6 +92,8 @@ func (p *hardeningProvider) FindGVR(kind string) (schema.GroupVersionResource, e
 		return schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, nil
 	case "service", "services":
 		return schema.GroupVersionResource{Version: "v1", Resource: "services"}, nil
+	case "networkpolicy", "networkpolicies":
+		return schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "networkpolicies"}, nil
 	case "configmap", "configmaps":
 		return schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, nil
 	case "secret", "secrets":
@@ -1,7 +1,9 @@
 package core
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -146,6 +148,190 @@ func TestExecuteRelationshipCreateAndErrorBranches(t *testing.T) {
 	}
 }
 
+// bodyRecordingProvider records the bodies of the resources it creates.
+type bodyRecordingProvider struct {
+	*hardeningProvider
+	bodies []map[string]interface{}
+}
+
+func (p *bodyRecordingProvider) CreateK8sResource(kind, name, namespace string, body interface{}, dryRun bool) error {
+	p.bodies = append(p.bodies, body.(map[string]interface{}))
+	return p.hardeningProvider.CreateK8sResource(kind, name, namespace, body, dryRun)
+}
+
+func TestOwnsRelationshipMatchesOwnerReferenceUIDs(t *testing.T) {
+	provider := &bodyRecordingProvider{hardeningProvider: newHardeningProvider()}
+	for i, deployment := range provider.resources["Deployment"] {
+		deployment["apiVersion"] = "apps/v1"
+		deployment["metadata"].(map[string]interface{})["uid"] = fmt.Sprintf("uid-%d", i)
+	}
+	owner := func(name, uid string) []interface{} {
+		return []interface{}{map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "name": name, "uid": uid}}
+	}
+	pods := provider.resources["Pod"]
+	pods[0]["metadata"].(map[string]interface{})["ownerReferences"] = owner("deploy-a", "uid-0")
+	// A deployment deleted and recreated under the same name
+	pods[1]["metadata"].(map[string]interface{})["ownerReferences"] = owner("deploy-a", "uid-stale")
+	executor, _ := NewQueryExecutor(provider)
+
+	for _, query := range []string{
+		`MATCH (d:Deployment)-[r:OWNS]->(p:Pod) RETURN p.metadata.name`,
+		`MATCH (p:Pod)-[r:OWNS]->(d:Deployment) RETURN p.metadata.name`,
+	} {
+		result := executeTestQuery(t, executor, query)
+		rows := result.Data["p"].([]interface{})
+		if len(rows) != 1 || rows[0].(map[string]interface{})["metadata"].(map[string]interface{})["name"] != "pod-a" {
+			t.Errorf("%s: expected only pod-a, got %#v", query, rows)
+		}
+		if len(result.Graph.Edges) != 1 || result.Graph.Edges[0].Type != string(Owns) {
+			t.Errorf("%s: expected one OWNS edge, got %#v", query, result.Graph.Edges)
+		}
+	}
+
+	executeTestQuery(t, executor, `MATCH (d:Deployment {app: "b"}) CREATE (d)-[r:OWNS]->(c:ConfigMap {"data": {"key": "value"}})`)
+	if len(provider.bodies) != 1 {
+		t.Fatalf("expected one configmap to be created, got %#v", provider.creates)
+	}
+	refs := provider.bodies[0]["metadata"].(map[string]interface{})["ownerReferences"]
+	want := []interface{}{map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "name": "deploy-b", "uid": "uid-1"}}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("ownerReferences = %#v, want %#v", refs, want)
+	}
+}
+
+func TestLabelSelectorRelationshipMatchesExpressions(t *testing.T) {
+	provider := &bodyRecordingProvider{hardeningProvider: newHardeningProvider()}
+	networkPolicy := func(name string, podSelector map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"kind":     "NetworkPolicy",
+			"metadata": map[string]interface{}{"name": name, "namespace": "default"},
+			"spec":     map[string]interface{}{"podSelector": podSelector},
+		}
+	}
+	provider.resources["NetworkPolicy"] = []map[string]interface{}{
+		networkPolicy("np-not-c", map[string]interface{}{"matchExpressions": []interface{}{
+			map[string]interface{}{"key": "app", "operator": "NotIn", "values": []interface{}{"c"}},
+		}}),
+		networkPolicy("np-all", map[string]interface{}{}),
+	}
+	executor, _ := NewQueryExecutor(provider)
+
+	result := executeTestQuery(t, executor, `MATCH (n:NetworkPolicy)->(p:Pod) RETURN n.metadata.name, p.metadata.name`)
+	edges := map[string]bool{}
+	for _, edge := range result.Graph.Edges {
+		edges[edge.From+" "+edge.To] = true
+	}
+	want := map[string]bool{
+		"NetworkPolicy/np-not-c Pod/pod-a": true,
+		"NetworkPolicy/np-not-c Pod/pod-b": true,
+		"NetworkPolicy/np-all Pod/pod-a":   true,
+		"NetworkPolicy/np-all Pod/pod-b":   true,
+		"NetworkPolicy/np-all Pod/pod-c":   true,
+	}
+	if !reflect.DeepEqual(edges, want) {
+		t.Errorf("edges = %v, want %v", edges, want)
+	}
+
+	executeTestQuery(t, executor, `MATCH (p:Pod {app: "c"}) CREATE (p)->(n:NetworkPolicy)`)
+	if len(provider.bodies) != 1 {
+		t.Fatalf("expected one network policy to be created, got %#v", provider.creates)
+	}
+	podSelector := provider.bodies[0]["spec"].(map[string]interface{})["podSelector"]
+	wantSelector := map[string]interface{}{"matchLabels": map[string]interface{}{"app": "c"}}
+	if !reflect.DeepEqual(podSelector, wantSelector) {
+		t.Errorf("podSelector = %#v, want %#v", podSelector, wantSelector)
+	}
+}
+
+func TestUnlabeledRelationshipBetweenUnrelatedKindsErrors(t *testing.T) {
+	provider := &bodyRecordingProvider{hardeningProvider: newHardeningProvider()}
+	executor, _ := NewQueryExecutor(provider)
+
+	for _, query := range []string{
+		`MATCH (p:Pod)->(d:Deployment) RETURN p.metadata.name`,
+		`MATCH (d:Deployment {app: "b"}) CREATE (d)->(c:ConfigMap {"data": {"key": "value"}})`,
+	} {
+		ast, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("parse query: %v", err)
+		}
+		_, err = executor.Execute(ast, "default")
+		if err == nil || !strings.Contains(err.Error(), "relationship type not found") {
+			t.Errorf("%s: expected a relationship type not found error, got %v", query, err)
+		}
+	}
+	if len(provider.bodies) != 0 {
+		t.Errorf("expected nothing to be created, got %#v", provider.bodies)
+	}
+}
+
+func TestOwnsRelationshipKindlessExpansion(t *testing.T) {
+	oldRules := relationshipRules
+	relationshipRules = append([]RelationshipRule(nil), relationshipRules...)
+	defer func() { relationshipRules = oldRules }()
+	AddRelationshipRule(RelationshipRule{
+		KindA:         "pods",
+		KindB:         "deployments",
+		Relationship:  "DEPLOYMENT_OWN_POD",
+		MatchCriteria: []MatchCriterion{ownerReferenceCriterion},
+	})
+
+	ast, err := ParseQuery(`MATCH (d:Deployment)-[r:OWNS]->(x) RETURN x.metadata.name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relationships := ast.Clauses[0].(*MatchClause).Relationships
+	kinds, err := FindPotentialKindsIntersection(relationships, newHardeningProvider())
+	if err != nil {
+		t.Fatalf("FindPotentialKindsIntersection() error = %v", err)
+	}
+	if !reflect.DeepEqual(kinds, []string{"core.pods"}) {
+		t.Errorf("potential kinds = %v, want [core.pods]", kinds)
+	}
+
+	oldMock := mockFindPotentialKinds
+	mockFindPotentialKinds = func([]*Relationship) []string { return []string{"Pod"} }
+	defer func() { mockFindPotentialKinds = oldMock }()
+	executor, _ := NewQueryExecutor(newHardeningProvider())
+	rewritten, err := executor.rewriteQueryForKindlessNodes(ast)
+	if err != nil {
+		t.Fatalf("rewriteQueryForKindlessNodes() error = %v", err)
+	}
+	if rel := rewritten.Clauses[0].(*MatchClause).Relationships[0]; !isOwnsRelationship(rel) {
+		t.Errorf("expected the expanded relationship to stay labeled OWNS, got %#v", rel.ResourceProperties)
+	}
+}
+
+// discoveringProvider is a hardeningProvider that lists its kinds up front
+type discoveringProvider struct {
+	*hardeningProvider
+	kinds []string
+}
+
+func (p *discoveringProvider) GetGVRCacheSnapshot() map[string]schema.GroupVersionResource {
+	return nil
+}
+
+func (p *discoveringProvider) GetKnownResourceKinds() []string {
+	return p.kinds
+}
+
+func TestOwnsKindlessExpansionCoversDiscoveredKinds(t *testing.T) {
+	ast, err := ParseQuery(`MATCH (x)-[r:OWNS]->(p:Pod) RETURN x.metadata.name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relationships := ast.Clauses[0].(*MatchClause).Relationships
+	p := &discoveringProvider{hardeningProvider: newHardeningProvider(), kinds: []string{"pods", "configmaps", "deployments", "unknowns"}}
+	kinds, err := FindPotentialKindsIntersection(relationships, p)
+	if err != nil {
+		t.Fatalf("FindPotentialKindsIntersection() error = %v", err)
+	}
+	if want := []string{"core.configmaps", "core.pods", "deployments.apps"}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("potential kinds = %v, want %v", kinds, want)
+	}
+}
+
 func TestExecuteKindlessRewriteMergesExpandedResults(t *testing.T) {
 	oldMock := mockFindPotentialKinds
 	mockFindPotentialKinds = func([]*Relationship) []string { return []string{"Pod", "Deployment"} }
@@ -192,9 +378,10 @@ func TestRelationshipInitializationDiscoversAndCachesRules(t *testing.T) {
 			"spec.secretName",
 			"spec.secretName",
 		},
-		"io.k8s.api.core.v1.Service":   {"metadata.name"},
-		"io.k8s.api.core.v1.ConfigMap": {"metadata.name"},
-		"io.k8s.api.core.v1.Secret":    {"metadata.name"},
+		"io.k8s.api.apps.v1.Deployment": {"spec.secretRef", "spec.secretRef.namespace"},
+		"io.k8s.api.core.v1.Service":    {"metadata.name"},
+		"io.k8s.api.core.v1.ConfigMap":  {"metadata.name"},
+		"io.k8s.api.core.v1.Secret":     {"metadata.name"},
 	}
 	before := len(relationshipRules)
 	InitializeRelationships(specs, provider)
@@ -204,7 +391,16 @@ func TestRelationshipInitializationDiscoversAndCachesRules(t *testing.T) {
 
 	var foundConfigMapRule bool
 	var foundSecretRule bool
+	var foundNamespacedReference bool
 	for _, rule := range relationshipRules {
+		if rule.KindA == "deployments" && rule.KindB == "secrets" {
+			if rule.CrossNamespace {
+				t.Errorf("expected a reference with its own namespace to keep the rule namespaced, got %#v", rule)
+			}
+			for _, criterion := range rule.MatchCriteria {
+				foundNamespacedReference = foundNamespacedReference || criterion.NamespaceFieldA == "$.spec.secretRef.namespace"
+			}
+		}
 		if rule.KindA == "pods" && rule.KindB == "configmaps" {
 			foundConfigMapRule = true
 		}
@@ -218,6 +414,9 @@ func TestRelationshipInitializationDiscoversAndCachesRules(t *testing.T) {
 	if !foundConfigMapRule || !foundSecretRule {
 		t.Fatalf("expected configmap and secret discovery, got %#v", relationshipRules[before:])
 	}
+	if !foundNamespacedReference {
+		t.Errorf("expected a reference with its own namespace to match by it, got %#v", relationshipRules[before:])
+	}
 
 	potentialKindsMutex.RLock()
 	podPotentials := append([]string(nil), potentialKindsCache["core.pods"]...)
@@ -227,6 +426,81 @@ func TestRelationshipInitializationDiscoversAndCachesRules(t *testing.T) {
 	}
 }
 
+// specCacheProvider keeps spec cache entries in memory, encoded like a disk
+// cache would.
+type specCacheProvider struct {
+	*hardeningProvider
+	entries map[string][]byte
+}
+
+func (p *specCacheProvider) LoadCached(name string, v interface{}) bool {
+	entry, ok := p.entries[name]
+	return ok && json.Unmarshal(entry, v) == nil
+}
+
+func (p *specCacheProvider) StoreCached(name string, v interface{}) error {
+	entry, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	p.entries[name] = entry
+	return nil
+}
+
+func TestRelationshipInitializationUsesSpecCache(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	provider := &specCacheProvider{hardeningProvider: newHardeningProvider(), entries: map[string][]byte{}}
+
+	oldRules := relationshipRules
+	oldPotentialKindsCache := potentialKindsCache
+	oldCleanOutput := CleanOutput
+	builtin := cloneRelationshipRules(relationshipRules)
+	relationshipRules = cloneRelationshipRules(builtin)
+	CleanOutput = true
+	defer func() {
+		relationshipRules = oldRules
+		potentialKindsCache = oldPotentialKindsCache
+		CleanOutput = oldCleanOutput
+	}()
+
+	specs := map[string][]string{
+		"io.k8s.api.core.v1.Pod":       {"spec.configMap.name", "spec.secretName", "spec.volumes.secret.secretName"},
+		"io.k8s.api.core.v1.ConfigMap": {"metadata.name"},
+		"io.k8s.api.core.v1.Secret":    {"metadata.name"},
+	}
+	InitializeRelationships(specs, provider)
+	if _, ok := provider.entries[relationshipsCacheEntry]; !ok {
+		t.Fatalf("expected the inferred relationships to be cached")
+	}
+	inferred := rulesSignature(relationshipRules)
+	if inferred == rulesSignature(builtin) {
+		t.Fatalf("expected relationships to be inferred from the specs")
+	}
+	potentialKindsMutex.RLock()
+	inferredKinds := fmt.Sprint(potentialKindsCache)
+	potentialKindsMutex.RUnlock()
+
+	// Without specs nothing can be inferred, so the rules come from the cache.
+	relationshipRules = cloneRelationshipRules(builtin)
+	InitializeRelationships(map[string][]string{}, provider)
+	if rulesSignature(relationshipRules) != inferred {
+		t.Errorf("expected the cached rules to be restored, got %#v", relationshipRules[len(builtin):])
+	}
+	potentialKindsMutex.RLock()
+	cachedKinds := fmt.Sprint(potentialKindsCache)
+	potentialKindsMutex.RUnlock()
+	if cachedKinds != inferredKinds {
+		t.Errorf("potential kinds = %s, want %s", cachedKinds, inferredKinds)
+	}
+
+	// Rules inferred from other built-in rules are not reused.
+	relationshipRules = cloneRelationshipRules(builtin[1:])
+	InitializeRelationships(map[string][]string{}, provider)
+	if len(relationshipRules) != len(builtin)-1 {
+		t.Errorf("expected no cached rules for changed built-in rules, got %d rules", len(relationshipRules))
+	}
+}
+
 func TestTemporalHandlerBranches(t *testing.T) {
 	handler := NewTemporalHandler()
 	base := "2026-06-07T10:00:00Z"
@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/AvitalTamir/jsonpath"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
 )
 
 func getResourcesFromMap(filteredResults map[string][]map[string]interface{}, key string, state *executionState) []map[string]interface{} {
@@ -47,13 +50,13 @@ func (q *QueryExecutor) processNodes(c *MatchClause, results *QueryResult, state
 			}
 			node.ResourceProperties.Kind = potentialKinds[0]
 		}
+	}
 
-		if _, ok := state.getResources(node.ResourceProperties.Name); !ok {
-			err := getNodeResources(node, q, c.ExtraFilters, state)
-			if err != nil {
-				return fmt.Errorf("error getting node resources >> %s", err)
-			}
-		}
+	if err := q.fetchNodeResources(c, state); err != nil {
+		return err
+	}
+
+	for _, node := range c.Nodes {
 		resources, ok := state.getResources(node.ResourceProperties.Name)
 		if !ok {
 			return fmt.Errorf("node %s resources were not loaded", node.ResourceProperties.Name)
@@ -95,6 +98,51 @@ func (q *QueryExecutor) processNodes(c *MatchClause, results *QueryResult, state
 	return nil
 }
 
+// fetchNodeResources loads the resources of every node that is not loaded
+// yet, in clause order. The nodes are independent, so with parallelFetch
+// they are fetched concurrently; errors are still reported for the first
+// failing node in clause order.
+func (q *QueryExecutor) fetchNodeResources(c *MatchClause, state *executionState) error {
+	var pending []*NodePattern
+	seen := make(map[string]bool)
+	for _, node := range c.Nodes {
+		name := node.ResourceProperties.Name
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if _, ok := state.getResources(name); !ok {
+			pending = append(pending, node)
+		}
+	}
+
+	errs := make([]error, len(pending))
+	if state.parallelFetch {
+		var wg sync.WaitGroup
+		for i, node := range pending {
+			wg.Add(1)
+			go func(i int, node *NodePattern) {
+				defer wg.Done()
+				errs[i] = getNodeResources(node, q, c.ExtraFilters, state)
+			}(i, node)
+		}
+		wg.Wait()
+	} else {
+		for i, node := range pending {
+			if errs[i] = getNodeResources(node, q, c.ExtraFilters, state); errs[i] != nil {
+				break
+			}
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return fmt.Errorf("error getting node resources >> %s", err)
+		}
+	}
+	return nil
+}
+
 func (q *QueryExecutor) findGVR(kind string) (schema.GroupVersionResource, error) {
 	return tryResolveGVR(q.provider, kind)
 }
@@ -117,53 +165,48 @@ func (q *QueryExecutor) providerKind(kind string) (string, error) {
 	return gvr.Resource + "." + gvr.Group, nil
 }
 
-func getNodeResources(n *NodePattern, q *QueryExecutor, extraFilters []*Filter, state *executionState) (err error) {
-	namespace := state.namespace
-
-	// Create a copy of ResourceProperties
-	resourcePropertiesCopy := &ResourceProperties{}
-	if n.ResourceProperties.Properties != nil {
-		resourcePropertiesCopy.Properties = &Properties{
-			PropertyList: make([]*Property, len(n.ResourceProperties.Properties.PropertyList)),
-		}
-		copy(resourcePropertiesCopy.Properties.PropertyList, n.ResourceProperties.Properties.PropertyList)
+// labelSelectorRequirement renders a node property as a Kubernetes label
+// selector requirement. Keys and values are validated, so a value can't add
+// requirements of its own to the selector.
+func labelSelectorRequirement(prop *Property) (string, error) {
+	var operator selection.Operator
+	var values []string
+	switch prop.Operator {
+	case "!=":
+		operator, values = selection.NotEquals, []string{fmt.Sprint(prop.Value)}
+	case "IN":
+		operator, values = selection.In, prop.Value.([]string)
+	case "NOT IN":
+		operator, values = selection.NotIn, prop.Value.([]string)
+	case "EXISTS":
+		operator = selection.Exists
+	case "NOT EXISTS":
+		operator = selection.DoesNotExist
+	default:
+		operator, values = selection.Equals, []string{fmt.Sprint(prop.Value)}
 	}
-
-	if resourcePropertiesCopy.Properties != nil && len(resourcePropertiesCopy.Properties.PropertyList) > 0 {
-		for i, prop := range resourcePropertiesCopy.Properties.PropertyList {
-			if prop.Key == "namespace" || prop.Key == "metadata.namespace" {
-				namespace = prop.Value.(string)
-				// Remove the namespace slice from the properties
-				resourcePropertiesCopy.Properties.PropertyList = append(resourcePropertiesCopy.Properties.PropertyList[:i], resourcePropertiesCopy.Properties.PropertyList[i+1:]...)
-			}
-		}
+	requirement, err := labels.NewRequirement(prop.Key, operator, values)
+	if err != nil {
+		return "", fmt.Errorf("invalid label selector on %q: %v", prop.Key, err)
 	}
+	return requirement.String(), nil
+}
 
-	var fieldSelector string
-	var labelSelector string
-	var hasNameSelector bool
-	var hasLabelSelector bool
-
-	if resourcePropertiesCopy.Properties != nil {
-		for _, prop := range resourcePropertiesCopy.Properties.PropertyList {
-			if prop.Key == "name" || prop.Key == "metadata.name" || prop.Key == `"name"` || prop.Key == `"metadata.name"` {
-				fieldSelector += fmt.Sprintf("metadata.name=%s,", prop.Value)
-				hasNameSelector = true
-			} else {
-				hasLabelSelector = true
-				labelSelector += fmt.Sprintf("%s=%s,", prop.Key, prop.Value)
-			}
-		}
-		fieldSelector = strings.TrimSuffix(fieldSelector, ",")
-		labelSelector = strings.TrimSuffix(labelSelector, ",")
+func getNodeResources(n *NodePattern, q *QueryExecutor, extraFilters []*Filter, state *executionState) (err error) {
+	plan, err := q.planNodeFetch(n, extraFilters, state.namespace)
+	if err != nil {
+		return err
 	}
-	if hasNameSelector && hasLabelSelector {
-		// both name and label selectors are specified, error out
-		return fmt.Errorf("the 'name' selector can be used by itself or combined with 'namespace', but not with other label selectors")
+	if n.ResourceProperties.Name == state.limitNode {
+		plan.applyListLimit(state.listLimit)
 	}
+	plan.applyProjection(state.projections[n.ResourceProperties.Name])
+	fieldSelector, labelSelector := plan.FieldSelector, plan.LabelSelector
+	extraFilters = plan.filters
+	debugLog("Fetching %s with fieldSelector=%q labelSelector=%q, pushed down: %v", n.ResourceProperties.Name, fieldSelector, labelSelector, plan.PushedDown)
 
 	// Check if the resource has already been fetched for this exact query shape.
-	cacheKey, err := q.resourceFetchKey(n, namespace, fieldSelector, labelSelector, extraFilters)
+	cacheKey, err := q.resourceFetchKey(n, plan.namespaceKey(), fieldSelector, labelSelector, extraFilters)
 	if err != nil {
 		return fmt.Errorf("error getting resource fetch key: %v", err)
 	}
@@ -174,7 +217,7 @@ func getNodeResources(n *NodePattern, q *QueryExecutor, extraFilters []*Filter,
 		if err != nil {
 			return fmt.Errorf("error resolving resource kind: %v", err)
 		}
-		resources, err := q.provider.GetK8sResources(providerKind, fieldSelector, labelSelector, namespace)
+		resources, err := q.listResources(state.ctx, providerKind, plan)
 		if err != nil {
 			return fmt.Errorf("error getting resources: %v", err)
 		}
@@ -184,113 +227,21 @@ func getNodeResources(n *NodePattern, q *QueryExecutor, extraFilters []*Filter,
 		if !ok {
 			return fmt.Errorf("provider returned %T for %s, expected []map[string]interface{}", resources, n.ResourceProperties.Kind)
 		}
+		if !plan.ManagedFields {
+			resourceList = stripManagedFields(resourceList)
+		}
 		var filtered []map[string]interface{}
 
 		var subMatches []*SubMatch
 
 		// Process each resource
 		for _, resource := range resourceList {
-			keep := true
-			// Apply extra filters
 			for _, extraFilter := range extraFilters {
-				if extraFilter.Type == "KeyValuePair" {
-					filter := extraFilter.KeyValuePair
-					// Extract node name from filter key
-					var resultMapKey string
-					dotIndex := strings.Index(filter.Key, ".")
-					if dotIndex != -1 {
-						resultMapKey = filter.Key[:dotIndex]
-					} else {
-						resultMapKey = filter.Key
-					}
-
-					// Handle escaped dots
-					for strings.HasSuffix(resultMapKey, "\\") {
-						nextDotIndex := strings.Index(filter.Key[len(resultMapKey)+1:], ".")
-						if nextDotIndex == -1 {
-							resultMapKey = filter.Key
-							break
-						}
-						resultMapKey = filter.Key[:len(resultMapKey)+1+nextDotIndex]
-					}
-
-					if resultMapKey == n.ResourceProperties.Name {
-						// Transform path
-						path := filter.Key
-						path = strings.Replace(path, resultMapKey+".", "$.", 1)
-
-						// Compile and fix the path
-						compiledPath, err := jsonpath.Compile(path)
-						if err != nil {
-							keep = false
-							break
-						}
-						compiledPath = fixCompiledPath(compiledPath)
-
-						debugLog("Looking up path: %s in resource: %+v", path, resource)
-
-						// If path contains wildcards, we need special handling
-						if strings.Contains(path, "[*]") {
-							keep = evaluateWildcardPath(resource, path, filter.Value, filter.Operator)
-							if filter.IsNegated {
-								keep = !keep
-							}
-						} else {
-							// Regular path handling using the fixed compiled path
-							value, err := compiledPath.Lookup(resource)
-							if err != nil {
-								keep = false
-								break
-							}
-
-							// Check if the filter value is a temporal expression
-							if temporalExpr, ok := filter.Value.(*TemporalExpression); ok {
-								// Convert resource value to time.Time if it's a string
-								var resourceTime time.Time
-								if timeStr, ok := value.(string); ok {
-									resourceTime, err = time.Parse(time.RFC3339, timeStr)
-									if err != nil {
-										keep = false
-										break
-									}
-								} else {
-									keep = false
-									break
-								}
-
-								// Use temporal handler to compare values
-								temporalHandler := NewTemporalHandler()
-								keep, err = temporalHandler.CompareTemporalValues(resourceTime, temporalExpr, filter.Operator)
-								if err != nil {
-									keep = false
-									break
-								}
-							} else {
-								// Regular value comparison
-								resourceValue, filterValue, err := convertToComparableTypes(value, filter.Value)
-								if err != nil {
-									keep = false
-									break
-								}
-
-								keep = compareValues(resourceValue, filterValue, filter.Operator)
-							}
-
-							if filter.IsNegated {
-								keep = !keep
-							}
-						}
-
-						if !keep {
-							break
-						}
-					}
-				} else if extraFilter.Type == "SubMatch" {
+				if extraFilter.Type == "SubMatch" {
 					subMatches = append(subMatches, extraFilter.SubMatch)
 				}
 			}
-
-			if keep {
+			if matchesFilters(resource, n.ResourceProperties.Name, extraFilters) {
 				filtered = append(filtered, resource)
 			}
 		}
@@ -333,6 +284,109 @@ func getNodeResources(n *NodePattern, q *QueryExecutor, extraFilters []*Filter,
 	return nil
 }
 
+// matchesFilters reports whether resource, bound to nodeName, satisfies the
+// WHERE comparisons on that node. Filters on other nodes and sub-matches are
+// not evaluated.
+func matchesFilters(resource map[string]interface{}, nodeName string, filters []*Filter) bool {
+	keep := true
+	for _, extraFilter := range filters {
+		if extraFilter.Type == "KeyValuePair" {
+			filter := extraFilter.KeyValuePair
+			// Extract node name from filter key
+			var resultMapKey string
+			dotIndex := strings.Index(filter.Key, ".")
+			if dotIndex != -1 {
+				resultMapKey = filter.Key[:dotIndex]
+			} else {
+				resultMapKey = filter.Key
+			}
+
+			// Handle escaped dots
+			for strings.HasSuffix(resultMapKey, "\\") {
+				nextDotIndex := strings.Index(filter.Key[len(resultMapKey)+1:], ".")
+				if nextDotIndex == -1 {
+					resultMapKey = filter.Key
+					break
+				}
+				resultMapKey = filter.Key[:len(resultMapKey)+1+nextDotIndex]
+			}
+
+			if resultMapKey == nodeName {
+				// Transform path
+				path := filter.Key
+				path = strings.Replace(path, resultMapKey+".", "$.", 1)
+
+				// Compile and fix the path
+				compiledPath, err := jsonpath.Compile(path)
+				if err != nil {
+					keep = false
+					break
+				}
+				compiledPath = fixCompiledPath(compiledPath)
+
+				debugLog("Looking up path: %s in resource: %+v", path, resource)
+
+				// If path contains wildcards, we need special handling
+				if strings.Contains(path, "[*]") {
+					keep = evaluateWildcardPath(resource, path, filter.Value, filter.Operator)
+					if filter.IsNegated {
+						keep = !keep
+					}
+				} else {
+					// Regular path handling using the fixed compiled path
+					value, err := compiledPath.Lookup(resource)
+					if err != nil {
+						keep = false
+						break
+					}
+
+					// Check if the filter value is a temporal expression
+					if temporalExpr, ok := filter.Value.(*TemporalExpression); ok {
+						// Convert resource value to time.Time if it's a string
+						var resourceTime time.Time
+						if timeStr, ok := value.(string); ok {
+							resourceTime, err = time.Parse(time.RFC3339, timeStr)
+							if err != nil {
+								keep = false
+								break
+							}
+						} else {
+							keep = false
+							break
+						}
+
+						// Use temporal handler to compare values
+						temporalHandler := NewTemporalHandler()
+						keep, err = temporalHandler.CompareTemporalValues(resourceTime, temporalExpr, filter.Operator)
+						if err != nil {
+							keep = false
+							break
+						}
+					} else {
+						// Regular value comparison
+						resourceValue, filterValue, err := convertToComparableTypes(value, filter.Value)
+						if err != nil {
+							keep = false
+							break
+						}
+
+						keep = compareValues(resourceValue, filterValue, filter.Operator)
+					}
+
+					if filter.IsNegated {
+						keep = !keep
+					}
+				}
+
+				if !keep {
+					break
+				}
+			}
+		}
+	}
+	return keep
+}
+
 func compareValues(resourceValue, filterValue interface{}, operator string) bool {
 	switch operator {
 	case "EQUALS", "=", "==":
@@ -382,6 +436,7 @@ func compareValues(resourceValue, filterValue interface{}, operator string) bool
 func (q *QueryExecutor) checkSubMatch(subMatch *SubMatch, referenceNodeName string, state *executionState) (map[string][]map[string]interface{}, error) {
 	subMatch = cloneSubMatch(subMatch)
 	subState := newExecutionState()
+	subState.ctx = state.ctx
 	subState.namespace = state.namespace
 
 	// Create temporary results and filtered results maps
@@ -160,7 +160,7 @@ func TestPatchJsonPathAndSetHelpers(t *testing.T) {
 	}
 
 	patches = createCompatiblePatch([]string{"spec", "template", "spec", "containers[0]", "resources", "limits", "cpu"}, "500m")
-	if patchPath(t, patches, 1) != "/spec/template/spec/containers/0/resources/limits/cpu" {
+	if len(patches) != 1 || patchPath(t, patches, 0) != "/spec/template/spec/containers/0/resources/limits/cpu" {
 		t.Fatalf("unexpected container patch: %#v", patches)
 	}
 
@@ -355,10 +355,16 @@ func TestRelationshipStateAndComparisonHelpers(t *testing.T) {
 		t.Fatalf("unexpected relationships: %#v", gotRelationships)
 	}
 
-	if !containsResource([]map[string]interface{}{testPod("pod-a", "default", "a", 1)}, testPod("pod-a", "default", "b", 1)) {
-		t.Fatal("expected containsResource to match by name and namespace")
+	var set resourceSet
+	set.add(testPod("pod-a", "default", "a", 1))
+	set.add(testPod("pod-a", "default", "b", 1))
+	if len(set.resources) != 1 {
+		t.Fatal("expected resourceSet to match by name and namespace")
 	}
-	if containsResource([]map[string]interface{}{{"kind": "Pod"}}, testPod("pod-a", "default", "a", 1)) {
+	set = resourceSet{}
+	set.add(map[string]interface{}{"kind": "Pod"})
+	set.add(testPod("pod-a", "default", "a", 1))
+	if len(set.resources) != 2 {
 		t.Fatal("did not expect malformed resource to match")
 	}
 }
@@ -2,6 +2,7 @@ package core
 
 import (
 	"strings"
+	"sync"
 
 	"github.com/AvitalTamir/jsonpath"
 )
@@ -51,9 +52,21 @@ func splitEscapedPath(path string) []string {
 	var result []string
 	var current strings.Builder
 	escaped := false
+	// Dots inside list item selectors, e.g. env[name="a.b"], don't split.
+	inSelector, quoted := false, false
 
 	for i := 0; i < len(path); i++ {
-		if escaped {
+		if inSelector {
+			current.WriteByte(path[i])
+			if path[i] == '"' {
+				quoted = !quoted
+			} else if path[i] == ']' && !quoted {
+				inSelector = false
+			}
+		} else if path[i] == '[' {
+			inSelector = true
+			current.WriteByte('[')
+		} else if escaped {
 			// If we're in escaped mode and see a dot, add it to current
 			if path[i] == '.' {
 				current.WriteByte('.')
@@ -167,14 +180,33 @@ func fixCompiledPath(compiledPath *jsonpath.Compiled) *jsonpath.Compiled {
 	return compiledPath
 }
 
+// compiledJSONPaths caches compileJSONPath's results by query.
+var compiledJSONPaths sync.Map
+
+// compileJSONPath compiles the given query and fixes escape characters in it.
+// Queries are compiled once: relationship rules and filters look the same
+// paths up in every resource.
+func compileJSONPath(query string) (*jsonpath.Compiled, error) {
+	if compiled, ok := compiledJSONPaths.Load(query); ok {
+		return compiled.(*jsonpath.Compiled), nil
+	}
+	compiled, err := jsonpath.Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	compiled = fixCompiledPath(compiled)
+	compiledJSONPaths.Store(query, compiled)
+	return compiled, nil
+}
+
 // JsonPathCompileAndLookup compiles the given query, fixes escape characters in the query and executes
 // the query to return the value of the query.
 func JsonPathCompileAndLookup(resource interface{}, query string) (interface{}, error) {
-	pathA, err := jsonpath.Compile(query)
+	pathA, err := compileJSONPath(query)
 	if err != nil {
 		return nil, err
 	}
-	queriedValue, err := fixCompiledPath(pathA).Lookup(resource)
+	queriedValue, err := pathA.Lookup(resource)
 	if err != nil {
 		return nil, err
 	}
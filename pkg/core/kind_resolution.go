@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/avitaltamir/cyphernetes/pkg/provider"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 // FindPotentialKinds returns all possible target kinds that could have a relationship with the given source kind
@@ -84,6 +85,70 @@ func FindPotentialKinds(sourceKind string, provider provider.Provider) ([]string
 	return result, nil
 }
 
+// FindPotentialOwnerKinds returns the kinds that may own or be owned by
+// sourceKind. Owner references are matched between any two kinds, so for
+// providers that support discovery these are all the listable kinds, CRDs
+// included. Other providers only know the kinds relationship rules matching
+// owner references relate sourceKind to.
+func FindPotentialOwnerKinds(sourceKind string, provider provider.Provider) ([]string, error) {
+	gvr, err := tryResolveGVR(provider, strings.ToLower(sourceKind))
+	if err != nil {
+		return []string{}, fmt.Errorf("error getting GVR for %s: %v", sourceKind, err)
+	}
+
+	potentialKinds := make(map[string]bool)
+	for _, kind := range knownResourceKinds(provider) {
+		if gvrKind, err := tryResolveGVR(provider, kind); err == nil {
+			potentialKinds[fullKindName(gvrKind)] = true
+		}
+	}
+	for _, rule := range GetRelationshipRules() {
+		if !matchesOwnerReferences(rule) {
+			continue
+		}
+		gvrA, err := tryResolveGVR(provider, rule.KindA)
+		if err != nil {
+			continue
+		}
+		gvrB, err := tryResolveGVR(provider, rule.KindB)
+		if err != nil {
+			continue
+		}
+		if gvrA.GroupResource() == gvr.GroupResource() {
+			potentialKinds[fullKindName(gvrB)] = true
+		}
+		if gvrB.GroupResource() == gvr.GroupResource() {
+			potentialKinds[fullKindName(gvrA)] = true
+		}
+	}
+
+	result := make([]string, 0, len(potentialKinds))
+	for kind := range potentialKinds {
+		result = append(result, kind)
+	}
+	sort.Strings(result)
+	debugLog("FindPotentialOwnerKinds: final result for %s = %v", sourceKind, result)
+	return result, nil
+}
+
+func matchesOwnerReferences(rule RelationshipRule) bool {
+	for _, criterion := range rule.MatchCriteria {
+		if criterion.ComparisonType == OwnerReference {
+			return true
+		}
+	}
+	return false
+}
+
+// fullKindName names the resource of gvr the way the potential kinds cache
+// does, like "replicasets.apps" or "core.pods".
+func fullKindName(gvr schema.GroupVersionResource) string {
+	if gvr.Group == "" {
+		return "core." + gvr.Resource
+	}
+	return gvr.Resource + "." + gvr.Group
+}
+
 // FindPotentialKindsIntersection returns the intersection of possible kinds from multiple relationships
 func FindPotentialKindsIntersection(relationships []*Relationship, provider provider.Provider) ([]string, error) {
 	debugLog("FindPotentialKindsIntersection: Starting with relationships: %+v", relationships)
@@ -107,15 +172,20 @@ func FindPotentialKindsIntersection(relationships []*Relationship, provider prov
 		return []string{}, nil
 	}
 
-	// Find all known kinds in the relationships
+	// Find all known kinds in the relationships. A known kind in a
+	// relationship labeled OWNS only relates to the kinds it may own or be
+	// owned by.
 	knownKinds := make(map[string]bool)
 	for _, rel := range relationships {
+		owns := isOwnsRelationship(rel)
 		if rel.LeftNode.ResourceProperties.Kind != "" {
-			knownKinds[strings.ToLower(rel.LeftNode.ResourceProperties.Kind)] = true
+			kind := strings.ToLower(rel.LeftNode.ResourceProperties.Kind)
+			knownKinds[kind] = knownKinds[kind] || owns
 			debugLog("FindPotentialKindsIntersection: Found known kind (left): %s", rel.LeftNode.ResourceProperties.Kind)
 		}
 		if rel.RightNode.ResourceProperties.Kind != "" {
-			knownKinds[strings.ToLower(rel.RightNode.ResourceProperties.Kind)] = true
+			kind := strings.ToLower(rel.RightNode.ResourceProperties.Kind)
+			knownKinds[kind] = knownKinds[kind] || owns
 			debugLog("FindPotentialKindsIntersection: Found known kind (right): %s", rel.RightNode.ResourceProperties.Kind)
 		}
 	}
@@ -135,7 +205,7 @@ func FindPotentialKindsIntersection(relationships []*Relationship, provider prov
 	}
 
 	result := make(map[string]bool)
-	initialPotentialKinds, err := FindPotentialKinds(firstKnownKind, provider)
+	initialPotentialKinds, err := findPotentialKinds(firstKnownKind, knownKinds[firstKnownKind], provider)
 	if err != nil {
 		return nil, fmt.Errorf("%s", err)
 	}
@@ -150,7 +220,7 @@ func FindPotentialKindsIntersection(relationships []*Relationship, provider prov
 			continue
 		}
 
-		potentialKinds, err := FindPotentialKinds(kind, provider)
+		potentialKinds, err := findPotentialKinds(kind, knownKinds[kind], provider)
 		if err != nil {
 			return nil, fmt.Errorf("unable to determine kind for nodes in relationship >> %s", err)
 		}
@@ -177,6 +247,13 @@ func FindPotentialKindsIntersection(relationships []*Relationship, provider prov
 	return kinds, nil
 }
 
+func findPotentialKinds(kind string, owns bool, provider provider.Provider) ([]string, error) {
+	if owns {
+		return FindPotentialOwnerKinds(kind, provider)
+	}
+	return FindPotentialKinds(kind, provider)
+}
+
 // Helper function to check if a string slice contains a string
 func contains(slice []string, str string) bool {
 	for _, s := range slice {
@@ -166,6 +166,24 @@ func TestLexer(t *testing.T) {
 				{Type: EOF, Literal: ""},
 			},
 		},
+		{
+			name:  "version-pinned resource kinds",
+			input: "(h:HorizontalPodAutoscaler.autoscaling/v2), (c:certificates.cert-manager.io/v1beta1)",
+			expected: []Token{
+				{Type: LPAREN, Literal: "("},
+				{Type: IDENT, Literal: "h"},
+				{Type: COLON, Literal: ":"},
+				{Type: IDENT, Literal: "HorizontalPodAutoscaler.autoscaling/v2"},
+				{Type: RPAREN, Literal: ")"},
+				{Type: COMMA, Literal: ","},
+				{Type: LPAREN, Literal: "("},
+				{Type: IDENT, Literal: "c"},
+				{Type: COLON, Literal: ":"},
+				{Type: IDENT, Literal: "certificates.cert-manager.io/v1beta1"},
+				{Type: RPAREN, Literal: ")"},
+				{Type: EOF, Literal: ""},
+			},
+		},
 		{
 			name:  "array wildcards",
 			input: "containers[*].image, volumes[0].name, mounts[*].path[*]",
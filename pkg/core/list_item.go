@@ -0,0 +1,308 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// listItemKeys are the merge keys of the well-known Kubernetes lists of
+// objects, by field name. Lists named "ports" are keyed by port and protocol
+// in Services and by containerPort and protocol in containers.
+var listItemKeys = map[string][]string{
+	"containers":                {"name"},
+	"initContainers":            {"name"},
+	"ephemeralContainers":       {"name"},
+	"env":                       {"name"},
+	"volumes":                   {"name"},
+	"volumeMounts":              {"mountPath"},
+	"volumeDevices":             {"devicePath"},
+	"ports":                     {"containerPort", "port", "protocol"},
+	"imagePullSecrets":          {"name"},
+	"hostAliases":               {"ip"},
+	"conditions":                {"type"},
+	"topologySpreadConstraints": {"topologyKey", "whenUnsatisfiable"},
+	"resourceClaims":            {"name"},
+	"claims":                    {"name"},
+}
+
+// listItemSelector addresses a list item by key, as in env[name="LOG_LEVEL"].
+type listItemSelector struct {
+	keys   []string
+	values []interface{}
+	// create appends the item when no item matches, as in env[name="LOG_LEVEL"]+.
+	create bool
+}
+
+func (s *listItemSelector) String() string {
+	parts := make([]string, len(s.keys))
+	for i, key := range s.keys {
+		parts[i] = fmt.Sprintf("%s=%v", key, formatSelectorValue(s.values[i]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *listItemSelector) matches(item map[string]interface{}) bool {
+	for i, key := range s.keys {
+		value, ok := item[key]
+		if !ok || fmt.Sprint(value) != fmt.Sprint(s.values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	listItemSelectorPattern = regexp.MustCompile(`\[[A-Za-z_]`)
+	listSegmentPattern      = regexp.MustCompile(`^([^\[]+)\[(.*)\](\+?)$`)
+)
+
+// hasListItemSelector reports whether a path addresses a list item by key.
+func hasListItemSelector(path string) bool {
+	return listItemSelectorPattern.MatchString(path)
+}
+
+// parseListSegment splits a path segment into its field and, for list items,
+// the index or key selector. index is -1 unless the segment has an index.
+func parseListSegment(segment string) (field string, index int, selector *listItemSelector, err error) {
+	matches := listSegmentPattern.FindStringSubmatch(segment)
+	if matches == nil {
+		return segment, -1, nil, nil
+	}
+	field, inner, create := matches[1], matches[2], matches[3] == "+"
+	if n, err := strconv.Atoi(inner); err == nil {
+		if create {
+			return "", -1, nil, fmt.Errorf("\"+\" is only allowed after a key selector, got %s", segment)
+		}
+		return field, n, nil, nil
+	}
+
+	selector = &listItemSelector{create: create}
+	for _, pair := range splitSelectorPairs(inner) {
+		key, raw, ok := strings.Cut(pair, "=")
+		if !ok || key == "" || raw == "" {
+			return "", -1, nil, fmt.Errorf("invalid list item selector %s", segment)
+		}
+		selector.keys = append(selector.keys, key)
+		selector.values = append(selector.values, parseSelectorValue(raw))
+	}
+	return field, -1, selector, nil
+}
+
+// splitSelectorPairs splits a selector on the commas outside quoted values.
+func splitSelectorPairs(selector string) []string {
+	var pairs []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range selector {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case r == ',' && !quoted:
+			pairs = append(pairs, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(pairs, current.String())
+}
+
+func parseSelectorValue(raw string) interface{} {
+	if len(raw) >= 2 && strings.HasPrefix(raw, "\"") && strings.HasSuffix(raw, "\"") {
+		return raw[1 : len(raw)-1]
+	}
+	if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(raw, 64); err == nil {
+		return f
+	}
+	if b, err := strconv.ParseBool(raw); err == nil {
+		return b
+	}
+	return raw
+}
+
+func formatSelectorValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(value)
+}
+
+// createSetPatch builds the JSON patch that sets path to value in resource.
+// List items addressed by key selector are resolved to their index in
+// resource. Every item the patch goes through is guarded by "test"
+// operations on its keys, so the patch fails instead of changing the wrong
+// item when the list changed after resource was read.
+func createSetPatch(resource map[string]interface{}, path []string, value interface{}) ([]interface{}, error) {
+	var guards []interface{}
+	var tokens []string
+	resolved := make([]string, 0, len(path))
+	var current interface{} = resource
+	replaceItem := false
+
+	for i, segment := range path {
+		field, index, selector, err := parseListSegment(segment)
+		if err != nil {
+			return nil, err
+		}
+		parent, _ := current.(map[string]interface{})
+		if index < 0 && selector == nil {
+			resolved = append(resolved, segment)
+			tokens = append(tokens, field)
+			current = parent[field]
+			replaceItem = false
+			continue
+		}
+
+		list, _ := parent[field].([]interface{})
+		listPath := strings.Join(append(resolved, field), ".")
+		var keys []string
+		if selector != nil {
+			index = -1
+			for j, item := range list {
+				if m, ok := item.(map[string]interface{}); ok && selector.matches(m) {
+					if index >= 0 {
+						return nil, fmt.Errorf("more than one item in %s matches [%s]", listPath, selector)
+					}
+					index = j
+				}
+			}
+			if index < 0 {
+				if !selector.create {
+					return nil, fmt.Errorf("no item in %s matches [%s]; append \"+\" to the selector to create it", listPath, selector)
+				}
+				return appendListItem(guards, append(tokens, field), list != nil, selector, path[i+1:], value)
+			}
+			keys = selector.keys
+		}
+
+		resolved = append(resolved, fmt.Sprintf("%s[%d]", field, index))
+		tokens = append(tokens, field, strconv.Itoa(index))
+		if index >= len(list) {
+			// Left to the backend to reject, as before key selectors.
+			current = nil
+			replaceItem = false
+			continue
+		}
+		item, _ := list[index].(map[string]interface{})
+		if keys == nil {
+			keys = guardKeys(field, item)
+		}
+		for _, key := range keys {
+			guards = append(guards, map[string]interface{}{
+				"op":    "test",
+				"path":  jsonPointer(append(tokens, key)),
+				"value": item[key],
+			})
+		}
+		current = list[index]
+		replaceItem = true
+	}
+
+	if replaceItem {
+		// "add" inserts into lists; an existing item is replaced.
+		return append(guards, map[string]interface{}{
+			"op":    "replace",
+			"path":  jsonPointer(tokens),
+			"value": value,
+		}), nil
+	}
+	return append(guards, createCompatiblePatch(resolved, value)...), nil
+}
+
+// guardKeys returns the keys an item addressed by index is guarded by: the
+// merge keys of well-known lists, or its name.
+func guardKeys(field string, item map[string]interface{}) []string {
+	var keys []string
+	for _, key := range listItemKeys[field] {
+		if _, ok := item[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 && len(listItemKeys[field]) == 0 {
+		if _, ok := item["name"].(string); ok {
+			keys = append(keys, "name")
+		}
+	}
+	return keys
+}
+
+// appendListItem returns the patch that appends the item a selector with
+// "+" addresses, holding its keys and value at the rest of the path.
+func appendListItem(guards []interface{}, tokens []string, listExists bool, selector *listItemSelector, rest []string, value interface{}) ([]interface{}, error) {
+	item, err := newListItem(selector, rest, value)
+	if err != nil {
+		return nil, err
+	}
+	if listExists {
+		return append(guards, map[string]interface{}{
+			"op":    "add",
+			"path":  jsonPointer(append(tokens, "-")),
+			"value": item,
+		}), nil
+	}
+	return append(guards, map[string]interface{}{
+		"op":    "add",
+		"path":  jsonPointer(tokens),
+		"value": []interface{}{item},
+	}), nil
+}
+
+func newListItem(selector *listItemSelector, rest []string, value interface{}) (map[string]interface{}, error) {
+	item := map[string]interface{}{}
+	if len(rest) == 0 {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("a new list item must be set to an object, got %v", value)
+		}
+		for k, v := range fields {
+			item[k] = v
+		}
+	} else {
+		field, index, nested, err := parseListSegment(rest[0])
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case index >= 0:
+			return nil, fmt.Errorf("cannot address %s in a new list item", rest[0])
+		case nested != nil:
+			if !nested.create {
+				return nil, fmt.Errorf("no item matches [%s] in a new list item; append \"+\" to the selector to create it", nested)
+			}
+			child, err := newListItem(nested, rest[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			item[field] = []interface{}{child}
+		case len(rest) == 1:
+			item[field] = value
+		default:
+			child, err := newListItem(&listItemSelector{}, rest[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			item[field] = child
+		}
+	}
+	for i, key := range selector.keys {
+		item[key] = selector.values[i]
+	}
+	return item, nil
+}
+
+// jsonPointer joins tokens into a JSON pointer, escaping "~" and "/".
+func jsonPointer(tokens []string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteByte('/')
+		token = strings.ReplaceAll(token, "~", "~0")
+		b.WriteString(strings.ReplaceAll(token, "/", "~1"))
+	}
+	return b.String()
+}
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseListItemSelectors(t *testing.T) {
+	ast, err := ParseQuery(`MATCH (d:Deployment) SET d.spec.template.spec.containers[name="nginx"].env[name="LOG_LEVEL"]+.value = "debug", d.spec.template.spec.containers[name = "nginx"].ports[containerPort=80, protocol="TCP"].name = "http" RETURN d`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	set := ast.Clauses[1].(*SetClause)
+	want := []string{
+		`d.spec.template.spec.containers[name="nginx"].env[name="LOG_LEVEL"]+.value`,
+		`d.spec.template.spec.containers[name="nginx"].ports[containerPort=80,protocol="TCP"].name`,
+	}
+	for i, kvp := range set.KeyValuePairs {
+		if kvp.Key != want[i] {
+			t.Errorf("key %d = %s, want %s", i, kvp.Key, want[i])
+		}
+	}
+
+	for query, wantErr := range map[string]string{
+		`MATCH (d:Deployment) WHERE d.spec.template.spec.containers[name="nginx"].image = "nginx" RETURN d`: "only supported in SET",
+		`MATCH (d:Deployment) SET d.spec.template.spec.containers[0]+.image = "nginx" RETURN d`:             `"+" is only allowed after a key selector`,
+		`MATCH (d:Deployment) SET d.spec.template.spec.containers[name=].image = "nginx" RETURN d`:          "expected string, number or boolean",
+	} {
+		if _, err := ParseQuery(query); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("ParseQuery(%s) error = %v, want error containing %q", query, err, wantErr)
+		}
+	}
+}
+
+func TestSplitEscapedPathKeepsSelectorsWhole(t *testing.T) {
+	got := splitEscapedPath(`spec.containers[name="a.b"].env[name="X"]+.value`)
+	want := []string{"spec", `containers[name="a.b"]`, `env[name="X"]+`, "value"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitEscapedPath() = %q, want %q", got, want)
+	}
+}
+
+func listItemDeployment() map[string]interface{} {
+	return map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "app", "image": "app:1"},
+						map[string]interface{}{
+							"name":  "nginx",
+							"image": "nginx:1",
+							"env":   []interface{}{map[string]interface{}{"name": "LOG_LEVEL", "value": "info"}},
+							"ports": []interface{}{map[string]interface{}{"containerPort": int64(80), "protocol": "TCP"}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestCreateSetPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		value interface{}
+		want  string
+	}{
+		{
+			name:  "key selector",
+			path:  `spec.template.spec.containers[name="nginx"].image`,
+			value: "nginx:2",
+			want: `[{"op":"test","path":"/spec/template/spec/containers/1/name","value":"nginx"},` +
+				`{"op":"add","path":"/spec/template/spec/containers/1/image","value":"nginx:2"}]`,
+		},
+		{
+			name:  "nested key selectors",
+			path:  `spec.template.spec.containers[name="nginx"].env[name="LOG_LEVEL"].value`,
+			value: "debug",
+			want: `[{"op":"test","path":"/spec/template/spec/containers/1/name","value":"nginx"},` +
+				`{"op":"test","path":"/spec/template/spec/containers/1/env/0/name","value":"LOG_LEVEL"},` +
+				`{"op":"add","path":"/spec/template/spec/containers/1/env/0/value","value":"debug"}]`,
+		},
+		{
+			name:  "numeric key",
+			path:  `spec.template.spec.containers[name="nginx"].ports[containerPort=80].name`,
+			value: "http",
+			want: `[{"op":"test","path":"/spec/template/spec/containers/1/name","value":"nginx"},` +
+				`{"op":"test","path":"/spec/template/spec/containers/1/ports/0/containerPort","value":80},` +
+				`{"op":"add","path":"/spec/template/spec/containers/1/ports/0/name","value":"http"}]`,
+		},
+		{
+			name:  "index guarded by merge keys",
+			path:  `spec.template.spec.containers[1].ports[0].name`,
+			value: "http",
+			want: `[{"op":"test","path":"/spec/template/spec/containers/1/name","value":"nginx"},` +
+				`{"op":"test","path":"/spec/template/spec/containers/1/ports/0/containerPort","value":80},` +
+				`{"op":"test","path":"/spec/template/spec/containers/1/ports/0/protocol","value":"TCP"},` +
+				`{"op":"add","path":"/spec/template/spec/containers/1/ports/0/name","value":"http"}]`,
+		},
+		{
+			name:  "append to existing list",
+			path:  `spec.template.spec.containers[name="nginx"].env[name="DEBUG"]+.value`,
+			value: "1",
+			want: `[{"op":"test","path":"/spec/template/spec/containers/1/name","value":"nginx"},` +
+				`{"op":"add","path":"/spec/template/spec/containers/1/env/-","value":{"name":"DEBUG","value":"1"}}]`,
+		},
+		{
+			name:  "create missing list",
+			path:  `spec.template.spec.containers[name="app"].env[name="DEBUG"]+.valueFrom.configMapKeyRef.key`,
+			value: "debug",
+			want: `[{"op":"test","path":"/spec/template/spec/containers/0/name","value":"app"},` +
+				`{"op":"add","path":"/spec/template/spec/containers/0/env","value":[{"name":"DEBUG","valueFrom":{"configMapKeyRef":{"key":"debug"}}}]}]`,
+		},
+		{
+			name:  "replace item",
+			path:  `spec.template.spec.containers[name="app"]`,
+			value: map[string]interface{}{"name": "app", "image": "app:2"},
+			want: `[{"op":"test","path":"/spec/template/spec/containers/0/name","value":"app"},` +
+				`{"op":"replace","path":"/spec/template/spec/containers/0","value":{"image":"app:2","name":"app"}}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patches, err := createSetPatch(listItemDeployment(), splitEscapedPath(tt.path), tt.value)
+			if err != nil {
+				t.Fatalf("createSetPatch() error = %v", err)
+			}
+			got, _ := json.Marshal(patches)
+			if string(got) != tt.want {
+				t.Errorf("createSetPatch() = %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateSetPatchErrors(t *testing.T) {
+	tests := map[string]string{
+		`spec.template.spec.containers[name="sidecar"].image`:                             "no item in spec.template.spec.containers matches [name=\"sidecar\"]",
+		`spec.template.spec.containers[image="nginx:1",name="nginx"].env[name="X"].value`: "no item in spec.template.spec.containers[1].env matches",
+		`spec.template.spec.containers[name="sidecar"]+.env[name="X"].value`:              "append \"+\" to the selector",
+		`spec.template.spec.containers[name="sidecar"]+`:                                  "must be set to an object",
+	}
+	for path, wantErr := range tests {
+		if _, err := createSetPatch(listItemDeployment(), splitEscapedPath(path), "x"); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("createSetPatch(%s) error = %v, want error containing %q", path, err, wantErr)
+		}
+	}
+
+	resource := listItemDeployment()
+	containers := resource["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+	containers["containers"] = append(containers["containers"].([]interface{}), map[string]interface{}{"name": "nginx"})
+	if _, err := createSetPatch(resource, splitEscapedPath(`spec.template.spec.containers[name="nginx"].image`), "x"); err == nil || !strings.Contains(err.Error(), "more than one item") {
+		t.Errorf("createSetPatch() error = %v, want an ambiguity error", err)
+	}
+}
+
+func TestSetByKeySelector(t *testing.T) {
+	provider := newHardeningProvider()
+	executor, _ := NewQueryExecutor(provider)
+
+	executeTestQuery(t, executor, `MATCH (d:Deployment {name: "deploy-a"}) SET d.spec.template.spec.containers[name="main"].image = "nginx:2" RETURN d.metadata.name AS name`)
+	if len(provider.patches) != 1 {
+		t.Fatalf("expected one patch, got %v", provider.patches)
+	}
+	want := `[{"op":"test","path":"/spec/template/spec/containers/0/name","value":"main"},{"op":"add","path":"/spec/template/spec/containers/0/image","value":"nginx:2"}]`
+	if !strings.HasSuffix(provider.patches[0], want) {
+		t.Errorf("patch = %s, want %s", provider.patches[0], want)
+	}
+
+	ast, err := ParseQuery(`MATCH (d:Deployment {name: "deploy-a"}) SET d.spec.template.spec.containers[name="sidecar"].image = "nginx:2" RETURN d`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	if _, err := executor.Execute(ast, "default"); err == nil || !strings.Contains(err.Error(), "no item") {
+		t.Errorf("Execute() error = %v, want a missing item error", err)
+	}
+}
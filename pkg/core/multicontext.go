@@ -115,6 +115,46 @@ func prefixMatchClause(c *MatchClause, context string) *MatchClause {
 		}
 	}
 
+	// Prefix shortest path endpoints, reusing the prefixed nodes above
+	for _, sp := range c.ShortestPaths {
+		prefixed := &ShortestPath{All: sp.All, MaxDepth: sp.MaxDepth}
+		for _, node := range modified.Nodes {
+			switch node.ResourceProperties.Name {
+			case context + "_" + sp.Start.ResourceProperties.Name:
+				prefixed.Start = node
+			case context + "_" + sp.End.ResourceProperties.Name:
+				prefixed.End = node
+			}
+		}
+		modified.ShortestPaths = append(modified.ShortestPaths, prefixed)
+	}
+
+	// Prefix path variables, pointing them at the prefixed patterns above
+	for _, path := range c.Paths {
+		prefixed := &PathVariable{Name: context + "_" + path.Name}
+		for _, node := range path.Nodes {
+			for _, candidate := range modified.Nodes {
+				if candidate.ResourceProperties.Name == context+"_"+node.ResourceProperties.Name {
+					prefixed.Nodes = append(prefixed.Nodes, candidate)
+					break
+				}
+			}
+		}
+		for _, rel := range path.Relationships {
+			for i, original := range c.Relationships {
+				if original == rel {
+					prefixed.Relationships = append(prefixed.Relationships, modified.Relationships[i])
+				}
+			}
+		}
+		for i, sp := range c.ShortestPaths {
+			if sp == path.ShortestPath {
+				prefixed.ShortestPath = modified.ShortestPaths[i]
+			}
+		}
+		modified.Paths = append(modified.Paths, prefixed)
+	}
+
 	// Prefix filter variables
 	for i, extraFilter := range c.ExtraFilters {
 		if extraFilter.Type == "KeyValuePair" {
@@ -153,6 +193,7 @@ func prefixReturnClause(c *ReturnClause, context string) *ReturnClause {
 			JsonPath:  strings.Join(parts, "."),
 			Alias:     item.Alias,
 			Aggregate: item.Aggregate,
+			Function:  item.Function,
 		}
 	}
 
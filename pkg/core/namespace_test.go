@@ -0,0 +1,135 @@
+package core
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// namespaceRecordingProvider serves pods spread over several namespaces and
+// records the namespace of every list call.
+type namespaceRecordingProvider struct {
+	*hardeningProvider
+	listMu     sync.Mutex
+	namespaces []string
+}
+
+func newNamespaceRecordingProvider() *namespaceRecordingProvider {
+	p := newHardeningProvider()
+	p.resources["Pod"] = []map[string]interface{}{
+		testPod("pod-a", "team-a", "a", 1),
+		testPod("pod-b", "team-b", "b", 1),
+		testPod("pod-c", "team-c", "c", 1),
+		testPod("pod-d", "other", "d", 1),
+	}
+	return &namespaceRecordingProvider{hardeningProvider: p}
+}
+
+func (p *namespaceRecordingProvider) GetK8sResources(kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	p.listMu.Lock()
+	p.namespaces = append(p.namespaces, namespace)
+	p.listMu.Unlock()
+	return p.hardeningProvider.GetK8sResources(kind, fieldSelector, labelSelector, namespace)
+}
+
+func podNames(t *testing.T, result QueryResult) []string {
+	t.Helper()
+	rows, _ := result.Data["p"].([]interface{})
+	var names []string
+	for _, row := range rows {
+		names = append(names, row.(map[string]interface{})["name"].(string))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestParseNamespaceProperties(t *testing.T) {
+	ast, err := ParseQuery(`MATCH (p:Pod {namespace: ["team-a", "team-b"], app: ["a", "b"]}), (q:Pod {namespace =~ "team-.*"}) RETURN p, q`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	nodes := ast.Clauses[0].(*MatchClause).Nodes
+	got := []*Property{
+		nodes[0].ResourceProperties.Properties.PropertyList[0],
+		nodes[0].ResourceProperties.Properties.PropertyList[1],
+		nodes[1].ResourceProperties.Properties.PropertyList[0],
+	}
+	want := []*Property{
+		{Key: "namespace", Value: []string{"team-a", "team-b"}},
+		{Key: "app", Value: []string{"a", "b"}, Operator: "IN"},
+		{Key: "namespace", Value: "team-.*", Operator: "=~"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v %+v %+v", got[0], got[1], got[2])
+	}
+
+	for _, query := range []string{
+		`MATCH (p:Pod {namespace =~ "team-("}) RETURN p`,
+		`MATCH (p:Pod {name: ["a", "b"]}) RETURN p`,
+		`MATCH (p:Pod {app =~ "a.*"}) RETURN p`,
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("ParseQuery(%q) expected error", query)
+		}
+	}
+}
+
+func TestNamespaceListFetchesPerNamespace(t *testing.T) {
+	provider := newNamespaceRecordingProvider()
+	executor, _ := NewQueryExecutor(provider)
+	result := executeTestQuery(t, executor, `MATCH (p:Pod {namespace: ["team-a", "team-b"]}) RETURN p.metadata.name AS name`)
+
+	if names := podNames(t, result); !reflect.DeepEqual(names, []string{"pod-a", "pod-b"}) {
+		t.Fatalf("got pods %v", names)
+	}
+	sort.Strings(provider.namespaces)
+	if !reflect.DeepEqual(provider.namespaces, []string{"team-a", "team-b"}) {
+		t.Fatalf("expected one list per namespace, got %q", provider.namespaces)
+	}
+}
+
+func TestNamespacePatternsFetchClusterWide(t *testing.T) {
+	queries := []string{
+		`MATCH (p:Pod {namespace =~ "^team-[ab]$"}) RETURN p.metadata.name AS name`,
+		`MATCH (p:Pod {namespace: ["team-a", "team-b*"]}) RETURN p.metadata.name AS name`,
+	}
+	for _, query := range queries {
+		provider := newNamespaceRecordingProvider()
+		executor, _ := NewQueryExecutor(provider)
+		result := executeTestQuery(t, executor, query)
+
+		if names := podNames(t, result); !reflect.DeepEqual(names, []string{"pod-a", "pod-b"}) {
+			t.Errorf("%s: got pods %v", query, names)
+		}
+		if !reflect.DeepEqual(provider.namespaces, []string{""}) {
+			t.Errorf("%s: expected a single cluster-wide list, got %q", query, provider.namespaces)
+		}
+	}
+}
+
+func TestNamespaceSelectionIsPartOfFetchKey(t *testing.T) {
+	executor, _ := NewQueryExecutor(newHardeningProvider())
+	first, _ := ParseQuery(`MATCH (p:Pod {namespace: ["team-a", "team-b"]}) RETURN p`)
+	second, _ := ParseQuery(`MATCH (p:Pod {namespace: ["team-a", "team-c"]}) RETURN p`)
+
+	var keys []string
+	for _, ast := range []*Expression{first, second} {
+		node := ast.Clauses[0].(*MatchClause).Nodes[0]
+		plan, err := executor.planNodeFetch(node, nil, "default")
+		if err != nil {
+			t.Fatalf("planNodeFetch() error = %v", err)
+		}
+		if plan.NamespaceStrategy != namespaceStrategyPerNamespace {
+			t.Fatalf("NamespaceStrategy = %q", plan.NamespaceStrategy)
+		}
+		key, err := executor.resourceFetchKey(node, plan.namespaceKey(), plan.FieldSelector, plan.LabelSelector, nil)
+		if err != nil {
+			t.Fatalf("resourceFetchKey() error = %v", err)
+		}
+		keys = append(keys, key)
+	}
+	if keys[0] == keys[1] {
+		t.Fatalf("different namespace lists produced the same fetch key")
+	}
+}
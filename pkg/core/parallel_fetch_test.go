@@ -0,0 +1,87 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// barrierProvider holds every list until `parties` lists are in flight, or
+// for timeout, so a query only finishes quickly when its nodes are fetched
+// in parallel. It records how many lists were in flight at most.
+type barrierProvider struct {
+	*hardeningProvider
+	parties     int
+	timeout     time.Duration
+	mu          sync.Mutex
+	arrived     int
+	inFlight    int
+	maxInFlight int
+	released    chan struct{}
+}
+
+func newBarrierProvider(parties int, timeout time.Duration) *barrierProvider {
+	return &barrierProvider{hardeningProvider: newHardeningProvider(), parties: parties, timeout: timeout, released: make(chan struct{})}
+}
+
+func (p *barrierProvider) GetK8sResources(kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	p.mu.Lock()
+	p.arrived++
+	if p.arrived == p.parties {
+		close(p.released)
+	}
+	p.inFlight++
+	p.maxInFlight = max(p.maxInFlight, p.inFlight)
+	p.mu.Unlock()
+
+	select {
+	case <-p.released:
+	case <-time.After(p.timeout):
+	}
+	p.mu.Lock()
+	p.inFlight--
+	p.mu.Unlock()
+	return p.hardeningProvider.GetK8sResources(kind, fieldSelector, labelSelector, namespace)
+}
+
+func TestProcessNodesFetchesIndependentNodesInParallel(t *testing.T) {
+	provider := newBarrierProvider(2, 2*time.Second)
+	executor, _ := NewQueryExecutor(provider)
+
+	ast, err := ParseQuery(`MATCH (p:Pod), (d:Deployment) RETURN p.metadata.name, d.metadata.name`)
+	if err != nil {
+		t.Fatalf("parse query: %v", err)
+	}
+	start := time.Now()
+	result, err := executor.Execute(ast, "default", WithParallelFetch())
+	if err != nil {
+		t.Fatalf("execute query: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected nodes to be fetched in parallel, query took %s", elapsed)
+	}
+
+	var kinds []string
+	for _, node := range result.Graph.Nodes {
+		kinds = append(kinds, node.Kind)
+	}
+	want := []string{"Pod", "Pod", "Pod", "Deployment", "Deployment", "Deployment"}
+	if len(kinds) != len(want) {
+		t.Fatalf("got graph node kinds %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("graph nodes are not in clause order: %v", kinds)
+		}
+	}
+}
+
+func TestProcessNodesFetchesNodesSequentiallyByDefault(t *testing.T) {
+	provider := newBarrierProvider(2, 100*time.Millisecond)
+	executor, _ := NewQueryExecutor(provider)
+
+	executeTestQuery(t, executor, `MATCH (p:Pod), (d:Deployment) RETURN p.metadata.name, d.metadata.name`)
+	if provider.maxInFlight != 1 {
+		t.Fatalf("expected nodes to be fetched one after another, %d lists were in flight", provider.maxInFlight)
+	}
+}
@@ -3,9 +3,14 @@ package core
 import (
 	"fmt"
 	"log"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
 )
 
 type Parser struct {
@@ -36,6 +41,16 @@ func (p *Parser) Parse() (*Expression, error) {
 	var contexts []string
 	var clauses []Clause
 
+	// Check for EXPLAIN or PROFILE prefix
+	explain, profile := false, false
+	if p.current.Type == IDENT && strings.EqualFold(p.current.Literal, "EXPLAIN") {
+		explain = true
+		p.advance()
+	} else if p.current.Type == IDENT && strings.EqualFold(p.current.Literal, "PROFILE") {
+		profile = true
+		p.advance()
+	}
+
 	// Check for IN clause
 	if p.current.Type == IN {
 		p.advance()
@@ -70,6 +85,9 @@ func (p *Parser) Parse() (*Expression, error) {
 			if err != nil {
 				return nil, fmt.Errorf("parsing WHERE clause: %w", err)
 			}
+			if err := rejectListItemSelectors(filters); err != nil {
+				return nil, fmt.Errorf("parsing WHERE clause: %w", err)
+			}
 			matchClause.ExtraFilters = filters
 		} else {
 			return nil, fmt.Errorf("WHERE clause can only follow MATCH")
@@ -154,6 +172,8 @@ func (p *Parser) Parse() (*Expression, error) {
 	return &Expression{
 		Contexts: contexts,
 		Clauses:  clauses,
+		Explain:  explain,
+		Profile:  profile,
 	}, nil
 }
 
@@ -194,6 +214,13 @@ func (p *Parser) parseMatchClause() (*MatchClause, error) {
 	// Track variables from the MATCH clause
 	p.trackMatchVariables(nodeRels.Nodes)
 
+	// Path variables share the namespace of node variables
+	for _, path := range nodeRels.Paths {
+		if _, exists := p.matchVariables[path.Name]; exists {
+			return nil, fmt.Errorf("path variable %s is already used as a node variable", path.Name)
+		}
+	}
+
 	// Validate that we don't have standalone anonymous nodes
 	if len(nodeRels.Nodes) == 1 && len(nodeRels.Relationships) == 0 {
 		node := nodeRels.Nodes[0]
@@ -209,11 +236,16 @@ func (p *Parser) parseMatchClause() (*MatchClause, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := rejectListItemSelectors(filters); err != nil {
+			return nil, err
+		}
 	}
 
 	return &MatchClause{
 		Nodes:         nodeRels.Nodes,
 		Relationships: nodeRels.Relationships,
+		ShortestPaths: nodeRels.ShortestPaths,
+		Paths:         nodeRels.Paths,
 		ExtraFilters:  filters,
 	}, nil
 }
@@ -228,6 +260,12 @@ func (p *Parser) parseCreateClause() (*CreateClause, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(nodeList.ShortestPaths) > 0 {
+		return nil, fmt.Errorf("shortest path patterns are only supported in MATCH")
+	}
+	if len(nodeList.Paths) > 0 {
+		return nil, fmt.Errorf("path variables are only supported in MATCH")
+	}
 
 	return &CreateClause{
 		Nodes:         nodeList.Nodes,
@@ -235,58 +273,172 @@ func (p *Parser) parseCreateClause() (*CreateClause, error) {
 	}, nil
 }
 
-// parseNodeRelationshipList parses node patterns and relationships
+// parseNodeRelationshipList parses a comma-separated list of pattern elements
 func (p *Parser) parseNodeRelationshipList() (*NodeRelationshipList, error) {
-	var nodes []*NodePattern
-	var relationships []*Relationship
+	list := &NodeRelationshipList{}
 
 	debugLog("Parsing node relationship list, current token: %v", p.current.Literal)
 
-	// Parse first node
-	node, err := p.parseNodePattern()
-	if err != nil {
-		return nil, err
+	for {
+		if err := p.parsePatternElement(list); err != nil {
+			return nil, err
+		}
+		if p.current.Type != COMMA {
+			break
+		}
+		p.advance()
 	}
-	nodes = append(nodes, node)
 
-	// Check for invalid relationship tokens before entering the loop
-	if p.current.Type == '<' || (p.current.Type == '<' && p.lexer.Peek() == '<') {
-		debugLog("Found invalid relationship token: \"%v\"", p.current.Literal)
-		return nil, fmt.Errorf("unexpected relationship token: \"%v\"", p.current.Literal)
-	}
+	return list, nil
+}
 
-	// Parse subsequent relationships and nodes
-	for {
-		debugLog("In relationship loop, current token: %v", p.current.Literal)
+// parsePatternElement parses one element of a pattern list and appends its
+// nodes, relationships and paths to list:
+// (IDENT EQUALS)? (ShortestPath | NodePattern (Relationship NodePattern)*)
+func (p *Parser) parsePatternElement(list *NodeRelationshipList) error {
+	// A pattern element can only start with an identifier when it is bound
+	// to a path variable, e.g. p = (d:Deployment)->(s:Service)
+	var pathName string
+	if p.current.Type == IDENT && !p.isShortestPathStart() {
+		pathName = p.current.Literal
+		p.advance()
+		if p.current.Type != EQUALS {
+			return fmt.Errorf("expected = after path variable %s, got \"%v\"", pathName, p.current.Literal)
+		}
+		p.advance()
+	}
+	firstNode := len(list.Nodes)
+	firstRelationship := len(list.Relationships)
 
+	var shortestPath *ShortestPath
+	if p.isShortestPathStart() {
+		sp, err := p.parseShortestPath()
+		if err != nil {
+			return err
+		}
+		list.Nodes = append(list.Nodes, sp.Start, sp.End)
+		list.ShortestPaths = append(list.ShortestPaths, sp)
 		if isRelationshipStart(p.current.Type) {
+			return fmt.Errorf("relationships cannot be chained onto a shortest path pattern")
+		}
+		shortestPath = sp
+	} else {
+		node, err := p.parseNodePattern()
+		if err != nil {
+			return err
+		}
+		list.Nodes = append(list.Nodes, node)
+
+		// Check for invalid relationship tokens before entering the loop
+		if p.current.Type == '<' {
+			debugLog("Found invalid relationship token: \"%v\"", p.current.Literal)
+			return fmt.Errorf("unexpected relationship token: \"%v\"", p.current.Literal)
+		}
+
+		// Parse subsequent relationships and nodes
+		for isRelationshipStart(p.current.Type) {
+			debugLog("In relationship loop, current token: %v", p.current.Literal)
 			rel, rightNode, err := p.parseRelationshipAndNode()
 			if err != nil {
-				return nil, err
+				return err
 			}
-			rel.LeftNode = nodes[len(nodes)-1]
+			rel.LeftNode = list.Nodes[len(list.Nodes)-1]
 			rel.RightNode = rightNode
-			relationships = append(relationships, rel)
-			nodes = append(nodes, rightNode)
-			continue
+			list.Relationships = append(list.Relationships, rel)
+			list.Nodes = append(list.Nodes, rightNode)
 		}
+	}
 
-		if p.current.Type == COMMA {
-			p.advance()
-			node, err := p.parseNodePattern()
-			if err != nil {
-				return nil, err
-			}
-			nodes = append(nodes, node)
-			continue
+	if pathName != "" {
+		path := &PathVariable{
+			Name:          pathName,
+			Nodes:         append([]*NodePattern(nil), list.Nodes[firstNode:]...),
+			Relationships: append([]*Relationship(nil), list.Relationships[firstRelationship:]...),
+			ShortestPath:  shortestPath,
+		}
+		if hasKindlessNodes(path.Nodes) {
+			return fmt.Errorf("path variable %s cannot contain kindless nodes", pathName)
 		}
+		list.Paths = append(list.Paths, path)
+	}
+
+	return nil
+}
 
-		break
+// isShortestPathStart reports whether the current token opens a
+// shortestPath(...) or allShortestPaths(...) pattern
+func (p *Parser) isShortestPathStart() bool {
+	if p.current.Type != IDENT {
+		return false
 	}
+	return strings.EqualFold(p.current.Literal, "shortestPath") || strings.EqualFold(p.current.Literal, "allShortestPaths")
+}
+
+// parseShortestPath parses: (shortestPath|allShortestPaths) LPAREN NodePattern -[*(..N)?]- NodePattern RPAREN
+func (p *Parser) parseShortestPath() (*ShortestPath, error) {
+	function := p.current.Literal
+	all := strings.EqualFold(function, "allShortestPaths")
+	p.advance()
+
+	if p.current.Type != LPAREN {
+		return nil, fmt.Errorf("expected ( after %s, got \"%v\"", function, p.current.Literal)
+	}
+	p.advance()
 
-	return &NodeRelationshipList{
-		Nodes:         nodes,
-		Relationships: relationships,
+	start, err := p.parseNodePattern()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.current.Type != REL_BEGINPROPS_NONE && p.current.Type != REL_BEGINPROPS_LEFT {
+		return nil, fmt.Errorf("expected variable-length relationship -[*]- in %s, got \"%v\"", function, p.current.Literal)
+	}
+	p.advance()
+	if p.current.Type != ILLEGAL || p.current.Literal != "*" {
+		return nil, fmt.Errorf("expected * in %s relationship, got \"%v\"", function, p.current.Literal)
+	}
+	p.advance()
+
+	maxDepth := defaultShortestPathDepth
+	if p.current.Type == DOT {
+		// The lexer splits "..N" into a DOT followed by the number ".N"
+		p.advance()
+		if p.current.Type != NUMBER || !strings.HasPrefix(p.current.Literal, ".") {
+			return nil, fmt.Errorf("expected depth bound [*..N] in %s, got \"%v\"", function, p.current.Literal)
+		}
+		maxDepth, err = strconv.Atoi(strings.TrimPrefix(p.current.Literal, "."))
+		if err != nil || maxDepth < 1 {
+			return nil, fmt.Errorf("invalid depth bound \"%s\" in %s", strings.TrimPrefix(p.current.Literal, "."), function)
+		}
+		p.advance()
+	}
+
+	if p.current.Type != REL_ENDPROPS_NONE && p.current.Type != REL_ENDPROPS_RIGHT {
+		return nil, fmt.Errorf("expected relationship end token in %s, got \"%v\"", function, p.current.Literal)
+	}
+	p.advance()
+
+	end, err := p.parseNodePattern()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.current.Type != RPAREN {
+		return nil, fmt.Errorf("expected ) to close %s, got \"%v\"", function, p.current.Literal)
+	}
+	p.advance()
+
+	for _, node := range []*NodePattern{start, end} {
+		if node.ResourceProperties.Kind == "" {
+			return nil, fmt.Errorf("%s endpoints must specify a kind", function)
+		}
+	}
+
+	return &ShortestPath{
+		Start:    start,
+		End:      end,
+		All:      all,
+		MaxDepth: maxDepth,
 	}, nil
 }
 
@@ -654,8 +806,20 @@ func (p *Parser) parseReturnItems() ([]*ReturnItem, error) {
 		nodeRef := p.current.Literal
 		p.advance()
 
-		// Handle full node reference or path
-		if p.current.Type == DOT {
+		// Handle path functions: nodes(p), relationships(p), length(p)
+		if p.current.Type == LPAREN && item.Aggregate == "" && isPathFunction(nodeRef) {
+			p.advance()
+			if p.current.Type != IDENT {
+				return nil, fmt.Errorf("expected path variable in %s(), got \"%v\"", nodeRef, p.current.Literal)
+			}
+			item.Function = strings.ToLower(nodeRef)
+			item.JsonPath = p.current.Literal
+			p.advance()
+			if p.current.Type != RPAREN {
+				return nil, fmt.Errorf("expected ), got \"%v\"", p.current.Literal)
+			}
+			p.advance()
+		} else if p.current.Type == DOT {
 			p.advance()
 			var path strings.Builder
 			path.WriteString(nodeRef)
@@ -731,6 +895,15 @@ func (p *Parser) parseReturnItems() ([]*ReturnItem, error) {
 	return items, nil
 }
 
+// isPathFunction reports whether name is a function taking a path variable
+func isPathFunction(name string) bool {
+	switch strings.ToLower(name) {
+	case "nodes", "relationships", "length":
+		return true
+	}
+	return false
+}
+
 // parseContexts parses a list of context identifiers
 func (p *Parser) parseContexts() ([]string, error) {
 	var contexts []string
@@ -787,28 +960,189 @@ func (p *Parser) parseProperties() (*Properties, error) {
 		p.advance()
 
 		if p.current.Type != COLON {
-			return nil, fmt.Errorf("expected :, got \"%v\"", p.current.Literal)
+			prop, err := p.parseSelectorProperty(key)
+			if err != nil {
+				return nil, err
+			}
+			propertyList = append(propertyList, prop)
+			debugLog("Added selector property: key='%s' operator='%s' value='%v'", key, prop.Operator, prop.Value)
+		} else {
+			p.advance()
+
+			if p.current.Type == LBRACKET {
+				prop, err := p.parseListProperty(key)
+				if err != nil {
+					return nil, err
+				}
+				propertyList = append(propertyList, prop)
+				debugLog("Added list property: key='%s' value='%v'", key, prop.Value)
+			} else {
+				value, err := p.parseValue()
+				if err != nil {
+					return nil, err
+				}
+
+				propertyList = append(propertyList, &Property{
+					Key:   key,
+					Value: value,
+				})
+				debugLog("Added property: key='%s' value='%v'", key, value)
+			}
+		}
+
+		if p.current.Type != COMMA {
+			break
 		}
 		p.advance()
+	}
 
-		value, err := p.parseValue()
+	return &Properties{PropertyList: propertyList}, nil
+}
+
+// parseSelectorProperty parses a property using a set-based label selector
+// operator: key != value, key IN [v1, v2], key NOT IN [v1, v2], key EXISTS
+// or key NOT EXISTS. Keywords are lexed as identifiers inside node patterns,
+// so both token types are accepted.
+func (p *Parser) parseSelectorProperty(key string) (*Property, error) {
+	if isNamespaceKey(key) && p.current.Type == REGEX_COMPARE {
+		p.advance()
+		pattern, err := p.parseSelectorValue()
 		if err != nil {
 			return nil, err
 		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q: %v", pattern, err)
+		}
+		return &Property{Key: key, Value: pattern, Operator: "=~"}, nil
+	}
+	if key == "name" || key == "metadata.name" || isNamespaceKey(key) {
+		return nil, fmt.Errorf("expected :, got \"%v\" (selector operators are only supported for labels)", p.current.Literal)
+	}
 
-		propertyList = append(propertyList, &Property{
-			Key:   key,
-			Value: value,
-		})
-		debugLog("Added property: key='%s' value='%v'", key, value)
+	if p.current.Type == NOT_EQUALS {
+		p.advance()
+		value, err := p.parseSelectorValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := validateLabelSelector(key, value); err != nil {
+			return nil, err
+		}
+		return &Property{Key: key, Value: value, Operator: "!="}, nil
+	}
+
+	operator := ""
+	if p.isSelectorKeyword("NOT") {
+		operator = "NOT "
+		p.advance()
+	}
+	switch {
+	case p.isSelectorKeyword("EXISTS"):
+		p.advance()
+		if err := validateLabelSelector(key); err != nil {
+			return nil, err
+		}
+		return &Property{Key: key, Operator: operator + "EXISTS"}, nil
+	case p.isSelectorKeyword("IN"):
+		p.advance()
+		values, err := p.parseSelectorValueList()
+		if err != nil {
+			return nil, err
+		}
+		if err := validateLabelSelector(key, values...); err != nil {
+			return nil, err
+		}
+		return &Property{Key: key, Value: values, Operator: operator + "IN"}, nil
+	}
+
+	if operator != "" {
+		return nil, fmt.Errorf("expected IN or EXISTS after NOT, got \"%v\"", p.current.Literal)
+	}
+	return nil, fmt.Errorf("expected :, got \"%v\"", p.current.Literal)
+}
 
+// parseListProperty parses key: [v1, v2]. A namespace list selects several
+// namespaces; for a label it is shorthand for key IN [v1, v2].
+func (p *Parser) parseListProperty(key string) (*Property, error) {
+	if key == "name" || key == "metadata.name" {
+		return nil, fmt.Errorf("the 'name' property does not accept a list")
+	}
+	values, err := p.parseSelectorValueList()
+	if err != nil {
+		return nil, err
+	}
+	if isNamespaceKey(key) {
+		return &Property{Key: key, Value: values}, nil
+	}
+	if err := validateLabelSelector(key, values...); err != nil {
+		return nil, err
+	}
+	return &Property{Key: key, Value: values, Operator: "IN"}, nil
+}
+
+// validateLabelSelector checks the key and values of a set-based label
+// selector property, so a query can't build a selector the API server
+// rejects or one with requirements of its own inside a value.
+func validateLabelSelector(key string, values ...string) error {
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return fmt.Errorf("invalid label key %q: %s", key, strings.Join(errs, "; "))
+	}
+	for _, value := range values {
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value %q for label %q: %s", value, key, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+func isNamespaceKey(key string) bool {
+	return key == "namespace" || key == "metadata.namespace"
+}
+
+func (p *Parser) isSelectorKeyword(keyword string) bool {
+	switch p.current.Type {
+	case IDENT, IN, NOT:
+		return strings.EqualFold(p.current.Literal, keyword)
+	}
+	return false
+}
+
+// parseSelectorValue parses a single label value, quoted or not
+func (p *Parser) parseSelectorValue() (string, error) {
+	switch p.current.Type {
+	case STRING, IDENT, INT, NUMBER, BOOLEAN:
+		value := strings.Trim(p.current.Literal, "\"")
+		p.advance()
+		return value, nil
+	}
+	return "", fmt.Errorf("expected label value, got \"%v\"", p.current.Literal)
+}
+
+// parseSelectorValueList parses a non-empty bracketed list of label values
+func (p *Parser) parseSelectorValueList() ([]string, error) {
+	if p.current.Type != LBRACKET {
+		return nil, fmt.Errorf("expected [, got \"%v\"", p.current.Literal)
+	}
+	p.advance()
+
+	var values []string
+	for {
+		value, err := p.parseSelectorValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
 		if p.current.Type != COMMA {
 			break
 		}
 		p.advance()
 	}
 
-	return &Properties{PropertyList: propertyList}, nil
+	if p.current.Type != RBRACKET {
+		return nil, fmt.Errorf("expected ], got \"%v\"", p.current.Literal)
+	}
+	p.advance()
+	return values, nil
 }
 
 // parseFilters parses a list of either key-value pairs with operators or submatch patterns
@@ -834,6 +1168,12 @@ func (p *Parser) parseFilters() ([]*Filter, error) {
 			if err != nil {
 				return nil, err
 			}
+			if len(nodeRels.ShortestPaths) > 0 {
+				return nil, fmt.Errorf("shortest path patterns are not allowed in WHERE clause patterns")
+			}
+			if len(nodeRels.Paths) > 0 {
+				return nil, fmt.Errorf("path variables are not allowed in WHERE clause patterns")
+			}
 
 			// Validate the submatch pattern
 			err = p.validateSubmatchPattern(nodeRels.Nodes, nodeRels.Relationships)
@@ -879,6 +1219,7 @@ func (p *Parser) parseFilters() ([]*Filter, error) {
 				} else if p.current.Type == LBRACKET {
 					p.advance()
 					path.WriteString("[")
+					selector := false
 					// Add support for wildcard
 					if p.current.Type == ILLEGAL && p.current.Literal == "*" {
 						path.WriteString("*")
@@ -886,6 +1227,11 @@ func (p *Parser) parseFilters() ([]*Filter, error) {
 					} else if p.current.Type == NUMBER {
 						path.WriteString(p.current.Literal)
 						p.advance()
+					} else if p.current.Type == IDENT {
+						if err := p.parseListItemSelector(&path); err != nil {
+							return nil, err
+						}
+						selector = true
 					} else {
 						return nil, fmt.Errorf("expected number or * in array index, got \"%v\"", p.current.Literal)
 					}
@@ -894,6 +1240,14 @@ func (p *Parser) parseFilters() ([]*Filter, error) {
 					}
 					path.WriteString("]")
 					p.advance()
+					// A "+" after a key selector creates the item when it is absent.
+					if p.current.Type == PLUS {
+						if !selector {
+							return nil, fmt.Errorf("\"+\" is only allowed after a key selector, e.g. env[name=\"LOG_LEVEL\"]+")
+						}
+						path.WriteString("+")
+						p.advance()
+					}
 					if p.current.Type == DOT {
 						continue
 					}
@@ -932,6 +1286,50 @@ func (p *Parser) parseFilters() ([]*Filter, error) {
 	return filters, nil
 }
 
+// parseListItemSelector parses the key selector of a list item, e.g.
+// name="nginx" or containerPort=80, protocol="TCP", and writes it to path.
+func (p *Parser) parseListItemSelector(path *strings.Builder) error {
+	for first := true; ; first = false {
+		if p.current.Type != IDENT {
+			return fmt.Errorf("expected key in list item selector, got \"%v\"", p.current.Literal)
+		}
+		key := p.current.Literal
+		path.WriteString(key)
+		p.advance()
+		if p.current.Type != EQUALS {
+			if first {
+				return fmt.Errorf("expected number or * in array index, got \"%v\"", key)
+			}
+			return fmt.Errorf("expected = in list item selector, got \"%v\"", p.current.Literal)
+		}
+		path.WriteString("=")
+		p.advance()
+		switch p.current.Type {
+		case STRING, INT, NUMBER, BOOLEAN:
+			path.WriteString(p.current.Literal)
+			p.advance()
+		default:
+			return fmt.Errorf("expected string, number or boolean in list item selector, got \"%v\"", p.current.Literal)
+		}
+		if p.current.Type != COMMA {
+			return nil
+		}
+		path.WriteString(",")
+		p.advance()
+	}
+}
+
+// rejectListItemSelectors fails for key selectors outside of SET, which is
+// the only clause that resolves them.
+func rejectListItemSelectors(filters []*Filter) error {
+	for _, filter := range filters {
+		if filter.Type == "KeyValuePair" && hasListItemSelector(filter.KeyValuePair.Key) {
+			return fmt.Errorf("list item selectors are only supported in SET, got %s", filter.KeyValuePair.Key)
+		}
+	}
+	return nil
+}
+
 // parseOperator parses comparison operators
 func (p *Parser) parseOperator() (string, error) {
 	switch p.current.Type {
@@ -1208,6 +1606,10 @@ func ParseQuery(query string) (*Expression, error) {
 }
 
 // Add debug logging function
+func init() {
+	provider.Debugf = debugLog
+}
+
 func debugLog(format string, args ...interface{}) {
 	if LogLevel == "debug" {
 		log.Printf(format, args...)
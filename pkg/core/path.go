@@ -0,0 +1,205 @@
+package core
+
+import (
+	"fmt"
+)
+
+// relationshipMatch remembers what a MATCH relationship was resolved to: its
+// relationship type and, as pattern rows, the pairs of resources it related.
+// Each pattern match holds a left node row and a right node row, so path
+// variables are assembled from them without evaluating the rule again.
+type relationshipMatch struct {
+	relType RelationshipType
+	rows    *ColumnarData
+}
+
+// pathResourceColumn is the column of a relationship's pattern rows holding
+// the resource.
+const pathResourceColumn = "$"
+
+// related returns, for each resource of left by resourceKey, the indexes of
+// the resources of right it is related to, in ascending order.
+func (m *relationshipMatch) related(right []map[string]interface{}) map[string][]int {
+	positions := make(map[string]int, len(right))
+	for i, resource := range right {
+		positions[resourceKey(resource)] = i
+	}
+	related := make(map[string][]int)
+	for _, pattern := range m.rows.GetPatternMatches() {
+		left, _ := pattern.Data[0][0].(map[string]interface{})
+		rightResource, _ := pattern.Data[1][0].(map[string]interface{})
+		if i, ok := positions[resourceKey(rightResource)]; ok {
+			leftKey := resourceKey(left)
+			related[leftKey] = append(related[leftKey], i)
+		}
+	}
+	for key, indexes := range related {
+		related[key] = sortedUnique(indexes)
+	}
+	return related
+}
+
+// resourceKey identifies a resource by its kind, namespace and name.
+func resourceKey(resource map[string]interface{}) string {
+	kind, _ := resource["kind"].(string)
+	return pathHopKey(kind, resource)
+}
+
+// bindPathVariables traces every chain path variable of a MATCH clause over
+// the narrowed node results. Shortest path variables are bound while their
+// pattern is processed.
+func bindPathVariables(c *MatchClause, state *executionState) error {
+	for _, path := range c.Paths {
+		if path.ShortestPath != nil {
+			continue
+		}
+		paths, err := tracePath(path, state)
+		if err != nil {
+			return fmt.Errorf("error tracing path %s: %w", path.Name, err)
+		}
+		state.setPaths(path.Name, paths)
+	}
+	return nil
+}
+
+// tracePath expands a chain pattern into one resourcePath per pattern row:
+// starting from each resource of the first node, a path is extended by every
+// resource of the next node that the connecting relationship's pattern rows
+// relate it to.
+func tracePath(path *PathVariable, state *executionState) ([]resourcePath, error) {
+	first := path.Nodes[0].ResourceProperties.Name
+	resources, ok := state.getResources(first)
+	if !ok {
+		return nil, fmt.Errorf("node %s resources were not loaded", first)
+	}
+	paths := make([]resourcePath, 0, len(resources))
+	for _, resource := range resources {
+		paths = append(paths, resourcePath{hops: []pathHop{{resource: resource}}})
+	}
+
+	for _, rel := range path.Relationships {
+		match, ok := state.relationshipMatch(rel)
+		if !ok {
+			return nil, fmt.Errorf("relationship between %s and %s was not resolved", rel.LeftNode.ResourceProperties.Name, rel.RightNode.ResourceProperties.Name)
+		}
+		rightResources, ok := state.getResources(rel.RightNode.ResourceProperties.Name)
+		if !ok {
+			return nil, fmt.Errorf("node %s resources were not loaded", rel.RightNode.ResourceProperties.Name)
+		}
+
+		related := match.related(rightResources)
+		var extended []resourcePath
+		for _, partial := range paths {
+			last := partial.hops[len(partial.hops)-1].resource
+			for _, i := range related[resourceKey(last)] {
+				extended = append(extended, resourcePath{
+					hops:  append(append([]pathHop{}, partial.hops...), pathHop{resource: rightResources[i]}),
+					edges: append(append([]RelationshipType{}, partial.edges...), match.relType),
+				})
+			}
+		}
+		paths = extended
+	}
+	return paths, nil
+}
+
+// isPathReturnItem reports whether a RETURN item refers to a path variable,
+// either directly (RETURN p) or through a path function (RETURN length(p)).
+func isPathReturnItem(item *ReturnItem, state *executionState) bool {
+	if item.Function != "" {
+		return true
+	}
+	_, ok := state.getPaths(item.JsonPath)
+	return ok
+}
+
+// returnPathItems adds the path RETURN items to the result data. Each path is
+// one row under the path variable's name, like resources are for nodes:
+// RETURN p yields nodes, relationships and length, while a path function
+// yields only its own field (or its alias).
+func returnPathItems(c *ReturnClause, results *QueryResult, state *executionState) error {
+	for _, item := range c.Items {
+		if !isPathReturnItem(item, state) {
+			continue
+		}
+		paths, ok := state.getPaths(item.JsonPath)
+		if !ok {
+			return fmt.Errorf("path variable %s not found in return clause", item.JsonPath)
+		}
+
+		rows, _ := results.Data[item.JsonPath].([]interface{})
+		if rows == nil {
+			rows = []interface{}{}
+		}
+		for len(rows) < len(paths) {
+			rows = append(rows, make(map[string]interface{}))
+		}
+
+		for i, path := range paths {
+			row := rows[i].(map[string]interface{})
+			relationships, err := pathRelationships(path)
+			if err != nil {
+				return err
+			}
+			fields := map[string]interface{}{
+				"nodes":         pathNodes(path),
+				"relationships": relationships,
+				"length":        len(path.edges),
+			}
+
+			switch {
+			case item.Function != "" && item.Alias != "":
+				row[item.Alias] = fields[item.Function]
+			case item.Function != "":
+				row[item.Function] = fields[item.Function]
+			case item.Alias != "":
+				row[item.Alias] = fields
+			default:
+				for key, value := range fields {
+					row[key] = value
+				}
+			}
+		}
+		results.Data[item.JsonPath] = rows
+	}
+	return nil
+}
+
+func pathNodes(path resourcePath) []interface{} {
+	nodes := make([]interface{}, len(path.hops))
+	for i, hop := range path.hops {
+		nodes[i] = hop.resource
+	}
+	return nodes
+}
+
+// pathRelationships lists the edges of a path in order, naming their ends the
+// same way graph edges do ("Kind/name").
+func pathRelationships(path resourcePath) ([]interface{}, error) {
+	ids := make([]string, len(path.hops))
+	for i, hop := range path.hops {
+		metadata, err := getResourceMetadata(hop.resource)
+		if err != nil {
+			return nil, fmt.Errorf("error reading path resource metadata: %w", err)
+		}
+		name, err := getResourceName(metadata)
+		if err != nil {
+			return nil, fmt.Errorf("error reading path resource name: %w", err)
+		}
+		kind, err := getResourceKind(hop.resource)
+		if err != nil {
+			return nil, fmt.Errorf("error reading path resource kind: %w", err)
+		}
+		ids[i] = fmt.Sprintf("%s/%s", kind, name)
+	}
+
+	relationships := make([]interface{}, len(path.edges))
+	for i, relType := range path.edges {
+		relationships[i] = map[string]interface{}{
+			"type": string(relType),
+			"from": ids[i],
+			"to":   ids[i+1],
+		}
+	}
+	return relationships, nil
+}
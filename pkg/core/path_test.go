@@ -0,0 +1,144 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestParsePathVariable(t *testing.T) {
+	ast, err := ParseQuery(`MATCH p = (d:Deployment)->(s:Service)->(x:Pod) RETURN p, nodes(p) AS hops, length(p)`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	match := ast.Clauses[0].(*MatchClause)
+	if len(match.Paths) != 1 {
+		t.Fatalf("expected 1 path variable, got %d", len(match.Paths))
+	}
+	path := match.Paths[0]
+	if path.Name != "p" || len(path.Nodes) != 3 || len(path.Relationships) != 2 {
+		t.Fatalf("unexpected path %+v", path)
+	}
+
+	items := ast.Clauses[1].(*ReturnClause).Items
+	want := []struct{ jsonPath, function, alias string }{
+		{"p", "", ""},
+		{"p", "nodes", "hops"},
+		{"p", "length", ""},
+	}
+	for i, w := range want {
+		if items[i].JsonPath != w.jsonPath || items[i].Function != w.function || items[i].Alias != w.alias {
+			t.Errorf("item %d = %+v, want %+v", i, items[i], w)
+		}
+	}
+}
+
+func TestParsePathVariableErrors(t *testing.T) {
+	queries := []string{
+		`MATCH p = (d:Deployment)->(x) RETURN p`,
+		`MATCH p = (p:Deployment)->(x:Pod) RETURN p`,
+		`CREATE p = (d:Deployment)->(x:Pod)`,
+	}
+	for _, query := range queries {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("ParseQuery(%q) expected error", query)
+		}
+	}
+}
+
+func TestPathVariableReturnsWholePath(t *testing.T) {
+	executor, _ := NewQueryExecutor(newPathProvider())
+	result := executeTestQuery(t, executor, `MATCH p = (d:Deployment {app: "a"})->(s:Service)->(x:Pod) RETURN p, length(p) AS hops`)
+
+	rows, ok := result.Data["p"].([]interface{})
+	if !ok || len(rows) != 1 {
+		t.Fatalf("expected a single path, got %#v", result.Data["p"])
+	}
+	row := rows[0].(map[string]interface{})
+	if row["hops"] != 2 || row["length"] != 2 {
+		t.Fatalf("expected length 2, got %#v", row)
+	}
+	if nodes := row["nodes"].([]interface{}); len(nodes) != 3 {
+		t.Fatalf("expected 3 nodes, got %d", len(nodes))
+	}
+
+	relationships := row["relationships"].([]interface{})
+	want := []map[string]interface{}{
+		{"type": string(ServiceExposeDeployment), "from": "Deployment/deploy-a", "to": "Service/svc-a"},
+		{"type": string(ServiceExposePod), "from": "Service/svc-a", "to": "Pod/pod-a"},
+	}
+	if len(relationships) != len(want) {
+		t.Fatalf("expected %d relationships, got %#v", len(want), relationships)
+	}
+	for i, w := range want {
+		got := relationships[i].(map[string]interface{})
+		for key, value := range w {
+			if got[key] != value {
+				t.Errorf("relationship %d %s = %v, want %v", i, key, got[key], value)
+			}
+		}
+	}
+}
+
+func TestPathVariableOverShortestPath(t *testing.T) {
+	executor, _ := NewQueryExecutor(newPathProvider())
+	result := executeTestQuery(t, executor, `MATCH p = shortestPath((d:Deployment {app: "a"})-[*]-(x:Pod)) RETURN length(p)`)
+
+	rows, ok := result.Data["p"].([]interface{})
+	if !ok || len(rows) != 1 {
+		t.Fatalf("expected a single path, got %#v", result.Data["p"])
+	}
+	if got := rows[0].(map[string]interface{})["length"]; got != 2 {
+		t.Fatalf("expected length 2, got %v", got)
+	}
+}
+
+func TestTracePathFollowsRecordedPatternRows(t *testing.T) {
+	resource := func(kind, name string) map[string]interface{} {
+		return map[string]interface{}{"kind": kind, "metadata": map[string]interface{}{"name": name, "namespace": "default"}}
+	}
+	deployA, deployB := resource("Deployment", "a"), resource("Deployment", "b")
+	podA, podB1, podB2 := resource("Pod", "a"), resource("Pod", "b-1"), resource("Pod", "b-2")
+
+	d := &NodePattern{ResourceProperties: &ResourceProperties{Name: "d", Kind: "Deployment"}}
+	x := &NodePattern{ResourceProperties: &ResourceProperties{Name: "x", Kind: "Pod"}}
+	rel := &Relationship{LeftNode: d, RightNode: x}
+	state := newExecutionState()
+	state.setResources("d", []map[string]interface{}{deployA, deployB})
+	state.setResources("x", []map[string]interface{}{podA, podB1, podB2})
+
+	state.recordRelationshipMatch(rel, "DEPLOYMENT_OWN_POD")
+	state.recordRelatedPair(rel, deployB, podB2)
+	state.recordRelatedPair(rel, deployA, podA)
+	state.recordRelatedPair(rel, deployB, podB1)
+	// Recorded again by a later pass, and for a pod narrowed away since
+	state.recordRelatedPair(rel, deployA, podA)
+	state.recordRelatedPair(rel, deployA, resource("Pod", "gone"))
+
+	paths, err := tracePath(&PathVariable{Name: "p", Nodes: []*NodePattern{d, x}, Relationships: []*Relationship{rel}}, state)
+	if err != nil {
+		t.Fatalf("tracePath() error = %v", err)
+	}
+	var got []string
+	for _, path := range paths {
+		relationships, err := pathRelationships(path)
+		if err != nil {
+			t.Fatalf("pathRelationships() error = %v", err)
+		}
+		for _, relationship := range relationships {
+			r := relationship.(map[string]interface{})
+			got = append(got, r["from"].(string)+" "+r["type"].(string)+" "+r["to"].(string))
+		}
+	}
+	want := []string{
+		"Deployment/a DEPLOYMENT_OWN_POD Pod/a",
+		"Deployment/b DEPLOYMENT_OWN_POD Pod/b-1",
+		"Deployment/b DEPLOYMENT_OWN_POD Pod/b-2",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got paths %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("path %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
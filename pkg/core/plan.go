@@ -0,0 +1,474 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// supportedFieldSelectors lists the fields, beyond metadata.name and
+// metadata.namespace, that the API server can filter each resource by and
+// that hold strings. Fields such as spec.hostNetwork or status.replicas are
+// selectable too, but WHERE compares them as booleans and numbers in memory,
+// so they are not pushed down.
+var supportedFieldSelectors = map[string][]string{
+	"pods": {
+		"spec.nodeName", "spec.restartPolicy", "spec.schedulerName", "spec.serviceAccountName",
+		"status.phase", "status.podIP", "status.nominatedNodeName",
+	},
+	"events": {
+		"involvedObject.kind", "involvedObject.namespace", "involvedObject.name", "involvedObject.uid",
+		"involvedObject.apiVersion", "involvedObject.resourceVersion", "involvedObject.fieldPath",
+		"reason", "reportingComponent", "type",
+	},
+	"secrets":                    {"type"},
+	"namespaces":                 {"status.phase"},
+	"certificatesigningrequests": {"spec.signerName"},
+}
+
+// maxPerNamespaceLists is the largest namespace list fetched with one list
+// call per namespace; longer lists are served by a single cluster-wide list.
+const maxPerNamespaceLists = 5
+
+const (
+	namespaceStrategySingle       = "single"
+	namespaceStrategyPerNamespace = "per-namespace"
+	namespaceStrategyClusterWide  = "cluster-wide"
+)
+
+// fetchPlan describes how a node's resources are listed: the selectors sent
+// to the provider and the WHERE filters left to evaluate in memory.
+type fetchPlan struct {
+	Node      string
+	Kind      string
+	Namespace string
+	// Namespaces and NamespacePattern are set when the node selects several
+	// namespaces, by list or by regex/glob pattern respectively
+	Namespaces        []string
+	NamespacePattern  string
+	NamespaceStrategy string
+	FieldSelector     string
+	LabelSelector     string
+	// PushedDown holds the WHERE filters folded into the selectors
+	PushedDown []string
+	// InMemory holds the WHERE filters on this node evaluated after listing
+	InMemory []string
+	// Limit, when positive, is the number of resources after which listing
+	// may stop
+	Limit int64
+	// MetadataOnly is set when the query reads nothing but the metadata of
+	// the resources, which are then listed without their spec and status
+	MetadataOnly bool
+	// ManagedFields is set when the query reads metadata.managedFields,
+	// which are stripped from the resources otherwise
+	ManagedFields bool
+
+	filters []*Filter
+}
+
+// planNodeFetch builds the fetch plan of a node from its properties and the
+// WHERE filters of its MATCH clause. String equality filters on labels, names,
+// namespaces and the kind's supported fields are pushed down to the selectors;
+// every other filter is kept for in-memory evaluation.
+func (q *QueryExecutor) planNodeFetch(n *NodePattern, extraFilters []*Filter, namespace string) (*fetchPlan, error) {
+	plan := &fetchPlan{
+		Node:              n.ResourceProperties.Name,
+		Kind:              n.ResourceProperties.Kind,
+		Namespace:         namespace,
+		NamespaceStrategy: namespaceStrategySingle,
+	}
+
+	var properties []*Property
+	if n.ResourceProperties.Properties != nil {
+		for _, prop := range n.ResourceProperties.Properties.PropertyList {
+			if isNamespaceKey(prop.Key) {
+				if err := plan.selectNamespaces(prop); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			properties = append(properties, prop)
+		}
+	}
+
+	var fieldSelectors, labelSelectors []string
+	var hasNameSelector, hasLabelSelector bool
+	for _, prop := range properties {
+		if prop.Key == "name" || prop.Key == "metadata.name" || prop.Key == `"name"` || prop.Key == `"metadata.name"` {
+			fieldSelectors = append(fieldSelectors, fmt.Sprintf("metadata.name=%s", prop.Value))
+			hasNameSelector = true
+		} else {
+			hasLabelSelector = true
+			requirement, err := labelSelectorRequirement(prop)
+			if err != nil {
+				return nil, err
+			}
+			labelSelectors = append(labelSelectors, requirement)
+		}
+	}
+	if hasNameSelector && hasLabelSelector {
+		// both name and label selectors are specified, error out
+		return nil, fmt.Errorf("the 'name' selector can be used by itself or combined with 'namespace', but not with other label selectors")
+	}
+
+	resource := ""
+	if gvr, err := tryResolveGVR(q.provider, n.ResourceProperties.Kind); err == nil {
+		resource = gvr.Resource
+	}
+
+	for _, extraFilter := range extraFilters {
+		path, ok := nodeFilterPath(extraFilter, n.ResourceProperties.Name)
+		if !ok {
+			plan.filters = append(plan.filters, extraFilter)
+			continue
+		}
+		filter := extraFilter.KeyValuePair
+
+		if selector, isLabel, ok := pushdownSelector(resource, path, filter); ok {
+			if isLabel {
+				labelSelectors = append(labelSelectors, selector)
+			} else {
+				fieldSelectors = append(fieldSelectors, selector)
+			}
+			plan.PushedDown = append(plan.PushedDown, describeFilter(filter))
+			continue
+		}
+		plan.filters = append(plan.filters, extraFilter)
+		plan.InMemory = append(plan.InMemory, describeFilter(filter))
+	}
+
+	plan.FieldSelector = strings.Join(fieldSelectors, ",")
+	plan.LabelSelector = strings.Join(labelSelectors, ",")
+	return plan, nil
+}
+
+// queryListLimit returns the node whose list may stop early and the number of
+// resources the query needs from it. This only holds for a single-node MATCH
+// returned with a LIMIT and no ORDER BY or aggregation, where every listed
+// resource becomes exactly one row.
+func queryListLimit(ast *Expression) (string, int64) {
+	if len(ast.Clauses) != 2 {
+		return "", 0
+	}
+	match, ok := ast.Clauses[0].(*MatchClause)
+	if !ok || len(match.Nodes) != 1 || len(match.Relationships) > 0 || len(match.ShortestPaths) > 0 || len(match.Paths) > 0 {
+		return "", 0
+	}
+	ret, ok := ast.Clauses[1].(*ReturnClause)
+	if !ok || ret.Limit == nil || *ret.Limit <= 0 || len(ret.OrderBy) > 0 {
+		return "", 0
+	}
+	for _, item := range ret.Items {
+		if item.Aggregate != "" {
+			return "", 0
+		}
+	}
+	limit := *ret.Limit
+	if ret.Skip != nil && *ret.Skip > 0 {
+		limit += *ret.Skip
+	}
+	return match.Nodes[0].ResourceProperties.Name, int64(limit)
+}
+
+// applyListLimit lets the plan stop listing after limit resources, unless
+// resources still have to be filtered in memory or are listed cluster-wide
+// and narrowed down to namespaces afterwards.
+func (p *fetchPlan) applyListLimit(limit int64) {
+	if limit <= 0 || len(p.filters) > 0 || p.NamespaceStrategy == namespaceStrategyClusterWide {
+		return
+	}
+	p.Limit = limit
+}
+
+// applyProjection lists only the parts of the resources the query reads
+func (p *fetchPlan) applyProjection(projection nodeProjection) {
+	p.MetadataOnly = projection.metadataOnly
+	p.ManagedFields = projection.managedFields
+}
+
+// listOptions returns the list hints of the plan
+func (p *fetchPlan) listOptions() provider.ListOptions {
+	return provider.ListOptions{Limit: p.Limit, MetadataOnly: p.MetadataOnly}
+}
+
+// selectNamespaces applies a namespace property to the plan. A namespace
+// containing * or ? is a glob, and a list containing one is matched as a
+// pattern like a =~ regex.
+func (p *fetchPlan) selectNamespaces(prop *Property) error {
+	var namespaces []string
+	switch value := prop.Value.(type) {
+	case string:
+		if prop.Operator == "=~" {
+			p.NamespacePattern = value
+		} else {
+			namespaces = []string{value}
+		}
+	case []string:
+		namespaces = value
+	default:
+		return fmt.Errorf("invalid namespace %v", prop.Value)
+	}
+
+	if p.NamespacePattern == "" {
+		hasGlob := false
+		for _, namespace := range namespaces {
+			hasGlob = hasGlob || strings.ContainsAny(namespace, "*?")
+		}
+		if hasGlob {
+			alternatives := make([]string, len(namespaces))
+			for i, namespace := range namespaces {
+				alternatives[i] = globToRegex(namespace)
+			}
+			p.NamespacePattern = "^(?:" + strings.Join(alternatives, "|") + ")$"
+		}
+	}
+
+	switch {
+	case p.NamespacePattern != "":
+		if _, err := regexp.Compile(p.NamespacePattern); err != nil {
+			return fmt.Errorf("invalid namespace pattern %q: %v", p.NamespacePattern, err)
+		}
+		p.Namespace = ""
+		p.NamespaceStrategy = namespaceStrategyClusterWide
+	case len(namespaces) == 1:
+		p.Namespace = namespaces[0]
+	case len(namespaces) <= maxPerNamespaceLists:
+		p.Namespace = ""
+		p.Namespaces = namespaces
+		p.NamespaceStrategy = namespaceStrategyPerNamespace
+	default:
+		p.Namespace = ""
+		p.Namespaces = namespaces
+		p.NamespaceStrategy = namespaceStrategyClusterWide
+	}
+	return nil
+}
+
+func globToRegex(glob string) string {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+	return strings.ReplaceAll(pattern, `\?`, ".")
+}
+
+// namespaceKey identifies the plan's namespace selection in fetch cache keys
+func (p *fetchPlan) namespaceKey() string {
+	switch {
+	case p.NamespacePattern != "":
+		return "=~" + p.NamespacePattern
+	case len(p.Namespaces) > 0:
+		namespaces := append([]string{}, p.Namespaces...)
+		sort.Strings(namespaces)
+		return strings.Join(namespaces, ",")
+	default:
+		return p.Namespace
+	}
+}
+
+// inNamespaces reports whether a resource listed cluster-wide belongs to the
+// plan's namespace selection.
+func (p *fetchPlan) inNamespaces(resource map[string]interface{}, pattern *regexp.Regexp) bool {
+	metadata, _ := resource["metadata"].(map[string]interface{})
+	namespace, _ := metadata["namespace"].(string)
+	if pattern != nil {
+		return pattern.MatchString(namespace)
+	}
+	for _, candidate := range p.Namespaces {
+		if candidate == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// listResources lists the plan's resources according to its namespace
+// strategy. Per-namespace lists run in parallel and are concatenated in the
+// order the namespaces were given.
+func (q *QueryExecutor) listResources(ctx context.Context, providerKind string, plan *fetchPlan) (interface{}, error) {
+	switch plan.NamespaceStrategy {
+	case namespaceStrategyPerNamespace:
+		lists := make([][]map[string]interface{}, len(plan.Namespaces))
+		errs := make([]error, len(plan.Namespaces))
+		var wg sync.WaitGroup
+		for i, namespace := range plan.Namespaces {
+			wg.Add(1)
+			go func(i int, namespace string) {
+				defer wg.Done()
+				resources, err := provider.GetK8sResourcesWithOptions(ctx, q.provider, providerKind, plan.FieldSelector, plan.LabelSelector, namespace, plan.listOptions())
+				if err != nil {
+					errs[i] = fmt.Errorf("namespace %s: %w", namespace, err)
+					return
+				}
+				list, ok := resources.([]map[string]interface{})
+				if !ok {
+					errs[i] = fmt.Errorf("provider returned %T for %s, expected []map[string]interface{}", resources, plan.Kind)
+					return
+				}
+				lists[i] = list
+			}(i, namespace)
+		}
+		wg.Wait()
+
+		var merged []map[string]interface{}
+		for i := range lists {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			merged = append(merged, lists[i]...)
+		}
+		return merged, nil
+
+	case namespaceStrategyClusterWide:
+		resources, err := provider.GetK8sResourcesWithOptions(ctx, q.provider, providerKind, plan.FieldSelector, plan.LabelSelector, "", plan.listOptions())
+		if err != nil {
+			return nil, err
+		}
+		list, ok := resources.([]map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("provider returned %T for %s, expected []map[string]interface{}", resources, plan.Kind)
+		}
+		var pattern *regexp.Regexp
+		if plan.NamespacePattern != "" {
+			pattern = regexp.MustCompile(plan.NamespacePattern)
+		}
+		var filtered []map[string]interface{}
+		for _, resource := range list {
+			if plan.inNamespaces(resource, pattern) {
+				filtered = append(filtered, resource)
+			}
+		}
+		return filtered, nil
+
+	default:
+		return provider.GetK8sResourcesWithOptions(ctx, q.provider, providerKind, plan.FieldSelector, plan.LabelSelector, plan.Namespace, plan.listOptions())
+	}
+}
+
+// nodeFilterPath returns the path of a WHERE key-value filter relative to the
+// node it filters, if that node is nodeName.
+func nodeFilterPath(filter *Filter, nodeName string) (string, bool) {
+	if filter.Type != "KeyValuePair" {
+		return "", false
+	}
+	return strings.CutPrefix(filter.KeyValuePair.Key, nodeName+".")
+}
+
+// pushdownSelector translates a filter into a label or field selector
+// requirement. Only non-negated string equality can be pushed down without
+// changing results: for inequality the API server also returns resources
+// missing the field or label, which fail every comparison in memory. For the
+// same reason a field is never selected by the empty string, which the API
+// server also matches when the field is unset.
+func pushdownSelector(resource, path string, filter *KeyValuePair) (string, bool, bool) {
+	value, ok := filter.Value.(string)
+	if !ok || filter.Operator != "EQUALS" || filter.IsNegated {
+		return "", false, false
+	}
+
+	if label, ok := strings.CutPrefix(path, "metadata.labels."); ok {
+		label = strings.ReplaceAll(label, `\.`, ".")
+		if len(validation.IsQualifiedName(label)) > 0 || len(validation.IsValidLabelValue(value)) > 0 {
+			return "", false, false
+		}
+		return fmt.Sprintf("%s=%s", label, value), true, true
+	}
+
+	if value == "" {
+		return "", false, false
+	}
+	if path == "metadata.name" || path == "metadata.namespace" || isSupportedFieldSelector(resource, path) {
+		return fmt.Sprintf("%s=%s", path, fields.EscapeValue(value)), false, true
+	}
+	return "", false, false
+}
+
+func isSupportedFieldSelector(resource, path string) bool {
+	for _, field := range supportedFieldSelectors[resource] {
+		if field == path {
+			return true
+		}
+	}
+	return false
+}
+
+func describeFilter(filter *KeyValuePair) string {
+	description := fmt.Sprintf("%s %s %v", filter.Key, filter.Operator, filter.Value)
+	if filter.IsNegated {
+		description = "NOT " + description
+	}
+	return description
+}
+
+// explain plans every node of a query without listing any resources or
+// running its mutations. The plan is returned under the "plan" key, one
+// entry per node.
+func (q *QueryExecutor) explain(ast *Expression, namespace string) (QueryResult, error) {
+	executionConfigMu.Lock()
+	if AllNamespaces {
+		namespace = ""
+		AllNamespaces = false // to reset value
+	}
+	executionConfigMu.Unlock()
+
+	limitNode, limit := queryListLimit(ast)
+	projections := q.queryProjections(ast)
+	plans := []interface{}{}
+	for _, clause := range ast.Clauses {
+		c, ok := clause.(*MatchClause)
+		if !ok {
+			continue
+		}
+		for _, node := range c.Nodes {
+			plan, err := q.planNodeFetch(node, c.ExtraFilters, namespace)
+			if err != nil {
+				return QueryResult{}, fmt.Errorf("error planning node %s: %w", node.ResourceProperties.Name, err)
+			}
+			if node.ResourceProperties.Name == limitNode {
+				plan.applyListLimit(limit)
+			}
+			plan.applyProjection(projections[node.ResourceProperties.Name])
+			plans = append(plans, plan.toMap())
+		}
+	}
+	return QueryResult{
+		Data:  map[string]interface{}{"plan": plans},
+		Graph: Graph{Nodes: []Node{}, Edges: []Edge{}},
+	}, nil
+}
+
+func (p *fetchPlan) toMap() map[string]interface{} {
+	pushedDown := make([]interface{}, 0, len(p.PushedDown))
+	for _, filter := range p.PushedDown {
+		pushedDown = append(pushedDown, filter)
+	}
+	inMemory := make([]interface{}, 0, len(p.InMemory))
+	for _, filter := range p.InMemory {
+		inMemory = append(inMemory, filter)
+	}
+	namespace := p.Namespace
+	switch {
+	case p.NamespacePattern != "":
+		namespace = "=~ " + p.NamespacePattern
+	case len(p.Namespaces) > 0:
+		namespace = strings.Join(p.Namespaces, ",")
+	}
+	return map[string]interface{}{
+		"node":              p.Node,
+		"kind":              p.Kind,
+		"namespace":         namespace,
+		"namespaceStrategy": p.NamespaceStrategy,
+		"fieldSelector":     p.FieldSelector,
+		"labelSelector":     p.LabelSelector,
+		"pushedDown":        pushedDown,
+		"inMemory":          inMemory,
+		"limit":             p.Limit,
+		"metadataOnly":      p.MetadataOnly,
+		"managedFields":     p.ManagedFields,
+	}
+}
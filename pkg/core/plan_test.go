@@ -0,0 +1,233 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+func TestPlanNodeFetchPushesDownEligibleFilters(t *testing.T) {
+	executor, _ := NewQueryExecutor(newHardeningProvider())
+	ast, err := ParseQuery(`MATCH (p:Pod {app: "a"}) WHERE p.metadata.labels.tier = "web", p.status.phase = "Running", p.spec.nodeName = "n1", p.metadata.labels.env != "dev", p.spec.replicas > 1, NOT p.metadata.name = "x" RETURN p`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	match := ast.Clauses[0].(*MatchClause)
+
+	plan, err := executor.planNodeFetch(match.Nodes[0], match.ExtraFilters, "default")
+	if err != nil {
+		t.Fatalf("planNodeFetch() error = %v", err)
+	}
+	if plan.LabelSelector != "app=a,tier=web" {
+		t.Errorf("LabelSelector = %q", plan.LabelSelector)
+	}
+	if plan.FieldSelector != "status.phase=Running,spec.nodeName=n1" {
+		t.Errorf("FieldSelector = %q", plan.FieldSelector)
+	}
+	wantInMemory := []string{
+		"p.metadata.labels.env NOT_EQUALS dev",
+		"p.spec.replicas GREATER_THAN 1",
+		"NOT p.metadata.name EQUALS x",
+	}
+	if !reflect.DeepEqual(plan.InMemory, wantInMemory) {
+		t.Errorf("InMemory = %q, want %q", plan.InMemory, wantInMemory)
+	}
+	if len(plan.filters) != 3 {
+		t.Errorf("expected 3 filters left for in-memory evaluation, got %d", len(plan.filters))
+	}
+}
+
+func TestPlanNodeFetchKeepsUnsupportedFieldsInMemory(t *testing.T) {
+	executor, _ := NewQueryExecutor(newHardeningProvider())
+	ast, err := ParseQuery(`MATCH (d:Deployment), (p:Pod) WHERE d.status.phase = "Running", p.metadata.labels.app = "a" RETURN d, p`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	match := ast.Clauses[0].(*MatchClause)
+
+	plan, err := executor.planNodeFetch(match.Nodes[0], match.ExtraFilters, "default")
+	if err != nil {
+		t.Fatalf("planNodeFetch() error = %v", err)
+	}
+	if plan.FieldSelector != "" || plan.LabelSelector != "" {
+		t.Errorf("expected no selectors for deployment, got field=%q label=%q", plan.FieldSelector, plan.LabelSelector)
+	}
+	if len(plan.InMemory) != 1 || len(plan.filters) != 2 {
+		t.Errorf("expected the deployment filter in memory and the pod filter passed through, got %q and %d filters", plan.InMemory, len(plan.filters))
+	}
+}
+
+func TestPlanNodeFetchKeepsNonStringFieldsInMemory(t *testing.T) {
+	executor, _ := NewQueryExecutor(newHardeningProvider())
+	ast, err := ParseQuery(`MATCH (p:Pod) WHERE p.spec.hostNetwork = "true", p.status.podIP = "" RETURN p`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	match := ast.Clauses[0].(*MatchClause)
+
+	plan, err := executor.planNodeFetch(match.Nodes[0], match.ExtraFilters, "default")
+	if err != nil {
+		t.Fatalf("planNodeFetch() error = %v", err)
+	}
+	if plan.FieldSelector != "" || len(plan.PushedDown) != 0 {
+		t.Errorf("expected nothing pushed down, got field=%q pushed down %q", plan.FieldSelector, plan.PushedDown)
+	}
+	if len(plan.InMemory) != 2 || len(plan.filters) != 2 {
+		t.Errorf("expected both filters in memory, got %q and %d filters", plan.InMemory, len(plan.filters))
+	}
+}
+
+func TestWherePushdownReachesProvider(t *testing.T) {
+	provider := &selectorRecordingProvider{hardeningProvider: newHardeningProvider()}
+	executor, _ := NewQueryExecutor(provider)
+	executeTestQuery(t, executor, `MATCH (p:Pod) WHERE p.metadata.labels.app = "a" AND p.status.phase = "Running" RETURN p.metadata.name`)
+
+	if len(provider.labelSelectors) != 1 || provider.labelSelectors[0] != "app=a" {
+		t.Errorf("label selectors = %q", provider.labelSelectors)
+	}
+	if len(provider.fieldSelectors) != 1 || provider.fieldSelectors[0] != "status.phase=Running" {
+		t.Errorf("field selectors = %q", provider.fieldSelectors)
+	}
+}
+
+func TestExplainReturnsPlanWithoutFetching(t *testing.T) {
+	provider := &selectorRecordingProvider{hardeningProvider: newHardeningProvider()}
+	executor, _ := NewQueryExecutor(provider)
+	result := executeTestQuery(t, executor, `EXPLAIN MATCH (p:Pod) WHERE p.metadata.labels.app = "a", p.spec.replicas > 1 RETURN p`)
+
+	if len(provider.labelSelectors) != 0 {
+		t.Fatalf("EXPLAIN should not list resources, got %d calls", len(provider.labelSelectors))
+	}
+	plans := result.Data["plan"].([]interface{})
+	if len(plans) != 1 {
+		t.Fatalf("expected one node plan, got %#v", plans)
+	}
+	plan := plans[0].(map[string]interface{})
+	if plan["node"] != "p" || plan["labelSelector"] != "app=a" {
+		t.Errorf("unexpected plan %#v", plan)
+	}
+	if inMemory := plan["inMemory"].([]interface{}); len(inMemory) != 1 || inMemory[0] != "p.spec.replicas GREATER_THAN 1" {
+		t.Errorf("unexpected in-memory filters %#v", inMemory)
+	}
+}
+
+// retryingProvider reports a retry of every list call.
+type retryingProvider struct {
+	*hardeningProvider
+}
+
+func (p *retryingProvider) GetK8sResourcesWithOptions(ctx context.Context, kind, fieldSelector, labelSelector, namespace string, opts provider.ListOptions) (interface{}, error) {
+	provider.ObserveRetry(ctx, provider.Retry{Verb: "list", Resource: "pods", Namespace: namespace, Attempt: 1, Delay: time.Second, Err: errors.New("too many requests")})
+	return p.GetK8sResources(kind, fieldSelector, labelSelector, namespace)
+}
+
+func TestProfileReturnsResultsPlanAndRetries(t *testing.T) {
+	executor, _ := NewQueryExecutor(&retryingProvider{hardeningProvider: newHardeningProvider()})
+	result := executeTestQuery(t, executor, `PROFILE MATCH (p:Pod) WHERE p.metadata.labels.app = "a" RETURN p.metadata.name`)
+
+	if _, ok := result.Data["p"]; !ok {
+		t.Errorf("expected the query's results, got %#v", result.Data)
+	}
+	profile, ok := result.Data["profile"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected a profile, got %#v", result.Data)
+	}
+	if plans := profile["plan"].([]interface{}); len(plans) != 1 || plans[0].(map[string]interface{})["labelSelector"] != "app=a" {
+		t.Errorf("unexpected plan %#v", profile["plan"])
+	}
+	retries := profile["retries"].([]interface{})
+	if len(retries) != 1 {
+		t.Fatalf("expected one retry, got %#v", retries)
+	}
+	if retry := retries[0].(map[string]interface{}); retry["verb"] != "list" || retry["namespace"] != "default" || retry["delay"] != "1s" || retry["error"] != "too many requests" {
+		t.Errorf("unexpected retry %#v", retry)
+	}
+}
+
+// limitRecordingProvider records the list limit hint of every list call.
+type limitRecordingProvider struct {
+	*hardeningProvider
+	limits []int64
+}
+
+func (p *limitRecordingProvider) GetK8sResourcesWithOptions(ctx context.Context, kind, fieldSelector, labelSelector, namespace string, opts provider.ListOptions) (interface{}, error) {
+	p.limits = append(p.limits, opts.Limit)
+	return p.GetK8sResources(kind, fieldSelector, labelSelector, namespace)
+}
+
+func TestListLimitOnlyForUnorderedSingleNodeLimit(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int64
+	}{
+		{`MATCH (p:Pod) RETURN p.metadata.name LIMIT 2`, 2},
+		{`MATCH (p:Pod) WHERE p.status.phase = "Running" RETURN p.metadata.name SKIP 1 LIMIT 2`, 3},
+		{`MATCH (p:Pod) RETURN p.metadata.name ORDER BY p.metadata.name LIMIT 2`, 0},
+		{`MATCH (p:Pod) WHERE p.spec.replicas > 1 RETURN p.metadata.name LIMIT 2`, 0},
+		{`MATCH (p:Pod) RETURN COUNT {p.metadata.name} LIMIT 2`, 0},
+		{`MATCH (p:Pod) RETURN p.metadata.name`, 0},
+	}
+	for _, tt := range tests {
+		provider := &limitRecordingProvider{hardeningProvider: newHardeningProvider()}
+		executor, _ := NewQueryExecutor(provider)
+		executeTestQuery(t, executor, tt.query)
+		if len(provider.limits) != 1 || provider.limits[0] != tt.want {
+			t.Errorf("%s: list limits = %v, want [%d]", tt.query, provider.limits, tt.want)
+		}
+	}
+}
+
+func TestQueryProjections(t *testing.T) {
+	tests := []struct {
+		query         string
+		metadataOnly  bool
+		managedFields bool
+	}{
+		{`MATCH (p:Pod) RETURN p.metadata.name, p.metadata.labels`, true, false},
+		{`MATCH (p:Pod) RETURN COUNT {p}`, true, false},
+		{`MATCH (p:Pod) RETURN p.metadata.name AS name ORDER BY name`, true, false},
+		{`MATCH (p:Pod) RETURN p.metadata.managedFields`, true, true},
+		{`MATCH (p:Pod) WHERE p.metadata.labels.app = "a" DELETE p`, true, false},
+		{`MATCH (p:Pod) WHERE p.status.phase = "Running" RETURN p.metadata.name`, false, false},
+		{`MATCH (p:Pod) RETURN p.metadata.name ORDER BY p.spec.nodeName`, false, false},
+		{`MATCH (p:Pod) RETURN p`, false, false},
+		{`MATCH (p:Pod) SET p.metadata.labels.tier = "web"`, false, false},
+		{`MATCH (s:Service)->(p:Pod) RETURN p.metadata.name`, false, false},
+	}
+	executor, _ := NewQueryExecutor(newHardeningProvider())
+	for _, tt := range tests {
+		ast, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Fatalf("ParseQuery(%s) error = %v", tt.query, err)
+		}
+		got := executor.queryProjections(ast)["p"]
+		if got.metadataOnly != tt.metadataOnly || got.managedFields != tt.managedFields {
+			t.Errorf("%s: projection = %+v, want metadataOnly=%v managedFields=%v", tt.query, got, tt.metadataOnly, tt.managedFields)
+		}
+	}
+}
+
+func TestStripManagedFieldsCopiesResources(t *testing.T) {
+	resource := map[string]interface{}{
+		"kind": "Pod",
+		"metadata": map[string]interface{}{
+			"name":          "pod-a",
+			"managedFields": []interface{}{map[string]interface{}{"manager": "kubectl"}},
+		},
+	}
+	stripped := stripManagedFields([]map[string]interface{}{resource})
+
+	if _, ok := stripped[0]["metadata"].(map[string]interface{})["managedFields"]; ok {
+		t.Errorf("managedFields not stripped: %#v", stripped[0])
+	}
+	if stripped[0]["metadata"].(map[string]interface{})["name"] != "pod-a" || stripped[0]["kind"] != "Pod" {
+		t.Errorf("stripped resource lost fields: %#v", stripped[0])
+	}
+	if _, ok := resource["metadata"].(map[string]interface{})["managedFields"]; !ok {
+		t.Error("the provider's resource was modified")
+	}
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// profile runs ast and returns its results along with a "profile" entry: the
+// fetch plan EXPLAIN shows, the API requests the provider retried after
+// transient errors and how long the query took.
+func (q *QueryExecutor) profile(ast *Expression, namespace string, opts ...ExecuteOption) (QueryResult, error) {
+	// Explaining and executing the query each reset AllNamespaces, so it is
+	// resolved once for both.
+	executionConfigMu.Lock()
+	if AllNamespaces {
+		namespace = ""
+	}
+	executionConfigMu.Unlock()
+
+	explained := *ast
+	explained.Profile, explained.Explain = false, true
+	plan, err := q.Execute(&explained, namespace)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	var mu sync.Mutex
+	retries := []interface{}{}
+	ctx := provider.WithRetryObserver(resolveExecuteOptions(opts).ctx, func(retry provider.Retry) {
+		mu.Lock()
+		defer mu.Unlock()
+		retries = append(retries, map[string]interface{}{
+			"verb":      retry.Verb,
+			"resource":  retry.Resource,
+			"namespace": retry.Namespace,
+			"name":      retry.Name,
+			"attempt":   retry.Attempt,
+			"delay":     retry.Delay.String(),
+			"error":     retry.Err.Error(),
+		})
+	})
+
+	executed := *ast
+	executed.Profile = false
+	start := time.Now()
+	result, err := q.Execute(&executed, namespace, append(opts, WithContext(ctx))...)
+	if err != nil {
+		return result, err
+	}
+	if result.Data == nil {
+		result.Data = make(map[string]interface{})
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	result.Data["profile"] = map[string]interface{}{
+		"plan":     plan.Data["plan"],
+		"retries":  retries,
+		"duration": time.Since(start).String(),
+	}
+	return result, nil
+}
+
+// retryTarget names the resource or list a retried request was sent for.
+func retryTarget(retry provider.Retry) string {
+	target := retry.Resource
+	if retry.Name != "" {
+		target += "/" + retry.Name
+	}
+	if retry.Namespace != "" {
+		target += " in namespace " + retry.Namespace
+	}
+	return target
+}
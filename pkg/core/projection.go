@@ -0,0 +1,165 @@
+package core
+
+import (
+	"strings"
+)
+
+// nodeProjection describes which parts of a node's resources a query reads.
+// The zero value reads whole resources without their managedFields.
+type nodeProjection struct {
+	// metadataOnly is set when nothing outside metadata is read, so the
+	// resources can be listed without their spec and status
+	metadataOnly bool
+	// managedFields is set when metadata.managedFields is read
+	managedFields bool
+}
+
+// queryProjections returns the projection of every MATCH node, from the
+// paths the query's WHERE filters, RETURN items, ORDER BY fields and
+// relationship match criteria reference. Nodes that are returned whole, SET,
+// used by CREATE, filtered by a pattern or part of a path variable or
+// shortest path are read whole. DELETE only needs metadata.
+func (q *QueryExecutor) queryProjections(ast *Expression) map[string]nodeProjection {
+	var nodes []string
+	full := make(map[string]bool)
+	managedFields := make(map[string]bool)
+	reads := func(path string) {
+		name, rest, ok := strings.Cut(path, ".")
+		switch {
+		case !ok:
+			full[name] = true
+		case strings.HasPrefix(rest, "metadata.managedFields"):
+			managedFields[name] = true
+		case rest != "metadata" && !strings.HasPrefix(rest, "metadata."):
+			full[name] = true
+		}
+	}
+
+	for _, clause := range ast.Clauses {
+		switch c := clause.(type) {
+		case *MatchClause:
+			for _, node := range c.Nodes {
+				name := node.ResourceProperties.Name
+				nodes = append(nodes, name)
+				if node.ResourceProperties.Kind == "" || len(c.ShortestPaths) > 0 || len(c.Paths) > 0 {
+					full[name] = true
+				}
+			}
+			for _, filter := range c.ExtraFilters {
+				switch filter.Type {
+				case "KeyValuePair":
+					reads(filter.KeyValuePair.Key)
+				case "SubMatch":
+					full[filter.SubMatch.ReferenceNodeName] = true
+				}
+			}
+			for _, rel := range c.Relationships {
+				if !q.relationshipReadsOnlyMetadata(rel) {
+					full[rel.LeftNode.ResourceProperties.Name] = true
+					full[rel.RightNode.ResourceProperties.Name] = true
+				}
+			}
+		case *SetClause:
+			for _, kvp := range c.KeyValuePairs {
+				name, _, _ := strings.Cut(kvp.Key, ".")
+				full[name] = true
+			}
+		case *CreateClause:
+			for _, node := range c.Nodes {
+				full[node.ResourceProperties.Name] = true
+			}
+			for _, rel := range c.Relationships {
+				full[rel.LeftNode.ResourceProperties.Name] = true
+				full[rel.RightNode.ResourceProperties.Name] = true
+			}
+		case *ReturnClause:
+			aliases := make(map[string]bool)
+			for _, item := range c.Items {
+				if item.Alias != "" {
+					aliases[item.Alias] = true
+				}
+				if item.Function != "" || (item.Aggregate == "COUNT" && !strings.Contains(item.JsonPath, ".")) {
+					continue
+				}
+				reads(item.JsonPath)
+			}
+			for _, orderBy := range c.OrderBy {
+				if !aliases[orderBy.Field] {
+					reads(orderBy.Field)
+				}
+			}
+		}
+	}
+
+	projections := make(map[string]nodeProjection, len(nodes))
+	for _, name := range nodes {
+		projections[name] = nodeProjection{
+			metadataOnly:  !full[name],
+			managedFields: managedFields[name],
+		}
+	}
+	return projections
+}
+
+// relationshipReadsOnlyMetadata reports whether every rule the relationship
+// may be matched by compares metadata fields only. Both sides of each
+// criterion are checked, since edges are matched in both directions.
+func (q *QueryExecutor) relationshipReadsOnlyMetadata(rel *Relationship) bool {
+	if rel.LeftNode.ResourceProperties.Kind == "" || rel.RightNode.ResourceProperties.Kind == "" {
+		return false
+	}
+	leftKind, err := q.findGVR(rel.LeftNode.ResourceProperties.Kind)
+	if err != nil {
+		return false
+	}
+	rightKind, err := q.findGVR(rel.RightNode.ResourceProperties.Kind)
+	if err != nil {
+		return false
+	}
+
+	var rules []RelationshipRule
+	if leftKind.Resource == "namespaces" || rightKind.Resource == "namespaces" {
+		rule, err := findRuleByRelationshipType(NamespaceHasResource)
+		if err != nil {
+			return false
+		}
+		rules = append(rules, rule)
+	} else {
+		rules = findRelationshipRulesBetweenKinds(leftKind.Resource, rightKind.Resource)
+	}
+	for _, rule := range rules {
+		for _, criterion := range rule.MatchCriteria {
+			if !strings.HasPrefix(criterion.FieldA, "$.metadata.") || !strings.HasPrefix(criterion.FieldB, "$.metadata.") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// stripManagedFields returns resources without metadata.managedFields.
+// Resources that have them are copied rather than modified, since providers
+// may share them with a cache.
+func stripManagedFields(resources []map[string]interface{}) []map[string]interface{} {
+	stripped := make([]map[string]interface{}, len(resources))
+	for i, resource := range resources {
+		metadata, _ := resource["metadata"].(map[string]interface{})
+		if _, ok := metadata["managedFields"]; !ok {
+			stripped[i] = resource
+			continue
+		}
+		copied := make(map[string]interface{}, len(resource))
+		for k, v := range resource {
+			copied[k] = v
+		}
+		copiedMetadata := make(map[string]interface{}, len(metadata))
+		for k, v := range metadata {
+			if k != "managedFields" {
+				copiedMetadata[k] = v
+			}
+		}
+		copied["metadata"] = copiedMetadata
+		stripped[i] = copied
+	}
+	return stripped
+}
@@ -99,14 +99,7 @@ func (q *QueryExecutor) rewriteQueryForKindlessNodes(expr *Expression) (*Express
 					if rel.LeftNode.ResourceProperties.Properties != nil {
 						props := make([]string, 0)
 						for _, prop := range rel.LeftNode.ResourceProperties.Properties.PropertyList {
-							var valueStr string
-							switch v := prop.Value.(type) {
-							case string:
-								valueStr = fmt.Sprintf("\"%s\"", v)
-							default:
-								valueStr = fmt.Sprintf("%v", v)
-							}
-							props = append(props, fmt.Sprintf("%s: %s", prop.Key, valueStr))
+							props = append(props, formatNodeProperty(prop))
 						}
 						leftNodeStr += fmt.Sprintf(" {%s}", strings.Join(props, ", "))
 					}
@@ -123,21 +116,19 @@ func (q *QueryExecutor) rewriteQueryForKindlessNodes(expr *Expression) (*Express
 					if rel.RightNode.ResourceProperties.Properties != nil {
 						props := make([]string, 0)
 						for _, prop := range rel.RightNode.ResourceProperties.Properties.PropertyList {
-							var valueStr string
-							switch v := prop.Value.(type) {
-							case string:
-								valueStr = fmt.Sprintf("\"%s\"", v)
-							default:
-								valueStr = fmt.Sprintf("%v", v)
-							}
-							props = append(props, fmt.Sprintf("%s: %s", prop.Key, valueStr))
+							props = append(props, formatNodeProperty(prop))
 						}
 						rightNodeStr += fmt.Sprintf(" {%s}", strings.Join(props, ", "))
 					}
 					rightNodeStr += ")"
 
-					// Add relationship pattern
-					nodeParts = append(nodeParts, fmt.Sprintf("%s->%s", leftNodeStr, rightNodeStr))
+					// Add relationship pattern, keeping an OWNS label that
+					// decides how the nodes are related
+					if isOwnsRelationship(rel) {
+						nodeParts = append(nodeParts, fmt.Sprintf("%s-[%s__exp__%d:%s]->%s", leftNodeStr, rel.ResourceProperties.Name, i, Owns, rightNodeStr))
+					} else {
+						nodeParts = append(nodeParts, fmt.Sprintf("%s->%s", leftNodeStr, rightNodeStr))
+					}
 				}
 				if len(nodeParts) > 0 {
 					matchParts = append(matchParts, strings.Join(nodeParts, ", "))
@@ -300,6 +291,8 @@ func (q *QueryExecutor) rewriteQueryForKindlessNodes(expr *Expression) (*Express
 	if err != nil {
 		return nil, fmt.Errorf("error parsing expanded query: %w", err)
 	}
+	newAst.Explain = expr.Explain
+	newAst.Profile = expr.Profile
 
 	return newAst, nil
 }
@@ -337,3 +330,31 @@ func renderQueryLiteral(value interface{}) string {
 		return fmt.Sprintf("%v", v)
 	}
 }
+
+// formatNodeProperty renders a node property back into query syntax
+func formatNodeProperty(prop *Property) string {
+	switch prop.Operator {
+	case "":
+		switch v := prop.Value.(type) {
+		case string:
+			return fmt.Sprintf("%s: \"%s\"", prop.Key, v)
+		case []string:
+			return fmt.Sprintf("%s: %s", prop.Key, formatValueList(v))
+		}
+		return fmt.Sprintf("%s: %v", prop.Key, prop.Value)
+	case "!=", "=~":
+		return fmt.Sprintf("%s %s \"%v\"", prop.Key, prop.Operator, prop.Value)
+	case "IN", "NOT IN":
+		return fmt.Sprintf("%s %s %s", prop.Key, prop.Operator, formatValueList(prop.Value.([]string)))
+	default:
+		return fmt.Sprintf("%s %s", prop.Key, prop.Operator)
+	}
+}
+
+func formatValueList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, strconv.Quote(v))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
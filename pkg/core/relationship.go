@@ -11,7 +11,6 @@ import (
 	"sync"
 
 	"github.com/avitaltamir/cyphernetes/pkg/provider"
-	"github.com/avitaltamir/cyphernetes/pkg/provider/apiserver"
 	"github.com/gobwas/glob"
 	"gopkg.in/yaml.v2"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -41,78 +40,70 @@ func findRuleByRelationshipType(relType RelationshipType) (RelationshipRule, err
 	return RelationshipRule{}, fmt.Errorf("no rule found for relationship type: %s", relType)
 }
 
+// isOwnsRelationship reports whether rel is labeled OWNS, as in
+// (d:Deployment)-[r:OWNS]->(rs:ReplicaSet).
+func isOwnsRelationship(rel *Relationship) bool {
+	return rel.ResourceProperties != nil && strings.EqualFold(rel.ResourceProperties.Kind, string(Owns))
+}
+
+// ownsRule returns the OWNS rule for kindA and kindB.
+func ownsRule(kindA, kindB string) RelationshipRule {
+	return RelationshipRule{
+		KindA:         kindA,
+		KindB:         kindB,
+		Relationship:  Owns,
+		MatchCriteria: []MatchCriterion{ownerReferenceCriterion},
+	}
+}
+
 func applyRelationshipRule(resourcesA, resourcesB []map[string]interface{}, rule RelationshipRule, direction Direction) map[string]interface{} {
-	var matchedResourcesA []map[string]interface{}
-	var matchedResourcesB []map[string]interface{}
-
-	for _, resourceA := range resourcesA {
-		for _, resourceB := range resourcesB {
-			matched := false
-			for _, criterion := range rule.MatchCriteria {
-				if matchByCriterion(resourceA, resourceB, criterion) {
-					matched = true
-					break
-				}
-			}
+	var matchedResourcesA, matchedResourcesB resourceSet
 
-			if matched {
-				if !containsResource(matchedResourcesA, resourceA) {
-					matchedResourcesA = append(matchedResourcesA, resourceA)
-				}
-				if !containsResource(matchedResourcesB, resourceB) {
-					matchedResourcesB = append(matchedResourcesB, resourceB)
-				}
-			}
+	for i, matches := range joinRule(resourcesA, resourcesB, rule) {
+		for _, j := range matches {
+			matchedResourcesA.add(resourcesA[i])
+			matchedResourcesB.add(resourcesB[j])
 		}
 	}
 
 	if direction == Left {
 		return map[string]interface{}{
-			"right": matchedResourcesA,
-			"left":  matchedResourcesB,
+			"right": matchedResourcesA.resources,
+			"left":  matchedResourcesB.resources,
 		}
 	} else {
 		return map[string]interface{}{
-			"right": matchedResourcesB,
-			"left":  matchedResourcesA,
+			"right": matchedResourcesB.resources,
+			"left":  matchedResourcesA.resources,
 		}
 	}
 }
 
-func containsResource(resources []map[string]interface{}, resource map[string]interface{}) bool {
-	metadata1, ok1 := resource["metadata"].(map[string]interface{})
-	if !ok1 {
-		return false
+// resourceSet collects resources in the order they are added, each once.
+// Resources are the same if both their name and namespace match; resources
+// without a name are always added.
+type resourceSet struct {
+	resources []map[string]interface{}
+	seen      map[string]bool
+}
+
+func (s *resourceSet) add(resource map[string]interface{}) {
+	metadata, _ := resource["metadata"].(map[string]interface{})
+	name, ok := metadata["name"].(string)
+	if !ok {
+		s.resources = append(s.resources, resource)
+		return
 	}
-	name1, ok1 := metadata1["name"].(string)
-	if !ok1 {
-		return false
+	namespace, _ := metadata["namespace"].(string)
+	key := namespace + "/" + name
+	if s.seen[key] {
+		return
 	}
-
-	// Get namespace for the resource being checked
-	ns1, _ := metadata1["namespace"].(string)
-	// If no namespace is set, treat as empty string (cluster-scoped or default)
-
-	for _, res := range resources {
-		metadata2, ok2 := res["metadata"].(map[string]interface{})
-		if !ok2 {
-			continue
-		}
-		name2, ok2 := metadata2["name"].(string)
-		if !ok2 {
-			continue
-		}
-
-		// Get namespace for the resource in the list
-		ns2, _ := metadata2["namespace"].(string)
-		// If no namespace is set, treat as empty string (cluster-scoped or default)
-
-		// Resources are considered the same if both name AND namespace match
-		if name1 == name2 && ns1 == ns2 {
-			return true
-		}
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
 	}
-	return false
+	s.seen[key] = true
+	s.resources = append(s.resources, resource)
 }
 
 func InitializeRelationships(resourceSpecs map[string][]string, provider provider.Provider) {
@@ -127,16 +118,45 @@ func InitializeRelationships(resourceSpecs map[string][]string, provider provide
 		fmt.Print("🧠 Initializing relationships")
 	}
 
-	relationshipCount := 0
-	totalKinds := len(resourceSpecs)
-	processed := 0
-	lastProgress := 0
-
 	// Initialize potential kinds cache
 	potentialKindsMutex.Lock()
 	potentialKindsCache = make(map[string][]string)
 	potentialKindsMutex.Unlock()
 
+	relationshipCount, cached := loadCachedRelationships(provider)
+	if !cached {
+		builtinRules := cloneRelationshipRules(relationshipRules)
+		relationshipCount = inferRelationships(resourceSpecs, provider)
+		storeCachedRelationships(provider, builtinRules, relationshipCount)
+	}
+
+	customRelationshipsCount, err := loadCustomRelationships(knownResourceKinds(provider))
+	if err != nil && !CleanOutput {
+		fmt.Println("\nError loading custom relationships:", err)
+	}
+
+	suffix := ""
+	if customRelationshipsCount > 0 {
+		suffix = fmt.Sprintf(" and %d custom", customRelationshipsCount)
+	}
+
+	debugLog("Relationship initialization complete. Found %d internal relationships and %d custom relationships", relationshipCount, customRelationshipsCount)
+
+	if !CleanOutput {
+		fmt.Printf("\033[K\r ✔️ Initializing relationships (%d internal%s processed)\n", relationshipCount, suffix)
+	}
+}
+
+// inferRelationships adds the relationship rules implied by the fields of
+// resourceSpecs, such as a spec.configMapRef.name field referencing a
+// ConfigMap, and fills the potential kinds cache. It returns the number of
+// rules added.
+func inferRelationships(resourceSpecs map[string][]string, provider provider.Provider) int {
+	relationshipCount := 0
+	totalKinds := len(resourceSpecs)
+	processed := 0
+	lastProgress := 0
+
 	// Regular expression to match fields ending with 'Name', or 'Ref'
 	nameOrKeyRefFieldRegex := regexp.MustCompile(`(\w+)(Name|KeyRef)`)
 	refFieldRegex := regexp.MustCompile(`(\w+)(Ref)`)
@@ -205,6 +225,11 @@ func InitializeRelationships(resourceSpecs map[string][]string, provider provide
 			lastProgress = progress
 		}
 
+		fieldSet := make(map[string]bool, len(fields))
+		for _, fieldPath := range fields {
+			fieldSet[fieldPath] = true
+		}
+
 		for _, fieldPath := range fields {
 			parts := strings.Split(fieldPath, ".")
 			fieldName := parts[len(parts)-1]
@@ -310,6 +335,9 @@ func InitializeRelationships(resourceSpecs map[string][]string, provider provide
 							FieldB:         fieldB,
 							ComparisonType: ExactMatch,
 						}
+						if namespaceField := strings.Join(parts[:len(parts)-1], ".") + ".namespace"; fieldSet[namespaceField] {
+							criterion.NamespaceFieldA = "$." + namespaceField
+						}
 
 						// Create new rule
 						debugLog("Creating new relationship rule for: %s -> %s", ruleKindA, ruleKindB)
@@ -393,6 +421,11 @@ func InitializeRelationships(resourceSpecs map[string][]string, provider provide
 					FieldB:         fieldB,
 					ComparisonType: ExactMatch,
 				}
+				// A reference with a namespace of its own, like a
+				// RoleBinding's subjects, may point to another namespace.
+				if (relSpecType == "Ref" || relSpecType == "KeyRef") && fieldSet[fieldPath+".namespace"] {
+					criterion.NamespaceFieldA = "$." + fieldPath + ".namespace"
+				}
 
 				// Check for existing rule and add/create as before
 				existingRuleIndex := -1
@@ -486,26 +519,7 @@ func InitializeRelationships(resourceSpecs map[string][]string, provider provide
 	}
 	potentialKindsMutex.Unlock()
 
-	var knownResourceKinds []string
-	serverProvider, ok := provider.(*apiserver.APIServerProvider)
-	if ok {
-		knownResourceKinds = serverProvider.GetKnownResourceKinds()
-	}
-	customRelationshipsCount, err := loadCustomRelationships(knownResourceKinds)
-	if err != nil && !CleanOutput {
-		fmt.Println("\nError loading custom relationships:", err)
-	}
-
-	suffix := ""
-	if customRelationshipsCount > 0 {
-		suffix = fmt.Sprintf(" and %d custom", customRelationshipsCount)
-	}
-
-	debugLog("Relationship initialization complete. Found %d internal relationships and %d custom relationships", relationshipCount, customRelationshipsCount)
-
-	if !CleanOutput {
-		fmt.Printf("\033[K\r ✔️ Initializing relationships (%d internal%s processed)\n", relationshipCount, suffix)
-	}
+	return relationshipCount
 }
 
 // Helper function to try resolving GVR with core prefix if ambiguous
@@ -577,8 +591,13 @@ func loadCustomRelationships(knownResourceKinds []string) (int, error) {
 			}
 			if criterion.ComparisonType != ExactMatch &&
 				criterion.ComparisonType != ContainsAll &&
-				criterion.ComparisonType != StringContains {
-				return 0, fmt.Errorf("invalid comparison type: must be ExactMatch, ContainsAll, or StringContains: %v", criterion.ComparisonType)
+				criterion.ComparisonType != StringContains &&
+				criterion.ComparisonType != OwnerReference &&
+				criterion.ComparisonType != LabelSelector {
+				return 0, fmt.Errorf("invalid comparison type: must be ExactMatch, ContainsAll, StringContains, OwnerReference or LabelSelector: %v", criterion.ComparisonType)
+			}
+			if criterion.NamespaceFieldA != "" && criterion.ComparisonType != ExactMatch {
+				return 0, fmt.Errorf("invalid match criterion: namespaceFieldA requires ExactMatch: %+v", criterion)
 			}
 		}
 
@@ -594,10 +613,11 @@ func loadCustomRelationships(knownResourceKinds []string) (int, error) {
 					continue
 				}
 				regexRule := RelationshipRule{
-					KindA:         strings.ToLower(gvrName),
-					KindB:         rule.KindB,
-					MatchCriteria: append([]MatchCriterion{}, rule.MatchCriteria...),
-					Relationship:  RelationshipType(fmt.Sprintf("%s_%s", rule.Relationship, strings.ToUpper(gvrName))),
+					KindA:          strings.ToLower(gvrName),
+					KindB:          rule.KindB,
+					MatchCriteria:  append([]MatchCriterion{}, rule.MatchCriteria...),
+					Relationship:   RelationshipType(fmt.Sprintf("%s_%s", rule.Relationship, strings.ToUpper(gvrName))),
+					CrossNamespace: rule.CrossNamespace,
 				}
 				counter++
 				relationshipRules = append(relationshipRules, regexRule)
@@ -743,23 +763,24 @@ func (q *QueryExecutor) processRelationship(rel *Relationship, c *MatchClause, r
 		return false, fmt.Errorf("error finding API resource >> %s", err)
 	}
 
-	// Namespace special case (handled first)
-	if rightKind.Resource == "namespaces" || leftKind.Resource == "namespaces" {
+	var selectedRule *RelationshipRule
+	if isOwnsRelationship(rel) {
+		relType = Owns
+		ruleCopy := ownsRule(leftKind.Resource, rightKind.Resource)
+		selectedRule = &ruleCopy
+	} else if rightKind.Resource == "namespaces" || leftKind.Resource == "namespaces" {
+		// Namespace special case
 		relType = NamespaceHasResource
 	}
 
 	// Find all possible rules between these kinds
-	var selectedRule *RelationshipRule
 	if relType == "" {
 		matchingRules := findRelationshipRulesBetweenKinds(leftKind.Resource, rightKind.Resource)
 
 		if len(matchingRules) == 0 {
 			// No relationship type found, error out
 			return false, fmt.Errorf("relationship type not found between %s and %s", leftKind.Resource, rightKind.Resource)
-		}
-
-		// Check if we have multiple rules to consolidate
-		if len(matchingRules) > 1 {
+		} else if len(matchingRules) > 1 {
 			// Consolidate criteria from related rules
 			consolidatedRule := consolidateMatchingRules(matchingRules, q.provider)
 			// Use the consolidated rule's relationship type
@@ -816,6 +837,7 @@ func (q *QueryExecutor) processRelationship(rel *Relationship, c *MatchClause, r
 
 	matchedResources := applyRelationshipRule(resourcesA, resourcesB, rule, filteredDirection)
 	state.markPatternRows()
+	state.recordRelationshipMatch(rel, relType)
 
 	// Add nodes and edges based on the matched resources
 	rightResources := matchedResources["right"].([]map[string]interface{})
@@ -870,44 +892,62 @@ func (q *QueryExecutor) processRelationship(rel *Relationship, c *MatchClause, r
 		results.Graph.Nodes = append(results.Graph.Nodes, node)
 	}
 
-	// Process edges
-	for _, rightResource := range rightResources {
-		for _, leftResource := range leftResources {
-			// Check if these resources actually match according to the criteria
-			for _, criterion := range rule.MatchCriteria {
-				if matchByCriterion(rightResource, leftResource, criterion) || matchByCriterion(leftResource, rightResource, criterion) {
-					rightKind, err := getResourceKind(rightResource)
-					if err != nil {
-						return false, fmt.Errorf("error reading right resource kind: %w", err)
-					}
-					leftKind, err := getResourceKind(leftResource)
-					if err != nil {
-						return false, fmt.Errorf("error reading left resource kind: %w", err)
-					}
-					rightMetadata, err := getResourceMetadata(rightResource)
-					if err != nil {
-						return false, fmt.Errorf("error reading right resource metadata: %w", err)
-					}
-					leftMetadata, err := getResourceMetadata(leftResource)
-					if err != nil {
-						return false, fmt.Errorf("error reading left resource metadata: %w", err)
-					}
-					rightName, err := getResourceName(rightMetadata)
-					if err != nil {
-						return false, fmt.Errorf("error reading right resource name: %w", err)
-					}
-					leftName, err := getResourceName(leftMetadata)
-					if err != nil {
-						return false, fmt.Errorf("error reading left resource name: %w", err)
-					}
-					rightNodeId := fmt.Sprintf("%s/%s", rightKind, rightName)
-					leftNodeId := fmt.Sprintf("%s/%s", leftKind, leftName)
-					results.Graph.Edges = append(results.Graph.Edges, Edge{
-						From: rightNodeId,
-						To:   leftNodeId,
-						Type: string(relType),
-					})
+	// Process edges, one per criterion matching a pair in either direction.
+	// Edges point from the resource on the rule's B side to the one on its
+	// A side, whichever side of the pattern each is on.
+	perCriterion := make([][][]int, len(rule.MatchCriteria))
+	rightOnA := make([][][]int, len(rule.MatchCriteria))
+	for k, criterion := range rule.MatchCriteria {
+		perCriterion[k] = joinCriterionEitherWay(rightResources, leftResources, criterion)
+		rightOnA[k] = joinCriterion(rightResources, leftResources, criterion)
+	}
+	for i, rightResource := range rightResources {
+		for _, j := range mergeMatches(perCriterion, i) {
+			leftResource := leftResources[j]
+			related := false
+			for k, criterion := range rule.MatchCriteria {
+				if !containsIndex(perCriterion[k][i], j) || !namespacesMatch(rule, criterion, rightResource, leftResource) {
+					continue
+				}
+				related = true
+				rightKind, err := getResourceKind(rightResource)
+				if err != nil {
+					return false, fmt.Errorf("error reading right resource kind: %w", err)
 				}
+				leftKind, err := getResourceKind(leftResource)
+				if err != nil {
+					return false, fmt.Errorf("error reading left resource kind: %w", err)
+				}
+				rightMetadata, err := getResourceMetadata(rightResource)
+				if err != nil {
+					return false, fmt.Errorf("error reading right resource metadata: %w", err)
+				}
+				leftMetadata, err := getResourceMetadata(leftResource)
+				if err != nil {
+					return false, fmt.Errorf("error reading left resource metadata: %w", err)
+				}
+				rightName, err := getResourceName(rightMetadata)
+				if err != nil {
+					return false, fmt.Errorf("error reading right resource name: %w", err)
+				}
+				leftName, err := getResourceName(leftMetadata)
+				if err != nil {
+					return false, fmt.Errorf("error reading left resource name: %w", err)
+				}
+				rightNodeId := fmt.Sprintf("%s/%s", rightKind, rightName)
+				leftNodeId := fmt.Sprintf("%s/%s", leftKind, leftName)
+				from, to := rightNodeId, leftNodeId
+				if containsIndex(rightOnA[k][i], j) {
+					from, to = leftNodeId, rightNodeId
+				}
+				results.Graph.Edges = append(results.Graph.Edges, Edge{
+					From: from,
+					To:   to,
+					Type: string(relType),
+				})
+			}
+			if related {
+				state.recordRelatedPair(rel, leftResource, rightResource)
 			}
 		}
 	}
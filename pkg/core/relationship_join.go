@@ -0,0 +1,404 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// Relationship rules are evaluated as joins: rather than comparing every
+// resource of one side with every resource of the other, the values a
+// criterion compares are looked up once per resource and indexed, so each
+// resource is only compared with the resources sharing a value with it. The
+// joins match exactly the pairs matchByCriterion does.
+
+// joinRule returns, for each resource of resourcesA, the ascending indexes of
+// the resources of resourcesB that rule relates it to.
+func joinRule(resourcesA, resourcesB []map[string]interface{}, rule RelationshipRule) [][]int {
+	perCriterion := make([][][]int, len(rule.MatchCriteria))
+	for k, criterion := range rule.MatchCriteria {
+		perCriterion[k] = joinCriterion(resourcesA, resourcesB, criterion)
+		for i, matches := range perCriterion[k] {
+			var kept []int
+			for _, j := range matches {
+				if namespacesMatch(rule, criterion, resourcesA[i], resourcesB[j]) {
+					kept = append(kept, j)
+				}
+			}
+			perCriterion[k][i] = kept
+		}
+	}
+	joined := make([][]int, len(resourcesA))
+	for i := range resourcesA {
+		joined[i] = mergeMatches(perCriterion, i)
+	}
+	return joined
+}
+
+// mergeMatches returns the ascending union of the matches of resource i by
+// each criterion.
+func mergeMatches(perCriterion [][][]int, i int) []int {
+	if len(perCriterion) == 1 {
+		return perCriterion[0][i]
+	}
+	var merged []int
+	for _, matches := range perCriterion {
+		merged = append(merged, matches[i]...)
+	}
+	return sortedUnique(merged)
+}
+
+// joinCriterion returns, for each resource of resourcesA, the ascending
+// indexes of the resources of resourcesB that criterion matches it to.
+func joinCriterion(resourcesA, resourcesB []map[string]interface{}, criterion MatchCriterion) [][]int {
+	switch criterion.ComparisonType {
+	case ExactMatch:
+		if criterion.NamespaceFieldA != "" {
+			return joinReference(resourcesA, resourcesB, criterion)
+		}
+		return joinExactMatch(resourcesA, resourcesB, criterion)
+	case ContainsAll:
+		return joinContainsAll(resourcesA, resourcesB, criterion)
+	case StringContains:
+		return joinStringContains(resourcesA, resourcesB, criterion)
+	case OwnerReference:
+		return joinOwnerReference(resourcesA, resourcesB, criterion)
+	case LabelSelector:
+		return joinLabelSelector(resourcesA, resourcesB, criterion)
+	}
+	return make([][]int, len(resourcesA))
+}
+
+// joinCriterionEitherWay is joinCriterion matching the resources with
+// either one on the criterion's A side.
+func joinCriterionEitherWay(resourcesA, resourcesB []map[string]interface{}, criterion MatchCriterion) [][]int {
+	joined := joinCriterion(resourcesA, resourcesB, criterion)
+	for j, matches := range joinCriterion(resourcesB, resourcesA, criterion) {
+		for _, i := range matches {
+			joined[i] = append(joined[i], j)
+		}
+	}
+	for i, matches := range joined {
+		joined[i] = sortedUnique(matches)
+	}
+	return joined
+}
+
+// joinExactMatch indexes resourcesB by the value at FieldB. A resource of
+// resourcesA matches the resources whose value equals any of the values
+// nested in its own, like matchFields.
+func joinExactMatch(resourcesA, resourcesB []map[string]interface{}, criterion MatchCriterion) [][]int {
+	valuesB, foundB := lookupField(resourcesB, criterion.FieldB)
+	index := make(map[interface{}][]int)
+	for j, value := range valuesB {
+		if foundB[j] && hashable(value) {
+			index[value] = append(index[value], j)
+		}
+	}
+
+	valuesA, foundA := lookupField(resourcesA, criterion.FieldA)
+	joined := make([][]int, len(resourcesA))
+	for i, value := range valuesA {
+		if !foundA[i] {
+			continue
+		}
+		var matches []int
+		forEachLeaf(value, func(leaf interface{}) {
+			if hashable(leaf) {
+				matches = append(matches, index[leaf]...)
+			}
+		})
+		joined[i] = sortedUnique(matches)
+	}
+	return joined
+}
+
+// joinReference indexes resourcesB by the name at FieldB. A resource of
+// resourcesA matches the resources named by its references that are in the
+// namespace the reference names, like matchReference.
+func joinReference(resourcesA, resourcesB []map[string]interface{}, criterion MatchCriterion) [][]int {
+	valuesB, foundB := lookupField(resourcesB, criterion.FieldB)
+	index := make(map[string][]int)
+	for j, value := range valuesB {
+		if name, ok := value.(string); ok && foundB[j] {
+			index[name] = append(index[name], j)
+		}
+	}
+
+	joined := make([][]int, len(resourcesA))
+	for i, resourceA := range resourcesA {
+		var matches []int
+		forEachReference(resourceA, criterion, func(name, namespace string) {
+			for _, j := range index[name] {
+				if inNamespace(resourcesB[j], namespace) {
+					matches = append(matches, j)
+				}
+			}
+		})
+		joined[i] = sortedUnique(matches)
+	}
+	return joined
+}
+
+// labelPair is a key and value of a label set.
+type labelPair struct {
+	key   string
+	value interface{}
+}
+
+// joinContainsAll indexes the labels of resourcesA by key and value. A
+// selector of resourcesB is only checked against the resources carrying its
+// least common label.
+func joinContainsAll(resourcesA, resourcesB []map[string]interface{}, criterion MatchCriterion) [][]int {
+	valuesA, _ := lookupField(resourcesA, criterion.FieldA)
+	labelsA := make([]map[string]interface{}, len(resourcesA))
+	index := make(map[labelPair][]int)
+	for i, value := range valuesA {
+		labels, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		labelsA[i] = labels
+		for key, labelValue := range labels {
+			if hashable(labelValue) {
+				pair := labelPair{key, labelValue}
+				index[pair] = append(index[pair], i)
+			}
+		}
+	}
+
+	valuesB, _ := lookupField(resourcesB, criterion.FieldB)
+	joined := make([][]int, len(resourcesA))
+	for j, value := range valuesB {
+		selector, ok := value.(map[string]interface{})
+		if !ok || len(selector) == 0 {
+			continue
+		}
+		var candidates []int
+		first := true
+		for key, selectorValue := range selector {
+			if !hashable(selectorValue) {
+				// No label can equal it
+				candidates = nil
+				break
+			}
+			posting := index[labelPair{key, selectorValue}]
+			if first || len(posting) < len(candidates) {
+				candidates = posting
+				first = false
+			}
+		}
+		for _, i := range candidates {
+			if matchContainsAll(labelsA[i], selector) {
+				joined[i] = append(joined[i], j)
+			}
+		}
+	}
+	return joined
+}
+
+// joinLabelSelector indexes the labels of resourcesA by key and value. A
+// selector of resourcesB is only checked against the resources carrying a
+// value of its most selective equality or In requirement, or against all of
+// them if it has none.
+func joinLabelSelector(resourcesA, resourcesB []map[string]interface{}, criterion MatchCriterion) [][]int {
+	valuesA, _ := lookupField(resourcesA, criterion.FieldA)
+	labelSets := make([]k8slabels.Set, len(resourcesA))
+	index := make(map[labelPair][]int)
+	for i, value := range valuesA {
+		labelSets[i] = labelSet(value)
+		for key, labelValue := range labelSets[i] {
+			pair := labelPair{key, labelValue}
+			index[pair] = append(index[pair], i)
+		}
+	}
+
+	valuesB, foundB := lookupField(resourcesB, criterion.FieldB)
+	joined := make([][]int, len(resourcesA))
+	for j, value := range valuesB {
+		if !foundB[j] {
+			continue
+		}
+		selector, err := parseLabelSelector(value)
+		if err != nil {
+			continue
+		}
+		for _, i := range selectorCandidates(selector, index, len(resourcesA)) {
+			if selector.Matches(labelSets[i]) {
+				joined[i] = append(joined[i], j)
+			}
+		}
+	}
+	return joined
+}
+
+// selectorCandidates returns the ascending indexes of the label sets
+// selector may select.
+func selectorCandidates(selector k8slabels.Selector, index map[labelPair][]int, count int) []int {
+	requirements, selectable := selector.Requirements()
+	if !selectable {
+		return nil
+	}
+	var candidates []int
+	narrowed := false
+	for _, requirement := range requirements {
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In:
+		default:
+			continue
+		}
+		var matches []int
+		for _, value := range requirement.Values().UnsortedList() {
+			matches = append(matches, index[labelPair{requirement.Key(), value}]...)
+		}
+		if !narrowed || len(matches) < len(candidates) {
+			candidates = matches
+			narrowed = true
+		}
+	}
+	if !narrowed {
+		candidates = make([]int, count)
+		for i := range candidates {
+			candidates[i] = i
+		}
+		return candidates
+	}
+	return sortedUnique(candidates)
+}
+
+// joinStringContains compares every pair, as substrings can't be indexed,
+// but renders the values of each resource only once.
+func joinStringContains(resourcesA, resourcesB []map[string]interface{}, criterion MatchCriterion) [][]int {
+	valuesA, foundA := lookupField(resourcesA, criterion.FieldA)
+	valuesB, foundB := lookupField(resourcesB, criterion.FieldB)
+	stringsB := make([]string, len(resourcesB))
+	for j, value := range valuesB {
+		stringsB[j] = fmt.Sprintf("%v", value)
+	}
+
+	joined := make([][]int, len(resourcesA))
+	for i, value := range valuesA {
+		if !foundA[i] {
+			continue
+		}
+		stringA := fmt.Sprintf("%v", value)
+		for j, stringB := range stringsB {
+			if foundB[j] && strings.Contains(stringA, stringB) {
+				joined[i] = append(joined[i], j)
+			}
+		}
+	}
+	return joined
+}
+
+// joinOwnerReference indexes the resources of both sides by uid and looks
+// up the owner references of the other side in them, like ownedBy does in
+// either direction.
+func joinOwnerReference(resourcesA, resourcesB []map[string]interface{}, criterion MatchCriterion) [][]int {
+	joined := make([][]int, len(resourcesA))
+	joinOwned(resourcesA, resourcesB, criterion, func(owned, owner int) {
+		joined[owned] = append(joined[owned], owner)
+	})
+	joinOwned(resourcesB, resourcesA, criterion, func(owned, owner int) {
+		joined[owner] = append(joined[owner], owned)
+	})
+	for i, matches := range joined {
+		joined[i] = sortedUnique(matches)
+	}
+	return joined
+}
+
+// joinOwned calls match with the indexes of each resource of owned and each
+// of its owners among owners.
+func joinOwned(owned, owners []map[string]interface{}, criterion MatchCriterion, match func(owned, owner int)) {
+	uids, found := lookupField(owners, criterion.FieldB)
+	index := make(map[interface{}][]int)
+	for j, uid := range uids {
+		if found[j] && uid != nil && uid != "" && hashable(uid) {
+			index[uid] = append(index[uid], j)
+		}
+	}
+	if len(index) == 0 {
+		return
+	}
+
+	refs, _ := lookupField(owned, criterion.FieldA)
+	for i, value := range refs {
+		refList, _ := value.([]interface{})
+		for _, ref := range refList {
+			refMap, ok := ref.(map[string]interface{})
+			if !ok || !hashable(refMap["uid"]) {
+				continue
+			}
+			for _, j := range index[refMap["uid"]] {
+				if refMap["kind"] == owners[j]["kind"] && refMap["apiVersion"] == owners[j]["apiVersion"] {
+					match(i, j)
+				}
+			}
+		}
+	}
+}
+
+// lookupField looks field up in every resource. found reports which
+// resources the field could be looked up in, as its value may be nil.
+func lookupField(resources []map[string]interface{}, field string) (values []interface{}, found []bool) {
+	values = make([]interface{}, len(resources))
+	found = make([]bool, len(resources))
+	path, err := compileJSONPath(strings.ReplaceAll(field, "[]", ""))
+	if err != nil {
+		return values, found
+	}
+	for i, resource := range resources {
+		value, err := path.Lookup(resource)
+		if err == nil {
+			values[i], found[i] = value, true
+		}
+	}
+	return values, found
+}
+
+// forEachLeaf calls fn with every value nested in the lists and maps of
+// value, or with value itself.
+func forEachLeaf(value interface{}, fn func(interface{})) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, element := range v {
+			forEachLeaf(element, fn)
+		}
+	case map[string]interface{}:
+		for _, element := range v {
+			forEachLeaf(element, fn)
+		}
+	default:
+		fn(v)
+	}
+}
+
+// hashable reports whether value can be used as a map key.
+func hashable(value interface{}) bool {
+	return value == nil || reflect.TypeOf(value).Comparable()
+}
+
+// containsIndex reports whether the ascending indexes contain index.
+func containsIndex(indexes []int, index int) bool {
+	i := sort.SearchInts(indexes, index)
+	return i < len(indexes) && indexes[i] == index
+}
+
+func sortedUnique(values []int) []int {
+	if len(values) < 2 {
+		return values
+	}
+	sort.Ints(values)
+	unique := values[:1]
+	for _, value := range values[1:] {
+		if value != unique[len(unique)-1] {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,253 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// joinFixtures returns pods and the deployments, services and replicasets
+// selecting and owning them, spread over a few namespaces.
+func joinFixtures(pods, owners int) (podList, deployments, services, replicaSets []map[string]interface{}) {
+	for i := 0; i < owners; i++ {
+		namespace := fmt.Sprintf("ns-%d", i%5)
+		app := fmt.Sprintf("app-%d", i)
+		selector := map[string]interface{}{"matchLabels": map[string]interface{}{"app": app}}
+		if i%2 == 0 {
+			selector["matchExpressions"] = []interface{}{
+				map[string]interface{}{"key": "tier", "operator": "NotIn", "values": []interface{}{"batch"}},
+			}
+		}
+		deployments = append(deployments, map[string]interface{}{
+			"kind":       "Deployment",
+			"apiVersion": "apps/v1",
+			"metadata":   map[string]interface{}{"name": app, "namespace": namespace},
+			"spec": map[string]interface{}{
+				"selector": selector,
+				"template": map[string]interface{}{"spec": map[string]interface{}{"serviceAccountName": app}},
+			},
+		})
+		services = append(services, map[string]interface{}{
+			"kind":       "Service",
+			"apiVersion": "v1",
+			"metadata":   map[string]interface{}{"name": app, "namespace": namespace},
+			"spec":       map[string]interface{}{"selector": map[string]interface{}{"app": app, "tier": "web"}},
+		})
+		replicaSets = append(replicaSets, map[string]interface{}{
+			"kind":       "ReplicaSet",
+			"apiVersion": "apps/v1",
+			"metadata":   map[string]interface{}{"name": app, "namespace": namespace, "uid": app + "-uid"},
+		})
+	}
+	for i := 0; i < pods; i++ {
+		owner := i % owners
+		app := fmt.Sprintf("app-%d", owner)
+		tier := "web"
+		if i%3 == 0 {
+			tier = "batch"
+		}
+		podList = append(podList, map[string]interface{}{
+			"kind":       "Pod",
+			"apiVersion": "v1",
+			"metadata": map[string]interface{}{
+				"name":      fmt.Sprintf("%s-pod-%d", app, i),
+				"namespace": fmt.Sprintf("ns-%d", owner%5),
+				"labels":    map[string]interface{}{"app": app, "tier": tier},
+				"ownerReferences": []interface{}{
+					map[string]interface{}{"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": app, "uid": app + "-uid"},
+				},
+			},
+			"spec": map[string]interface{}{"serviceAccountName": app},
+		})
+	}
+	return podList, deployments, services, replicaSets
+}
+
+func TestJoinCriterionMatchesPairwiseComparison(t *testing.T) {
+	pods, deployments, services, replicaSets := joinFixtures(60, 7)
+	// A pod without labels, owners or a service account
+	pods = append(pods, map[string]interface{}{
+		"kind":     "Pod",
+		"metadata": map[string]interface{}{"name": "bare"},
+	})
+	// Deployments selecting every pod, and pods without a tier
+	deployments = append(deployments,
+		map[string]interface{}{
+			"kind":     "Deployment",
+			"metadata": map[string]interface{}{"name": "all"},
+			"spec":     map[string]interface{}{"selector": map[string]interface{}{}},
+		},
+		map[string]interface{}{
+			"kind":     "Deployment",
+			"metadata": map[string]interface{}{"name": "untiered"},
+			"spec": map[string]interface{}{"selector": map[string]interface{}{"matchExpressions": []interface{}{
+				map[string]interface{}{"key": "tier", "operator": "DoesNotExist"},
+			}}},
+		},
+	)
+
+	// Bindings referencing deployments by name, in their own namespace or
+	// in the one a subject names
+	var bindings []map[string]interface{}
+	for i := 0; i < 20; i++ {
+		subjects := []interface{}{
+			map[string]interface{}{"name": fmt.Sprintf("app-%d", i%7)},
+			map[string]interface{}{"name": fmt.Sprintf("app-%d", (i+1)%7), "namespace": fmt.Sprintf("ns-%d", i%5)},
+		}
+		bindings = append(bindings, map[string]interface{}{
+			"kind":     "RoleBinding",
+			"metadata": map[string]interface{}{"name": fmt.Sprintf("binding-%d", i), "namespace": fmt.Sprintf("ns-%d", i%3)},
+			"subjects": subjects,
+		})
+	}
+
+	tests := []struct {
+		name       string
+		resourcesA []map[string]interface{}
+		resourcesB []map[string]interface{}
+		criterion  MatchCriterion
+	}{
+		{
+			name:       "ExactMatch",
+			resourcesA: pods,
+			resourcesB: deployments,
+			criterion:  MatchCriterion{FieldA: "$.spec.serviceAccountName", FieldB: "$.spec.template.spec.serviceAccountName", ComparisonType: ExactMatch},
+		},
+		{
+			name:       "ExactMatch on nested values",
+			resourcesA: pods,
+			resourcesB: replicaSets,
+			criterion:  MatchCriterion{FieldA: "$.metadata.ownerReferences[].name", FieldB: "$.metadata.name", ComparisonType: ExactMatch},
+		},
+		{
+			name:       "ExactMatch on namespaced references",
+			resourcesA: bindings,
+			resourcesB: deployments,
+			criterion:  MatchCriterion{FieldA: "$.subjects[].name", FieldB: "$.metadata.name", ComparisonType: ExactMatch, NamespaceFieldA: "$.subjects[].namespace"},
+		},
+		{
+			name:       "ContainsAll",
+			resourcesA: pods,
+			resourcesB: services,
+			criterion:  MatchCriterion{FieldA: "$.metadata.labels", FieldB: "$.spec.selector", ComparisonType: ContainsAll},
+		},
+		{
+			name:       "LabelSelector",
+			resourcesA: pods,
+			resourcesB: deployments,
+			criterion:  MatchCriterion{FieldA: "$.metadata.labels", FieldB: "$.spec.selector", ComparisonType: LabelSelector},
+		},
+		{
+			name:       "StringContains",
+			resourcesA: pods,
+			resourcesB: deployments,
+			criterion:  MatchCriterion{FieldA: "$.metadata.name", FieldB: "$.metadata.name", ComparisonType: StringContains},
+		},
+		{
+			name:       "OwnerReference",
+			resourcesA: pods,
+			resourcesB: replicaSets,
+			criterion:  ownerReferenceCriterion,
+		},
+		{
+			name:       "OwnerReference with the owner on the A side",
+			resourcesA: replicaSets,
+			resourcesB: pods,
+			criterion:  ownerReferenceCriterion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := make([][]int, len(tt.resourcesA))
+			for i, resourceA := range tt.resourcesA {
+				for j, resourceB := range tt.resourcesB {
+					if matchByCriterion(resourceA, resourceB, tt.criterion) {
+						expected[i] = append(expected[i], j)
+					}
+				}
+			}
+			matched := 0
+			for _, matches := range expected {
+				matched += len(matches)
+			}
+			if matched == 0 {
+				t.Fatalf("fixtures don't exercise the criterion")
+			}
+
+			joined := joinCriterion(tt.resourcesA, tt.resourcesB, tt.criterion)
+			if !reflect.DeepEqual(joined, expected) {
+				t.Errorf("joinCriterion() = %v, want %v", joined, expected)
+			}
+		})
+	}
+}
+
+func TestJoinRuleMatchesAnyCriterionWithinNamespace(t *testing.T) {
+	pods, _, services, _ := joinFixtures(10, 2)
+	rule := RelationshipRule{
+		KindA:        "pods",
+		KindB:        "services",
+		Relationship: "SERVICE_EXPOSE_POD",
+		MatchCriteria: []MatchCriterion{
+			{FieldA: "$.metadata.labels", FieldB: "$.spec.selector", ComparisonType: ContainsAll},
+			{FieldA: "$.metadata.name", FieldB: "$.metadata.name", ComparisonType: StringContains},
+		},
+	}
+	// A service in another namespace whose name every pod contains
+	services = append(services, map[string]interface{}{
+		"kind":     "Service",
+		"metadata": map[string]interface{}{"name": "pod", "namespace": "elsewhere"},
+	})
+
+	joined := joinRule(pods, services, rule)
+	for i, pod := range pods {
+		var expected []int
+		for j, service := range services {
+			for _, criterion := range rule.MatchCriteria {
+				if namespacesMatch(rule, criterion, pod, service) && matchByCriterion(pod, service, criterion) {
+					expected = append(expected, j)
+					break
+				}
+			}
+		}
+		if !reflect.DeepEqual(joined[i], expected) {
+			t.Errorf("joinRule()[%d] = %v, want %v", i, joined[i], expected)
+		}
+	}
+}
+
+func benchmarkApplyRelationshipRule(b *testing.B, criterion MatchCriterion, owners func(deployments, services, replicaSets []map[string]interface{}) []map[string]interface{}) {
+	pods, deployments, services, replicaSets := joinFixtures(50000, 5000)
+	resourcesB := owners(deployments, services, replicaSets)
+	rule := RelationshipRule{MatchCriteria: []MatchCriterion{criterion}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		applyRelationshipRule(pods, resourcesB, rule, Right)
+	}
+}
+
+func BenchmarkApplyRelationshipRuleExactMatch(b *testing.B) {
+	benchmarkApplyRelationshipRule(b,
+		MatchCriterion{FieldA: "$.spec.serviceAccountName", FieldB: "$.spec.template.spec.serviceAccountName", ComparisonType: ExactMatch},
+		func(deployments, _, _ []map[string]interface{}) []map[string]interface{} { return deployments })
+}
+
+func BenchmarkApplyRelationshipRuleContainsAll(b *testing.B) {
+	benchmarkApplyRelationshipRule(b,
+		MatchCriterion{FieldA: "$.metadata.labels", FieldB: "$.spec.selector", ComparisonType: ContainsAll},
+		func(_, services, _ []map[string]interface{}) []map[string]interface{} { return services })
+}
+
+func BenchmarkApplyRelationshipRuleOwnerReference(b *testing.B) {
+	benchmarkApplyRelationshipRule(b, ownerReferenceCriterion,
+		func(_, _, replicaSets []map[string]interface{}) []map[string]interface{} { return replicaSets })
+}
+
+func BenchmarkApplyRelationshipRuleLabelSelector(b *testing.B) {
+	benchmarkApplyRelationshipRule(b,
+		MatchCriterion{FieldA: "$.metadata.labels", FieldB: "$.spec.selector", ComparisonType: LabelSelector},
+		func(deployments, _, _ []map[string]interface{}) []map[string]interface{} { return deployments })
+}
@@ -3,8 +3,37 @@ package core
 import (
 	"fmt"
 	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// namespacesMatch reports whether criterion of rule may relate resourceA
+// and resourceB given their namespaces: unless the rule is CrossNamespace,
+// resources that both have a namespace must have the same one. Criteria
+// with a NamespaceFieldA match the referenced namespace themselves.
+func namespacesMatch(rule RelationshipRule, criterion MatchCriterion, resourceA, resourceB interface{}) bool {
+	if rule.CrossNamespace || criterion.NamespaceFieldA != "" {
+		return true
+	}
+	return inNamespace(resourceB, resourceNamespace(resourceA))
+}
+
+// inNamespace reports whether resource may be in namespace: namespaced
+// resources relate to cluster-scoped ones, and the other way around.
+func inNamespace(resource interface{}, namespace string) bool {
+	resourceNamespace := resourceNamespace(resource)
+	return namespace == "" || resourceNamespace == "" || namespace == resourceNamespace
+}
+
+func resourceNamespace(resource interface{}) string {
+	r, _ := resource.(map[string]interface{})
+	metadata, _ := r["metadata"].(map[string]interface{})
+	namespace, _ := metadata["namespace"].(string)
+	return namespace
+}
+
 func matchByCriterion(resourceA, resourceB interface{}, criterion MatchCriterion) bool {
 	switch criterion.ComparisonType {
 	case ContainsAll:
@@ -29,6 +58,9 @@ func matchByCriterion(resourceA, resourceB interface{}, criterion MatchCriterion
 		return matchContainsAll(labels, selector)
 
 	case ExactMatch:
+		if criterion.NamespaceFieldA != "" {
+			return matchReference(resourceA, resourceB, criterion)
+		}
 		// Extract the fields
 		fieldsA, err := JsonPathCompileAndLookup(resourceA, strings.ReplaceAll(criterion.FieldA, "[]", ""))
 		if err != nil {
@@ -57,6 +89,145 @@ func matchByCriterion(resourceA, resourceB interface{}, criterion MatchCriterion
 
 		// Check if fieldA contains fieldB
 		return strings.Contains(strA, strB)
+
+	case OwnerReference:
+		return ownedBy(resourceA, resourceB, criterion) || ownedBy(resourceB, resourceA, criterion)
+
+	case LabelSelector:
+		// Resources without labels can still be selected, e.g. by an empty
+		// selector or a DoesNotExist expression
+		l, _ := JsonPathCompileAndLookup(resourceA, strings.ReplaceAll(criterion.FieldA, "[]", ""))
+		s, err := JsonPathCompileAndLookup(resourceB, strings.ReplaceAll(criterion.FieldB, "[]", ""))
+		if err != nil {
+			return false
+		}
+		selector, err := parseLabelSelector(s)
+		if err != nil {
+			return false
+		}
+		return selector.Matches(labelSet(l))
+	}
+	return false
+}
+
+// matchReference reports whether any reference of resourceA at the
+// criterion's FieldA names resourceB, in the namespace the reference names.
+func matchReference(resourceA, resourceB interface{}, criterion MatchCriterion) bool {
+	name, err := JsonPathCompileAndLookup(resourceB, strings.ReplaceAll(criterion.FieldB, "[]", ""))
+	if err != nil {
+		return false
+	}
+	matched := false
+	forEachReference(resourceA, criterion, func(referencedName, namespace string) {
+		matched = matched || (referencedName == name && inNamespace(resourceB, namespace))
+	})
+	return matched
+}
+
+// forEachReference calls fn with the name and namespace of every reference
+// at the criterion's FieldA, the objects holding both FieldA and its
+// NamespaceFieldA. References without a namespace are to the namespace of
+// resource itself.
+func forEachReference(resource interface{}, criterion MatchCriterion, fn func(name, namespace string)) {
+	referencePath, nameKey := splitFieldPath(criterion.FieldA)
+	_, namespaceKey := splitFieldPath(criterion.NamespaceFieldA)
+	references, err := JsonPathCompileAndLookup(resource, strings.ReplaceAll(referencePath, "[]", ""))
+	if err != nil {
+		return
+	}
+	defaultNamespace := resourceNamespace(resource)
+	forEachObject(references, func(reference map[string]interface{}) {
+		name, ok := reference[nameKey].(string)
+		if !ok {
+			return
+		}
+		namespace, _ := reference[namespaceKey].(string)
+		if namespace == "" {
+			namespace = defaultNamespace
+		}
+		fn(name, namespace)
+	})
+}
+
+// splitFieldPath splits a JSONPath into the path of the object holding the
+// last field and that field's name.
+func splitFieldPath(field string) (string, string) {
+	i := strings.LastIndex(field, ".")
+	if i < 0 {
+		return "$", field
+	}
+	return field[:i], field[i+1:]
+}
+
+// forEachObject calls fn with value, if it's an object, or with every
+// object in the lists nested in it.
+func forEachObject(value interface{}, fn func(map[string]interface{})) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, element := range v {
+			forEachObject(element, fn)
+		}
+	case map[string]interface{}:
+		fn(v)
+	}
+}
+
+// parseLabelSelector parses a metav1.LabelSelector with Kubernetes
+// semantics: an empty selector selects everything. Fields other than
+// matchLabels and matchExpressions are rejected, so a plain label map used
+// as a selector doesn't parse as an empty one.
+func parseLabelSelector(value interface{}) (k8slabels.Selector, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("label selector must be an object, got %T", value)
+	}
+	for key := range m {
+		if key != "matchLabels" && key != "matchExpressions" {
+			return nil, fmt.Errorf("unknown label selector field %q", key)
+		}
+	}
+	var labelSelector metav1.LabelSelector
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &labelSelector); err != nil {
+		return nil, fmt.Errorf("invalid label selector: %w", err)
+	}
+	return metav1.LabelSelectorAsSelector(&labelSelector)
+}
+
+// labelSet returns the string labels of a label map.
+func labelSet(value interface{}) k8slabels.Set {
+	m, _ := value.(map[string]interface{})
+	set := make(k8slabels.Set, len(m))
+	for key, labelValue := range m {
+		if s, ok := labelValue.(string); ok {
+			set[key] = s
+		}
+	}
+	return set
+}
+
+// ownedBy reports whether the owner references of owned, at the criterion's
+// FieldA, include owner, identified by the uid at FieldB along with its kind
+// and apiVersion. Unlike names, uids don't match owners of another kind or
+// ones deleted and recreated under the same name.
+func ownedBy(owned, owner interface{}, criterion MatchCriterion) bool {
+	refs, err := JsonPathCompileAndLookup(owned, strings.ReplaceAll(criterion.FieldA, "[]", ""))
+	if err != nil {
+		return false
+	}
+	uid, err := JsonPathCompileAndLookup(owner, strings.ReplaceAll(criterion.FieldB, "[]", ""))
+	if err != nil || uid == nil || uid == "" {
+		return false
+	}
+	ownerMap, _ := owner.(map[string]interface{})
+	refList, _ := refs.([]interface{})
+	for _, ref := range refList {
+		refMap, ok := ref.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if refMap["uid"] == uid && refMap["kind"] == ownerMap["kind"] && refMap["apiVersion"] == ownerMap["apiVersion"] {
+			return true
+		}
 	}
 	return false
 }
@@ -139,6 +139,42 @@ func TestMatchByCriteria(t *testing.T) {
 			},
 			expectMatch: false,
 		},
+		{
+			name: "Owner reference by uid",
+			resourceA: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": "web-1",
+					"ownerReferences": []interface{}{
+						map[string]interface{}{"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "web", "uid": "1234"},
+					},
+				},
+			},
+			resourceB: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "ReplicaSet",
+				"metadata":   map[string]interface{}{"name": "web", "uid": "1234"},
+			},
+			criteria:    []MatchCriterion{ownerReferenceCriterion},
+			expectMatch: true,
+		},
+		{
+			name: "Owner reference of another kind with the same name",
+			resourceA: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "StatefulSet",
+				"metadata":   map[string]interface{}{"name": "web", "uid": "5678"},
+			},
+			resourceB: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": "web-1",
+					"ownerReferences": []interface{}{
+						map[string]interface{}{"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "web", "uid": "1234"},
+					},
+				},
+			},
+			criteria:    []MatchCriterion{ownerReferenceCriterion},
+			expectMatch: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -297,7 +333,106 @@ func TestApplyRelationshipRule(t *testing.T) {
 				},
 			},
 		},
-		// Add more test cases for different scenarios and directions
+		{
+			name: "Resources in different namespaces are not related",
+			resourcesA: []map[string]interface{}{
+				{"metadata": map[string]interface{}{"name": "resA1", "namespace": "a", "labels": map[string]interface{}{"app": "example"}}},
+			},
+			resourcesB: []map[string]interface{}{
+				{"metadata": map[string]interface{}{"name": "resB1", "namespace": "b"}, "spec": map[string]interface{}{"selector": map[string]interface{}{"app": "example"}}},
+			},
+			rule: RelationshipRule{
+				Relationship:  "EXPOSE",
+				MatchCriteria: []MatchCriterion{{FieldA: "$.metadata.labels", FieldB: "$.spec.selector", ComparisonType: ContainsAll}},
+			},
+			direction: Right,
+			expectedResult: map[string]interface{}{
+				"right": []map[string]interface{}(nil),
+				"left":  []map[string]interface{}(nil),
+			},
+		},
+		{
+			name: "Cross-namespace rule relates resources in different namespaces",
+			resourcesA: []map[string]interface{}{
+				{"metadata": map[string]interface{}{"name": "resA1", "namespace": "a"}},
+			},
+			resourcesB: []map[string]interface{}{
+				{"metadata": map[string]interface{}{"name": "resB1", "namespace": "b"}, "spec": map[string]interface{}{"serviceAccountName": "resA1"}},
+			},
+			rule: RelationshipRule{
+				Relationship:   "SERVICEACCOUNT_INSPEC_POD",
+				MatchCriteria:  []MatchCriterion{{FieldA: "$.metadata.name", FieldB: "$.spec.serviceAccountName", ComparisonType: ExactMatch}},
+				CrossNamespace: true,
+			},
+			direction: Right,
+			expectedResult: map[string]interface{}{
+				"right": []map[string]interface{}{
+					{"metadata": map[string]interface{}{"name": "resB1", "namespace": "b"}, "spec": map[string]interface{}{"serviceAccountName": "resA1"}},
+				},
+				"left": []map[string]interface{}{
+					{"metadata": map[string]interface{}{"name": "resA1", "namespace": "a"}},
+				},
+			},
+		},
+		{
+			name: "Cluster-scoped resources relate to every namespace",
+			resourcesA: []map[string]interface{}{
+				{"metadata": map[string]interface{}{"name": "claim", "namespace": "a"}, "spec": map[string]interface{}{"volumeName": "pv1"}},
+			},
+			resourcesB: []map[string]interface{}{
+				{"metadata": map[string]interface{}{"name": "pv1"}},
+			},
+			rule: RelationshipRule{
+				Relationship:  "PERSISTENTVOLUME_PERSISTENTVOLUMECLAIM",
+				MatchCriteria: []MatchCriterion{{FieldA: "$.spec.volumeName", FieldB: "$.metadata.name", ComparisonType: ExactMatch}},
+			},
+			direction: Right,
+			expectedResult: map[string]interface{}{
+				"right": []map[string]interface{}{
+					{"metadata": map[string]interface{}{"name": "pv1"}},
+				},
+				"left": []map[string]interface{}{
+					{"metadata": map[string]interface{}{"name": "claim", "namespace": "a"}, "spec": map[string]interface{}{"volumeName": "pv1"}},
+				},
+			},
+		},
+		{
+			name: "References relate resources in the namespace they name",
+			resourcesA: []map[string]interface{}{
+				{"metadata": map[string]interface{}{"name": "binding", "namespace": "a"}, "subjects": []interface{}{
+					map[string]interface{}{"kind": "ServiceAccount", "name": "builder", "namespace": "b"},
+					map[string]interface{}{"kind": "ServiceAccount", "name": "deployer"},
+				}},
+			},
+			resourcesB: []map[string]interface{}{
+				{"metadata": map[string]interface{}{"name": "builder", "namespace": "a"}},
+				{"metadata": map[string]interface{}{"name": "builder", "namespace": "b"}},
+				{"metadata": map[string]interface{}{"name": "deployer", "namespace": "a"}},
+				{"metadata": map[string]interface{}{"name": "deployer", "namespace": "b"}},
+			},
+			rule: RelationshipRule{
+				Relationship: "SERVICEACCOUNT_INSPEC_ROLEBINDING",
+				MatchCriteria: []MatchCriterion{{
+					FieldA:          "$.subjects[].name",
+					FieldB:          "$.metadata.name",
+					ComparisonType:  ExactMatch,
+					NamespaceFieldA: "$.subjects[].namespace",
+				}},
+			},
+			direction: Right,
+			expectedResult: map[string]interface{}{
+				"right": []map[string]interface{}{
+					{"metadata": map[string]interface{}{"name": "builder", "namespace": "b"}},
+					{"metadata": map[string]interface{}{"name": "deployer", "namespace": "a"}},
+				},
+				"left": []map[string]interface{}{
+					{"metadata": map[string]interface{}{"name": "binding", "namespace": "a"}, "subjects": []interface{}{
+						map[string]interface{}{"kind": "ServiceAccount", "name": "builder", "namespace": "b"},
+						map[string]interface{}{"kind": "ServiceAccount", "name": "deployer"},
+					}},
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -368,6 +503,36 @@ relationships:
 			},
 			expectedError: false,
 		},
+		{
+			name: "Cross-namespace custom relationship",
+			yamlContent: `
+relationships:
+  - kindA: serviceaccounts
+    kindB: rolebindings
+    relationship: ROLEBINDING_BINDS_SERVICEACCOUNT
+    crossNamespace: true
+    matchCriteria:
+      - fieldA: "$.metadata.name"
+        fieldB: "$.subjects[].name"
+        comparisonType: ExactMatch
+`,
+			expectedRules: []RelationshipRule{
+				{
+					KindA:        "serviceaccounts",
+					KindB:        "rolebindings",
+					Relationship: "ROLEBINDING_BINDS_SERVICEACCOUNT",
+					MatchCriteria: []MatchCriterion{
+						{
+							FieldA:         "$.metadata.name",
+							FieldB:         "$.subjects[].name",
+							ComparisonType: ExactMatch,
+						},
+					},
+					CrossNamespace: true,
+				},
+			},
+			expectedError: false,
+		},
 		{
 			name: "Missing required fields",
 			yamlContent: `
@@ -394,7 +559,23 @@ relationships:
         comparisonType: InvalidType
 `,
 			expectedError: true,
-			errorContains: "must be ExactMatch, ContainsAll, or StringContains",
+			errorContains: "must be ExactMatch, ContainsAll, StringContains, OwnerReference or LabelSelector",
+		},
+		{
+			name: "Namespace field without ExactMatch",
+			yamlContent: `
+relationships:
+  - kindA: rolebindings
+    kindB: serviceaccounts
+    relationship: ROLEBINDING_BINDS_SERVICEACCOUNT
+    matchCriteria:
+      - fieldA: "$.subjects[].name"
+        fieldB: "$.metadata.name"
+        comparisonType: StringContains
+        namespaceFieldA: "$.subjects[].namespace"
+`,
+			expectedError: true,
+			errorContains: "namespaceFieldA requires ExactMatch",
 		},
 		{
 			name: "wildcard match with no known resource kinds",
@@ -612,3 +793,118 @@ func TestStringContainsComparison(t *testing.T) {
 		})
 	}
 }
+
+func TestLabelSelectorComparison(t *testing.T) {
+	criterion := MatchCriterion{
+		FieldA:         "$.metadata.labels",
+		FieldB:         "$.spec.selector",
+		ComparisonType: LabelSelector,
+	}
+	expression := func(key, operator string, values ...interface{}) interface{} {
+		e := map[string]interface{}{"key": key, "operator": operator}
+		if len(values) > 0 {
+			e["values"] = values
+		}
+		return e
+	}
+
+	tests := []struct {
+		name        string
+		labels      map[string]interface{}
+		selector    interface{}
+		expectMatch bool
+	}{
+		{
+			name:        "matchLabels",
+			labels:      map[string]interface{}{"app": "web", "tier": "frontend"},
+			selector:    map[string]interface{}{"matchLabels": map[string]interface{}{"app": "web"}},
+			expectMatch: true,
+		},
+		{
+			name:        "matchLabels mismatch",
+			labels:      map[string]interface{}{"app": "db"},
+			selector:    map[string]interface{}{"matchLabels": map[string]interface{}{"app": "web"}},
+			expectMatch: false,
+		},
+		{
+			name:        "In",
+			labels:      map[string]interface{}{"tier": "frontend"},
+			selector:    map[string]interface{}{"matchExpressions": []interface{}{expression("tier", "In", "frontend", "cache")}},
+			expectMatch: true,
+		},
+		{
+			name:        "NotIn",
+			labels:      map[string]interface{}{"tier": "frontend"},
+			selector:    map[string]interface{}{"matchExpressions": []interface{}{expression("tier", "NotIn", "frontend")}},
+			expectMatch: false,
+		},
+		{
+			name:        "NotIn without the label",
+			labels:      map[string]interface{}{"app": "web"},
+			selector:    map[string]interface{}{"matchExpressions": []interface{}{expression("tier", "NotIn", "frontend")}},
+			expectMatch: true,
+		},
+		{
+			name:        "Exists",
+			labels:      map[string]interface{}{"app": "web"},
+			selector:    map[string]interface{}{"matchExpressions": []interface{}{expression("app", "Exists")}},
+			expectMatch: true,
+		},
+		{
+			name:        "DoesNotExist",
+			labels:      map[string]interface{}{"app": "web"},
+			selector:    map[string]interface{}{"matchExpressions": []interface{}{expression("app", "DoesNotExist")}},
+			expectMatch: false,
+		},
+		{
+			name:   "matchLabels and matchExpressions together",
+			labels: map[string]interface{}{"app": "web", "tier": "frontend"},
+			selector: map[string]interface{}{
+				"matchLabels":      map[string]interface{}{"app": "web"},
+				"matchExpressions": []interface{}{expression("tier", "In", "backend")},
+			},
+			expectMatch: false,
+		},
+		{
+			name:        "Empty selector selects all",
+			labels:      nil,
+			selector:    map[string]interface{}{},
+			expectMatch: true,
+		},
+		{
+			name:        "Missing selector selects nothing",
+			labels:      map[string]interface{}{"app": "web"},
+			selector:    nil,
+			expectMatch: false,
+		},
+		{
+			name:        "Plain label map is not a selector",
+			labels:      map[string]interface{}{"app": "web"},
+			selector:    map[string]interface{}{"app": "db"},
+			expectMatch: false,
+		},
+		{
+			name:        "Invalid operator",
+			labels:      map[string]interface{}{"app": "web"},
+			selector:    map[string]interface{}{"matchExpressions": []interface{}{expression("app", "Matches", "web")}},
+			expectMatch: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resourceA := map[string]interface{}{"metadata": map[string]interface{}{"name": "a"}}
+			if tt.labels != nil {
+				resourceA["metadata"].(map[string]interface{})["labels"] = tt.labels
+			}
+			resourceB := map[string]interface{}{"metadata": map[string]interface{}{"name": "b"}, "spec": map[string]interface{}{}}
+			if tt.selector != nil {
+				resourceB["spec"].(map[string]interface{})["selector"] = tt.selector
+			}
+			result := matchByCriterion(resourceA, resourceB, criterion)
+			if result != tt.expectMatch {
+				t.Errorf("matchByCriterion() = %v, want %v", result, tt.expectMatch)
+			}
+		})
+	}
+}
@@ -35,6 +35,9 @@ const (
 
 	// special relationships
 	NamespaceHasResource RelationshipType = "NAMESPACE_HAS_RESOURCE"
+	// Owns relates a resource to the resources listing it in their
+	// ownerReferences, whatever their kinds.
+	Owns RelationshipType = "OWNS"
 )
 
 type ComparisonType string
@@ -43,6 +46,13 @@ const (
 	ExactMatch     ComparisonType = "ExactMatch"
 	ContainsAll    ComparisonType = "ContainsAll"
 	StringContains ComparisonType = "StringContains"
+	// OwnerReference matches when either resource lists the other in its
+	// ownerReferences, by uid, kind and apiVersion.
+	OwnerReference ComparisonType = "OwnerReference"
+	// LabelSelector matches when the metav1.LabelSelector at fieldB, with
+	// its matchLabels and matchExpressions, selects the labels at fieldA. An
+	// empty selector selects everything, a missing one nothing.
+	LabelSelector ComparisonType = "LabelSelector"
 )
 
 type MatchCriterion struct {
@@ -50,6 +60,12 @@ type MatchCriterion struct {
 	FieldB         string         `yaml:"fieldB"`
 	ComparisonType ComparisonType `yaml:"comparisonType"`
 	DefaultProps   []DefaultProp  `yaml:"defaultProps,omitempty"`
+	// NamespaceFieldA is the field next to an ExactMatch fieldA naming the
+	// namespace of the resource referenced, like a RoleBinding subject's
+	// namespace. The reference then only matches resources in that
+	// namespace, or in the namespace of the referrer when the field is
+	// empty, in place of the rule's namespace scoping.
+	NamespaceFieldA string `yaml:"namespaceFieldA,omitempty" json:",omitempty"`
 }
 
 type DefaultProp struct {
@@ -63,56 +79,43 @@ type RelationshipRule struct {
 	KindB         string           `yaml:"kindB"`
 	Relationship  RelationshipType `yaml:"relationship"`
 	MatchCriteria []MatchCriterion `yaml:"matchCriteria"`
+	// CrossNamespace lets the rule relate resources in different
+	// namespaces. Otherwise two namespaced resources are only related when
+	// they are in the same namespace; cluster-scoped resources relate to
+	// resources in any namespace either way.
+	CrossNamespace bool `yaml:"crossNamespace,omitempty" json:",omitempty"`
+}
+
+var ownerReferenceCriterion = MatchCriterion{
+	FieldA:         "$.metadata.ownerReferences",
+	FieldB:         "$.metadata.uid",
+	ComparisonType: OwnerReference,
 }
 
 var relationshipRules = []RelationshipRule{
 	{
-		KindA:        "pods",
-		KindB:        "replicasets",
-		Relationship: ReplicasetOwnPod,
-		MatchCriteria: []MatchCriterion{
-			{
-				FieldA:         "$.metadata.ownerReferences[].name",
-				FieldB:         "$.metadata.name",
-				ComparisonType: ExactMatch,
-			},
-		},
+		KindA:         "pods",
+		KindB:         "replicasets",
+		Relationship:  ReplicasetOwnPod,
+		MatchCriteria: []MatchCriterion{ownerReferenceCriterion},
 	},
 	{
-		KindA:        "replicasets",
-		KindB:        "deployments",
-		Relationship: DeploymentOwnReplicaset,
-		MatchCriteria: []MatchCriterion{
-			{
-				FieldA:         "$.metadata.ownerReferences[].name",
-				FieldB:         "$.metadata.name",
-				ComparisonType: ExactMatch,
-			},
-		},
+		KindA:         "replicasets",
+		KindB:         "deployments",
+		Relationship:  DeploymentOwnReplicaset,
+		MatchCriteria: []MatchCriterion{ownerReferenceCriterion},
 	},
 	{
-		KindA:        "pods",
-		KindB:        "cronjobs",
-		Relationship: CronJobOwnPod,
-		MatchCriteria: []MatchCriterion{
-			{
-				FieldA:         "$.metadata.ownerReferences[].name",
-				FieldB:         "$.metadata.name",
-				ComparisonType: ExactMatch,
-			},
-		},
+		KindA:         "pods",
+		KindB:         "cronjobs",
+		Relationship:  CronJobOwnPod,
+		MatchCriteria: []MatchCriterion{ownerReferenceCriterion},
 	},
 	{
-		KindA:        "jobs",
-		KindB:        "cronjobs",
-		Relationship: CronJobOwnJob,
-		MatchCriteria: []MatchCriterion{
-			{
-				FieldA:         "$.metadata.ownerReferences[].name",
-				FieldB:         "$.metadata.name",
-				ComparisonType: ExactMatch,
-			},
-		},
+		KindA:         "jobs",
+		KindB:         "cronjobs",
+		Relationship:  CronJobOwnJob,
+		MatchCriteria: []MatchCriterion{ownerReferenceCriterion},
 	},
 	{
 		KindA:        "endpoints",
@@ -127,14 +130,14 @@ var relationshipRules = []RelationshipRule{
 		},
 	},
 	{
-		KindA:        "networkpolicies",
-		KindB:        "pods",
+		KindA:        "pods",
+		KindB:        "networkpolicies",
 		Relationship: NetworkPolicyApplyPod,
 		MatchCriteria: []MatchCriterion{
 			{
-				FieldA:         "$.spec.podSelector.matchLabels",
-				FieldB:         "$.metadata.labels",
-				ComparisonType: ContainsAll,
+				FieldA:         "$.metadata.labels",
+				FieldB:         "$.spec.podSelector",
+				ComparisonType: LabelSelector,
 			},
 		},
 	},
@@ -170,40 +173,22 @@ var relationshipRules = []RelationshipRule{
 		},
 	},
 	{
-		KindA:        "pods",
-		KindB:        "statefulsets",
-		Relationship: StatefulsetOwnPod,
-		MatchCriteria: []MatchCriterion{
-			{
-				FieldA:         "$.metadata.ownerReferences[].name",
-				FieldB:         "$.metadata.name",
-				ComparisonType: ExactMatch,
-			},
-		},
+		KindA:         "pods",
+		KindB:         "statefulsets",
+		Relationship:  StatefulsetOwnPod,
+		MatchCriteria: []MatchCriterion{ownerReferenceCriterion},
 	},
 	{
-		KindA:        "pods",
-		KindB:        "daemonsets",
-		Relationship: DaemonsetOwnPod,
-		MatchCriteria: []MatchCriterion{
-			{
-				FieldA:         "$.metadata.ownerReferences[].name",
-				FieldB:         "$.metadata.name",
-				ComparisonType: ExactMatch,
-			},
-		},
+		KindA:         "pods",
+		KindB:         "daemonsets",
+		Relationship:  DaemonsetOwnPod,
+		MatchCriteria: []MatchCriterion{ownerReferenceCriterion},
 	},
 	{
-		KindA:        "pods",
-		KindB:        "jobs",
-		Relationship: JobOwnPod,
-		MatchCriteria: []MatchCriterion{
-			{
-				FieldA:         "$.metadata.ownerReferences[].name",
-				FieldB:         "$.metadata.name",
-				ComparisonType: ExactMatch,
-			},
-		},
+		KindA:         "pods",
+		KindB:         "jobs",
+		Relationship:  JobOwnPod,
+		MatchCriteria: []MatchCriterion{ownerReferenceCriterion},
 	},
 	{
 		KindA:        "ingresses",
@@ -335,14 +320,14 @@ var relationshipRules = []RelationshipRule{
 		},
 	},
 	{
-		KindA:        "poddisruptionbudgets",
-		KindB:        "pods",
+		KindA:        "pods",
+		KindB:        "poddisruptionbudgets",
 		Relationship: PDBProtectPod,
 		MatchCriteria: []MatchCriterion{
 			{
-				FieldA:         "$.spec.selector.matchLabels",
-				FieldB:         "$.metadata.labels",
-				ComparisonType: ContainsAll,
+				FieldA:         "$.metadata.labels",
+				FieldB:         "$.spec.selector",
+				ComparisonType: LabelSelector,
 			},
 		},
 	},
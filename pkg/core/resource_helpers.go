@@ -25,3 +25,55 @@ func getResourceKind(resource map[string]interface{}) (string, error) {
 	}
 	return kind, nil
 }
+
+// setOwnerReference makes resource owned by owner, replacing the owner
+// references resource had.
+func setOwnerReference(resource, owner map[string]interface{}) error {
+	ownerMetadata, err := getResourceMetadata(owner)
+	if err != nil {
+		return err
+	}
+	name, err := getResourceName(ownerMetadata)
+	if err != nil {
+		return err
+	}
+	kind, err := getResourceKind(owner)
+	if err != nil {
+		return err
+	}
+	apiVersion, _ := owner["apiVersion"].(string)
+	uid, _ := ownerMetadata["uid"].(string)
+	if apiVersion == "" || uid == "" {
+		return fmt.Errorf("%s %s has no apiVersion or uid", kind, name)
+	}
+
+	metadata, ok := resource["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = make(map[string]interface{})
+		resource["metadata"] = metadata
+	}
+	metadata["ownerReferences"] = []interface{}{
+		map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"name":       name,
+			"uid":        uid,
+		},
+	}
+	return nil
+}
+
+// labelSelectorValue converts the value copied between the sides of a
+// LabelSelector criterion: labels become a selector matching them, and a
+// selector becomes its matchLabels, as its matchExpressions don't describe
+// labels to set.
+func labelSelectorValue(value interface{}, toSelector bool) interface{} {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if toSelector {
+		return map[string]interface{}{"matchLabels": m}
+	}
+	return m["matchLabels"]
+}
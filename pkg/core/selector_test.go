@@ -0,0 +1,111 @@
+package core
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// selectorRecordingProvider records the selectors sent to the provider and
+// lists resources without applying them.
+type selectorRecordingProvider struct {
+	*hardeningProvider
+	listMu         sync.Mutex
+	fieldSelectors []string
+	labelSelectors []string
+}
+
+func (p *selectorRecordingProvider) GetK8sResources(kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	p.listMu.Lock()
+	p.fieldSelectors = append(p.fieldSelectors, fieldSelector)
+	p.labelSelectors = append(p.labelSelectors, labelSelector)
+	p.listMu.Unlock()
+	return p.hardeningProvider.GetK8sResources(kind, "", "", namespace)
+}
+
+func TestParseSelectorProperties(t *testing.T) {
+	ast, err := ParseQuery(`MATCH (p:Pod {env IN ["prod", "staging"], tier NOT IN [db], canary NOT EXISTS, ready EXISTS, track != "beta", app: "web"}) RETURN p`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	props := ast.Clauses[0].(*MatchClause).Nodes[0].ResourceProperties.Properties.PropertyList
+	want := []*Property{
+		{Key: "env", Value: []string{"prod", "staging"}, Operator: "IN"},
+		{Key: "tier", Value: []string{"db"}, Operator: "NOT IN"},
+		{Key: "canary", Operator: "NOT EXISTS"},
+		{Key: "ready", Operator: "EXISTS"},
+		{Key: "track", Value: "beta", Operator: "!="},
+		{Key: "app", Value: "web"},
+	}
+	if !reflect.DeepEqual(props, want) {
+		for i := range props {
+			t.Logf("got %+v", props[i])
+		}
+		t.Fatalf("unexpected properties")
+	}
+}
+
+func TestParseSelectorPropertyErrors(t *testing.T) {
+	queries := []string{
+		`MATCH (p:Pod {env IN []}) RETURN p`,
+		`MATCH (p:Pod {env IN "prod"}) RETURN p`,
+		`MATCH (p:Pod {env NOT "prod"}) RETURN p`,
+		`MATCH (p:Pod {name != "web"}) RETURN p`,
+		`MATCH (p:Pod {namespace IN [a, b]}) RETURN p`,
+		`MATCH (p:Pod {env IN ["a),tier in (x"]}) RETURN p`,
+		`MATCH (p:Pod {env != "a,b=c"}) RETURN p`,
+		`MATCH (p:Pod {env: ["a b"]}) RETURN p`,
+		`MATCH (p:Pod {"env,tier" EXISTS}) RETURN p`,
+	}
+	for _, query := range queries {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("ParseQuery(%q) expected error", query)
+		}
+	}
+}
+
+func TestSelectorPropertiesArePushedDown(t *testing.T) {
+	provider := &selectorRecordingProvider{hardeningProvider: newHardeningProvider()}
+	executor, _ := NewQueryExecutor(provider)
+	executeTestQuery(t, executor, `MATCH (p:Pod {env IN ["prod", "staging"], tier NOT IN ["db"], canary NOT EXISTS, ready EXISTS, track != "beta", app: "a"}) RETURN p.metadata.name`)
+
+	want := "env in (prod,staging),tier notin (db),!canary,ready,track!=beta,app=a"
+	if len(provider.labelSelectors) != 1 || provider.labelSelectors[0] != want {
+		t.Fatalf("label selectors = %q, want [%q]", provider.labelSelectors, want)
+	}
+}
+
+func TestEqualityLabelSelectorsAreValidated(t *testing.T) {
+	provider := &selectorRecordingProvider{hardeningProvider: newHardeningProvider()}
+	executor, _ := NewQueryExecutor(provider)
+	ast, err := ParseQuery(`MATCH (p:Pod {app: "a,tier=web"}) RETURN p.metadata.name`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	if _, err := executor.Execute(ast, "default"); err == nil {
+		t.Fatalf("expected an invalid label value to fail the query")
+	}
+	if len(provider.labelSelectors) != 0 {
+		t.Errorf("expected nothing listed, got label selectors %q", provider.labelSelectors)
+	}
+}
+
+func TestFormatNodePropertyRoundTrips(t *testing.T) {
+	props := []*Property{
+		{Key: "env", Value: []string{"prod", "staging"}, Operator: "IN"},
+		{Key: "canary", Operator: "NOT EXISTS"},
+		{Key: "track", Value: "beta", Operator: "!="},
+		{Key: "app", Value: "web"},
+	}
+	for _, prop := range props {
+		query := "MATCH (p:Pod {" + formatNodeProperty(prop) + "}) RETURN p"
+		ast, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q) error = %v", query, err)
+		}
+		got := ast.Clauses[0].(*MatchClause).Nodes[0].ResourceProperties.Properties.PropertyList[0]
+		if !reflect.DeepEqual(got, prop) {
+			t.Errorf("round trip of %+v gave %+v", prop, got)
+		}
+	}
+}
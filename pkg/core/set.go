@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
 )
 
 func createCompatiblePatch(path []string, value interface{}) []interface{} {
@@ -90,63 +92,9 @@ func createCompatiblePatch(path []string, value interface{}) []interface{} {
 
 				return []interface{}{testPatch, addPatch}
 			}
-		} else if path[0] == "spec" && len(path) >= 3 {
-			if path[1] == "template" && path[2] == "spec" {
-				// Handle pod spec paths like spec.template.spec.containers[0].resources
-				if len(path) >= 5 && strings.HasPrefix(path[3], "containers[") {
-					// Extract the container index
-					containerIndexMatch := regexp.MustCompile(`\[(\d+)\]`).FindStringSubmatch(path[3])
-					if len(containerIndexMatch) < 2 {
-						// If we can't extract the index, fall back to regular patching
-						goto DEFAULT_CASE
-					}
-
-					containerIndex := containerIndexMatch[1]
-
-					// For container resources, we'll use a special approach
-					// Create a single strategic merge patch instead of multiple JSON patches
-					// This is more reliable for container resources
-
-					// Create a special patch that signals to the provider to use a strategic merge patch
-					specialPatch := map[string]interface{}{
-						"op":    "test",
-						"path":  "/spec/template/spec/containers",
-						"value": []interface{}{},
-					}
-
-					// Create a patch that adds the specific property
-					// We'll use a special format that the provider will recognize
-					// and convert to a strategic merge patch
-					containerPath := fmt.Sprintf("/spec/template/spec/containers/%s", containerIndex)
-
-					// Build the path to the specific property
-					propertyPath := containerPath
-					for i := 4; i < len(path); i++ {
-						part := path[i]
-						// Handle array indices in the path
-						if strings.Contains(part, "[") && strings.Contains(part, "]") {
-							part = regexp.MustCompile(`\[(\d+)\]`).ReplaceAllString(part, "/$1")
-						}
-						propertyPath += "/" + part
-					}
-
-					// Create the add patch
-					addPatch := map[string]interface{}{
-						"op":    "add",
-						"path":  propertyPath,
-						"value": value,
-					}
-
-					debugLog("Created special container patch: %+v\n", specialPatch)
-					debugLog("Created add patch: %+v\n", addPatch)
-
-					return []interface{}{specialPatch, addPatch}
-				}
-			}
 		}
 	}
 
-DEFAULT_CASE:
 	// For all other paths, use a simple add operation
 	// This will work if the parent path already exists
 	jsonPath := "/" + strings.Join(path, "/")
@@ -234,9 +182,21 @@ func (q *QueryExecutor) PatchK8sResource(resource map[string]interface{}, patchJ
 	return q.provider.PatchK8sResource(providerKind, name, namespace, patchJSON, dryRun)
 }
 
+// setPath returns the path a SET key sets below its node, e.g.
+// ["spec", "replicas"] for d.spec.replicas.
+func setPath(key string) []string {
+	_, path, _ := strings.Cut(key, ".")
+	return splitEscapedPath(path)
+}
+
 func (q *QueryExecutor) handleSetClause(c *SetClause, state *executionState) error {
 	debugLog("Processing %d key-value pairs\n", len(c.KeyValuePairs))
 
+	// With optimistic concurrency, the pairs are collected per resource and
+	// patched together once all are known.
+	var conditionalSets []*conditionalSet
+	conditionalSetIndex := make(map[string]*conditionalSet)
+
 	for i, kvp := range c.KeyValuePairs {
 		debugLog("Processing key-value pair %d: %s = %v", i, kvp.Key, kvp.Value)
 
@@ -252,22 +212,31 @@ func (q *QueryExecutor) handleSetClause(c *SetClause, state *executionState) err
 		debugLog("Found %d resources for node %s", len(resources), resultMapKey)
 
 		// Find the matching node from the stored match nodes
-		var nodeKind string
+		var matchNode *NodePattern
 		for _, node := range state.matchNodes {
 			if node.ResourceProperties.Name == resultMapKey {
-				nodeKind = node.ResourceProperties.Kind
-				debugLog("Found kind %s for node %s", nodeKind, resultMapKey)
+				matchNode = node
+				debugLog("Found kind %s for node %s", node.ResourceProperties.Kind, resultMapKey)
 				break
 			}
 		}
-		if nodeKind == "" {
+		if matchNode == nil || matchNode.ResourceProperties.Kind == "" {
 			return fmt.Errorf("could not find kind for node %s in MATCH clause", resultMapKey)
 		}
+		nodeKind := matchNode.ResourceProperties.Kind
 
 		for j, resource := range resources {
 			debugLog("Processing resource %d of %d", j+1, len(resources))
 
 			if strings.Contains(kvp.Key, "[*]") {
+				if hasListItemSelector(kvp.Key) {
+					return fmt.Errorf("list item selectors cannot be combined with [*] in %s", kvp.Key)
+				}
+				if state.conflictRetries >= 0 {
+					// Wildcard updates aren't sent as a patch a
+					// resourceVersion precondition could guard
+					return fmt.Errorf("[*] cannot be used with optimistic concurrency in %s", kvp.Key)
+				}
 				debugLog("Detected wildcard path: %s", kvp.Key)
 				// Handle wildcard updates
 				err := applyWildcardUpdate(resource, kvp.Key, kvp.Value)
@@ -275,21 +244,30 @@ func (q *QueryExecutor) handleSetClause(c *SetClause, state *executionState) err
 					return err
 				}
 				debugLog("Successfully applied wildcard update")
+			} else if state.conflictRetries >= 0 {
+				key := fmt.Sprintf("%s/%d", resultMapKey, j)
+				set, ok := conditionalSetIndex[key]
+				if !ok {
+					providerKind, err := q.providerKind(nodeKind)
+					if err != nil {
+						return fmt.Errorf("error resolving resource kind %s: %v", nodeKind, err)
+					}
+					set = &conditionalSet{node: matchNode, kind: providerKind, resource: resource}
+					conditionalSetIndex[key] = set
+					conditionalSets = append(conditionalSets, set)
+				}
+				set.pairs = append(set.pairs, kvp)
 			} else {
 				debugLog("Processing regular path update")
-				// Regular path update
-				// First remove the resource name prefix (e.g., "d.")
-				remainingPath := ""
-				if len(parts) > 1 {
-					remainingPath = parts[1]
-				}
-				debugLog("Remaining path after removing resource prefix: %s", remainingPath)
-
-				// Split the path handling escaped dots
-				pathParts := splitEscapedPath(remainingPath)
+				// Regular path update, without the resource name prefix
+				// (e.g. "d.") and with escaped dots kept within their part
+				pathParts := setPath(kvp.Key)
 				debugLog("Path parts after splitting escaped dots: %v", pathParts)
 
-				patches := createCompatiblePatch(pathParts, kvp.Value)
+				patches, err := createSetPatch(resource, pathParts, kvp.Value)
+				if err != nil {
+					return fmt.Errorf("error setting %s: %v", kvp.Key, err)
+				}
 				patchJSON, err := json.Marshal(patches)
 				if err != nil {
 					return fmt.Errorf("error marshalling patches: %s", err)
@@ -315,15 +293,16 @@ func (q *QueryExecutor) handleSetClause(c *SetClause, state *executionState) err
 				if err != nil {
 					return fmt.Errorf("error resolving resource kind %s: %v", nodeKind, err)
 				}
-				err = q.provider.PatchK8sResource(providerKind, name, namespace, patchJSON, state.dryRun)
+				err = provider.PatchK8sResource(state.ctx, q.provider, providerKind, name, namespace, patchJSON, state.dryRun)
 				if err != nil {
 					return fmt.Errorf("error patching resource: %s", err)
 				}
+				state.recordMutation()
 				debugLog("Successfully applied patch")
 
 				// Verify the patch was applied
 				debugLog("Verifying patch was applied")
-				updatedResource, err := q.provider.GetK8sResources(providerKind, fmt.Sprintf("metadata.name=%s", name), "", namespace)
+				updatedResource, err := provider.GetK8sResources(state.ctx, q.provider, providerKind, fmt.Sprintf("metadata.name=%s", name), "", namespace)
 				if err != nil {
 					return fmt.Errorf("error verifying patch: %s", err)
 				}
@@ -331,5 +310,5 @@ func (q *QueryExecutor) handleSetClause(c *SetClause, state *executionState) err
 			}
 		}
 	}
-	return nil
+	return q.applyConditionalSets(conditionalSets, state)
 }
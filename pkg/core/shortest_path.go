@@ -0,0 +1,397 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// defaultShortestPathDepth bounds the search of a shortestPath((a)-[*]-(b))
+// pattern that does not give its own [*..N] limit.
+const defaultShortestPathDepth = 6
+
+// pathHop is one resource along a shortest path. kind is the lower-cased
+// resource name used by the relationship rules, e.g. "pods".
+type pathHop struct {
+	kind     string
+	resource map[string]interface{}
+}
+
+// pathLink records which resource a hop was reached from, and through which
+// relationship, during the breadth-first search. reversed is set when the
+// relationship points from the hop back to the resource it was reached from.
+type pathLink struct {
+	from     string
+	relType  RelationshipType
+	reversed bool
+}
+
+// resourcePath is a single start-to-end path; edges[i] connects hops[i] and
+// hops[i+1]. Paths found by a shortest path search also record, in
+// reversed[i], whether the relationship of edges[i] points from hops[i+1]
+// to hops[i].
+type resourcePath struct {
+	hops     []pathHop
+	edges    []RelationshipType
+	reversed []bool
+}
+
+// pathSearch holds the per-pattern state shared by the searches started from
+// each start resource, so every intermediate kind is listed at most once.
+type pathSearch struct {
+	q         *QueryExecutor
+	state     *executionState
+	kindGraph map[string][]string
+	distToEnd map[string]int
+	fetched   map[string][]map[string]interface{}
+}
+
+// processShortestPath resolves a shortestPath / allShortestPaths pattern. The
+// start and end nodes are fetched like any other node; the hops in between
+// are found by a bounded breadth-first search over the relationship rules,
+// listing each intermediate kind from the provider on demand. Start and end
+// results are narrowed to the resources that are connected, and every path
+// found is added to the result graph.
+func (q *QueryExecutor) processShortestPath(sp *ShortestPath, c *MatchClause, results *QueryResult, state *executionState) error {
+	for _, node := range []*NodePattern{sp.Start, sp.End} {
+		if _, ok := state.getResources(node.ResourceProperties.Name); !ok {
+			if err := getNodeResources(node, q, c.ExtraFilters, state); err != nil {
+				return fmt.Errorf("error getting node resources >> %s", err)
+			}
+		}
+	}
+	startResources, _ := state.getResources(sp.Start.ResourceProperties.Name)
+	endResources, _ := state.getResources(sp.End.ResourceProperties.Name)
+
+	startGVR, err := q.findGVR(sp.Start.ResourceProperties.Kind)
+	if err != nil {
+		return fmt.Errorf("error finding API resource >> %s", err)
+	}
+	endGVR, err := q.findGVR(sp.End.ResourceProperties.Kind)
+	if err != nil {
+		return fmt.Errorf("error finding API resource >> %s", err)
+	}
+	startKind := strings.ToLower(startGVR.Resource)
+	endKind := strings.ToLower(endGVR.Resource)
+
+	maxDepth := sp.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultShortestPathDepth
+	}
+
+	kindGraph := shortestPathKindGraph()
+	search := &pathSearch{
+		q:         q,
+		state:     state,
+		kindGraph: kindGraph,
+		distToEnd: kindDistances(kindGraph, endKind),
+		fetched:   make(map[string][]map[string]interface{}),
+	}
+
+	endKeys := make(map[string]bool)
+	for _, resource := range endResources {
+		endKeys[pathHopKey(endKind, resource)] = true
+	}
+
+	var paths []resourcePath
+	if _, reachable := search.distToEnd[startKind]; reachable && len(endKeys) > 0 {
+		for _, start := range startResources {
+			found, err := search.run(pathHop{kind: startKind, resource: start}, endKeys, maxDepth, sp.All)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, found...)
+		}
+	} else {
+		debugLog("No relationship chain between %s and %s", startKind, endKind)
+	}
+
+	connectedStarts := make(map[string]bool)
+	connectedEnds := make(map[string]bool)
+	for _, path := range paths {
+		connectedStarts[pathHopKey(startKind, path.hops[0].resource)] = true
+		connectedEnds[pathHopKey(endKind, path.hops[len(path.hops)-1].resource)] = true
+	}
+	state.setResources(sp.Start.ResourceProperties.Name, filterPathResources(startResources, startKind, connectedStarts))
+	state.setResources(sp.End.ResourceProperties.Name, filterPathResources(endResources, endKind, connectedEnds))
+
+	for _, path := range paths {
+		if err := addPathToGraph(path, sp, results); err != nil {
+			return err
+		}
+	}
+	for _, path := range c.Paths {
+		if path.ShortestPath == sp {
+			state.setPaths(path.Name, paths)
+		}
+	}
+	return nil
+}
+
+// run searches outwards from start, one hop per level, until every end
+// resource has been reached or maxDepth is exhausted. Each end resource is
+// reported at the depth it was first reached; with all set every path of that
+// length is returned, otherwise only the first one found.
+func (s *pathSearch) run(start pathHop, endKeys map[string]bool, maxDepth int, all bool) ([]resourcePath, error) {
+	startKey := pathHopKey(start.kind, start.resource)
+	hops := map[string]pathHop{startKey: start}
+	depth := map[string]int{startKey: 0}
+	parents := make(map[string][]pathLink)
+
+	// The start is never reported as its own end, so it doesn't count
+	// towards the end resources left to reach.
+	targets := len(endKeys)
+	if endKeys[startKey] {
+		targets--
+	}
+
+	var reached []string
+	frontier := []string{startKey}
+	for d := 1; d <= maxDepth && len(frontier) > 0 && len(reached) < targets; d++ {
+		var next []string
+		for _, key := range frontier {
+			current := hops[key]
+			for _, neighborKind := range s.kindGraph[current.kind] {
+				// Skip kinds that cannot lead to the end kind within the bound
+				if dist, ok := s.distToEnd[neighborKind]; !ok || d+dist > maxDepth {
+					continue
+				}
+				rules := shortestPathRules(current.kind, neighborKind)
+				candidates, err := s.resourcesOfKind(neighborKind)
+				if err != nil {
+					return nil, err
+				}
+				for _, candidate := range candidates {
+					candidateKey := pathHopKey(neighborKind, candidate)
+					if candidateKey == startKey {
+						continue
+					}
+					if seenAt, seen := depth[candidateKey]; seen && seenAt < d {
+						continue
+					}
+					relType, reversed, ok := matchPathRules(rules, current, pathHop{kind: neighborKind, resource: candidate})
+					if !ok {
+						continue
+					}
+					if _, seen := depth[candidateKey]; !seen {
+						depth[candidateKey] = d
+						hops[candidateKey] = pathHop{kind: neighborKind, resource: candidate}
+						next = append(next, candidateKey)
+						if endKeys[candidateKey] {
+							reached = append(reached, candidateKey)
+						}
+					}
+					parents[candidateKey] = append(parents[candidateKey], pathLink{from: key, relType: relType, reversed: reversed})
+				}
+			}
+		}
+		frontier = next
+	}
+
+	var paths []resourcePath
+	for _, endKey := range reached {
+		paths = append(paths, buildPaths(endKey, hops, parents, all)...)
+	}
+	return paths, nil
+}
+
+// resourcesOfKind lists all resources of an intermediate kind in the query
+// namespace. Kinds the provider cannot list are treated as empty so a single
+// inaccessible kind does not fail the whole search.
+func (s *pathSearch) resourcesOfKind(kind string) ([]map[string]interface{}, error) {
+	if resources, ok := s.fetched[kind]; ok {
+		return resources, nil
+	}
+	providerKind, err := s.q.providerKind(kind)
+	if err != nil {
+		debugLog("Skipping kind %s in shortest path search: %v", kind, err)
+		s.fetched[kind] = nil
+		return nil, nil
+	}
+	resources, err := provider.GetK8sResources(s.state.ctx, s.q.provider, providerKind, "", "", s.state.namespace)
+	if err != nil {
+		debugLog("Skipping kind %s in shortest path search: %v", kind, err)
+		s.fetched[kind] = nil
+		return nil, nil
+	}
+	resourceList, ok := resources.([]map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("provider returned %T for %s, expected []map[string]interface{}", resources, kind)
+	}
+	s.fetched[kind] = resourceList
+	return resourceList, nil
+}
+
+// buildPaths walks the recorded parent links back from endKey to the start.
+func buildPaths(endKey string, hops map[string]pathHop, parents map[string][]pathLink, all bool) []resourcePath {
+	links := parents[endKey]
+	if len(links) == 0 {
+		return []resourcePath{{hops: []pathHop{hops[endKey]}}}
+	}
+	if !all {
+		links = links[:1]
+	}
+
+	var paths []resourcePath
+	for _, link := range links {
+		for _, prefix := range buildPaths(link.from, hops, parents, all) {
+			path := resourcePath{
+				hops:     append(append([]pathHop{}, prefix.hops...), hops[endKey]),
+				edges:    append(append([]RelationshipType{}, prefix.edges...), link.relType),
+				reversed: append(append([]bool{}, prefix.reversed...), link.reversed),
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// shortestPathKindGraph builds an undirected adjacency list of resource kinds
+// from the relationship rules. Namespace membership is left out since it
+// would connect every namespaced resource to every other in two hops.
+func shortestPathKindGraph() map[string][]string {
+	neighbors := make(map[string]map[string]bool)
+	link := func(a, b string) {
+		if neighbors[a] == nil {
+			neighbors[a] = make(map[string]bool)
+		}
+		neighbors[a][b] = true
+	}
+	for _, rule := range relationshipRules {
+		if rule.Relationship == NamespaceHasResource || rule.KindA == "*" || rule.KindB == "*" {
+			continue
+		}
+		kindA, kindB := strings.ToLower(rule.KindA), strings.ToLower(rule.KindB)
+		link(kindA, kindB)
+		link(kindB, kindA)
+	}
+
+	graph := make(map[string][]string, len(neighbors))
+	for kind, set := range neighbors {
+		for neighbor := range set {
+			graph[kind] = append(graph[kind], neighbor)
+		}
+		sort.Strings(graph[kind])
+	}
+	return graph
+}
+
+// kindDistances returns the number of hops from every kind to target in the
+// kind graph. Kinds that cannot reach target are absent.
+func kindDistances(graph map[string][]string, target string) map[string]int {
+	dist := map[string]int{target: 0}
+	queue := []string{target}
+	for len(queue) > 0 {
+		kind := queue[0]
+		queue = queue[1:]
+		for _, neighbor := range graph[kind] {
+			if _, seen := dist[neighbor]; !seen {
+				dist[neighbor] = dist[kind] + 1
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+	return dist
+}
+
+// shortestPathRules returns the rules connecting two kinds, in either
+// direction, without the namespace membership rule.
+func shortestPathRules(kindA, kindB string) []RelationshipRule {
+	var rules []RelationshipRule
+	for _, rule := range findRelationshipRulesBetweenKinds(kindA, kindB) {
+		if rule.Relationship != NamespaceHasResource {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// matchPathRules reports whether two resources are related by any of rules,
+// and through which relationship type. Like the edges of other patterns, the
+// relationship points from the rule's B side to its A side; reversed is set
+// when that is from to back to from.
+func matchPathRules(rules []RelationshipRule, from, to pathHop) (relType RelationshipType, reversed bool, ok bool) {
+	for _, rule := range rules {
+		for _, criterion := range rule.MatchCriteria {
+			if !namespacesMatch(rule, criterion, from.resource, to.resource) {
+				continue
+			}
+			if strings.EqualFold(rule.KindA, from.kind) && strings.EqualFold(rule.KindB, to.kind) &&
+				matchByCriterion(from.resource, to.resource, criterion) {
+				return rule.Relationship, true, true
+			}
+			if strings.EqualFold(rule.KindA, to.kind) && strings.EqualFold(rule.KindB, from.kind) &&
+				matchByCriterion(to.resource, from.resource, criterion) {
+				return rule.Relationship, false, true
+			}
+		}
+	}
+	return "", false, false
+}
+
+func pathHopKey(kind string, resource map[string]interface{}) string {
+	metadata, _ := resource["metadata"].(map[string]interface{})
+	namespace, _ := metadata["namespace"].(string)
+	name, _ := metadata["name"].(string)
+	return kind + "/" + namespace + "/" + name
+}
+
+func filterPathResources(resources []map[string]interface{}, kind string, keep map[string]bool) []map[string]interface{} {
+	filtered := []map[string]interface{}{}
+	for _, resource := range resources {
+		if keep[pathHopKey(kind, resource)] {
+			filtered = append(filtered, resource)
+		}
+	}
+	return filtered
+}
+
+// addPathToGraph adds the hops and edges of a path to the result graph. Only
+// the two ends are bound to query variables; intermediate hops have no Id.
+func addPathToGraph(path resourcePath, sp *ShortestPath, results *QueryResult) error {
+	nodeIds := make([]string, len(path.hops))
+	for i, hop := range path.hops {
+		metadata, err := getResourceMetadata(hop.resource)
+		if err != nil {
+			return fmt.Errorf("error reading path resource metadata: %w", err)
+		}
+		name, err := getResourceName(metadata)
+		if err != nil {
+			return fmt.Errorf("error reading path resource name: %w", err)
+		}
+		kind, err := getResourceKind(hop.resource)
+		if err != nil {
+			return fmt.Errorf("error reading path resource kind: %w", err)
+		}
+		node := Node{
+			Kind: kind,
+			Name: name,
+		}
+		switch i {
+		case 0:
+			node.Id = sp.Start.ResourceProperties.Name
+		case len(path.hops) - 1:
+			node.Id = sp.End.ResourceProperties.Name
+		}
+		if node.Kind != "Namespace" {
+			node.Namespace = getNamespaceName(metadata)
+		}
+		results.Graph.Nodes = append(results.Graph.Nodes, node)
+		nodeIds[i] = fmt.Sprintf("%s/%s", kind, name)
+	}
+
+	for i, relType := range path.edges {
+		from, to := nodeIds[i], nodeIds[i+1]
+		if path.reversed[i] {
+			from, to = to, from
+		}
+		results.Graph.Edges = append(results.Graph.Edges, Edge{
+			From: from,
+			To:   to,
+			Type: string(relType),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"testing"
+)
+
+// newPathProvider returns a hardeningProvider that also answers the
+// plural resource names the shortest path search lists intermediate kinds by.
+func newPathProvider() *hardeningProvider {
+	p := newHardeningProvider()
+	p.resources["pods"] = p.resources["Pod"]
+	p.resources["services"] = p.resources["Service"]
+	p.resources["deployments"] = p.resources["Deployment"]
+	return p
+}
+
+func TestParseShortestPath(t *testing.T) {
+	ast, err := ParseQuery(`MATCH allShortestPaths((d:Deployment {name: "web"})-[*..3]-(p:Pod)) RETURN d, p`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	match := ast.Clauses[0].(*MatchClause)
+	if len(match.ShortestPaths) != 1 {
+		t.Fatalf("expected 1 shortest path, got %d", len(match.ShortestPaths))
+	}
+	sp := match.ShortestPaths[0]
+	if !sp.All || sp.MaxDepth != 3 {
+		t.Fatalf("got All=%v MaxDepth=%d, want All=true MaxDepth=3", sp.All, sp.MaxDepth)
+	}
+	if sp.Start.ResourceProperties.Name != "d" || sp.End.ResourceProperties.Name != "p" {
+		t.Fatalf("unexpected endpoints %q and %q", sp.Start.ResourceProperties.Name, sp.End.ResourceProperties.Name)
+	}
+	if len(match.Nodes) != 2 || match.Nodes[0] != sp.Start || match.Nodes[1] != sp.End {
+		t.Fatalf("shortest path endpoints should be listed as match nodes, got %+v", match.Nodes)
+	}
+
+	ast, err = ParseQuery(`MATCH shortestPath((d:Deployment)-[*]->(p:Pod)) RETURN d`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	sp = ast.Clauses[0].(*MatchClause).ShortestPaths[0]
+	if sp.All || sp.MaxDepth != defaultShortestPathDepth {
+		t.Fatalf("got All=%v MaxDepth=%d, want All=false MaxDepth=%d", sp.All, sp.MaxDepth, defaultShortestPathDepth)
+	}
+}
+
+func TestParseShortestPathErrors(t *testing.T) {
+	queries := []string{
+		`MATCH shortestPath((d:Deployment)-[*]-(p)) RETURN d`,
+		`MATCH shortestPath((d:Deployment)-[r:Pod]->(p:Pod)) RETURN d`,
+		`MATCH shortestPath((d:Deployment)-[*..0]-(p:Pod)) RETURN d`,
+		`MATCH shortestPath((d:Deployment)-[*]-(p:Pod))->(s:Service) RETURN d`,
+		`CREATE shortestPath((d:Deployment)-[*]-(p:Pod))`,
+	}
+	for _, query := range queries {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("ParseQuery(%q) expected error", query)
+		}
+	}
+}
+
+func TestShortestPathFindsIntermediateHops(t *testing.T) {
+	executor, _ := NewQueryExecutor(newPathProvider())
+	result := executeTestQuery(t, executor, `MATCH shortestPath((d:Deployment {app: "a"})-[*]-(p:Pod)) RETURN d.metadata.name AS dName, p.metadata.name AS pName`)
+
+	pods := result.Data["p"].([]interface{})
+	if len(pods) != 1 {
+		t.Fatalf("expected only the connected pod, got %#v", pods)
+	}
+	assertFirstValue(t, result, "p", "pName", "pod-a")
+	assertFirstValue(t, result, "d", "dName", "deploy-a")
+
+	wantEdges := map[Edge]bool{
+		{From: "Service/svc-a", To: "Deployment/deploy-a", Type: string(ServiceExposeDeployment)}: false,
+		{From: "Service/svc-a", To: "Pod/pod-a", Type: string(ServiceExposePod)}:                  false,
+	}
+	for _, edge := range result.Graph.Edges {
+		if _, ok := wantEdges[edge]; ok {
+			wantEdges[edge] = true
+		}
+	}
+	for edge, found := range wantEdges {
+		if !found {
+			t.Errorf("missing path edge %+v in %+v", edge, result.Graph.Edges)
+		}
+	}
+
+	var sawService bool
+	for _, node := range result.Graph.Nodes {
+		if node.Kind == "Service" && node.Name == "svc-a" {
+			sawService = true
+		}
+	}
+	if !sawService {
+		t.Errorf("intermediate service missing from graph nodes %+v", result.Graph.Nodes)
+	}
+}
+
+func TestShortestPathRespectsDepthBound(t *testing.T) {
+	executor, _ := NewQueryExecutor(newPathProvider())
+	result := executeTestQuery(t, executor, `MATCH shortestPath((d:Deployment {app: "a"})-[*..1]-(p:Pod)) RETURN p.metadata.name AS pName`)
+
+	if pods, _ := result.Data["p"].([]interface{}); len(pods) != 0 {
+		t.Fatalf("expected no pods within one hop, got %#v", pods)
+	}
+}
+
+func TestShortestPathSearchSkipsStartAmongEnds(t *testing.T) {
+	executor, _ := NewQueryExecutor(newPathProvider())
+	kindGraph := shortestPathKindGraph()
+	search := &pathSearch{
+		q:         executor,
+		state:     newExecutionState(),
+		kindGraph: kindGraph,
+		distToEnd: kindDistances(kindGraph, "pods"),
+		fetched:   make(map[string][]map[string]interface{}),
+	}
+	start := pathHop{kind: "pods", resource: testPod("pod-a", "default", "a", 1)}
+
+	paths, err := search.run(start, map[string]bool{pathHopKey("pods", start.resource): true}, defaultShortestPathDepth, false)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if len(paths) != 0 || len(search.fetched) != 0 {
+		t.Errorf("expected no search when the start is the only end, got %d paths after listing %d kinds", len(paths), len(search.fetched))
+	}
+}
+
+func TestKindDistances(t *testing.T) {
+	graph := map[string][]string{
+		"a": {"b"},
+		"b": {"a", "c"},
+		"c": {"b"},
+		"d": {},
+	}
+	dist := kindDistances(graph, "c")
+	if dist["a"] != 2 || dist["b"] != 1 || dist["c"] != 0 {
+		t.Fatalf("unexpected distances %v", dist)
+	}
+	if _, ok := dist["d"]; ok {
+		t.Fatalf("unreachable kind should be absent, got %v", dist)
+	}
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strings"
@@ -9,25 +10,59 @@ import (
 
 type executionState struct {
 	mu          sync.RWMutex
+	ctx         context.Context
 	resultMap   map[string]interface{}
 	resultCache map[string]interface{}
 	matchNodes  []*NodePattern
-	namespace   string
-	dryRun      bool
-	graphNodes  map[string]bool
-	graphEdges  map[string]bool
-	hasPatterns bool
+	// matchFilters are the WHERE filters of the MATCH clause
+	matchFilters []*Filter
+	namespace    string
+	dryRun       bool
+	// conflictRetries is -1 unless SET uses optimistic concurrency
+	conflictRetries int
+	// parallelFetch fetches the nodes of a MATCH clause concurrently
+	parallelFetch bool
+	graphNodes      map[string]bool
+	graphEdges      map[string]bool
+	hasPatterns     bool
+	relMatches      map[*Relationship]*relationshipMatch
+	paths           map[string][]resourcePath
+	mutations       int
+	// limitNode may stop listing after listLimit resources; see queryListLimit
+	limitNode string
+	listLimit int64
+	// projections are the parts of each MATCH node's resources the query
+	// reads; see queryProjections
+	projections map[string]nodeProjection
 }
 
 func newExecutionState() *executionState {
 	return &executionState{
-		resultMap:   make(map[string]interface{}),
-		resultCache: make(map[string]interface{}),
-		graphNodes:  make(map[string]bool),
-		graphEdges:  make(map[string]bool),
+		ctx:             context.Background(),
+		conflictRetries: -1,
+		resultMap:       make(map[string]interface{}),
+		resultCache:     make(map[string]interface{}),
+		graphNodes:      make(map[string]bool),
+		graphEdges:      make(map[string]bool),
+		relMatches:      make(map[*Relationship]*relationshipMatch),
+		paths:           make(map[string][]resourcePath),
 	}
 }
 
+// recordMutation counts a resource created, patched or deleted
+func (s *executionState) recordMutation() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mutations++
+}
+
+// abortError reports how far an execution got before its context was done
+func (s *executionState) abortError(completedClauses, totalClauses int) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fmt.Errorf("query aborted after %d of %d clauses, %d resources changed: %w", completedClauses, totalClauses, s.mutations, s.ctx.Err())
+}
+
 func (s *executionState) getResources(key string) ([]map[string]interface{}, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -91,6 +126,49 @@ func (s *executionState) hasPatternRows() bool {
 	return s.hasPatterns
 }
 
+// recordRelationshipMatch records the relationship type rel was resolved
+// with, keeping the pairs already recorded for it.
+func (s *executionState) recordRelationshipMatch(rel *Relationship, relType RelationshipType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if match, ok := s.relMatches[rel]; ok {
+		match.relType = relType
+		return
+	}
+	s.relMatches[rel] = &relationshipMatch{relType: relType, rows: NewColumnarData()}
+}
+
+// recordRelatedPair adds left and right, which rel relates, to its pattern
+// rows.
+func (s *executionState) recordRelatedPair(rel *Relationship, left, right map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	match := s.relMatches[rel]
+	id := len(match.rows.Rows) / 2
+	match.rows.AddRow(map[string]interface{}{pathResourceColumn: left}, rel.LeftNode.ResourceProperties.Name, id)
+	match.rows.AddRow(map[string]interface{}{pathResourceColumn: right}, rel.RightNode.ResourceProperties.Name, id)
+}
+
+func (s *executionState) relationshipMatch(rel *Relationship) (*relationshipMatch, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	match, ok := s.relMatches[rel]
+	return match, ok
+}
+
+func (s *executionState) setPaths(name string, paths []resourcePath) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paths[name] = paths
+}
+
+func (s *executionState) getPaths(name string) ([]resourcePath, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	paths, ok := s.paths[name]
+	return paths, ok
+}
+
 func resourcesFromValue(value interface{}) ([]map[string]interface{}, bool) {
 	resources, ok := value.([]map[string]interface{})
 	return resources, ok
@@ -207,8 +285,9 @@ func cloneResourceProperties(props *ResourceProperties) *ResourceProperties {
 				continue
 			}
 			cloned.Properties.PropertyList[i] = &Property{
-				Key:   prop.Key,
-				Value: prop.Value,
+				Key:      prop.Key,
+				Value:    prop.Value,
+				Operator: prop.Operator,
 			}
 		}
 	}
@@ -23,6 +23,11 @@ type TokenType int
 type Expression struct {
 	Contexts []string
 	Clauses  []Clause
+	// Explain requests the fetch plan of the query instead of its results
+	Explain bool
+	// Profile requests the fetch plan and the retried API requests of the
+	// query along with its results
+	Profile bool
 }
 
 // Clause is an interface implemented by all clause types
@@ -34,6 +39,8 @@ type Clause interface {
 type MatchClause struct {
 	Nodes         []*NodePattern
 	Relationships []*Relationship
+	ShortestPaths []*ShortestPath
+	Paths         []*PathVariable
 	ExtraFilters  []*Filter
 }
 
@@ -81,6 +88,7 @@ type ReturnItem struct {
 	JsonPath  string
 	Alias     string
 	Aggregate string
+	Function  string // Path function applied to a path variable: "nodes", "relationships" or "length"
 }
 
 // OrderByItem represents an ORDER BY field with direction
@@ -112,6 +120,10 @@ type Properties struct {
 type Property struct {
 	Key   string
 	Value interface{}
+	// Operator is empty for equality, or one of the label selector operators
+	// "!=", "IN", "NOT IN", "EXISTS" and "NOT EXISTS". IN and NOT IN hold a
+	// []string value; EXISTS and NOT EXISTS have none.
+	Operator string
 }
 
 // KeyValuePair represents a key-value pair with an operator
@@ -138,10 +150,33 @@ type Relationship struct {
 	RightNode          *NodePattern
 }
 
+// ShortestPath represents a shortestPath((a)-[*]-(b)) or
+// allShortestPaths((a)-[*]-(b)) pattern. Start and End are also listed in the
+// enclosing clause's Nodes; the hops between them are discovered at execution
+// time by walking the relationship rules.
+type ShortestPath struct {
+	Start    *NodePattern
+	End      *NodePattern
+	All      bool // allShortestPaths: keep every path of minimal length
+	MaxDepth int  // Maximum number of hops, from [*..N]
+}
+
+// PathVariable binds a name to the pattern that follows it in a MATCH clause,
+// e.g. p = (d:Deployment)->(rs:ReplicaSet)->(pod:Pod). Exactly one of
+// Relationships (a chain of Nodes) or ShortestPath describes the pattern.
+type PathVariable struct {
+	Name          string
+	Nodes         []*NodePattern
+	Relationships []*Relationship
+	ShortestPath  *ShortestPath
+}
+
 // NodeRelationshipList represents a list of nodes and relationships
 type NodeRelationshipList struct {
 	Nodes         []*NodePattern
 	Relationships []*Relationship
+	ShortestPaths []*ShortestPath
+	Paths         []*PathVariable
 }
 
 // Implement isClause for all clause types
@@ -0,0 +1,658 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// DefaultFieldManager is the field manager writes are recorded under when
+// APIServerProviderConfig.FieldManager is empty.
+const DefaultFieldManager = "cyphernetes"
+
+// FieldConflict is a field another manager owns, reported by a server-side
+// apply that was not forced.
+type FieldConflict struct {
+	// Field is the path of the field, e.g. ".spec.replicas".
+	Field string
+	// Message names the manager that owns the field.
+	Message string
+}
+
+// ApplyConflictError is returned when a server-side apply conflicts with
+// fields owned by other managers, such as a GitOps controller.
+type ApplyConflictError struct {
+	Resource  string
+	Namespace string
+	Name      string
+	Conflicts []FieldConflict
+	err       error
+}
+
+func (e *ApplyConflictError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "apply of %s/%s", e.Resource, e.Name)
+	if e.Namespace != "" {
+		fmt.Fprintf(&b, " in namespace %s", e.Namespace)
+	}
+	b.WriteString(" conflicts with fields owned by other managers (force conflicts to take ownership):")
+	for _, conflict := range e.Conflicts {
+		fmt.Fprintf(&b, "\n  %s: %s", conflict.Field, conflict.Message)
+	}
+	return b.String()
+}
+
+func (e *ApplyConflictError) Unwrap() error {
+	return e.err
+}
+
+// asApplyConflict turns a conflict returned by an apply into an
+// ApplyConflictError, and returns other errors unchanged.
+func asApplyConflict(err error, gvr schema.GroupVersionResource, namespace, name string) error {
+	var status apierrors.APIStatus
+	if !apierrors.IsConflict(err) || !errors.As(err, &status) || status.Status().Details == nil {
+		return err
+	}
+	conflictErr := &ApplyConflictError{Resource: gvr.Resource, Namespace: namespace, Name: name, err: err}
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseTypeFieldManagerConflict {
+			conflictErr.Conflicts = append(conflictErr.Conflicts, FieldConflict{Field: cause.Field, Message: cause.Message})
+		}
+	}
+	if len(conflictErr.Conflicts) == 0 {
+		return err
+	}
+	return conflictErr
+}
+
+func (p *APIServerProvider) fieldManager() string {
+	if p.applyOptions.FieldManager == "" {
+		return DefaultFieldManager
+	}
+	return p.applyOptions.FieldManager
+}
+
+// apply sends object as a server-side apply request.
+func (p *APIServerProvider) apply(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string, object map[string]interface{}, dryRun bool) error {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return fmt.Errorf("error marshalling apply configuration: %v", err)
+	}
+	force := p.applyOptions.ForceConflicts
+	opts := metav1.PatchOptions{FieldManager: p.fieldManager(), Force: &force}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	err = p.withRetries(ctx, retryRequest{verb: "apply", gvr: gvr, namespace: namespace, name: name}, func(ctx context.Context) error {
+		_, err := p.dynamicClient.Resource(gvr).Namespace(namespace).Patch(ctx, name, types.ApplyPatchType, data, opts)
+		return err
+	})
+	if err != nil {
+		err = asApplyConflict(err, gvr, namespace, name)
+		var fieldConflict *ApplyConflictError
+		if errors.As(err, &fieldConflict) {
+			return err
+		}
+		// Other conflicts mean the resourceVersion the apply names is stale.
+		return conflictError(err)
+	}
+	return nil
+}
+
+// create creates object, as a server-side apply when ServerSideApply is set.
+func (p *APIServerProvider) create(ctx context.Context, gvr schema.GroupVersionResource, namespace string, object *unstructured.Unstructured, opts metav1.CreateOptions) error {
+	if !p.applyOptions.ServerSideApply {
+		return p.withRetries(ctx, retryRequest{verb: "create", gvr: gvr, namespace: namespace, name: object.GetName()}, func(ctx context.Context) error {
+			_, err := p.dynamicClient.Resource(gvr).Namespace(namespace).Create(ctx, object, opts)
+			return err
+		})
+	}
+	gvk, err := p.gvkFor(gvr)
+	if err != nil {
+		return err
+	}
+	object.SetGroupVersionKind(gvk)
+	return p.apply(ctx, gvr, namespace, object.GetName(), object.Object, len(opts.DryRun) > 0)
+}
+
+// applyPatch applies the JSON patch operations of a SET as a server-side
+// apply of the fields they set.
+func (p *APIServerProvider) applyPatch(ctx context.Context, gvr schema.GroupVersionResource, namespace, name, resourceVersion string, operations []interface{}, dryRun bool) error {
+	var live *unstructured.Unstructured
+	err := p.withRetries(ctx, retryRequest{verb: "get", gvr: gvr, namespace: namespace, name: name}, func(ctx context.Context) (err error) {
+		live, err = p.dynamicClient.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error getting resource: %v", err)
+	}
+	gvk := live.GroupVersionKind()
+	withoutSchema := false
+	keys := func(fields []string) []string {
+		schemas := p.openAPISchemas(ctx, gvk.GroupVersion())
+		if schemas == nil {
+			withoutSchema = true
+		}
+		return listMapKeys(schemas, gvk, fields)
+	}
+	owned, err := ownedConfiguration(live, p.fieldManager())
+	if err != nil {
+		return err
+	}
+	object, err := applyConfiguration(live.Object, owned, operations, keys)
+	if err != nil {
+		return err
+	}
+	if withoutSchema {
+		return errNoApplySchema
+	}
+	if resourceVersion != "" {
+		// An apply that names a resourceVersion only applies to that version.
+		(&unstructured.Unstructured{Object: object}).SetResourceVersion(resourceVersion)
+	}
+	return p.apply(ctx, gvr, namespace, name, object, dryRun)
+}
+
+// gvkFor returns the kind a resource is served as, which apply requests
+// must name. Resources pinned to a version other than the preferred one are
+// looked up in the resources of that version.
+func (p *APIServerProvider) gvkFor(gvr schema.GroupVersionResource) (schema.GroupVersionKind, error) {
+	p.gvrCacheMutex.RLock()
+	kind, ok := p.resourceKinds[gvr]
+	p.gvrCacheMutex.RUnlock()
+	if !ok {
+		resource, served := p.servedResource(gvr)
+		if !served {
+			return schema.GroupVersionKind{}, fmt.Errorf("unknown kind for resource %s", gvr.String())
+		}
+		kind = resource.Kind
+	}
+	return gvr.GroupVersion().WithKind(kind), nil
+}
+
+// errNoApplySchema is returned by applyPatch when a SET goes through a list
+// whose schema could not be fetched. Applied whole, the list's elements
+// would all become owned by the field manager, so the SET is sent as a JSON
+// patch instead.
+var errNoApplySchema = errors.New("no OpenAPI schema to apply lists by")
+
+// applyConfiguration builds the object to apply for JSON patch operations on
+// live. It holds owned, the fields the field manager applied before, the
+// fields the operations set and the identity of the list elements they are
+// under: elements of lists with map keys are addressed by their key fields,
+// while lists without keys are applied whole.
+func applyConfiguration(live, owned map[string]interface{}, operations []interface{}, keys func(fields []string) []string) (map[string]interface{}, error) {
+	liveObject := &unstructured.Unstructured{Object: live}
+	object := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	if owned != nil {
+		object.Object = runtime.DeepCopyJSON(owned)
+	}
+	object.SetAPIVersion(liveObject.GetAPIVersion())
+	object.SetKind(liveObject.GetKind())
+	object.SetName(liveObject.GetName())
+	if liveObject.GetNamespace() != "" {
+		object.SetNamespace(liveObject.GetNamespace())
+	}
+
+	for _, operation := range operations {
+		op, ok := operation.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid patch operation: %v", operation)
+		}
+		switch op["op"] {
+		case "test":
+			// Tests guard the adds that follow them; an apply creates
+			// missing parents anyway.
+			continue
+		case "add", "replace":
+		default:
+			return nil, fmt.Errorf("server-side apply does not support %q patch operations", op["op"])
+		}
+		path, _ := op["path"].(string)
+		tokens := splitPointer(path)
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("invalid patch path %q", path)
+		}
+		value := runtime.DeepCopyJSONValue(op["value"])
+		if err := setApplied(object.Object, live, tokens, nil, value, keys); err != nil {
+			return nil, fmt.Errorf("error setting %s: %v", path, err)
+		}
+	}
+	return object.Object, nil
+}
+
+// ownedConfiguration returns the fields of live that manager owns through
+// server-side apply, as recorded in its managed fields. Every apply must
+// repeat them, as the server removes the fields a manager applied before but
+// leaves out.
+func ownedConfiguration(live *unstructured.Unstructured, manager string) (map[string]interface{}, error) {
+	for _, entry := range live.GetManagedFields() {
+		if entry.Manager != manager || entry.Operation != metav1.ManagedFieldsOperationApply || entry.Subresource != "" || entry.FieldsV1 == nil {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			return nil, fmt.Errorf("error reading the fields %s manages: %v", manager, err)
+		}
+		return ownedFields(live.Object, fields), nil
+	}
+	return nil, nil
+}
+
+// ownedFields returns the fields of object that fields, a FieldsV1 set,
+// lists.
+func ownedFields(object map[string]interface{}, fields map[string]interface{}) map[string]interface{} {
+	owned := map[string]interface{}{}
+	for key, child := range fields {
+		name, ok := strings.CutPrefix(key, "f:")
+		if !ok {
+			continue
+		}
+		value, exists := object[name]
+		if !exists {
+			continue
+		}
+		childFields, _ := child.(map[string]interface{})
+		owned[name] = ownedValue(value, childFields)
+	}
+	return owned
+}
+
+// ownedValue returns the parts of value fields lists: all of it when
+// fields lists nothing under it.
+func ownedValue(value interface{}, fields map[string]interface{}) interface{} {
+	if len(fields) == 0 {
+		return runtime.DeepCopyJSONValue(value)
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return ownedFields(v, fields)
+	case []interface{}:
+		return ownedItems(v, fields)
+	}
+	return runtime.DeepCopyJSONValue(value)
+}
+
+// ownedItems returns the elements of list that fields lists, by their keys
+// ("k:"), values ("v:") or indices ("i:"), in the order of the list.
+func ownedItems(list []interface{}, fields map[string]interface{}) []interface{} {
+	owned := map[int]interface{}{}
+	for key, child := range fields {
+		childFields, _ := child.(map[string]interface{})
+		switch {
+		case strings.HasPrefix(key, "k:"):
+			var identity map[string]interface{}
+			if err := json.Unmarshal([]byte(key[2:]), &identity); err != nil {
+				continue
+			}
+			for i, item := range list {
+				element, ok := item.(map[string]interface{})
+				if !ok || !hasIdentity(element, identity) {
+					continue
+				}
+				ownedElement := ownedFields(element, childFields)
+				for field := range identity {
+					ownedElement[field] = runtime.DeepCopyJSONValue(element[field])
+				}
+				owned[i] = ownedElement
+				break
+			}
+		case strings.HasPrefix(key, "v:"):
+			var value interface{}
+			if err := json.Unmarshal([]byte(key[2:]), &value); err != nil {
+				continue
+			}
+			for i, item := range list {
+				if fmt.Sprint(item) == fmt.Sprint(value) {
+					owned[i] = runtime.DeepCopyJSONValue(item)
+					break
+				}
+			}
+		case strings.HasPrefix(key, "i:"):
+			index, err := strconv.Atoi(key[2:])
+			if err == nil && index >= 0 && index < len(list) {
+				owned[index] = ownedValue(list[index], childFields)
+			}
+		}
+	}
+	indices := make([]int, 0, len(owned))
+	for index := range owned {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	items := make([]interface{}, 0, len(indices))
+	for _, index := range indices {
+		items = append(items, owned[index])
+	}
+	return items
+}
+
+// setApplied sets value at tokens in config, a partial copy of live.
+// fields is the path of config in the object without list indices.
+func setApplied(config, live map[string]interface{}, tokens, fields []string, value interface{}, keys func([]string) []string) error {
+	key := tokens[0]
+	fields = append(fields, key)
+	if len(tokens) == 1 {
+		config[key] = value
+		return nil
+	}
+
+	liveList, isList := live[key].([]interface{})
+	if !isList {
+		child, ok := config[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			config[key] = child
+		}
+		liveChild, _ := live[key].(map[string]interface{})
+		return setApplied(child, liveChild, tokens[1:], fields, value, keys)
+	}
+
+	if tokens[1] == "-" {
+		return appendApplied(config, key, liveList, tokens, fields, value, keys)
+	}
+	index, err := strconv.Atoi(tokens[1])
+	if err != nil || index < 0 || index >= len(liveList) {
+		return fmt.Errorf("%s has no element %s", strings.Join(fields, "."), tokens[1])
+	}
+	element, isMap := liveList[index].(map[string]interface{})
+	identity := elementIdentity(element, keys(fields))
+	if !isMap || identity == nil {
+		// Without keys the list is owned as a whole; later operations on
+		// the same list build on the earlier ones.
+		whole, ok := config[key].([]interface{})
+		if !ok {
+			whole = runtime.DeepCopyJSONValue(liveList).([]interface{})
+			config[key] = whole
+		}
+		return setPath(whole, tokens[1:], value)
+	}
+
+	configList, _ := config[key].([]interface{})
+	var configElement map[string]interface{}
+	for _, item := range configList {
+		if candidate, ok := item.(map[string]interface{}); ok && hasIdentity(candidate, identity) {
+			configElement = candidate
+			break
+		}
+	}
+	if configElement == nil {
+		configElement = map[string]interface{}{}
+		for field, fieldValue := range identity {
+			configElement[field] = fieldValue
+		}
+		configList = append(configList, configElement)
+		config[key] = configList
+	}
+	if len(tokens) == 2 {
+		replacement, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s.%s must be an object", strings.Join(fields, "."), tokens[1])
+		}
+		for field := range configElement {
+			delete(configElement, field)
+		}
+		for field, fieldValue := range identity {
+			configElement[field] = fieldValue
+		}
+		for field, fieldValue := range replacement {
+			configElement[field] = fieldValue
+		}
+		return nil
+	}
+	return setApplied(configElement, element, tokens[2:], fields, value, keys)
+}
+
+// appendApplied adds the item an "add" to the end of a list appends: by
+// itself to lists with keys, and at the end of the whole list otherwise.
+func appendApplied(config map[string]interface{}, key string, liveList []interface{}, tokens, fields []string, value interface{}, keys func([]string) []string) error {
+	if len(tokens) != 2 {
+		return fmt.Errorf("%s has no element -", strings.Join(fields, "."))
+	}
+	item, _ := value.(map[string]interface{})
+	if elementIdentity(item, keys(fields)) != nil {
+		configList, _ := config[key].([]interface{})
+		config[key] = append(configList, item)
+		return nil
+	}
+	whole, ok := config[key].([]interface{})
+	if !ok {
+		whole = runtime.DeepCopyJSONValue(liveList).([]interface{})
+	}
+	config[key] = append(whole, value)
+	return nil
+}
+
+// elementIdentity returns the key fields of a list element, or nil when the
+// list has no keys or the element lacks all of them.
+func elementIdentity(element map[string]interface{}, keys []string) map[string]interface{} {
+	if element == nil {
+		return nil
+	}
+	identity := map[string]interface{}{}
+	for _, key := range keys {
+		if value, ok := element[key]; ok {
+			identity[key] = value
+		}
+	}
+	if len(identity) == 0 {
+		return nil
+	}
+	return identity
+}
+
+func hasIdentity(element, identity map[string]interface{}) bool {
+	for key, value := range identity {
+		if fmt.Sprint(element[key]) != fmt.Sprint(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// setPath sets value at tokens in a JSON value, which must exist down to
+// the parent of the last token.
+func setPath(target interface{}, tokens []string, value interface{}) error {
+	for i, token := range tokens {
+		last := i == len(tokens)-1
+		switch current := target.(type) {
+		case map[string]interface{}:
+			if last {
+				current[token] = value
+				return nil
+			}
+			next, ok := current[token]
+			if !ok || next == nil {
+				next = map[string]interface{}{}
+				current[token] = next
+			}
+			target = next
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(current) {
+				return fmt.Errorf("list has no element %s", token)
+			}
+			if last {
+				current[index] = value
+				return nil
+			}
+			target = current[index]
+		default:
+			return fmt.Errorf("cannot set %s in a %T", token, current)
+		}
+	}
+	return nil
+}
+
+// splitPointer splits a JSON pointer into its unescaped tokens.
+func splitPointer(pointer string) []string {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		token = strings.ReplaceAll(token, "~1", "/")
+		tokens[i] = strings.ReplaceAll(token, "~0", "~")
+	}
+	return tokens
+}
+
+// openAPISchemas returns the OpenAPI v3 component schemas of a group
+// version, fetched once per provider. Concurrent callers wait for the fetch
+// of their group version already in flight, without holding up others.
+// Failures are not cached, and applies through lists fall back to JSON
+// patches without them.
+func (p *APIServerProvider) openAPISchemas(ctx context.Context, gv schema.GroupVersion) map[string]interface{} {
+	p.applySchemaMutex.Lock()
+	if schemas, ok := p.applySchemas[gv]; ok {
+		p.applySchemaMutex.Unlock()
+		return schemas
+	}
+	if fetch, ok := p.applySchemaFetches[gv]; ok {
+		p.applySchemaMutex.Unlock()
+		select {
+		case <-fetch.done:
+			return fetch.schemas
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	if p.clientset == nil || ctx.Err() != nil {
+		p.applySchemaMutex.Unlock()
+		return nil
+	}
+	fetch := &schemaFetch{done: make(chan struct{})}
+	if p.applySchemaFetches == nil {
+		p.applySchemaFetches = make(map[schema.GroupVersion]*schemaFetch)
+	}
+	p.applySchemaFetches[gv] = fetch
+	p.applySchemaMutex.Unlock()
+
+	schemas, err := p.fetchOpenAPISchemas(gv)
+	if err != nil {
+		provider.Debugf("No OpenAPI schemas for %s, applying through lists with JSON patches: %v", gv, err)
+	}
+
+	p.applySchemaMutex.Lock()
+	if err == nil {
+		if p.applySchemas == nil {
+			p.applySchemas = make(map[schema.GroupVersion]map[string]interface{})
+		}
+		p.applySchemas[gv] = schemas
+	}
+	delete(p.applySchemaFetches, gv)
+	p.applySchemaMutex.Unlock()
+	fetch.schemas = schemas
+	close(fetch.done)
+	return schemas
+}
+
+// schemaFetch is a fetch of a group version's schemas in flight; schemas
+// is set before done is closed.
+type schemaFetch struct {
+	done    chan struct{}
+	schemas map[string]interface{}
+}
+
+func (p *APIServerProvider) fetchOpenAPISchemas(gv schema.GroupVersion) (map[string]interface{}, error) {
+	path := "apis/" + gv.String()
+	if gv.Group == "" {
+		path = "api/" + gv.Version
+	}
+	paths, err := p.clientset.Discovery().OpenAPIV3().Paths()
+	if err != nil {
+		return nil, fmt.Errorf("error listing OpenAPI v3 paths: %w", err)
+	}
+	groupVersion, ok := paths[path]
+	if !ok {
+		return nil, fmt.Errorf("the API server publishes no OpenAPI v3 document at %s", path)
+	}
+	data, err := groupVersion.Schema(runtime.ContentTypeJSON)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching OpenAPI v3 document %s: %w", path, err)
+	}
+	var document struct {
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("error decoding OpenAPI v3 document %s: %w", path, err)
+	}
+	return document.Components.Schemas, nil
+}
+
+// listMapKeys returns the x-kubernetes-list-map-keys of the list at fields
+// in the schema of gvk, or nil when it has none.
+func listMapKeys(schemas map[string]interface{}, gvk schema.GroupVersionKind, fields []string) []string {
+	current := rootSchema(schemas, gvk)
+	for _, field := range fields {
+		current = resolveSchema(schemas, current)
+		if items, ok := current["items"].(map[string]interface{}); ok {
+			current = resolveSchema(schemas, items)
+		}
+		properties, _ := current["properties"].(map[string]interface{})
+		current, _ = properties[field].(map[string]interface{})
+		if current == nil {
+			return nil
+		}
+	}
+	current = resolveSchema(schemas, current)
+	rawKeys, _ := current["x-kubernetes-list-map-keys"].([]interface{})
+	var keys []string
+	for _, key := range rawKeys {
+		if s, ok := key.(string); ok {
+			keys = append(keys, s)
+		}
+	}
+	return keys
+}
+
+// rootSchema finds the schema tagged with gvk.
+func rootSchema(schemas map[string]interface{}, gvk schema.GroupVersionKind) map[string]interface{} {
+	for _, raw := range schemas {
+		candidate, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		tags, _ := candidate["x-kubernetes-group-version-kind"].([]interface{})
+		for _, rawTag := range tags {
+			tag, _ := rawTag.(map[string]interface{})
+			if tag["group"] == gvk.Group && tag["version"] == gvk.Version && tag["kind"] == gvk.Kind {
+				return candidate
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSchema follows $ref and single-element allOf wrappers.
+func resolveSchema(schemas, current map[string]interface{}) map[string]interface{} {
+	for i := 0; current != nil && i < 32; i++ {
+		if ref, ok := current["$ref"].(string); ok {
+			next, _ := schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{})
+			current = next
+			continue
+		}
+		if allOf, ok := current["allOf"].([]interface{}); ok && len(allOf) == 1 {
+			next, _ := allOf[0].(map[string]interface{})
+			current = next
+			continue
+		}
+		break
+	}
+	return current
+}
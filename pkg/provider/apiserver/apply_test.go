@@ -0,0 +1,626 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+func testDeployment() map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":            "web",
+			"namespace":       "default",
+			"resourceVersion": "7",
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "app", "image": "app:1", "args": []interface{}{"--a", "--b"}},
+						map[string]interface{}{"name": "sidecar", "image": "proxy:1"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// containerKeys keys containers by name, like the Kubernetes schema.
+func containerKeys(fields []string) []string {
+	if strings.Join(fields, ".") == "spec.template.spec.containers" {
+		return []string{"name"}
+	}
+	return nil
+}
+
+func decodeOperations(t *testing.T, patch string) []interface{} {
+	t.Helper()
+	var operations []interface{}
+	if err := json.Unmarshal([]byte(patch), &operations); err != nil {
+		t.Fatalf("invalid patch: %v", err)
+	}
+	return operations
+}
+
+func TestApplyConfigurationAddressesListElementsByKey(t *testing.T) {
+	operations := decodeOperations(t, `[
+		{"op": "test", "path": "/spec/template/spec/containers", "value": []},
+		{"op": "add", "path": "/spec/template/spec/containers/1/image", "value": "proxy:2"},
+		{"op": "test", "path": "/metadata/labels", "value": {}},
+		{"op": "add", "path": "/metadata/labels/app.kubernetes.io~1name", "value": "web"}
+	]`)
+
+	got, err := applyConfiguration(testDeployment(), nil, operations, containerKeys)
+	if err != nil {
+		t.Fatalf("applyConfiguration() error = %v", err)
+	}
+	want := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":      "web",
+			"namespace": "default",
+			"labels":    map[string]interface{}{"app.kubernetes.io/name": "web"},
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "sidecar", "image": "proxy:2"},
+					},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("applyConfiguration() = %v, want %v", got, want)
+	}
+}
+
+func TestApplyConfigurationAppliesListsWithoutKeysWhole(t *testing.T) {
+	operations := decodeOperations(t, `[
+		{"op": "add", "path": "/spec/template/spec/containers/0/args/1", "value": "--c"},
+		{"op": "add", "path": "/spec/template/spec/containers/0/args/0", "value": "--z"}
+	]`)
+
+	got, err := applyConfiguration(testDeployment(), nil, operations, containerKeys)
+	if err != nil {
+		t.Fatalf("applyConfiguration() error = %v", err)
+	}
+	containers, _, _ := unstructured.NestedSlice(got, "spec", "template", "spec", "containers")
+	want := []interface{}{
+		map[string]interface{}{"name": "app", "args": []interface{}{"--z", "--c"}},
+	}
+	if !reflect.DeepEqual(containers, want) {
+		t.Errorf("containers = %v, want %v", containers, want)
+	}
+}
+
+func TestApplyConfigurationErrors(t *testing.T) {
+	tests := map[string]string{
+		"out of range": `[{"op": "add", "path": "/spec/template/spec/containers/2/image", "value": "x"}]`,
+		"remove":       `[{"op": "remove", "path": "/spec/replicas"}]`,
+	}
+	for name, patch := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := applyConfiguration(testDeployment(), nil, decodeOperations(t, patch), containerKeys); err == nil {
+				t.Fatalf("applyConfiguration() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestListMapKeys(t *testing.T) {
+	var schemas map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"io.k8s.api.apps.v1.Deployment": {
+			"x-kubernetes-group-version-kind": [{"group": "apps", "version": "v1", "kind": "Deployment"}],
+			"properties": {"spec": {"allOf": [{"$ref": "#/components/schemas/io.k8s.api.apps.v1.DeploymentSpec"}]}}
+		},
+		"io.k8s.api.apps.v1.DeploymentSpec": {
+			"properties": {"template": {"allOf": [{"$ref": "#/components/schemas/io.k8s.api.core.v1.PodTemplateSpec"}]}}
+		},
+		"io.k8s.api.core.v1.PodTemplateSpec": {
+			"properties": {"spec": {"allOf": [{"$ref": "#/components/schemas/io.k8s.api.core.v1.PodSpec"}]}}
+		},
+		"io.k8s.api.core.v1.PodSpec": {
+			"properties": {"containers": {
+				"type": "array",
+				"items": {"allOf": [{"$ref": "#/components/schemas/io.k8s.api.core.v1.Container"}]},
+				"x-kubernetes-list-map-keys": ["name"],
+				"x-kubernetes-list-type": "map"
+			}}
+		},
+		"io.k8s.api.core.v1.Container": {
+			"properties": {
+				"ports": {"type": "array", "x-kubernetes-list-map-keys": ["containerPort", "protocol"]},
+				"args": {"type": "array", "x-kubernetes-list-type": "atomic"}
+			}
+		}
+	}`), &schemas)
+	if err != nil {
+		t.Fatalf("invalid schemas: %v", err)
+	}
+	deployment := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+
+	tests := []struct {
+		fields []string
+		want   []string
+	}{
+		{[]string{"spec", "template", "spec", "containers"}, []string{"name"}},
+		{[]string{"spec", "template", "spec", "containers", "ports"}, []string{"containerPort", "protocol"}},
+		{[]string{"spec", "template", "spec", "containers", "args"}, nil},
+		{[]string{"spec", "unknown"}, nil},
+	}
+	for _, tt := range tests {
+		if got := listMapKeys(schemas, deployment, tt.fields); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("listMapKeys(%v) = %v, want %v", tt.fields, got, tt.want)
+		}
+	}
+	if got := listMapKeys(schemas, schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, []string{"spec"}); got != nil {
+		t.Errorf("listMapKeys() for an unknown kind = %v, want nil", got)
+	}
+}
+
+// applyClient serves one object and records the patches sent to it.
+type applyClient struct {
+	dynamic.Interface
+	dynamic.NamespaceableResourceInterface
+	object    *unstructured.Unstructured
+	patchErr  error
+	patches   []string
+	patchType types.PatchType
+	opts      metav1.PatchOptions
+}
+
+func (c *applyClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return c
+}
+
+func (c *applyClient) Namespace(namespace string) dynamic.ResourceInterface {
+	return c
+}
+
+func (c *applyClient) Get(ctx context.Context, name string, opts metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return c.object.DeepCopy(), nil
+}
+
+func (c *applyClient) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	c.patches = append(c.patches, string(data))
+	c.patchType = pt
+	c.opts = opts
+	if c.patchErr == nil {
+		version, _ := strconv.Atoi(c.object.GetResourceVersion())
+		c.object.SetResourceVersion(strconv.Itoa(version + 1))
+	}
+	return c.object, c.patchErr
+}
+
+var testDeploymentsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+func newApplyProvider(client *applyClient, config APIServerProviderConfig) *APIServerProvider {
+	return &APIServerProvider{
+		dynamicClient:   client,
+		gvrCache:        map[string]schema.GroupVersionResource{"Deployment": testDeploymentsGVR},
+		namespacedCache: map[string]bool{"apps/v1/deployments": true},
+		resourceKinds:   map[schema.GroupVersionResource]string{testDeploymentsGVR: "Deployment"},
+		// No schemas: lists are applied whole.
+		applySchemas: map[schema.GroupVersion]map[string]interface{}{testDeploymentsGVR.GroupVersion(): nil},
+		applyOptions: config,
+	}
+}
+
+func TestPatchWithServerSideApply(t *testing.T) {
+	client := &applyClient{object: &unstructured.Unstructured{Object: testDeployment()}}
+	p := newApplyProvider(client, APIServerProviderConfig{ServerSideApply: true, ForceConflicts: true})
+
+	patch := `[{"op": "replace", "path": "/spec/replicas", "value": 3}]`
+	if err := p.PatchK8sResource("Deployment", "web", "default", []byte(patch), true); err != nil {
+		t.Fatalf("PatchK8sResource() error = %v", err)
+	}
+
+	if client.patchType != types.ApplyPatchType {
+		t.Errorf("patch type = %s, want %s", client.patchType, types.ApplyPatchType)
+	}
+	if client.opts.FieldManager != DefaultFieldManager || client.opts.Force == nil || !*client.opts.Force {
+		t.Errorf("patch options = %+v, want field manager %q and force", client.opts, DefaultFieldManager)
+	}
+	if !reflect.DeepEqual(client.opts.DryRun, []string{metav1.DryRunAll}) {
+		t.Errorf("dry run = %v, want %v", client.opts.DryRun, []string{metav1.DryRunAll})
+	}
+	want := `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"web","namespace":"default"},"spec":{"replicas":3}}`
+	if len(client.patches) != 1 || client.patches[0] != want {
+		t.Errorf("patches = %v, want [%s]", client.patches, want)
+	}
+}
+
+func TestCreateWithServerSideApply(t *testing.T) {
+	client := &applyClient{object: &unstructured.Unstructured{Object: testDeployment()}}
+	p := newApplyProvider(client, APIServerProviderConfig{ServerSideApply: true, FieldManager: "ops"})
+
+	body := map[string]interface{}{"spec": map[string]interface{}{"replicas": 1}}
+	if err := p.CreateK8sResource("Deployment", "api", "default", body, false); err != nil {
+		t.Fatalf("CreateK8sResource() error = %v", err)
+	}
+
+	if client.patchType != types.ApplyPatchType || client.opts.FieldManager != "ops" {
+		t.Errorf("patch type %s with options %+v, want an apply by %q", client.patchType, client.opts, "ops")
+	}
+	want := `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"api","namespace":"default"},"spec":{"replicas":1}}`
+	if len(client.patches) != 1 || client.patches[0] != want {
+		t.Errorf("patches = %v, want [%s]", client.patches, want)
+	}
+}
+
+func TestServerSideApplyReportsConflictsPerField(t *testing.T) {
+	client := &applyClient{
+		object: &unstructured.Unstructured{Object: testDeployment()},
+		patchErr: apierrors.NewApplyConflict([]metav1.StatusCause{
+			{Type: metav1.CauseTypeFieldManagerConflict, Field: ".spec.replicas", Message: `conflict with "argocd-controller" using apps/v1`},
+			{Type: metav1.CauseTypeFieldManagerConflict, Field: ".metadata.labels.team", Message: `conflict with "kubectl" using apps/v1`},
+		}, "Apply failed with 2 conflicts"),
+	}
+	p := newApplyProvider(client, APIServerProviderConfig{ServerSideApply: true})
+
+	patch := `[{"op": "replace", "path": "/spec/replicas", "value": 3}]`
+	err := p.PatchK8sResource("Deployment", "web", "default", []byte(patch), false)
+
+	var conflictErr *ApplyConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("PatchK8sResource() error = %v, want an ApplyConflictError", err)
+	}
+	want := []FieldConflict{
+		{Field: ".spec.replicas", Message: `conflict with "argocd-controller" using apps/v1`},
+		{Field: ".metadata.labels.team", Message: `conflict with "kubectl" using apps/v1`},
+	}
+	if !reflect.DeepEqual(conflictErr.Conflicts, want) {
+		t.Errorf("conflicts = %v, want %v", conflictErr.Conflicts, want)
+	}
+	if !apierrors.IsConflict(err) {
+		t.Errorf("apierrors.IsConflict(%v) = false, want true", err)
+	}
+	if client.opts.Force == nil || *client.opts.Force {
+		t.Errorf("force = %v, want false", client.opts.Force)
+	}
+}
+
+func TestApplyConfigurationAppendsItems(t *testing.T) {
+	operations := decodeOperations(t, `[
+		{"op": "add", "path": "/spec/template/spec/containers/-", "value": {"name": "debug", "image": "busybox"}},
+		{"op": "add", "path": "/spec/template/spec/containers/0/args/-", "value": "--c"}
+	]`)
+
+	got, err := applyConfiguration(testDeployment(), nil, operations, containerKeys)
+	if err != nil {
+		t.Fatalf("applyConfiguration() error = %v", err)
+	}
+	containers, _, _ := unstructured.NestedSlice(got, "spec", "template", "spec", "containers")
+	want := []interface{}{
+		map[string]interface{}{"name": "debug", "image": "busybox"},
+		map[string]interface{}{"name": "app", "args": []interface{}{"--a", "--b", "--c"}},
+	}
+	if !reflect.DeepEqual(containers, want) {
+		t.Errorf("containers = %v, want %v", containers, want)
+	}
+}
+
+func TestPatchSendsGuardsWithTheirOperation(t *testing.T) {
+	client := &applyClient{object: &unstructured.Unstructured{Object: testDeployment()}}
+	p := newApplyProvider(client, APIServerProviderConfig{})
+
+	patch := `[{"op":"test","path":"/spec/template/spec/containers/1/name","value":"sidecar"},` +
+		`{"op":"add","path":"/spec/template/spec/containers/1/image","value":"proxy:2"},` +
+		`{"op":"add","path":"/spec/replicas","value":3}]`
+	if err := p.PatchK8sResource("Deployment", "web", "default", []byte(patch), false); err != nil {
+		t.Fatalf("PatchK8sResource() error = %v", err)
+	}
+
+	want := []string{
+		`[{"op":"test","path":"/spec/template/spec/containers/1/name","value":"sidecar"},{"op":"add","path":"/spec/template/spec/containers/1/image","value":"proxy:2"}]`,
+		`[{"op":"add","path":"/spec/replicas","value":3}]`,
+	}
+	if !reflect.DeepEqual(client.patches, want) {
+		t.Errorf("patches = %v, want %v", client.patches, want)
+	}
+	if client.patchType != types.JSONPatchType || client.opts.FieldManager != DefaultFieldManager {
+		t.Errorf("patch type %s with options %+v, want a JSON patch by %q", client.patchType, client.opts, DefaultFieldManager)
+	}
+}
+
+func TestPatchPreconditionCoversEveryRequest(t *testing.T) {
+	client := &applyClient{object: &unstructured.Unstructured{Object: testDeployment()}}
+	p := newApplyProvider(client, APIServerProviderConfig{})
+
+	patch := `[{"op":"test","path":"/metadata/resourceVersion","value":"7"},` +
+		`{"op":"add","path":"/spec/replicas","value":3},` +
+		`{"op":"add","path":"/spec/paused","value":true}]`
+	if err := p.PatchK8sResource("Deployment", "web", "default", []byte(patch), false); err != nil {
+		t.Fatalf("PatchK8sResource() error = %v", err)
+	}
+
+	// Each request applies to the version the previous one left.
+	want := []string{
+		`[{"op":"replace","path":"/metadata/resourceVersion","value":"7"},{"op":"add","path":"/spec/replicas","value":3}]`,
+		`[{"op":"replace","path":"/metadata/resourceVersion","value":"8"},{"op":"add","path":"/spec/paused","value":true}]`,
+	}
+	if !reflect.DeepEqual(client.patches, want) {
+		t.Errorf("patches = %v, want %v", client.patches, want)
+	}
+
+	client = &applyClient{object: &unstructured.Unstructured{Object: testDeployment()}}
+	p = newApplyProvider(client, APIServerProviderConfig{})
+	patch = `[{"op":"test","path":"/metadata/resourceVersion","value":"7"},` +
+		`{"op":"test","path":"/metadata/labels","value":{}},{"op":"add","path":"/metadata/labels/tier","value":"web"}]`
+	if err := p.PatchK8sResource("Deployment", "web", "default", []byte(patch), false); err != nil {
+		t.Fatalf("PatchK8sResource() error = %v", err)
+	}
+	if want := `{"metadata":{"labels":{"tier":"web"},"resourceVersion":"7"}}`; len(client.patches) != 1 || client.patches[0] != want || client.patchType != types.MergePatchType {
+		t.Errorf("%s patches = %v, want the merge patch %s", client.patchType, client.patches, want)
+	}
+}
+
+func TestPatchPreconditionConflicts(t *testing.T) {
+	client := &applyClient{
+		object:   &unstructured.Unstructured{Object: testDeployment()},
+		patchErr: apierrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, "web", errors.New("the object has been modified")),
+	}
+	patches := map[string][]byte{
+		"replicas": []byte(`[{"op":"test","path":"/metadata/resourceVersion","value":"6"},{"op":"add","path":"/spec/replicas","value":3}]`),
+		"label": []byte(`[{"op":"test","path":"/metadata/resourceVersion","value":"6"},` +
+			`{"op":"test","path":"/metadata/labels","value":{}},{"op":"add","path":"/metadata/labels/tier","value":"web"}]`),
+	}
+
+	for name, patch := range patches {
+		for _, config := range []APIServerProviderConfig{{}, {ServerSideApply: true}} {
+			client.patches = nil
+			err := newApplyProvider(client, config).PatchK8sResource("Deployment", "web", "default", patch, false)
+			if !errors.Is(err, provider.ErrConflict) || !apierrors.IsConflict(err) {
+				t.Errorf("%s with server-side apply %t: error = %v, want a provider.ErrConflict", name, config.ServerSideApply, err)
+			}
+			if len(client.patches) == 0 || !strings.Contains(client.patches[0], `"6"`) {
+				t.Errorf("%s with server-side apply %t: patches = %v, want them to name resourceVersion 6", name, config.ServerSideApply, client.patches)
+			}
+		}
+	}
+}
+
+// managingClient is an applyClient that applies like the API server: the
+// fields an apply leaves out that the same manager applied before are
+// removed. Lists are treated as single fields.
+type managingClient struct {
+	*applyClient
+}
+
+func (c *managingClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return c
+}
+
+func (c *managingClient) Namespace(namespace string) dynamic.ResourceInterface {
+	return c
+}
+
+func (c *managingClient) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	result, err := c.applyClient.Patch(ctx, name, pt, data, opts, subresources...)
+	if err != nil || pt != types.ApplyPatchType {
+		return result, err
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	owned, err := ownedConfiguration(c.object, opts.FieldManager)
+	if err != nil {
+		return nil, err
+	}
+	removeFields(c.object.Object, owned)
+	mergeFields(c.object.Object, config)
+	fields, _ := json.Marshal(fieldSet(config))
+	c.object.SetManagedFields([]metav1.ManagedFieldsEntry{{
+		Manager:   opts.FieldManager,
+		Operation: metav1.ManagedFieldsOperationApply,
+		FieldsV1:  &metav1.FieldsV1{Raw: fields},
+	}})
+	return c.object, nil
+}
+
+func removeFields(object, fields map[string]interface{}) {
+	for key, value := range fields {
+		child, isMap := value.(map[string]interface{})
+		objectChild, objectIsMap := object[key].(map[string]interface{})
+		if isMap && objectIsMap && len(child) > 0 {
+			removeFields(objectChild, child)
+			continue
+		}
+		delete(object, key)
+	}
+}
+
+func mergeFields(object, config map[string]interface{}) {
+	for key, value := range config {
+		child, isMap := value.(map[string]interface{})
+		objectChild, objectIsMap := object[key].(map[string]interface{})
+		if isMap && objectIsMap {
+			mergeFields(objectChild, child)
+			continue
+		}
+		object[key] = value
+	}
+}
+
+func fieldSet(config map[string]interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for key, value := range config {
+		if key == "apiVersion" || key == "kind" || key == "name" || key == "namespace" {
+			continue
+		}
+		child, _ := value.(map[string]interface{})
+		fields["f:"+key] = fieldSet(child)
+	}
+	return fields
+}
+
+func TestServerSideApplyKeepsFieldsOfEarlierSets(t *testing.T) {
+	client := &managingClient{&applyClient{object: &unstructured.Unstructured{Object: testDeployment()}}}
+	client.object.SetLabels(map[string]string{"team": "payments"})
+	p := newApplyProvider(client.applyClient, APIServerProviderConfig{ServerSideApply: true})
+	p.dynamicClient = client
+
+	for _, patch := range []string{
+		`[{"op":"test","path":"/metadata/labels","value":{}},{"op":"add","path":"/metadata/labels/a","value":"1"}]`,
+		`[{"op":"test","path":"/metadata/labels","value":{}},{"op":"add","path":"/metadata/labels/b","value":"2"}]`,
+		`[{"op":"replace","path":"/spec/replicas","value":5}]`,
+	} {
+		if err := p.PatchK8sResource("Deployment", "web", "default", []byte(patch), false); err != nil {
+			t.Fatalf("PatchK8sResource(%s) error = %v", patch, err)
+		}
+	}
+
+	want := map[string]string{"team": "payments", "a": "1", "b": "2"}
+	if got := client.object.GetLabels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("labels = %v, want %v", got, want)
+	}
+	if replicas, _, _ := unstructured.NestedFieldNoCopy(client.object.Object, "spec", "replicas"); replicas != float64(5) {
+		t.Errorf("replicas = %v, want 5", replicas)
+	}
+}
+
+func TestOwnedConfiguration(t *testing.T) {
+	live := &unstructured.Unstructured{Object: testDeployment()}
+	live.SetLabels(map[string]string{"a": "1", "b": "2"})
+	live.SetManagedFields([]metav1.ManagedFieldsEntry{
+		{
+			Manager:   DefaultFieldManager,
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1: &metav1.FieldsV1{Raw: []byte(`{
+				"f:metadata": {"f:labels": {"f:a": {}}},
+				"f:spec": {"f:template": {"f:spec": {"f:containers": {
+					"k:{\"name\":\"sidecar\"}": {".": {}, "f:image": {}}
+				}}}}
+			}`)},
+		},
+		{
+			Manager:   "kubectl",
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:metadata": {"f:labels": {"f:b": {}}}}`)},
+		},
+	})
+
+	got, err := ownedConfiguration(live, DefaultFieldManager)
+	if err != nil {
+		t.Fatalf("ownedConfiguration() error = %v", err)
+	}
+	want := map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": map[string]interface{}{"a": "1"}},
+		"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{"name": "sidecar", "image": "proxy:1"}},
+		}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ownedConfiguration() = %v, want %v", got, want)
+	}
+
+	// The owned sidecar is kept next to the container a SET changes
+	operations := decodeOperations(t, `[{"op": "add", "path": "/spec/template/spec/containers/0/image", "value": "app:2"}]`)
+	config, err := applyConfiguration(live.Object, got, operations, containerKeys)
+	if err != nil {
+		t.Fatalf("applyConfiguration() error = %v", err)
+	}
+	containers, _, _ := unstructured.NestedSlice(config, "spec", "template", "spec", "containers")
+	wantContainers := []interface{}{
+		map[string]interface{}{"name": "sidecar", "image": "proxy:1"},
+		map[string]interface{}{"name": "app", "image": "app:2"},
+	}
+	if !reflect.DeepEqual(containers, wantContainers) {
+		t.Errorf("containers = %v, want %v", containers, wantContainers)
+	}
+}
+
+func TestServerSideApplyPatchesListsWithoutSchema(t *testing.T) {
+	client := &applyClient{object: &unstructured.Unstructured{Object: testDeployment()}}
+	p := newApplyProvider(client, APIServerProviderConfig{ServerSideApply: true})
+
+	patch := `[{"op":"add","path":"/spec/template/spec/containers/1/image","value":"proxy:2"}]`
+	if err := p.PatchK8sResource("Deployment", "web", "default", []byte(patch), false); err != nil {
+		t.Fatalf("PatchK8sResource() error = %v", err)
+	}
+	if client.patchType != types.JSONPatchType || !reflect.DeepEqual(client.patches, []string{patch}) {
+		t.Errorf("%s patches = %v, want the JSON patch %s", client.patchType, client.patches, patch)
+	}
+}
+
+func TestOpenAPISchemasFetchGroupVersionsIndependently(t *testing.T) {
+	release := make(chan struct{})
+	var appsFetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/openapi/v3":
+			fmt.Fprint(w, `{"paths": {"api/v1": {"serverRelativeURL": "/openapi/v3/api/v1"}, "apis/apps/v1": {"serverRelativeURL": "/openapi/v3/apis/apps/v1"}}}`)
+		case "/openapi/v3/apis/apps/v1":
+			appsFetches.Add(1)
+			<-release
+			fmt.Fprint(w, `{"components": {"schemas": {"io.k8s.api.apps.v1.Deployment": {}}}}`)
+		case "/openapi/v3/api/v1":
+			fmt.Fprint(w, `{"components": {"schemas": {"io.k8s.api.core.v1.Pod": {}}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	defer unblock()
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("NewForConfig() error = %v", err)
+	}
+	p := &APIServerProvider{clientset: clientset}
+
+	apps := schema.GroupVersion{Group: "apps", Version: "v1"}
+	results := make(chan map[string]interface{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() { results <- p.openAPISchemas(context.Background(), apps) }()
+	}
+
+	for appsFetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// The core group version is served while apps/v1 is still being fetched.
+	if schemas := p.openAPISchemas(context.Background(), schema.GroupVersion{Version: "v1"}); schemas["io.k8s.api.core.v1.Pod"] == nil {
+		t.Fatalf("core schemas = %v", schemas)
+	}
+	unblock()
+	for i := 0; i < 2; i++ {
+		if schemas := <-results; schemas["io.k8s.api.apps.v1.Deployment"] == nil {
+			t.Errorf("apps schemas = %v", schemas)
+		}
+	}
+	if n := appsFetches.Load(); n != 1 {
+		t.Errorf("apps/v1 fetched %d times, want 1", n)
+	}
+}
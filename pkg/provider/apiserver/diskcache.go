@@ -0,0 +1,224 @@
+package apiserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+)
+
+// The entries the provider keeps in its disk cache.
+const (
+	discoveryCacheEntry = "discovery"
+	groupsCacheEntry    = "groups"
+	specsCacheEntry     = "specs"
+)
+
+// diskCacheOpenTimeout bounds the requests that fingerprint the cluster, so
+// an unresponsive API server delays startup by at most this long.
+const diskCacheOpenTimeout = 10 * time.Second
+
+var (
+	crdGVR        = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+	apiServiceGVR = schema.GroupVersionResource{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}
+)
+
+// diskCache keeps the discovery and schema data of one cluster in a JSON
+// file, so they aren't fetched and processed on every run. The file records
+// the cluster's fingerprint, its server version and set of
+// CustomResourceDefinitions and APIServices; entries stored under another
+// fingerprint are ignored and replaced.
+type diskCache struct {
+	path        string
+	server      string
+	fingerprint string
+	mu          sync.Mutex
+}
+
+type diskCacheFile struct {
+	Server      string                     `json:"server"`
+	Fingerprint string                     `json:"fingerprint"`
+	Entries     map[string]json.RawMessage `json:"entries"`
+}
+
+// openDiskCache returns the cache of the cluster clientset talks to, in dir.
+// Fingerprinting the cluster takes a version request and metadata-only lists
+// of its CustomResourceDefinitions and APIServices, which together must
+// complete within diskCacheOpenTimeout.
+func openDiskCache(ctx context.Context, dir string, clientset kubernetes.Interface, metadataClient metadata.Interface) (*diskCache, error) {
+	server := serverURL(clientset)
+	if server == "" {
+		return nil, fmt.Errorf("unknown API server address")
+	}
+	if metadataClient == nil {
+		return nil, fmt.Errorf("no metadata client to list CustomResourceDefinitions with")
+	}
+	ctx, cancel := context.WithTimeout(ctx, diskCacheOpenTimeout)
+	defer cancel()
+
+	gitVersion, err := serverVersion(ctx, clientset)
+	if err != nil {
+		return nil, fmt.Errorf("error getting server version: %w", err)
+	}
+	var apiSet []string
+	for _, gvr := range []schema.GroupVersionResource{crdGVR, apiServiceGVR} {
+		list, err := metadataClient.Resource(gvr).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s: %w", gvr.Resource, err)
+		}
+		for _, item := range list.Items {
+			// APIService names are <version>.<group>, so a version served
+			// by an aggregated API server appearing or going away changes
+			// the set.
+			apiSet = append(apiSet, fmt.Sprintf("%s/%s/%s/%d", gvr.Resource, item.Name, item.UID, item.Generation))
+		}
+	}
+	return newDiskCache(dir, server, gitVersion, apiSet), nil
+}
+
+// serverVersion returns the git version of the API server. Unlike the
+// discovery client's ServerVersion, the request honours ctx.
+func serverVersion(ctx context.Context, clientset kubernetes.Interface) (string, error) {
+	body, err := clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		return "", err
+	}
+	var info version.Info
+	if err := json.Unmarshal(body, &info); err != nil {
+		return "", fmt.Errorf("error decoding server version: %w", err)
+	}
+	return info.GitVersion, nil
+}
+
+func newDiskCache(dir, server, serverVersion string, apiSet []string) *diskCache {
+	sort.Strings(apiSet)
+	apiHash := sha256.Sum256([]byte(strings.Join(apiSet, "\n")))
+	serverHash := sha256.Sum256([]byte(server))
+	return &diskCache{
+		path:        filepath.Join(dir, hex.EncodeToString(serverHash[:8])+".json"),
+		server:      server,
+		fingerprint: serverVersion + "/" + hex.EncodeToString(apiHash[:]),
+	}
+}
+
+// serverURL returns the address of the API server clientset talks to, or ""
+// when it isn't backed by a REST client.
+func serverURL(clientset kubernetes.Interface) string {
+	restClient := clientset.Discovery().RESTClient()
+	if restClient == nil {
+		return ""
+	}
+	u := restClient.Get().URL()
+	if u == nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// read returns the entries of the cache file, or none when it is missing,
+// unreadable or was written for another fingerprint.
+func (c *diskCache) read() map[string]json.RawMessage {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil
+	}
+	var file diskCacheFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Server != c.server || file.Fingerprint != c.fingerprint {
+		return nil
+	}
+	return file.Entries
+}
+
+func (c *diskCache) load(name string, v interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.read()[name]
+	return ok && json.Unmarshal(entry, v) == nil
+}
+
+// store writes the cache file through a temporary file, so concurrent runs
+// never read a partly written one.
+func (c *diskCache) store(name string, v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding cache entry %s: %w", name, err)
+	}
+	entries := c.read()
+	if entries == nil {
+		entries = make(map[string]json.RawMessage)
+	}
+	entries[name] = entry
+	data, err := json.Marshal(diskCacheFile{Server: c.server, Fingerprint: c.fingerprint, Entries: entries})
+	if err != nil {
+		return fmt.Errorf("error encoding cache file: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("error creating cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return fmt.Errorf("error writing cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing cache file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("error writing cache file: %w", err)
+	}
+	return nil
+}
+
+// LoadCached decodes the entry stored under name in the provider's disk
+// cache into v. It reports false when the provider has no disk cache.
+func (p *APIServerProvider) LoadCached(name string, v interface{}) bool {
+	return p.diskCache != nil && p.diskCache.load(name, v)
+}
+
+// StoreCached stores v under name in the provider's disk cache, if it has
+// one.
+func (p *APIServerProvider) StoreCached(name string, v interface{}) error {
+	if p.diskCache == nil {
+		return nil
+	}
+	return p.diskCache.store(name, v)
+}
+
+// DefaultCacheDir returns the directory the CLI caches discovery and schema
+// data in, ~/.cyphernetes/cache, or "" when the home directory is unknown.
+func DefaultCacheDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".cyphernetes", "cache")
+}
+
+// ClearDiskCache removes the discovery and schema data cached in dir for
+// every cluster.
+func ClearDiskCache(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("error removing cache directory %s: %w", dir, err)
+	}
+	return nil
+}
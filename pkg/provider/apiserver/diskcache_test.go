@@ -0,0 +1,110 @@
+package apiserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+)
+
+func TestDiskCacheInvalidatesOnFingerprintChange(t *testing.T) {
+	dir := t.TempDir()
+	cache := newDiskCache(dir, "https://cluster-a", "v1.31.0", []string{"widgets.example.com/uid-1/1"})
+	specs := map[string][]string{"io.k8s.api.core.v1.Pod": {"metadata.name", "spec.nodeName"}}
+	if err := cache.store(specsCacheEntry, specs); err != nil {
+		t.Fatalf("store() error = %v", err)
+	}
+
+	var loaded map[string][]string
+	reopened := newDiskCache(dir, "https://cluster-a", "v1.31.0", []string{"widgets.example.com/uid-1/1"})
+	if !reopened.load(specsCacheEntry, &loaded) || !reflect.DeepEqual(loaded, specs) {
+		t.Fatalf("expected the specs back, got %v", loaded)
+	}
+
+	for name, changed := range map[string]*diskCache{
+		"server version": newDiskCache(dir, "https://cluster-a", "v1.32.0", []string{"widgets.example.com/uid-1/1"}),
+		"CRD set":        newDiskCache(dir, "https://cluster-a", "v1.31.0", []string{"widgets.example.com/uid-1/2"}),
+		"APIService set": newDiskCache(dir, "https://cluster-a", "v1.31.0", []string{"widgets.example.com/uid-1/1", "apiservices/v1beta1.metrics.k8s.io/uid-2/1"}),
+	} {
+		if changed.load(specsCacheEntry, &loaded) {
+			t.Errorf("expected a changed %s to miss the cache", name)
+		}
+	}
+
+	other := newDiskCache(dir, "https://cluster-b", "v1.31.0", nil)
+	if err := other.store(discoveryCacheEntry, []string{"pods"}); err != nil {
+		t.Fatalf("store() error = %v", err)
+	}
+	if !reopened.load(specsCacheEntry, &loaded) {
+		t.Errorf("expected clusters to be cached separately")
+	}
+
+	if err := ClearDiskCache(dir); err != nil {
+		t.Fatalf("ClearDiskCache() error = %v", err)
+	}
+	if reopened.load(specsCacheEntry, &loaded) {
+		t.Errorf("expected the cache to be cleared")
+	}
+}
+
+func TestOpenDiskCacheHonoursContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	config := &rest.Config{Host: server.URL}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("NewForConfig() error = %v", err)
+	}
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("metadata.NewForConfig() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if cache, err := openDiskCache(ctx, t.TempDir(), clientset, metadataClient); err == nil || cache != nil {
+		t.Fatalf("openDiskCache() = %v, %v, want an error", cache, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("openDiskCache() took %v against an unresponsive server", elapsed)
+	}
+}
+
+func TestInitGVRCacheUsesDiskCache(t *testing.T) {
+	cache := newDiskCache(t.TempDir(), "https://cluster-a", "v1.31.0", nil)
+	if err := cache.store(discoveryCacheEntry, []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{{Name: "pods", SingularName: "pod", Kind: "Pod", Namespaced: true, Verbs: []string{"get", "list"}}},
+	}}); err != nil {
+		t.Fatalf("store() error = %v", err)
+	}
+
+	// The fake clientset discovers nothing, so pods can only come from the
+	// cache.
+	p := &APIServerProvider{clientset: kubernetesfake.NewSimpleClientset(), diskCache: cache}
+	if err := p.initGVRCache(); err != nil {
+		t.Fatalf("initGVRCache() error = %v", err)
+	}
+	gvr, err := p.FindGVR("pod")
+	if err != nil || gvr != (schema.GroupVersionResource{Version: "v1", Resource: "pods"}) {
+		t.Fatalf("FindGVR(pod) = %v, %v", gvr, err)
+	}
+	if namespaced, err := p.isNamespacedResource(gvr); err != nil || !namespaced {
+		t.Errorf("expected pods to be namespaced without discovery, got %v, %v", namespaced, err)
+	}
+}
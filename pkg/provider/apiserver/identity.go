@@ -0,0 +1,63 @@
+package apiserver
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Identity selects the kubeconfig file credentials are read from and the
+// user requests are made as, like kubectl's --kubeconfig, --token, --as and
+// --as-group flags.
+type Identity struct {
+	// Kubeconfig is the kubeconfig file to use instead of the KUBECONFIG
+	// env var and $HOME/.kube/config.
+	Kubeconfig string
+	// Token is a bearer token that replaces the credentials of the
+	// kubeconfig or in-cluster config, so requests are made as its owner.
+	Token string
+	// As impersonates a user, and AsGroups the groups it is in. The
+	// authenticated user must be allowed to impersonate them.
+	As       string
+	AsGroups []string
+}
+
+// Validate reports settings the API server would reject.
+func (i Identity) Validate() error {
+	if len(i.AsGroups) > 0 && i.As == "" {
+		return fmt.Errorf("impersonating groups requires a user to impersonate")
+	}
+	return nil
+}
+
+// LoadingRules returns the rules for loading the kubeconfig i selects.
+func (i Identity) LoadingRules() *clientcmd.ClientConfigLoadingRules {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = i.Kubeconfig
+	return rules
+}
+
+// apply returns config authenticating and impersonating as i. config itself
+// is left unchanged.
+func (i Identity) apply(config *rest.Config) *rest.Config {
+	if i.Token == "" && i.As == "" {
+		return config
+	}
+	config = rest.CopyConfig(config)
+	if i.Token != "" {
+		// The API server authenticates client certificates before tokens,
+		// so every other credential is dropped.
+		config.BearerToken = i.Token
+		config.BearerTokenFile = ""
+		config.Username, config.Password = "", ""
+		config.CertFile, config.KeyFile = "", ""
+		config.CertData, config.KeyData = nil, nil
+		config.AuthProvider = nil
+		config.ExecProvider = nil
+	}
+	if i.As != "" {
+		config.Impersonate = rest.ImpersonationConfig{UserName: i.As, Groups: i.AsGroups}
+	}
+	return config
+}
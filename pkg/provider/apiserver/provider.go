@@ -5,24 +5,27 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"regexp"
+	"os"
 	"runtime"
-	"sort"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/flowcontrol"
 
 	"github.com/avitaltamir/cyphernetes/pkg/provider"
 	openapi_v3 "github.com/google/gnostic/openapiv3"
@@ -32,22 +35,81 @@ import (
 type APIServerProviderConfig struct {
 	Clientset     kubernetes.Interface
 	DynamicClient dynamic.Interface
-	Kubeconfig    *rest.Config
+	// MetadataClient lists resources when only their metadata is needed.
+	// Without it, such lists fetch whole objects through DynamicClient.
+	MetadataClient metadata.Interface
+	Kubeconfig     *rest.Config
 	// Context is the kubeconfig context to use. When set, it takes precedence
 	// over Kubeconfig and the in-cluster config and forces loading from the
 	// kubeconfig with the given context as current-context.
 	Context   string
 	QuietMode bool
+	// MaxConcurrentRequests bounds how many list requests are sent to the
+	// API server at once. Zero means one request at a time.
+	MaxConcurrentRequests int
+	// QPS and Burst configure a client-side token bucket for list requests.
+	// When QPS is zero, requests are spaced by a fixed 10ms delay instead.
+	QPS   float32
+	Burst int
+	// PageSize is the number of items requested per list call; results are
+	// fetched in chunks using continue tokens. Zero uses defaultPageSize.
+	PageSize int64
+	// ServerSideApply sends creates and patches as server-side apply
+	// requests, so the fields they set are owned by FieldManager.
+	ServerSideApply bool
+	// ForceConflicts makes server-side applies take ownership of fields
+	// owned by other managers instead of failing with a conflict.
+	ForceConflicts bool
+	// FieldManager is the manager writes are recorded under in
+	// managedFields. Empty uses DefaultFieldManager.
+	FieldManager string
+	// Identity selects the kubeconfig file and the user requests are made
+	// as. Providers for other contexts inherit it.
+	Identity Identity
+	// Retry is the policy for retrying requests that failed with a
+	// transient error, like a 429 or an etcd leader election. Providers for
+	// other contexts inherit it. The dynamic and metadata clients the
+	// provider creates don't retry on their own; clients passed in keep
+	// client-go's retries, which come on top of these.
+	Retry RetryPolicy
+	// CacheDir, when set, is where discovery and schema data are cached
+	// between runs, one file per cluster. The cache is bypassed when the
+	// cluster can't be fingerprinted. Providers for other contexts inherit
+	// it.
+	CacheDir string
 }
 
+// defaultPageSize matches kubectl's default chunk size.
+const defaultPageSize = 500
+
+// maxListRestarts bounds how often a paginated list restarts after its
+// continue token expired.
+const maxListRestarts = 3
+
 type APIServerProvider struct {
 	clientset          kubernetes.Interface
 	dynamicClient      dynamic.Interface
+	metadataClient     metadata.Interface
 	gvrCache           map[string]schema.GroupVersionResource
 	gvrCacheMutex      sync.RWMutex
 	openAPIDoc         *openapi_v3.Document
 	requestChannel     chan *apiRequest
 	semaphore          chan struct{}
+	rateLimiter        flowcontrol.RateLimiter
+	pageSize           int64
+	retryPolicy        RetryPolicy
+	requestLimits      APIServerProviderConfig
+	applyOptions       APIServerProviderConfig
+	identity           Identity
+	cacheDir           string
+	diskCache          *diskCache
+	resourceKinds      map[schema.GroupVersionResource]string
+	groupVersions      map[string][]string
+	servedResources    map[string][]metav1.APIResource
+	servedMutex        sync.Mutex
+	applySchemas       map[schema.GroupVersion]map[string]interface{}
+	applySchemaMutex   sync.Mutex
+	applySchemaFetches map[schema.GroupVersion]*schemaFetch
 	resourceMutex      sync.RWMutex
 	quietMode          bool
 	namespacedCache    map[string]bool
@@ -56,10 +118,13 @@ type APIServerProvider struct {
 }
 
 type apiRequest struct {
+	ctx           context.Context
 	kind          string
 	fieldSelector string
 	labelSelector string
 	namespace     string
+	limit         int64
+	metadataOnly  bool
 	responseChan  chan *apiResponse
 }
 
@@ -74,43 +139,63 @@ func NewAPIServerProvider() (provider.Provider, error) {
 	})
 }
 
-// buildRestConfigFromKubeconfig loads a *rest.Config from the standard kubeconfig
-// loading rules (honoring the KUBECONFIG env var and $HOME/.kube/config). When
-// context is non-empty it overrides the kubeconfig's current-context, mirroring
-// kubectl's --context flag.
-func buildRestConfigFromKubeconfig(context string) (*rest.Config, error) {
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+// BuildRestConfig loads a *rest.Config from the kubeconfig identity selects
+// (its Kubeconfig file, or the KUBECONFIG env var and $HOME/.kube/config)
+// and makes it authenticate as identity. When context is non-empty it
+// overrides the kubeconfig's current-context, mirroring kubectl's --context
+// flag.
+func BuildRestConfig(context string, identity Identity) (*rest.Config, error) {
 	configOverrides := &clientcmd.ConfigOverrides{}
 	if context != "" {
 		configOverrides.CurrentContext = context
 	}
-	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
-	return kubeConfig.ClientConfig()
+	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(identity.LoadingRules(), configOverrides)
+	restConfig, err := kubeConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return identity.apply(restConfig), nil
 }
 
 func NewAPIServerProviderWithOptions(config *APIServerProviderConfig) (provider.Provider, error) {
+	return NewAPIServerProviderContext(context.Background(), config)
+}
+
+// NewAPIServerProviderContext is NewAPIServerProviderWithOptions with the
+// context that bounds the requests fingerprinting the cluster for its disk
+// cache.
+func NewAPIServerProviderContext(ctx context.Context, config *APIServerProviderConfig) (provider.Provider, error) {
+	if err := validateRequestLimits(config); err != nil {
+		return nil, err
+	}
+	if err := config.Identity.Validate(); err != nil {
+		return nil, err
+	}
+
 	var err error
 	clientset := config.Clientset
 	dynamicClient := config.DynamicClient
+	metadataClient := config.MetadataClient
 
 	// If clients are not provided, create them
 	if clientset == nil || dynamicClient == nil {
 		var restConfig *rest.Config
 		// Config selection precedence:
-		//   1. An explicit context (--context) forces loading from the kubeconfig
-		//      with that context as current-context, ignoring in-cluster config.
+		//   1. An explicit context (--context) or kubeconfig file (--kubeconfig)
+		//      forces loading from the kubeconfig, ignoring in-cluster config.
 		//   2. A caller-provided *rest.Config (Kubeconfig).
 		//   3. In-cluster config when running inside a pod.
 		//   4. The default kubeconfig (KUBECONFIG env or $HOME/.kube/config).
-		if config.Context != "" {
-			restConfig, err = buildRestConfigFromKubeconfig(config.Context)
+		// Whichever is used authenticates as config.Identity.
+		if config.Context != "" || config.Identity.Kubeconfig != "" {
+			restConfig, err = BuildRestConfig(config.Context, config.Identity)
 			if err != nil {
 				return nil, fmt.Errorf("failed to create config for context %s: %v", config.Context, err)
 			}
 		}
 		// If user provided a kubeconfig, use that.
 		if restConfig == nil && config.Kubeconfig != nil {
-			restConfig = config.Kubeconfig
+			restConfig = config.Identity.apply(config.Kubeconfig)
 		}
 		// If caller did not provide a kubeconfig, try in-cluster config first
 		if restConfig == nil {
@@ -118,16 +203,25 @@ func NewAPIServerProviderWithOptions(config *APIServerProviderConfig) (provider.
 			if err != nil && !errors.Is(err, rest.ErrNotInCluster) {
 				return nil, fmt.Errorf("failed to create config: %v", err)
 			}
+			if restConfig != nil {
+				restConfig = config.Identity.apply(restConfig)
+			}
 		}
 		// If the binary is not being run inside a kubernetes cluster,
 		// nor the caller provided a kubeconfig,
 		// try loading the config from KUBECONFIG env or $HOME/.kube/config file
 		if restConfig == nil {
-			restConfig, err = buildRestConfigFromKubeconfig("")
+			restConfig, err = BuildRestConfig("", config.Identity)
 			if err != nil {
 				return nil, fmt.Errorf("failed to create config: %v", err)
 			}
 		}
+		// Raise client-go's own limiter so it doesn't undercut ours.
+		if config.QPS > 0 {
+			restConfig = rest.CopyConfig(restConfig)
+			restConfig.QPS = config.QPS
+			restConfig.Burst = requestBurst(config)
+		}
 
 		if clientset == nil {
 			clientset, err = kubernetes.NewForConfig(restConfig)
@@ -137,22 +231,46 @@ func NewAPIServerProviderWithOptions(config *APIServerProviderConfig) (provider.
 		}
 
 		if dynamicClient == nil {
-			dynamicClient, err = dynamic.NewForConfig(restConfig)
+			dynamicClient, err = dynamic.NewForConfig(withoutClientRetries(restConfig))
 			if err != nil {
 				return nil, fmt.Errorf("failed to create dynamic client: %v", err)
 			}
 		}
+
+		if metadataClient == nil {
+			metadataClient, err = metadata.NewForConfig(withoutClientRetries(restConfig))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create metadata client: %v", err)
+			}
+		}
 	}
 
 	provider := &APIServerProvider{
 		clientset:          clientset,
 		dynamicClient:      dynamicClient,
+		metadataClient:     metadataClient,
 		gvrCache:           make(map[string]schema.GroupVersionResource),
 		requestChannel:     make(chan *apiRequest),
-		semaphore:          make(chan struct{}, 1),
 		quietMode:          config.QuietMode,
 		namespacedCache:    make(map[string]bool),
 		knownResourceKinds: make([]string, 0),
+		applyOptions: APIServerProviderConfig{
+			ServerSideApply: config.ServerSideApply,
+			ForceConflicts:  config.ForceConflicts,
+			FieldManager:    config.FieldManager,
+		},
+		identity: config.Identity,
+		cacheDir: config.CacheDir,
+	}
+	provider.configureRequests(config)
+	if config.CacheDir != "" {
+		// Without a fingerprint the cache could serve data of another
+		// version of the cluster, so it isn't used at all. This is reported
+		// on stderr even in quiet mode, which only keeps stdout clean.
+		provider.diskCache, err = openDiskCache(ctx, config.CacheDir, clientset, metadataClient)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Discovery cache disabled: %v\n", err)
+		}
 	}
 
 	// Start the request processor
@@ -178,19 +296,25 @@ func NewAPIServerProviderWithConfig(kubeConfig clientcmd.ClientConfig) (provider
 		return nil, fmt.Errorf("failed to create clientset: %v", err)
 	}
 
-	dynamicClient, err := dynamic.NewForConfig(config)
+	dynamicClient, err := dynamic.NewForConfig(withoutClientRetries(config))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create dynamic client: %v", err)
 	}
 
+	metadataClient, err := metadata.NewForConfig(withoutClientRetries(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metadata client: %v", err)
+	}
+
 	provider := &APIServerProvider{
 		clientset:          clientset,
 		dynamicClient:      dynamicClient,
+		metadataClient:     metadataClient,
 		gvrCache:           make(map[string]schema.GroupVersionResource),
 		requestChannel:     make(chan *apiRequest),
-		semaphore:          make(chan struct{}, 1),
 		knownResourceKinds: make([]string, 0),
 	}
+	provider.configureRequests(&APIServerProviderConfig{})
 
 	// Start the request processor
 	go provider.processRequests()
@@ -205,30 +329,142 @@ func NewAPIServerProviderWithConfig(kubeConfig clientcmd.ClientConfig) (provider
 
 // Implement Provider interface methods...
 func (p *APIServerProvider) GetK8sResources(kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
-	responseChan := make(chan *apiResponse)
-	p.requestChannel <- &apiRequest{
+	return p.GetK8sResourcesContext(context.Background(), kind, fieldSelector, labelSelector, namespace)
+}
+
+// GetK8sResourcesContext queues a list request and waits for its result.
+// A request whose context is done before it is processed is not sent.
+func (p *APIServerProvider) GetK8sResourcesContext(ctx context.Context, kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	return p.GetK8sResourcesWithOptions(ctx, kind, fieldSelector, labelSelector, namespace, provider.ListOptions{})
+}
+
+// GetK8sResourcesWithOptions is GetK8sResourcesContext with list hints. When
+// opts.Limit is set, paging stops as soon as that many items were listed.
+// When opts.MetadataOnly is set, resources are listed as
+// PartialObjectMetadata, leaving out everything but their metadata.
+func (p *APIServerProvider) GetK8sResourcesWithOptions(ctx context.Context, kind, fieldSelector, labelSelector, namespace string, opts provider.ListOptions) (interface{}, error) {
+	responseChan := make(chan *apiResponse, 1)
+	request := &apiRequest{
+		ctx:           ctx,
 		kind:          kind,
 		fieldSelector: fieldSelector,
 		labelSelector: labelSelector,
 		namespace:     namespace,
+		limit:         opts.Limit,
+		metadataOnly:  opts.MetadataOnly,
 		responseChan:  responseChan,
 	}
 
-	response := <-responseChan
-	return response.result, response.err
+	select {
+	case p.requestChannel <- request:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case response := <-responseChan:
+		return response.result, response.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func validateRequestLimits(config *APIServerProviderConfig) error {
+	if config.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("invalid MaxConcurrentRequests %d: must not be negative", config.MaxConcurrentRequests)
+	}
+	if config.QPS < 0 {
+		return fmt.Errorf("invalid QPS %v: must not be negative", config.QPS)
+	}
+	if config.Burst < 0 {
+		return fmt.Errorf("invalid Burst %d: must not be negative", config.Burst)
+	}
+	if config.PageSize < 0 {
+		return fmt.Errorf("invalid PageSize %d: must not be negative", config.PageSize)
+	}
+	return config.Retry.Validate()
+}
+
+// requestBurst returns the configured burst, defaulting to the QPS rounded up
+// so a token bucket can always hold at least one request.
+func requestBurst(config *APIServerProviderConfig) int {
+	if config.Burst > 0 {
+		return config.Burst
+	}
+	burst := int(config.QPS)
+	if float32(burst) < config.QPS {
+		burst++
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return burst
+}
+
+// configureRequests sets up the semaphore, rate limiter and page size used by
+// processRequests. The zero config keeps the original behaviour: one request
+// at a time, 10ms apart.
+func (p *APIServerProvider) configureRequests(config *APIServerProviderConfig) {
+	concurrency := config.MaxConcurrentRequests
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	p.semaphore = make(chan struct{}, concurrency)
+	p.rateLimiter = nil
+	if config.QPS > 0 {
+		p.rateLimiter = flowcontrol.NewTokenBucketRateLimiter(config.QPS, requestBurst(config))
+	}
+	p.pageSize = config.PageSize
+	if p.pageSize == 0 {
+		p.pageSize = defaultPageSize
+	}
+	p.retryPolicy = config.Retry.withDefaults()
+	p.requestLimits = APIServerProviderConfig{
+		MaxConcurrentRequests: config.MaxConcurrentRequests,
+		QPS:                   config.QPS,
+		Burst:                 config.Burst,
+		PageSize:              config.PageSize,
+		Retry:                 config.Retry,
+	}
 }
 
+// processRequests dispatches queued list requests, sending up to
+// cap(p.semaphore) of them at once.
 func (p *APIServerProvider) processRequests() {
 	for request := range p.requestChannel {
+		if err := request.ctx.Err(); err != nil {
+			request.responseChan <- &apiResponse{err: err}
+			continue
+		}
 		p.semaphore <- struct{}{} // Acquire token
-		time.Sleep(10 * time.Millisecond)
-		list, err := p.fetchResources(request.kind, request.fieldSelector, request.labelSelector, request.namespace)
-		<-p.semaphore // Release token
-		request.responseChan <- &apiResponse{result: list, err: err}
+		slot := &requestSlot{semaphore: p.semaphore, held: true}
+		go func(request *apiRequest) {
+			defer slot.release()
+			if err := p.throttle(request.ctx); err != nil {
+				request.responseChan <- &apiResponse{err: err}
+				return
+			}
+			ctx := withRequestSlot(request.ctx, slot)
+			list, err := p.fetchResources(ctx, request.kind, request.fieldSelector, request.labelSelector, request.namespace, request.limit, request.metadataOnly)
+			request.responseChan <- &apiResponse{result: list, err: err}
+		}(request)
 	}
 }
 
-func (p *APIServerProvider) fetchResources(kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+// throttle waits until the next list request may be sent.
+func (p *APIServerProvider) throttle(ctx context.Context) error {
+	if p.rateLimiter != nil {
+		return p.rateLimiter.Wait(ctx)
+	}
+	time.Sleep(10 * time.Millisecond)
+	return nil
+}
+
+// fetchResources lists resources page by page. A positive limit stops paging
+// once that many items were collected. When a continue token expires
+// mid-list, the list restarts from the beginning so the result comes from a
+// single consistent snapshot.
+func (p *APIServerProvider) fetchResources(ctx context.Context, kind, fieldSelector, labelSelector, namespace string, limit int64, metadataOnly bool) (interface{}, error) {
 	p.resourceMutex.RLock()
 	defer p.resourceMutex.RUnlock()
 
@@ -242,28 +478,112 @@ func (p *APIServerProvider) fetchResources(kind, fieldSelector, labelSelector, n
 		return nil, err
 	}
 
-	var list *unstructured.UnstructuredList
-	if namespace != "" && isNamespaced {
-		list, err = p.dynamicClient.Resource(gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{
-			FieldSelector: fieldSelector,
-			LabelSelector: labelSelector,
-		})
-	} else {
-		list, err = p.dynamicClient.Resource(gvr).List(context.TODO(), metav1.ListOptions{
-			FieldSelector: fieldSelector,
-			LabelSelector: labelSelector,
+	if !isNamespaced {
+		namespace = ""
+	}
+	listPage := p.listPageFunc(gvr, namespace)
+	if metadataOnly {
+		if listMetadata, ok := p.listMetadataPageFunc(gvr, namespace); ok {
+			listPage = listMetadata
+		}
+	}
+
+	pageSize := p.pageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	opts := metav1.ListOptions{
+		FieldSelector: fieldSelector,
+		LabelSelector: labelSelector,
+	}
+	var converted []map[string]interface{}
+	restarts := 0
+	for {
+		opts.Limit = pageSize
+		if remaining := limit - int64(len(converted)); limit > 0 && remaining < pageSize {
+			opts.Limit = remaining
+		}
+		var items []map[string]interface{}
+		var continueToken string
+		err := p.withRetries(ctx, retryRequest{verb: "list", gvr: gvr, namespace: namespace}, func(ctx context.Context) (err error) {
+			items, continueToken, err = listPage(ctx, opts)
+			return err
 		})
+		if err != nil {
+			if apierrors.IsResourceExpired(err) && opts.Continue != "" && restarts < maxListRestarts {
+				restarts++
+				converted = nil
+				opts.Continue = ""
+				continue
+			}
+			return nil, err
+		}
+
+		converted = append(converted, items...)
+		if limit > 0 && int64(len(converted)) >= limit {
+			return converted[:limit], nil
+		}
+		opts.Continue = continueToken
+		if opts.Continue == "" {
+			return converted, nil
+		}
 	}
+}
 
-	if err != nil {
-		return nil, err
+// listPageFunc returns a function listing one page of whole resources, in
+// namespace or in all namespaces when it is empty.
+func (p *APIServerProvider) listPageFunc(gvr schema.GroupVersionResource, namespace string) func(context.Context, metav1.ListOptions) ([]map[string]interface{}, string, error) {
+	var resource dynamic.ResourceInterface = p.dynamicClient.Resource(gvr)
+	if namespace != "" {
+		resource = p.dynamicClient.Resource(gvr).Namespace(namespace)
 	}
+	return func(ctx context.Context, opts metav1.ListOptions) ([]map[string]interface{}, string, error) {
+		list, err := resource.List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		items := make([]map[string]interface{}, 0, len(list.Items))
+		for _, u := range list.Items {
+			items = append(items, u.UnstructuredContent())
+		}
+		return items, list.GetContinue(), nil
+	}
+}
 
-	var converted []map[string]interface{}
-	for _, u := range list.Items {
-		converted = append(converted, u.UnstructuredContent())
+// listMetadataPageFunc is listPageFunc for metadata-only lists. Items are
+// given the apiVersion and kind of gvr, since PartialObjectMetadata doesn't
+// carry them. It reports false when the provider has no metadata client or
+// doesn't know gvr's kind.
+func (p *APIServerProvider) listMetadataPageFunc(gvr schema.GroupVersionResource, namespace string) (func(context.Context, metav1.ListOptions) ([]map[string]interface{}, string, error), bool) {
+	if p.metadataClient == nil {
+		return nil, false
+	}
+	gvk, err := p.gvkFor(gvr)
+	if err != nil {
+		return nil, false
+	}
+	var resource metadata.ResourceInterface = p.metadataClient.Resource(gvr)
+	if namespace != "" {
+		resource = p.metadataClient.Resource(gvr).Namespace(namespace)
 	}
-	return converted, nil
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	return func(ctx context.Context, opts metav1.ListOptions) ([]map[string]interface{}, string, error) {
+		list, err := resource.List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		items := make([]map[string]interface{}, 0, len(list.Items))
+		for i := range list.Items {
+			item, err := k8sruntime.DefaultUnstructuredConverter.ToUnstructured(&list.Items[i])
+			if err != nil {
+				return nil, "", fmt.Errorf("error converting %s metadata: %v", gvr.Resource, err)
+			}
+			item["apiVersion"] = apiVersion
+			item["kind"] = kind
+			items = append(items, item)
+		}
+		return items, list.GetContinue(), nil
+	}, true
 }
 
 func (p *APIServerProvider) findGVRForResourceOperation(kind string) (schema.GroupVersionResource, error) {
@@ -279,6 +599,14 @@ func (p *APIServerProvider) findGVRForResourceOperation(kind string) (schema.Gro
 	return schema.GroupVersionResource{}, err
 }
 
+// FindGVRContext resolves kind from the GVR cache, which needs no requests
+func (p *APIServerProvider) FindGVRContext(ctx context.Context, kind string) (schema.GroupVersionResource, error) {
+	if err := ctx.Err(); err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	return p.FindGVR(kind)
+}
+
 // Move the FindGVR implementation from k8s_client.go here
 func (p *APIServerProvider) FindGVR(kind string) (schema.GroupVersionResource, error) {
 	if kind == "" {
@@ -288,86 +616,58 @@ func (p *APIServerProvider) FindGVR(kind string) (schema.GroupVersionResource, e
 	p.gvrCacheMutex.RLock()
 	defer p.gvrCacheMutex.RUnlock()
 
-	// Use maps to deduplicate matches
-	uniqueGVRs := make(map[string]schema.GroupVersionResource)
-	uniqueOptions := make(map[string]bool)
-
-	// Special handling for core.* prefix
-	if strings.HasPrefix(strings.ToLower(kind), "core.") {
-		resourceName := strings.TrimPrefix(kind, "core.")
-
-		// Look for exact match in core group
-		for k, gvr := range p.gvrCache {
-			if gvr.Group == "" && // core group has empty string as group
-				(strings.EqualFold(k, resourceName) ||
-					strings.EqualFold(gvr.Resource, resourceName) ||
-					strings.EqualFold(strings.TrimSuffix(gvr.Resource, "s"), resourceName)) {
-				return gvr, nil
-			}
-		}
-		return schema.GroupVersionResource{}, fmt.Errorf("resource %q not found in core group", resourceName)
-	}
+	return provider.ResolveServedGVR(p.gvrCache, p.servedVersions, kind)
+}
 
-	// If kind contains dots (but not starting with core.), treat it as a fully qualified name
-	if strings.Contains(kind, ".") {
-		// Try exact match only
-		if gvr, ok := p.gvrCache[kind]; ok {
-			return gvr, nil
+// servedVersions returns the versions of group whose resources list
+// resource. Callers hold gvrCacheMutex.
+func (p *APIServerProvider) servedVersions(group, resource string) []string {
+	var versions []string
+	for _, version := range p.groupVersions[group] {
+		if _, ok := p.servedResource(schema.GroupVersionResource{Group: group, Version: version, Resource: resource}); ok {
+			versions = append(versions, version)
 		}
-		return schema.GroupVersionResource{}, fmt.Errorf("resource %q not found", kind)
 	}
+	return versions
+}
 
-	// For non-fully-qualified names, try all the matching strategies
-	// Try exact match first
-	if gvr, ok := p.gvrCache[kind]; ok {
-		key := fmt.Sprintf("%s/%s", gvr.Resource, gvr.Group)
-		uniqueGVRs[key] = gvr
-		if gvr.Group == "" {
-			uniqueOptions["core."+gvr.Resource] = true
-		} else {
-			uniqueOptions[gvr.Resource+"."+gvr.Group] = true
+// servedResource looks gvr up in the resources the API server lists for its
+// group version, which discovery only returns for preferred versions.
+func (p *APIServerProvider) servedResource(gvr schema.GroupVersionResource) (metav1.APIResource, bool) {
+	groupVersion := gvr.GroupVersion().String()
+	p.servedMutex.Lock()
+	resources, ok := p.servedResources[groupVersion]
+	p.servedMutex.Unlock()
+	if !ok {
+		if p.clientset == nil {
+			return metav1.APIResource{}, false
 		}
-	}
-
-	// Try case-insensitive lookup
-	lowerKind := strings.ToLower(kind)
-	for k, gvr := range p.gvrCache {
-		if strings.ToLower(k) == lowerKind || // Case-insensitive kind match
-			strings.ToLower(gvr.Resource) == lowerKind || // Plural form
-			strings.ToLower(strings.TrimSuffix(gvr.Resource, "s")) == lowerKind || // Singular form
-			strings.ToLower(strings.TrimSuffix(gvr.Resource, "es")) == lowerKind || // Singular form
-			(strings.HasSuffix(gvr.Resource, "ies") && strings.ToLower(strings.TrimSuffix(gvr.Resource, "ies")+"y") == lowerKind) { // Handle -ies to -y conversion
-			key := fmt.Sprintf("%s/%s", gvr.Resource, gvr.Group)
-			uniqueGVRs[key] = gvr
-			if gvr.Group == "" {
-				uniqueOptions["core."+gvr.Resource] = true
-			} else {
-				uniqueOptions[gvr.Resource+"."+gvr.Group] = true
-			}
+		list, err := p.clientset.Discovery().ServerResourcesForGroupVersion(groupVersion)
+		if err != nil {
+			return metav1.APIResource{}, false
 		}
-	}
-
-	if len(uniqueGVRs) > 1 {
-		var options []string
-		for option := range uniqueOptions {
-			options = append(options, option)
+		resources = list.APIResources
+		p.servedMutex.Lock()
+		if p.servedResources == nil {
+			p.servedResources = make(map[string][]metav1.APIResource)
 		}
-		sort.Strings(options)
-		return schema.GroupVersionResource{}, fmt.Errorf("ambiguous resource kind %q found. Please specify one of:\n%s",
-			kind, strings.Join(options, "\n"))
+		p.servedResources[groupVersion] = resources
+		p.servedMutex.Unlock()
 	}
-
-	if len(uniqueGVRs) == 1 {
-		for _, gvr := range uniqueGVRs {
-			return gvr, nil
+	for _, resource := range resources {
+		if resource.Name == gvr.Resource {
+			return resource, true
 		}
 	}
-
-	return schema.GroupVersionResource{}, fmt.Errorf("resource %q not found", kind)
+	return metav1.APIResource{}, false
 }
 
 // Implement other Provider interface methods...
 func (p *APIServerProvider) DeleteK8sResources(kind, name, namespace string, dryRun bool) error {
+	return p.DeleteK8sResourcesContext(context.Background(), kind, name, namespace, dryRun)
+}
+
+func (p *APIServerProvider) DeleteK8sResourcesContext(ctx context.Context, kind, name, namespace string, dryRun bool) error {
 	p.resourceMutex.Lock()
 	defer p.resourceMutex.Unlock()
 
@@ -388,7 +688,9 @@ func (p *APIServerProvider) DeleteK8sResources(kind, name, namespace string, dry
 
 	var deleteErr error
 	if namespace != "" && isNamespaced {
-		deleteErr = p.dynamicClient.Resource(gvr).Namespace(namespace).Delete(context.TODO(), name, deleteOpts)
+		deleteErr = p.withRetries(ctx, retryRequest{verb: "delete", gvr: gvr, namespace: namespace, name: name}, func(ctx context.Context) error {
+			return p.dynamicClient.Resource(gvr).Namespace(namespace).Delete(ctx, name, deleteOpts)
+		})
 		if deleteErr == nil {
 			if dryRun {
 				fmt.Printf("Dry run mode: would delete %s/%s\n", strings.ToLower(kind), name)
@@ -397,7 +699,9 @@ func (p *APIServerProvider) DeleteK8sResources(kind, name, namespace string, dry
 			}
 		}
 	} else {
-		deleteErr = p.dynamicClient.Resource(gvr).Delete(context.TODO(), name, deleteOpts)
+		deleteErr = p.withRetries(ctx, retryRequest{verb: "delete", gvr: gvr, name: name}, func(ctx context.Context) error {
+			return p.dynamicClient.Resource(gvr).Delete(ctx, name, deleteOpts)
+		})
 		if deleteErr == nil {
 			if !p.quietMode {
 				fmt.Printf("Deleted %s/%s\n", strings.ToLower(kind), name)
@@ -409,6 +713,10 @@ func (p *APIServerProvider) DeleteK8sResources(kind, name, namespace string, dry
 }
 
 func (p *APIServerProvider) CreateK8sResource(kind, name, namespace string, body interface{}, dryRun bool) error {
+	return p.CreateK8sResourceContext(context.Background(), kind, name, namespace, body, dryRun)
+}
+
+func (p *APIServerProvider) CreateK8sResourceContext(ctx context.Context, kind, name, namespace string, body interface{}, dryRun bool) error {
 	p.resourceMutex.Lock()
 	defer p.resourceMutex.Unlock()
 
@@ -433,14 +741,14 @@ func (p *APIServerProvider) CreateK8sResource(kind, name, namespace string, body
 	metadata := unstructuredObj.Object["metadata"].(map[string]interface{})
 	metadata["name"] = name
 
-	createOpts := metav1.CreateOptions{}
+	createOpts := metav1.CreateOptions{FieldManager: p.fieldManager()}
 	if dryRun {
 		createOpts.DryRun = []string{metav1.DryRunAll}
 	}
 
 	if namespace != "" && isNamespaced {
 		metadata["namespace"] = namespace
-		_, err = p.dynamicClient.Resource(gvr).Namespace(namespace).Create(context.TODO(), unstructuredObj, createOpts)
+		err = p.create(ctx, gvr, namespace, unstructuredObj, createOpts)
 		if err == nil {
 			if dryRun {
 				fmt.Printf("\nDry run mode: would create %s/%s", strings.ToLower(kind), name)
@@ -449,7 +757,7 @@ func (p *APIServerProvider) CreateK8sResource(kind, name, namespace string, body
 			}
 		}
 	} else {
-		_, err = p.dynamicClient.Resource(gvr).Create(context.TODO(), unstructuredObj, createOpts)
+		err = p.create(ctx, gvr, "", unstructuredObj, createOpts)
 		if err == nil {
 			if !p.quietMode {
 				fmt.Printf("\nCreated %s/%s", strings.ToLower(kind), name)
@@ -461,6 +769,10 @@ func (p *APIServerProvider) CreateK8sResource(kind, name, namespace string, body
 }
 
 func (p *APIServerProvider) PatchK8sResource(kind, name, namespace string, patchJSON []byte, dryRun bool) error {
+	return p.PatchK8sResourceContext(context.Background(), kind, name, namespace, patchJSON, dryRun)
+}
+
+func (p *APIServerProvider) PatchK8sResourceContext(ctx context.Context, kind, name, namespace string, patchJSON []byte, dryRun bool) error {
 	gvr, err := p.findGVRForResourceOperation(kind)
 	if err != nil {
 		return err
@@ -474,11 +786,31 @@ func (p *APIServerProvider) PatchK8sResource(kind, name, namespace string, patch
 	}
 
 	// Create patch options with dry run if needed
-	patchOpts := metav1.PatchOptions{}
+	patchOpts := metav1.PatchOptions{FieldManager: p.fieldManager()}
 	if dryRun {
 		patchOpts.DryRun = []string{metav1.DryRunAll}
 	}
 
+	// A resourceVersion precondition applies to every request the patch is
+	// sent as.
+	resourceVersion, patches := provider.SplitPrecondition(patches)
+
+	if p.applyOptions.ServerSideApply {
+		err := p.applyPatch(ctx, gvr, namespace, name, resourceVersion, patches, dryRun)
+		if !errors.Is(err, errNoApplySchema) {
+			if err != nil {
+				return err
+			}
+			if dryRun {
+				fmt.Printf("Dry run mode: would apply %s/%s in namespace %s\n", strings.ToLower(kind), name, namespace)
+			} else {
+				fmt.Printf("Applied %s/%s in namespace %s\n", strings.ToLower(kind), name, namespace)
+			}
+			return nil
+		}
+		// Lists can't be applied without their schema, patch them instead
+	}
+
 	// Check if this is a patch for metadata.annotations or metadata.labels
 	// If so, we'll use a strategic merge patch instead of JSON Patch
 	if len(patches) == 2 {
@@ -491,125 +823,6 @@ func (p *APIServerProvider) PatchK8sResource(kind, name, namespace string, patch
 			testPath, testOk := testPatch["path"].(string)
 			addPath, addOk := addPatch["path"].(string)
 
-			// Check for container resources patch
-			if testOk && addOk && testPath == "/spec/template/spec/containers" &&
-				strings.HasPrefix(addPath, "/spec/template/spec/containers/") {
-
-				// Extract the container index and property path
-				containerPathPattern := regexp.MustCompile(`/spec/template/spec/containers/(\d+)(/.*)?`)
-				matches := containerPathPattern.FindStringSubmatch(addPath)
-
-				if len(matches) >= 2 {
-					containerIndex := matches[1]
-					propertyPath := ""
-					if len(matches) >= 3 {
-						propertyPath = matches[2]
-					}
-
-					// Build a strategic merge patch for the container
-					mergePatch := map[string]interface{}{
-						"spec": map[string]interface{}{
-							"template": map[string]interface{}{
-								"spec": map[string]interface{}{
-									"containers": []interface{}{
-										map[string]interface{}{
-											"name": fmt.Sprintf("container-%s", containerIndex), // Placeholder name
-										},
-									},
-								},
-							},
-						},
-					}
-
-					// Set the value at the property path
-					if propertyPath != "" {
-						// Remove the leading slash
-						propertyPath = strings.TrimPrefix(propertyPath, "/")
-
-						// Split the property path into parts
-						parts := strings.Split(propertyPath, "/")
-
-						// Navigate to the container in the merge patch
-						container := mergePatch["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})[0].(map[string]interface{})
-
-						// Build the nested structure for the property
-						current := container
-						for i := 0; i < len(parts)-1; i++ {
-							part := parts[i]
-							if _, ok := current[part]; !ok {
-								current[part] = make(map[string]interface{})
-							}
-							current = current[part].(map[string]interface{})
-						}
-
-						// Set the value at the final part
-						current[parts[len(parts)-1]] = addPatch["value"]
-					}
-
-					// Get the actual container name from the resource
-					resource, err := p.dynamicClient.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-					if err != nil {
-						return fmt.Errorf("error getting resource: %v", err)
-					}
-
-					// Extract the container name from the resource
-					containers, found, err := unstructured.NestedSlice(resource.Object, "spec", "template", "spec", "containers")
-					if err != nil || !found || len(containers) <= 0 {
-						return fmt.Errorf("error getting containers: %v", err)
-					}
-
-					// Check if the container index is valid
-					containerIdx, err := strconv.Atoi(containerIndex)
-					if err != nil || containerIdx >= len(containers) {
-
-						return fmt.Errorf("invalid container index: %s", containerIndex)
-					}
-
-					// Get the container name
-					container, ok := containers[containerIdx].(map[string]interface{})
-					if !ok {
-
-						return fmt.Errorf("container is not a map: %v", containers[containerIdx])
-					}
-
-					containerName, ok := container["name"].(string)
-					if !ok {
-
-						return fmt.Errorf("container name is not a string: %v", container["name"])
-					}
-
-					// Update the container name in the merge patch
-					mergePatch["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})[0].(map[string]interface{})["name"] = containerName
-
-					// Marshal the merge patch
-					mergePatchJSON, err := json.Marshal(mergePatch)
-					if err != nil {
-						return fmt.Errorf("error marshalling merge patch: %v", err)
-					}
-
-					// Apply the strategic merge patch
-					_, err = p.dynamicClient.Resource(gvr).Namespace(namespace).Patch(
-						context.TODO(),
-						name,
-						types.StrategicMergePatchType,
-						mergePatchJSON,
-						patchOpts,
-					)
-
-					if err != nil {
-						return fmt.Errorf("error applying container merge patch: %v", err)
-					}
-
-					if dryRun {
-						fmt.Printf("Dry run mode: would patch %s/%s in namespace %s\n", strings.ToLower(kind), name, namespace)
-					} else {
-						fmt.Printf("Patched %s/%s in namespace %s\n", strings.ToLower(kind), name, namespace)
-					}
-
-					return nil
-				}
-			}
-
 			if testOk && addOk &&
 				(testPath == "/metadata/annotations" || testPath == "/metadata/labels") &&
 				strings.HasPrefix(addPath, testPath+"/") {
@@ -644,6 +857,9 @@ func (p *APIServerProvider) PatchK8sResource(kind, name, namespace string, patch
 						},
 					}
 				}
+				if resourceVersion != "" {
+					mergePatch["metadata"].(map[string]interface{})["resourceVersion"] = resourceVersion
+				}
 
 				mergePatchJSON, err := json.Marshal(mergePatch)
 				if err != nil {
@@ -651,16 +867,13 @@ func (p *APIServerProvider) PatchK8sResource(kind, name, namespace string, patch
 					return fmt.Errorf("error marshalling merge patch: %v", err)
 				}
 
-				_, err = p.dynamicClient.Resource(gvr).Namespace(namespace).Patch(
-					context.TODO(),
-					name,
-					types.MergePatchType,
-					mergePatchJSON,
-					patchOpts,
-				)
+				err = p.withRetries(ctx, retryRequest{verb: "patch", gvr: gvr, namespace: namespace, name: name}, func(ctx context.Context) error {
+					_, err := p.dynamicClient.Resource(gvr).Namespace(namespace).Patch(ctx, name, types.MergePatchType, mergePatchJSON, patchOpts)
+					return err
+				})
 
 				if err != nil {
-					return fmt.Errorf("error applying merge patch: %v", err)
+					return fmt.Errorf("error applying merge patch: %w", conflictError(err))
 				}
 
 				if dryRun {
@@ -674,7 +887,9 @@ func (p *APIServerProvider) PatchK8sResource(kind, name, namespace string, patch
 		}
 	}
 
-	// Apply each patch operation
+	// Apply each patch operation. Tests that guard the next operation, such
+	// as the keys of the list item it changes, are sent along with it.
+	var guards []interface{}
 	for i := 0; i < len(patches); i++ {
 		patch := patches[i]
 		patchMap, ok := patch.(map[string]interface{})
@@ -698,19 +913,11 @@ func (p *APIServerProvider) PatchK8sResource(kind, name, namespace string, patch
 			if nextOp == "add" && strings.HasPrefix(nextPath, path+"/") {
 
 				// Try to apply just the test patch to see if the map exists
-				testPatchData, err := json.Marshal([]interface{}{patch})
-				if err != nil {
-					return fmt.Errorf("error marshalling test patch: %v", err)
+				testErr := p.jsonPatch(ctx, gvr, namespace, name, []interface{}{patch}, patchOpts, &resourceVersion)
+				if errors.Is(testErr, provider.ErrConflict) {
+					return fmt.Errorf("error applying patch: %w", testErr)
 				}
 
-				_, testErr := p.dynamicClient.Resource(gvr).Namespace(namespace).Patch(
-					context.TODO(),
-					name,
-					types.JSONPatchType,
-					testPatchData,
-					patchOpts,
-				)
-
 				// If the test fails, the map doesn't exist, so we need to create it
 				if testErr != nil {
 
@@ -723,41 +930,17 @@ func (p *APIServerProvider) PatchK8sResource(kind, name, namespace string, patch
 						},
 					}
 
-					createMapPatchJSON, err := json.Marshal(createMapPatch)
-					if err != nil {
-						return fmt.Errorf("error marshalling create map patch: %v", err)
-					}
-
 					// Apply the patch to create the map
-					_, err = p.dynamicClient.Resource(gvr).Namespace(namespace).Patch(
-						context.TODO(),
-						name,
-						types.JSONPatchType,
-						createMapPatchJSON,
-						patchOpts,
-					)
-
-					if err != nil {
-						return fmt.Errorf("error creating map at %s: %v", path, err)
+					if err := p.jsonPatch(ctx, gvr, namespace, name, createMapPatch, patchOpts, &resourceVersion); err != nil {
+						return fmt.Errorf("error creating map at %s: %w", path, err)
 					}
 
 					// Skip the test patch and directly apply the add operation
 					// Apply just the add operation
-					addPatchData, err := json.Marshal([]interface{}{nextPatch})
+					err := p.jsonPatch(ctx, gvr, namespace, name, append(guards, nextPatch), patchOpts, &resourceVersion)
+					guards = nil
 					if err != nil {
-						return fmt.Errorf("error marshalling add patch: %v", err)
-					}
-
-					_, err = p.dynamicClient.Resource(gvr).Namespace(namespace).Patch(
-						context.TODO(),
-						name,
-						types.JSONPatchType,
-						addPatchData,
-						patchOpts,
-					)
-
-					if err != nil {
-						return fmt.Errorf("error applying add patch: %v", err)
+						return fmt.Errorf("error applying add patch: %w", err)
 					}
 
 					// Skip both patches since we've handled them
@@ -767,26 +950,20 @@ func (p *APIServerProvider) PatchK8sResource(kind, name, namespace string, patch
 			}
 		}
 
-		// Apply the current patch
-		patchData, err := json.Marshal([]interface{}{patch})
-		if err != nil {
-			return fmt.Errorf("error marshalling patch: %v", err)
+		if op == "test" && i+1 < len(patches) {
+			guards = append(guards, patch)
+			continue
 		}
 
-		_, err = p.dynamicClient.Resource(gvr).Namespace(namespace).Patch(
-			context.TODO(),
-			name,
-			types.JSONPatchType,
-			patchData,
-			patchOpts,
-		)
-
+		// Apply the current patch
+		err := p.jsonPatch(ctx, gvr, namespace, name, append(guards, patch), patchOpts, &resourceVersion)
+		guards = nil
 		if err != nil {
-			return fmt.Errorf("error applying patch: %v", err)
+			return fmt.Errorf("error applying patch: %w", err)
 		}
 
 		// Get state after patch
-		_, err = p.dynamicClient.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		_, err = p.dynamicClient.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
 		if err != nil {
 			if !p.quietMode {
 				fmt.Printf("Error getting updated state: %v\n", err)
@@ -803,12 +980,68 @@ func (p *APIServerProvider) PatchK8sResource(kind, name, namespace string, patch
 	return nil
 }
 
+// jsonPatch sends operations as a JSON patch request. When resourceVersion
+// is set, the request only applies to that version of the resource and
+// resourceVersion is advanced to the version it produced, so that the
+// requests a patch is split into each apply to the state the previous one
+// left.
+func (p *APIServerProvider) jsonPatch(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string, operations []interface{}, opts metav1.PatchOptions, resourceVersion *string) error {
+	if *resourceVersion != "" {
+		// The API server fails a patch that sets a stale resourceVersion
+		// with a conflict.
+		operations = append([]interface{}{map[string]interface{}{
+			"op":    "replace",
+			"path":  "/metadata/resourceVersion",
+			"value": *resourceVersion,
+		}}, operations...)
+	}
+	data, err := json.Marshal(operations)
+	if err != nil {
+		return fmt.Errorf("error marshalling patch: %v", err)
+	}
+	var patched *unstructured.Unstructured
+	err = p.withRetries(ctx, retryRequest{verb: "patch", gvr: gvr, namespace: namespace, name: name}, func(ctx context.Context) (err error) {
+		patched, err = p.dynamicClient.Resource(gvr).Namespace(namespace).Patch(ctx, name, types.JSONPatchType, data, opts)
+		return err
+	})
+	if err != nil {
+		return conflictError(err)
+	}
+	if *resourceVersion != "" && len(opts.DryRun) == 0 {
+		*resourceVersion = patched.GetResourceVersion()
+	}
+	return nil
+}
+
+// conflictError marks the API server's conflict errors as provider.ErrConflict.
+func conflictError(err error) error {
+	if apierrors.IsConflict(err) {
+		return fmt.Errorf("%w: %w", provider.ErrConflict, err)
+	}
+	return err
+}
+
 type GroupVersion interface {
 	Schema(contentType string) ([]byte, error)
 }
 
 func (p *APIServerProvider) GetOpenAPIResourceSpecs() (map[string][]string, error) {
-	if p.openAPIDoc == nil {
+	return p.GetOpenAPIResourceSpecsContext(context.Background())
+}
+
+// GetOpenAPIResourceSpecsContext stops fetching schemas once ctx is done and
+// leaves the OpenAPI document unset, so the next call starts over. Specs
+// found in the disk cache are returned without fetching any schema.
+func (p *APIServerProvider) GetOpenAPIResourceSpecsContext(ctx context.Context) (map[string][]string, error) {
+	var cached map[string][]string
+	if p.LoadCached(specsCacheEntry, &cached) {
+		return cached, nil
+	}
+
+	// Specs are only cached when every schema was just fetched successfully
+	var incomplete atomic.Bool
+	fetched := p.openAPIDoc == nil
+	if fetched {
 		// Get OpenAPI V3 client
 		openAPIV3Client := p.clientset.Discovery().OpenAPIV3()
 
@@ -886,6 +1119,10 @@ func (p *APIServerProvider) GetOpenAPIResourceSpecs() (map[string][]string, erro
 			go func() {
 				defer wg.Done()
 				for work := range workChan {
+					if ctx.Err() != nil {
+						progressChan <- 1
+						continue
+					}
 					time.Sleep(10 * time.Millisecond) // Add small delay between requests
 					schemaBytes, err := work.path.Schema("application/com.github.proto-openapi.spec.v3@v1.0+protobuf")
 
@@ -894,6 +1131,7 @@ func (p *APIServerProvider) GetOpenAPIResourceSpecs() (map[string][]string, erro
 
 					if err != nil {
 						if !strings.Contains(err.Error(), "the backend attempted to redirect this request") {
+							incomplete.Store(true)
 							if !p.quietMode {
 								fmt.Printf("\nError getting schema %s: %v\n", pathStr, err)
 							}
@@ -922,6 +1160,10 @@ func (p *APIServerProvider) GetOpenAPIResourceSpecs() (map[string][]string, erro
 		for result := range resultChan {
 			schemasBytes[result.index] = result.bytes
 		}
+		if err := ctx.Err(); err != nil {
+			p.openAPIDoc = nil
+			return nil, err
+		}
 
 		// Process schemas in batches
 		const batchSize = 10
@@ -988,6 +1230,9 @@ func (p *APIServerProvider) GetOpenAPIResourceSpecs() (map[string][]string, erro
 		fmt.Printf("\r ✔️ Resolving schemas (%v processed)                    \n", processed)
 	}
 
+	if fetched && !incomplete.Load() {
+		_ = p.StoreCached(specsCacheEntry, specs)
+	}
 	return specs, nil
 }
 
@@ -1161,29 +1406,58 @@ func (p *APIServerProvider) resolveReference(ref string) *openapi_v3.Schema {
 }
 
 // Add this method to implement the Provider interface
-func (p *APIServerProvider) CreateProviderForContext(context string) (provider.Provider, error) {
+func (p *APIServerProvider) CreateProviderForContext(kubeContext string) (provider.Provider, error) {
+	return p.CreateProviderForKubeContext(context.Background(), kubeContext)
+}
+
+// CreateProviderForKubeContext builds the provider for kubeContext. ctx
+// bounds the requests fingerprinting the cluster for the disk cache.
+func (p *APIServerProvider) CreateProviderForKubeContext(ctx context.Context, kubeContext string) (provider.Provider, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	// Get REST config for the context
-	restConfig, err := buildRestConfigFromKubeconfig(context)
+	restConfig, err := BuildRestConfig(kubeContext, p.identity)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create config for context %s: %v", context, err)
+		return nil, fmt.Errorf("failed to create config for context %s: %v", kubeContext, err)
+	}
+	if p.requestLimits.QPS > 0 {
+		restConfig.QPS = p.requestLimits.QPS
+		restConfig.Burst = requestBurst(&p.requestLimits)
 	}
 
 	// Create new clients for this context
 	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create clientset for context %s: %v", context, err)
+		return nil, fmt.Errorf("failed to create clientset for context %s: %v", kubeContext, err)
 	}
 
-	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	dynamicClient, err := dynamic.NewForConfig(withoutClientRetries(restConfig))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create dynamic client for context %s: %v", context, err)
+		return nil, fmt.Errorf("failed to create dynamic client for context %s: %v", kubeContext, err)
+	}
+
+	metadataClient, err := metadata.NewForConfig(withoutClientRetries(restConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metadata client for context %s: %v", kubeContext, err)
 	}
 
 	// Create new provider with the context-specific clients
-	return NewAPIServerProviderWithOptions(&APIServerProviderConfig{
-		Clientset:     clientset,
-		DynamicClient: dynamicClient,
-		QuietMode:     p.quietMode,
+	return NewAPIServerProviderContext(ctx, &APIServerProviderConfig{
+		Clientset:             clientset,
+		DynamicClient:         dynamicClient,
+		MetadataClient:        metadataClient,
+		QuietMode:             p.quietMode,
+		MaxConcurrentRequests: p.requestLimits.MaxConcurrentRequests,
+		QPS:                   p.requestLimits.QPS,
+		Burst:                 p.requestLimits.Burst,
+		PageSize:              p.requestLimits.PageSize,
+		Retry:                 p.requestLimits.Retry,
+		ServerSideApply:       p.applyOptions.ServerSideApply,
+		ForceConflicts:        p.applyOptions.ForceConflicts,
+		FieldManager:          p.applyOptions.FieldManager,
+		Identity:              p.identity,
+		CacheDir:              p.cacheDir,
 	})
 }
 
@@ -1213,11 +1487,41 @@ func (p *APIServerProvider) initGVRCache() error {
 	if p.gvrCache == nil {
 		p.gvrCache = make(map[string]schema.GroupVersionResource)
 	}
+	if p.resourceKinds == nil {
+		p.resourceKinds = make(map[schema.GroupVersionResource]string)
+	}
 
-	resources, err := p.clientset.Discovery().ServerPreferredResources()
-	if err != nil {
-		return fmt.Errorf("error getting server resources: %w", err)
+	if p.namespacedCache == nil {
+		p.namespacedCache = make(map[string]bool)
+	}
+
+	var resources []*metav1.APIResourceList
+	if !p.LoadCached(discoveryCacheEntry, &resources) {
+		var err error
+		resources, err = p.clientset.Discovery().ServerPreferredResources()
+		if err != nil {
+			return fmt.Errorf("error getting server resources: %w", err)
+		}
+		// A failed write only means discovery runs again next time.
+		_ = p.StoreCached(discoveryCacheEntry, resources)
+	}
+
+	// Discovery only returns each group's preferred version; kinds pinned to
+	// another version are checked against all the versions a group serves.
+	groupVersions := make(map[string][]string)
+	if !p.LoadCached(groupsCacheEntry, &groupVersions) {
+		groups, err := p.clientset.Discovery().ServerGroups()
+		if err != nil {
+			return fmt.Errorf("error getting server groups: %w", err)
+		}
+		for _, group := range groups.Groups {
+			for _, version := range group.Versions {
+				groupVersions[group.Name] = append(groupVersions[group.Name], version.Version)
+			}
+		}
+		_ = p.StoreCached(groupsCacheEntry, groupVersions)
 	}
+	p.groupVersions = groupVersions
 
 	for _, list := range resources {
 		gv, err := schema.ParseGroupVersion(list.GroupVersion)
@@ -1242,6 +1546,8 @@ func (p *APIServerProvider) initGVRCache() error {
 				p.knownResourceKinds = append(p.knownResourceKinds, r.Name)
 			}
 
+			p.resourceKinds[gvr] = r.Kind
+			p.namespacedCache[fmt.Sprintf("%s/%s/%s", gvr.Group, gvr.Version, gvr.Resource)] = r.Namespaced
 			// Store with kind as key
 			p.gvrCache[r.Kind] = gvr
 			// Store with resource name (plural) as key
@@ -1286,6 +1592,11 @@ func (p *APIServerProvider) GetGVRCacheSnapshot() map[string]schema.GroupVersion
 	return snapshot
 }
 
+// IsNamespaced reports whether resources of gvr are namespaced.
+func (p *APIServerProvider) IsNamespaced(gvr schema.GroupVersionResource) (bool, error) {
+	return p.isNamespacedResource(gvr)
+}
+
 // Add helper method to check if a resource is namespaced
 func (p *APIServerProvider) isNamespacedResource(gvr schema.GroupVersionResource) (bool, error) {
 	// Create cache key in format "group/version/resource"
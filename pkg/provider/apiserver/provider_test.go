@@ -1,9 +1,30 @@
 package apiserver
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	discoveryfake "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
 )
 
 const twoContextKubeconfig = `apiVersion: v1
@@ -74,12 +95,12 @@ func TestBuildRestConfigFromKubeconfig(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cfg, err := buildRestConfigFromKubeconfig(tt.context)
+			cfg, err := BuildRestConfig(tt.context, Identity{})
 			if err != nil {
-				t.Fatalf("buildRestConfigFromKubeconfig(%q) returned error: %v", tt.context, err)
+				t.Fatalf("BuildRestConfig(%q) returned error: %v", tt.context, err)
 			}
 			if cfg.Host != tt.wantServer {
-				t.Errorf("buildRestConfigFromKubeconfig(%q) host = %q, want %q", tt.context, cfg.Host, tt.wantServer)
+				t.Errorf("BuildRestConfig(%q) host = %q, want %q", tt.context, cfg.Host, tt.wantServer)
 			}
 		})
 	}
@@ -89,7 +110,382 @@ func TestBuildRestConfigFromKubeconfigUnknownContext(t *testing.T) {
 	kubeconfigPath := writeTempKubeconfig(t)
 	t.Setenv("KUBECONFIG", kubeconfigPath)
 
-	if _, err := buildRestConfigFromKubeconfig("does-not-exist"); err == nil {
+	if _, err := BuildRestConfig("does-not-exist", Identity{}); err == nil {
 		t.Fatalf("expected error for unknown context, got nil")
 	}
 }
+
+func TestBuildRestConfigWithIdentity(t *testing.T) {
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "missing"))
+	kubeconfigPath := writeTempKubeconfig(t)
+
+	cfg, err := BuildRestConfig("ctx-b", Identity{Kubeconfig: kubeconfigPath})
+	if err != nil {
+		t.Fatalf("BuildRestConfig() error = %v", err)
+	}
+	if cfg.Host != "https://server-b.example.com" || cfg.BearerToken != "token-b" {
+		t.Errorf("host = %q, token = %q, want ctx-b from the explicit kubeconfig", cfg.Host, cfg.BearerToken)
+	}
+
+	identity := Identity{Kubeconfig: kubeconfigPath, Token: "sa-token", As: "system:serviceaccount:ci:deployer", AsGroups: []string{"ci"}}
+	cfg, err = BuildRestConfig("", identity)
+	if err != nil {
+		t.Fatalf("BuildRestConfig() error = %v", err)
+	}
+	if cfg.BearerToken != "sa-token" || cfg.Impersonate.UserName != identity.As || !reflect.DeepEqual(cfg.Impersonate.Groups, identity.AsGroups) {
+		t.Errorf("token = %q, impersonate = %+v, want the identity's", cfg.BearerToken, cfg.Impersonate)
+	}
+
+	if err := (Identity{AsGroups: []string{"ci"}}).Validate(); err == nil {
+		t.Errorf("expected groups without a user to be rejected")
+	}
+}
+
+func TestGetK8sResourcesContextCancelled(t *testing.T) {
+	p := &APIServerProvider{
+		requestChannel: make(chan *apiRequest),
+		semaphore:      make(chan struct{}, 1),
+	}
+	go p.processRequests()
+	defer close(p.requestChannel)
+
+	var _ provider.ContextProvider = p
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.GetK8sResourcesContext(ctx, "pods", "", "", "default"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetK8sResourcesContext() error = %v, want context.Canceled", err)
+	}
+}
+
+// slowListClient delays every namespaced list and records the highest number
+// of lists in flight at once.
+type slowListClient struct {
+	dynamic.Interface
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+type slowListResource struct {
+	dynamic.NamespaceableResourceInterface
+	client *slowListClient
+}
+
+type slowNamespacedResource struct {
+	dynamic.ResourceInterface
+	client *slowListClient
+}
+
+func (c *slowListClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return slowListResource{c.Interface.Resource(gvr), c}
+}
+
+func (r slowListResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return slowNamespacedResource{r.NamespaceableResourceInterface.Namespace(namespace), r.client}
+}
+
+func (r slowNamespacedResource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	r.client.mu.Lock()
+	r.client.inFlight++
+	if r.client.inFlight > r.client.maxInFlight {
+		r.client.maxInFlight = r.client.inFlight
+	}
+	r.client.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	r.client.mu.Lock()
+	r.client.inFlight--
+	r.client.mu.Unlock()
+	return r.ResourceInterface.List(ctx, opts)
+}
+
+var testPodsGVR = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// newTestProvider builds a provider serving pods from client without
+// discovery, and starts its request processor.
+func newTestProvider(t *testing.T, client dynamic.Interface, config *APIServerProviderConfig) *APIServerProvider {
+	t.Helper()
+	if err := validateRequestLimits(config); err != nil {
+		t.Fatalf("validateRequestLimits() error = %v", err)
+	}
+	p := &APIServerProvider{
+		dynamicClient:   client,
+		gvrCache:        map[string]schema.GroupVersionResource{"Pod": testPodsGVR},
+		namespacedCache: map[string]bool{"/v1/pods": true},
+		requestChannel:  make(chan *apiRequest),
+	}
+	p.configureRequests(config)
+	go p.processRequests()
+	t.Cleanup(func() { close(p.requestChannel) })
+	return p
+}
+
+func newSlowListProvider(t *testing.T, config *APIServerProviderConfig) (*APIServerProvider, *slowListClient) {
+	t.Helper()
+	client := &slowListClient{
+		Interface: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(k8sruntime.NewScheme(), map[schema.GroupVersionResource]string{testPodsGVR: "PodList"}),
+	}
+	return newTestProvider(t, client, config), client
+}
+
+func listConcurrently(t *testing.T, p *APIServerProvider, n int) {
+	t.Helper()
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = p.GetK8sResources("Pod", "", "", fmt.Sprintf("ns-%d", i))
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("GetK8sResources() error = %v", err)
+		}
+	}
+}
+
+func TestProcessRequestsIsSerialByDefault(t *testing.T) {
+	p, client := newSlowListProvider(t, &APIServerProviderConfig{})
+	listConcurrently(t, p, 4)
+	if client.maxInFlight != 1 {
+		t.Fatalf("expected one list at a time by default, got %d", client.maxInFlight)
+	}
+}
+
+func TestProcessRequestsHonoursMaxConcurrentRequests(t *testing.T) {
+	p, client := newSlowListProvider(t, &APIServerProviderConfig{MaxConcurrentRequests: 3, QPS: 1000})
+	listConcurrently(t, p, 6)
+	if client.maxInFlight < 2 || client.maxInFlight > 3 {
+		t.Fatalf("expected between 2 and 3 lists in flight, got %d", client.maxInFlight)
+	}
+}
+
+func TestRequestLimitsValidation(t *testing.T) {
+	for _, config := range []*APIServerProviderConfig{
+		{MaxConcurrentRequests: -1},
+		{QPS: -1},
+		{Burst: -1},
+	} {
+		if err := validateRequestLimits(config); err == nil {
+			t.Errorf("validateRequestLimits(%+v) expected error", config)
+		}
+	}
+	if burst := requestBurst(&APIServerProviderConfig{QPS: 2.5}); burst != 3 {
+		t.Errorf("requestBurst() = %d, want 3", burst)
+	}
+}
+
+// pagedClient serves pods page by page, using the index of the next item as
+// the continue token. With expireOnce set, the first continued list fails
+// with an expired token.
+type pagedClient struct {
+	dynamic.Interface
+	dynamic.NamespaceableResourceInterface
+	pods       int
+	expireOnce bool
+	calls      []metav1.ListOptions
+}
+
+func (c *pagedClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return c
+}
+
+func (c *pagedClient) Namespace(namespace string) dynamic.ResourceInterface {
+	return c
+}
+
+func (c *pagedClient) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	c.calls = append(c.calls, opts)
+	start := 0
+	if opts.Continue != "" {
+		if c.expireOnce {
+			c.expireOnce = false
+			return nil, apierrors.NewResourceExpired("continue token expired")
+		}
+		start, _ = strconv.Atoi(opts.Continue)
+	}
+	end := c.pods
+	if opts.Limit > 0 && start+int(opts.Limit) < end {
+		end = start + int(opts.Limit)
+	}
+
+	list := &unstructured.UnstructuredList{}
+	for i := start; i < end; i++ {
+		pod := unstructured.Unstructured{}
+		pod.SetName(fmt.Sprintf("pod-%d", i))
+		list.Items = append(list.Items, pod)
+	}
+	if end < c.pods {
+		list.SetContinue(strconv.Itoa(end))
+	}
+	return list, nil
+}
+
+func listedNames(t *testing.T, result interface{}) []string {
+	t.Helper()
+	var names []string
+	for _, item := range result.([]map[string]interface{}) {
+		names = append(names, item["metadata"].(map[string]interface{})["name"].(string))
+	}
+	return names
+}
+
+func TestFetchResourcesPaginates(t *testing.T) {
+	client := &pagedClient{pods: 7}
+	p := newTestProvider(t, client, &APIServerProviderConfig{PageSize: 3})
+
+	result, err := p.GetK8sResources("Pod", "", "", "default")
+	if err != nil {
+		t.Fatalf("GetK8sResources() error = %v", err)
+	}
+	if names := listedNames(t, result); len(names) != 7 || names[0] != "pod-0" || names[6] != "pod-6" {
+		t.Fatalf("got %v", names)
+	}
+	if len(client.calls) != 3 {
+		t.Fatalf("expected 3 pages, got %d list calls", len(client.calls))
+	}
+	for _, call := range client.calls {
+		if call.Limit != 3 {
+			t.Errorf("expected page size 3, got %d", call.Limit)
+		}
+	}
+}
+
+func TestFetchResourcesRestartsOnExpiredContinueToken(t *testing.T) {
+	client := &pagedClient{pods: 5, expireOnce: true}
+	p := newTestProvider(t, client, &APIServerProviderConfig{PageSize: 2})
+
+	result, err := p.GetK8sResources("Pod", "", "", "default")
+	if err != nil {
+		t.Fatalf("GetK8sResources() error = %v", err)
+	}
+	want := []string{"pod-0", "pod-1", "pod-2", "pod-3", "pod-4"}
+	if names := listedNames(t, result); !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+	if client.calls[2].Continue != "" {
+		t.Fatalf("expected the list to restart without a continue token, got %+v", client.calls[2])
+	}
+}
+
+func TestFetchResourcesStopsAtLimit(t *testing.T) {
+	client := &pagedClient{pods: 10}
+	p := newTestProvider(t, client, &APIServerProviderConfig{PageSize: 3})
+
+	result, err := p.GetK8sResourcesWithOptions(context.Background(), "Pod", "", "", "default", provider.ListOptions{Limit: 4})
+	if err != nil {
+		t.Fatalf("GetK8sResourcesWithOptions() error = %v", err)
+	}
+	if names := listedNames(t, result); len(names) != 4 {
+		t.Fatalf("got %v", names)
+	}
+	if len(client.calls) != 2 || client.calls[1].Limit != 1 {
+		t.Fatalf("expected a second page of 1 item, got %+v", client.calls)
+	}
+}
+
+func TestFetchResourcesMetadataOnly(t *testing.T) {
+	scheme := k8sruntime.NewScheme()
+	metav1.AddMetaToScheme(scheme)
+	metadataClient := metadatafake.NewSimpleMetadataClient(scheme, &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", Labels: map[string]string{"app": "web"}},
+	})
+	client := &pagedClient{pods: 1}
+	p := newTestProvider(t, client, &APIServerProviderConfig{})
+	p.metadataClient = metadataClient
+	p.resourceKinds = map[schema.GroupVersionResource]string{testPodsGVR: "Pod"}
+
+	result, err := p.GetK8sResourcesWithOptions(context.Background(), "Pod", "", "", "default", provider.ListOptions{MetadataOnly: true})
+	if err != nil {
+		t.Fatalf("GetK8sResourcesWithOptions() error = %v", err)
+	}
+	items := result.([]map[string]interface{})
+	if len(items) != 1 || items[0]["apiVersion"] != "v1" || items[0]["kind"] != "Pod" {
+		t.Fatalf("expected web-1 as a v1 Pod, got %#v", items)
+	}
+	if names := listedNames(t, result); names[0] != "web-1" {
+		t.Errorf("got %v", names)
+	}
+	if len(client.calls) != 0 {
+		t.Errorf("expected no full list, got %d", len(client.calls))
+	}
+
+	// Without its kind, a resource is listed whole.
+	p.resourceKinds = nil
+	if _, err := p.GetK8sResourcesWithOptions(context.Background(), "Pod", "", "", "default", provider.ListOptions{MetadataOnly: true}); err != nil {
+		t.Fatalf("GetK8sResourcesWithOptions() error = %v", err)
+	}
+	if len(client.calls) != 1 {
+		t.Errorf("expected a full list, got %d", len(client.calls))
+	}
+}
+
+func TestFindGVRPinsServedVersions(t *testing.T) {
+	hpas := func(version string) *metav1.APIResourceList {
+		return &metav1.APIResourceList{
+			GroupVersion: "autoscaling/" + version,
+			APIResources: []metav1.APIResource{{Name: "horizontalpodautoscalers", SingularName: "horizontalpodautoscaler", Kind: "HorizontalPodAutoscaler", ShortNames: []string{"hpa"}, Namespaced: true, Verbs: []string{"list"}}},
+		}
+	}
+	widgets := func(group, version string) *metav1.APIResourceList {
+		return &metav1.APIResourceList{
+			GroupVersion: group + "/" + version,
+			APIResources: []metav1.APIResource{{Name: "widgets", SingularName: "widget", Kind: "Widget", Namespaced: true, Verbs: []string{"list"}}},
+		}
+	}
+
+	// Discovery returns the preferred versions, the clientset serves them
+	// all. autoscaling/v2beta2 no longer serves hpas.
+	cache := newDiskCache(t.TempDir(), "https://cluster-a", "v1.31.0", nil)
+	if err := cache.store(discoveryCacheEntry, []*metav1.APIResourceList{
+		hpas("v2"), widgets("example.com", "v1"), widgets("other.io", "v1beta1"),
+	}); err != nil {
+		t.Fatalf("store() error = %v", err)
+	}
+	clientset := kubernetesfake.NewSimpleClientset()
+	clientset.Discovery().(*discoveryfake.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		hpas("v1"), hpas("v2"), {GroupVersion: "autoscaling/v2beta2"}, widgets("example.com", "v1"), widgets("example.com", "v1beta1"), widgets("other.io", "v1beta1"),
+	}
+	p := &APIServerProvider{clientset: clientset, diskCache: cache}
+	if err := p.initGVRCache(); err != nil {
+		t.Fatalf("initGVRCache() error = %v", err)
+	}
+
+	for kind, want := range map[string]schema.GroupVersionResource{
+		"hpa":                                    {Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"},
+		"HorizontalPodAutoscaler.autoscaling/v1": {Group: "autoscaling", Version: "v1", Resource: "horizontalpodautoscalers"},
+		"hpa/v1":                                 {Group: "autoscaling", Version: "v1", Resource: "horizontalpodautoscalers"},
+		"widgets.example.com/v1beta1":            {Group: "example.com", Version: "v1beta1", Resource: "widgets"},
+	} {
+		if got, err := p.FindGVR(kind); err != nil || got != want {
+			t.Errorf("FindGVR(%s) = %v, %v, want %v", kind, got, err, want)
+		}
+	}
+	if gvk, err := p.gvkFor(schema.GroupVersionResource{Group: "autoscaling", Version: "v1", Resource: "horizontalpodautoscalers"}); err != nil || gvk.Kind != "HorizontalPodAutoscaler" {
+		t.Errorf("gvkFor(autoscaling/v1) = %v, %v", gvk, err)
+	}
+	if gvk, err := p.gvkFor(schema.GroupVersionResource{Group: "autoscaling", Version: "v2beta2", Resource: "horizontalpodautoscalers"}); err == nil {
+		t.Errorf("expected gvkFor(autoscaling/v2beta2) to fail for a version not serving the resource, got %v", gvk)
+	}
+
+	for kind, wantErr := range map[string]string{
+		"widget/v1beta1": "Please specify one of:\nwidgets.example.com/v1beta1\nwidgets.other.io/v1beta1",
+		"Widget":         "Please specify one of:\nwidgets.example.com\nwidgets.other.io",
+		"hpa/v3":         `not served at version "v3", available versions: v1, v2`,
+		"hpa/v2beta2":    `not served at version "v2beta2", available versions: v1, v2`,
+	} {
+		if _, err := p.FindGVR(kind); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("FindGVR(%s) error = %v, want %q", kind, err, wantErr)
+		}
+	}
+	// Only one group serves widgets at v1.
+	if got, err := p.FindGVR("widget/v1"); err != nil || got.Group != "example.com" {
+		t.Errorf("FindGVR(widget/v1) = %v, %v", got, err)
+	}
+}
@@ -0,0 +1,216 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// RetryPolicy configures how requests that failed with a transient error are
+// retried. Each retry waits twice as long as the one before, starting at
+// InitialBackoff and up to MaxBackoff, or as long as the API server asked
+// for in a Retry-After if that is longer.
+//
+// Reads are retried on 429s, 503s, timeouts and internal errors, like those
+// of an etcd leader election. Writes are only retried when the API server
+// rejected them without applying them: on 429s, 503s and ServerTimeouts.
+type RetryPolicy struct {
+	// MaxRetries is how often a request is retried. Zero uses
+	// DefaultRetryPolicy; a negative value disables retries.
+	MaxRetries int
+	// InitialBackoff and MaxBackoff bound the delay between attempts. Zero
+	// uses the values of DefaultRetryPolicy.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy is the policy of providers whose config leaves Retry
+// unset.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     5,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+}
+
+// Validate reports durations that can't be waited for.
+func (r RetryPolicy) Validate() error {
+	if r.InitialBackoff < 0 {
+		return fmt.Errorf("invalid retry InitialBackoff %v: must not be negative", r.InitialBackoff)
+	}
+	if r.MaxBackoff < 0 {
+		return fmt.Errorf("invalid retry MaxBackoff %v: must not be negative", r.MaxBackoff)
+	}
+	return nil
+}
+
+func (r RetryPolicy) withDefaults() RetryPolicy {
+	if r.MaxRetries == 0 {
+		r.MaxRetries = DefaultRetryPolicy.MaxRetries
+	}
+	if r.InitialBackoff == 0 {
+		r.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	return r
+}
+
+// backoff returns the delay before retry number retry, starting at 1.
+func (r RetryPolicy) backoff(retry int, err error) time.Duration {
+	delay := r.InitialBackoff
+	for i := 1; i < retry && delay < r.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, r.MaxBackoff)
+	if seconds, ok := apierrors.SuggestsClientDelay(err); ok {
+		delay = max(delay, time.Duration(seconds)*time.Second)
+	}
+	return delay
+}
+
+// withoutClientRetries returns a copy of config whose clients leave
+// retrying to withRetries. client-go retries any response carrying a
+// Retry-After, 429s included, up to 10 times on its own, which would stack
+// on the provider's policy and keep the retries out of PROFILE. The header
+// is dropped before client-go sees it and handed to withRetries through the
+// request's context instead, since throttled responses that aren't a Status
+// carry the delay the API server asks for nowhere else.
+func withoutClientRetries(config *rest.Config) *rest.Config {
+	config = rest.CopyConfig(config)
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return retryAfterStripper{rt}
+	})
+	return config
+}
+
+type retryAfterStripper struct {
+	http.RoundTripper
+}
+
+func (t retryAfterStripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if resp != nil {
+		if hint, ok := req.Context().Value(retryAfterKey{}).(*retryAfter); ok {
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+				hint.delay = time.Duration(seconds) * time.Second
+			}
+		}
+		resp.Header.Del("Retry-After")
+	}
+	return resp, err
+}
+
+// retryAfter holds the Retry-After of the last response to an attempt.
+type retryAfter struct {
+	delay time.Duration
+}
+
+type retryAfterKey struct{}
+
+// retriable reports whether a request that failed with err may be sent
+// again. Writes are only retried on errors that mean they were not applied.
+func retriable(err error, write bool) bool {
+	switch {
+	case apierrors.IsTooManyRequests(err), apierrors.IsServiceUnavailable(err), apierrors.IsServerTimeout(err):
+		return true
+	case apierrors.IsTimeout(err), apierrors.IsInternalError(err):
+		return !write
+	}
+	return false
+}
+
+// retryRequest identifies a request for retry reports.
+type retryRequest struct {
+	verb      string
+	gvr       schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+func (r retryRequest) write() bool {
+	switch r.verb {
+	case "create", "patch", "apply", "delete":
+		return true
+	}
+	return false
+}
+
+// requestSlot is a slot of the semaphore bounding concurrent list requests.
+// A request gives its slot up while it waits to be retried, so that one
+// throttled request doesn't hold up the others.
+type requestSlot struct {
+	semaphore chan struct{}
+	held      bool
+}
+
+type requestSlotKey struct{}
+
+// withRequestSlot returns a context whose retries give slot up while they
+// wait.
+func withRequestSlot(ctx context.Context, slot *requestSlot) context.Context {
+	return context.WithValue(ctx, requestSlotKey{}, slot)
+}
+
+func (s *requestSlot) acquire(ctx context.Context) error {
+	select {
+	case s.semaphore <- struct{}{}:
+		s.held = true
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *requestSlot) release() {
+	if s != nil && s.held {
+		<-s.semaphore
+		s.held = false
+	}
+}
+
+// withRetries calls send with a context derived from ctx until it succeeds, fails with an error the retry
+// policy doesn't cover or runs out of retries. Retries are reported to the
+// observers of ctx, and wait without the request slot of ctx, if any.
+func (p *APIServerProvider) withRetries(ctx context.Context, request retryRequest, send func(ctx context.Context) error) error {
+	policy := p.retryPolicy
+	for attempt := 1; ; attempt++ {
+		hint := &retryAfter{}
+		err := send(context.WithValue(ctx, retryAfterKey{}, hint))
+		if err == nil || policy.MaxRetries < 0 || attempt > policy.MaxRetries || !retriable(err, request.write()) {
+			return err
+		}
+		delay := max(policy.backoff(attempt, err), hint.delay)
+		provider.ObserveRetry(ctx, provider.Retry{
+			Verb:      request.verb,
+			Resource:  request.gvr.Resource,
+			Namespace: request.namespace,
+			Name:      request.name,
+			Attempt:   attempt,
+			Delay:     delay,
+			Err:       err,
+		})
+		slot, _ := ctx.Value(requestSlotKey{}).(*requestSlot)
+		slot.release()
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		if slot != nil {
+			if slotErr := slot.acquire(ctx); slotErr != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package apiserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// flakyClient fails its lists and deletes with errs, in order, before
+// serving them like pagedClient.
+type flakyClient struct {
+	*pagedClient
+	errs     []error
+	attempts int
+}
+
+func (c *flakyClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return c
+}
+
+func (c *flakyClient) Namespace(namespace string) dynamic.ResourceInterface {
+	return c
+}
+
+func (c *flakyClient) fail() error {
+	c.attempts++
+	if len(c.errs) == 0 {
+		return nil
+	}
+	err := c.errs[0]
+	c.errs = c.errs[1:]
+	return err
+}
+
+func (c *flakyClient) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	return c.pagedClient.List(ctx, opts)
+}
+
+func (c *flakyClient) Delete(ctx context.Context, name string, opts metav1.DeleteOptions, subresources ...string) error {
+	return c.fail()
+}
+
+var fastRetries = RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+func TestFetchResourcesRetriesTransientErrors(t *testing.T) {
+	client := &flakyClient{
+		pagedClient: &pagedClient{pods: 3},
+		errs:        []error{nil, apierrors.NewTooManyRequests("slow down", 0), apierrors.NewInternalError(context.DeadlineExceeded)},
+	}
+	p := newTestProvider(t, client, &APIServerProviderConfig{PageSize: 2, Retry: fastRetries})
+
+	var retries []provider.Retry
+	ctx := provider.WithRetryObserver(context.Background(), func(retry provider.Retry) {
+		retries = append(retries, retry)
+	})
+	result, err := p.GetK8sResourcesContext(ctx, "Pod", "", "", "default")
+	if err != nil {
+		t.Fatalf("GetK8sResourcesContext() error = %v", err)
+	}
+	if names := listedNames(t, result); len(names) != 3 {
+		t.Errorf("expected all pods, got %v", names)
+	}
+	if len(retries) != 2 || retries[0].Verb != "list" || retries[0].Resource != "pods" || retries[1].Attempt != 2 {
+		t.Errorf("expected the second page to be retried twice, got %+v", retries)
+	}
+	// The retried page continues where the first one ended.
+	if last := client.calls[len(client.calls)-1]; last.Continue != "2" {
+		t.Errorf("expected the second page to be listed, got %+v", last)
+	}
+}
+
+func TestRetriesStopAtMaxRetriesAndSpareWrites(t *testing.T) {
+	for name, tc := range map[string]struct {
+		err      error
+		policy   RetryPolicy
+		attempts int
+	}{
+		"exhausted retries":          {apierrors.NewServiceUnavailable("unavailable"), fastRetries, 3},
+		"write on internal error":    {apierrors.NewInternalError(context.DeadlineExceeded), fastRetries, 1},
+		"write on timeout":           {apierrors.NewTimeoutError("timed out", 0), fastRetries, 1},
+		"retries disabled":           {apierrors.NewServiceUnavailable("unavailable"), RetryPolicy{MaxRetries: -1}, 1},
+		"non-transient error":        {apierrors.NewForbidden(testPodsGVR.GroupResource(), "web", nil), fastRetries, 1},
+		"write on server timeout":    {apierrors.NewServerTimeout(testPodsGVR.GroupResource(), "delete", 0), fastRetries, 3},
+		"write on too many requests": {apierrors.NewTooManyRequests("slow down", 0), fastRetries, 3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &flakyClient{pagedClient: &pagedClient{}, errs: []error{tc.err, tc.err, tc.err}}
+			p := newTestProvider(t, client, &APIServerProviderConfig{Retry: tc.policy})
+			p.quietMode = true
+			if err := p.DeleteK8sResourcesContext(context.Background(), "Pod", "web", "default", false); err == nil {
+				t.Fatalf("expected the delete to fail")
+			}
+			if client.attempts != tc.attempts {
+				t.Errorf("expected %d attempt(s), got %d", tc.attempts, client.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}.withDefaults()
+	for retry, want := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		if got := policy.backoff(retry, apierrors.NewServiceUnavailable("unavailable")); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", retry, got, want)
+		}
+	}
+	if got := policy.backoff(1, apierrors.NewTooManyRequests("slow down", 5)); got != 5*time.Second {
+		t.Errorf("expected Retry-After to be respected, got %v", got)
+	}
+	if policy.MaxRetries != DefaultRetryPolicy.MaxRetries {
+		t.Errorf("expected the default MaxRetries, got %d", policy.MaxRetries)
+	}
+	if err := validateRequestLimits(&APIServerProviderConfig{Retry: RetryPolicy{MaxBackoff: -time.Second}}); err == nil {
+		t.Errorf("expected a negative MaxBackoff to be rejected")
+	}
+}
+
+func (c *flakyClient) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{}, nil
+}
+
+func TestLabelPatchesAreRetried(t *testing.T) {
+	client := &flakyClient{pagedClient: &pagedClient{}, errs: []error{apierrors.NewTooManyRequests("slow down", 0)}}
+	p := newTestProvider(t, client, &APIServerProviderConfig{Retry: fastRetries})
+	p.quietMode = true
+
+	patch := `[{"op":"test","path":"/metadata/labels","value":{}},{"op":"add","path":"/metadata/labels/tier","value":"web"}]`
+	if err := p.PatchK8sResourceContext(context.Background(), "Pod", "web", "default", []byte(patch), false); err != nil {
+		t.Fatalf("PatchK8sResourceContext() error = %v", err)
+	}
+	if client.attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", client.attempts)
+	}
+}
+
+// throttledClient throttles the first list in namespace "slow" and records
+// the order lists complete in.
+type throttledClient struct {
+	*pagedClient
+	mu        sync.Mutex
+	throttled bool
+	completed []string
+}
+
+type throttledNamespace struct {
+	dynamic.ResourceInterface
+	client    *throttledClient
+	namespace string
+}
+
+func (c *throttledClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return c
+}
+
+func (c *throttledClient) Namespace(namespace string) dynamic.ResourceInterface {
+	return throttledNamespace{client: c, namespace: namespace}
+}
+
+func (n throttledNamespace) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	n.client.mu.Lock()
+	defer n.client.mu.Unlock()
+	if n.namespace == "slow" && !n.client.throttled {
+		n.client.throttled = true
+		return nil, apierrors.NewTooManyRequests("slow down", 0)
+	}
+	n.client.completed = append(n.client.completed, n.namespace)
+	return n.client.pagedClient.List(ctx, opts)
+}
+
+func TestRetriesGiveUpTheirRequestSlot(t *testing.T) {
+	client := &throttledClient{pagedClient: &pagedClient{pods: 1}}
+	retry := RetryPolicy{MaxRetries: 1, InitialBackoff: 200 * time.Millisecond, MaxBackoff: 200 * time.Millisecond}
+	p := newTestProvider(t, client, &APIServerProviderConfig{MaxConcurrentRequests: 1, Retry: retry})
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := p.GetK8sResourcesContext(context.Background(), "Pod", "", "", "slow")
+		slow <- err
+	}()
+	// Let the slow list be throttled before the other one is queued
+	time.Sleep(50 * time.Millisecond)
+	if _, err := p.GetK8sResourcesContext(context.Background(), "Pod", "", "", "fast"); err != nil {
+		t.Fatalf("GetK8sResourcesContext() error = %v", err)
+	}
+	if err := <-slow; err != nil {
+		t.Fatalf("GetK8sResourcesContext() error = %v", err)
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if want := []string{"fast", "slow"}; !reflect.DeepEqual(client.completed, want) {
+		t.Errorf("lists completed in order %v, want %v", client.completed, want)
+	}
+	if len(p.semaphore) != 0 {
+		t.Errorf("expected every slot to be released, %d held", len(p.semaphore))
+	}
+}
+
+func TestRetryAfterIsLeftToTheRetryPolicy(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// Priority and fairness rejects requests in plain text, with the
+		// delay only in the header.
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many requests, please try again later.", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client, err := dynamic.NewForConfig(withoutClientRetries(&rest.Config{Host: server.URL}))
+	if err != nil {
+		t.Fatalf("dynamic.NewForConfig() error = %v", err)
+	}
+	p := newTestProvider(t, client, &APIServerProviderConfig{Retry: RetryPolicy{MaxRetries: 1, InitialBackoff: time.Millisecond}})
+	p.quietMode = true
+
+	var retries []provider.Retry
+	ctx := provider.WithRetryObserver(context.Background(), func(retry provider.Retry) {
+		retries = append(retries, retry)
+	})
+	if err := p.DeleteK8sResourcesContext(ctx, "Pod", "web", "default", false); !apierrors.IsTooManyRequests(err) {
+		t.Fatalf("expected the delete to be throttled, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, one per attempt, got %d", requests)
+	}
+	if len(retries) != 1 || retries[0].Delay != time.Second {
+		t.Errorf("expected one retry waiting for the Retry-After, got %+v", retries)
+	}
+}
@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ContextProvider is implemented by providers whose operations can be
+// cancelled. Each method mirrors a Provider method, taking a context that
+// bounds the backend calls it makes.
+type ContextProvider interface {
+	Provider
+
+	GetK8sResourcesContext(ctx context.Context, kind, fieldSelector, labelSelector, namespace string) (interface{}, error)
+	DeleteK8sResourcesContext(ctx context.Context, kind, name, namespace string, dryRun bool) error
+	CreateK8sResourceContext(ctx context.Context, kind, name, namespace string, body interface{}, dryRun bool) error
+	PatchK8sResourceContext(ctx context.Context, kind, name, namespace string, patchJSON []byte, dryRun bool) error
+
+	FindGVRContext(ctx context.Context, kind string) (schema.GroupVersionResource, error)
+	GetOpenAPIResourceSpecsContext(ctx context.Context) (map[string][]string, error)
+	// CreateProviderForKubeContext is the context-aware CreateProviderForContext;
+	// kubeContext names the kubeconfig context.
+	CreateProviderForKubeContext(ctx context.Context, kubeContext string) (Provider, error)
+}
+
+// The functions below call the context-aware method when p implements
+// ContextProvider. Otherwise they check ctx once before calling the plain
+// method, so providers without cancellation still stop between calls.
+
+func GetK8sResources(ctx context.Context, p Provider, kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.GetK8sResourcesContext(ctx, kind, fieldSelector, labelSelector, namespace)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p.GetK8sResources(kind, fieldSelector, labelSelector, namespace)
+}
+
+func DeleteK8sResources(ctx context.Context, p Provider, kind, name, namespace string, dryRun bool) error {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.DeleteK8sResourcesContext(ctx, kind, name, namespace, dryRun)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.DeleteK8sResources(kind, name, namespace, dryRun)
+}
+
+func CreateK8sResource(ctx context.Context, p Provider, kind, name, namespace string, body interface{}, dryRun bool) error {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.CreateK8sResourceContext(ctx, kind, name, namespace, body, dryRun)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.CreateK8sResource(kind, name, namespace, body, dryRun)
+}
+
+func PatchK8sResource(ctx context.Context, p Provider, kind, name, namespace string, patchJSON []byte, dryRun bool) error {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.PatchK8sResourceContext(ctx, kind, name, namespace, patchJSON, dryRun)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.PatchK8sResource(kind, name, namespace, patchJSON, dryRun)
+}
+
+func FindGVR(ctx context.Context, p Provider, kind string) (schema.GroupVersionResource, error) {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.FindGVRContext(ctx, kind)
+	}
+	if err := ctx.Err(); err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	return p.FindGVR(kind)
+}
+
+func GetOpenAPIResourceSpecs(ctx context.Context, p Provider) (map[string][]string, error) {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.GetOpenAPIResourceSpecsContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p.GetOpenAPIResourceSpecs()
+}
+
+func CreateProviderForContext(ctx context.Context, p Provider, kubeContext string) (Provider, error) {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.CreateProviderForKubeContext(ctx, kubeContext)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p.CreateProviderForContext(kubeContext)
+}
@@ -0,0 +1,152 @@
+package fake
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The verbs calls are recorded under.
+const (
+	VerbList   = "list"
+	VerbCreate = "create"
+	VerbPatch  = "patch"
+	VerbDelete = "delete"
+)
+
+// Call is a provider call, recorded with the arguments it was made with.
+type Call struct {
+	Verb string
+	// Kind is the kind as the caller named it, e.g. "Pod" or "deployments".
+	Kind          string
+	Namespace     string
+	Name          string
+	FieldSelector string
+	LabelSelector string
+	Limit         int64
+	MetadataOnly  bool
+	// Body is the object passed to a create, as JSON values.
+	Body map[string]interface{}
+	// Patch is the JSON patch passed to a patch.
+	Patch  []byte
+	DryRun bool
+}
+
+func (c Call) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", c.Verb, c.Kind)
+	if c.Namespace != "" {
+		fmt.Fprintf(&b, " -n %s", c.Namespace)
+	}
+	if c.Name != "" {
+		fmt.Fprintf(&b, " %s", c.Name)
+	}
+	if c.FieldSelector != "" {
+		fmt.Fprintf(&b, " --field-selector %s", c.FieldSelector)
+	}
+	if c.LabelSelector != "" {
+		fmt.Fprintf(&b, " -l %s", c.LabelSelector)
+	}
+	if c.DryRun {
+		b.WriteString(" --dry-run")
+	}
+	return b.String()
+}
+
+func (p *Provider) record(call Call) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, call)
+}
+
+// Calls returns the recorded calls in the order they were made, limited to
+// verbs when any are given.
+func (p *Provider) Calls(verbs ...string) []Call {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var calls []Call
+	for _, call := range p.calls {
+		if len(verbs) == 0 || containsVerb(verbs, call.Verb) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func containsVerb(verbs []string, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// ResetCalls forgets the recorded calls.
+func (p *Provider) ResetCalls() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = nil
+}
+
+// TestingT is the part of *testing.T the assertions use.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertCalled fails t unless a call of verb was made for the resource.
+// kind may be any name of the resource's type; an empty namespace or name
+// matches any.
+func (p *Provider) AssertCalled(t TestingT, verb, kind, namespace, name string) bool {
+	t.Helper()
+	if len(p.matchingCalls(verb, kind, namespace, name)) == 0 {
+		t.Errorf("expected %s, got calls:\n%s", describeCall(verb, kind, namespace, name), p.describeCalls())
+		return false
+	}
+	return true
+}
+
+// AssertNotCalled fails t if a call of verb was made for the resource,
+// matched as by AssertCalled.
+func (p *Provider) AssertNotCalled(t TestingT, verb, kind, namespace, name string) bool {
+	t.Helper()
+	if calls := p.matchingCalls(verb, kind, namespace, name); len(calls) > 0 {
+		t.Errorf("expected no %s, got %s", describeCall(verb, kind, namespace, name), calls[0])
+		return false
+	}
+	return true
+}
+
+func (p *Provider) matchingCalls(verb, kind, namespace, name string) []Call {
+	want, err := p.FindGVR(kind)
+	if err != nil {
+		return nil
+	}
+	var matched []Call
+	for _, call := range p.Calls(verb) {
+		got, err := p.FindGVR(call.Kind)
+		if err != nil || got.GroupResource() != want.GroupResource() {
+			continue
+		}
+		if (namespace == "" || call.Namespace == namespace) && (name == "" || call.Name == name) {
+			matched = append(matched, call)
+		}
+	}
+	return matched
+}
+
+func describeCall(verb, kind, namespace, name string) string {
+	return Call{Verb: verb, Kind: kind, Namespace: namespace, Name: name}.String()
+}
+
+func (p *Provider) describeCalls() string {
+	calls := p.Calls()
+	if len(calls) == 0 {
+		return "  (none)"
+	}
+	lines := make([]string, len(calls))
+	for i, call := range calls {
+		lines[i] = "  " + call.String()
+	}
+	return strings.Join(lines, "\n")
+}
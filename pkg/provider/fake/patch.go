@@ -0,0 +1,84 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// checkPrecondition fails like the API server when a patch is conditional
+// on a resourceVersion resource is no longer at.
+func checkPrecondition(gr schema.GroupResource, resource *unstructured.Unstructured, patchJSON []byte) error {
+	var operations []interface{}
+	if err := json.Unmarshal(patchJSON, &operations); err != nil {
+		// Left to applyPatch to report.
+		return nil
+	}
+	resourceVersion, _ := provider.SplitPrecondition(operations)
+	if resourceVersion == "" || resourceVersion == resource.GetResourceVersion() {
+		return nil
+	}
+	err := apierrors.NewConflict(gr, resource.GetName(), fmt.Errorf("the object has been modified; please apply your changes to the latest version and try again"))
+	return fmt.Errorf("%w: %w", provider.ErrConflict, err)
+}
+
+// applyPatch applies a JSON patch to object and returns the result, leaving
+// object unchanged. A "test" operation directly followed by an "add" below
+// its path is how queries set a key in a map that may not exist yet; as in
+// the API server provider, the test is dropped and the add creates the
+// missing parents instead of failing.
+func applyPatch(object map[string]interface{}, patchJSON []byte) (map[string]interface{}, error) {
+	var operations []map[string]interface{}
+	if err := json.Unmarshal(patchJSON, &operations); err != nil {
+		return nil, fmt.Errorf("invalid patch JSON: %v", err)
+	}
+
+	document, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(operations); i++ {
+		options := jsonpatch.NewApplyOptions()
+		if isParentTest(operations, i) {
+			i++
+			options.EnsurePathExistsOnAdd = true
+		}
+		single, err := json.Marshal(operations[i : i+1])
+		if err != nil {
+			return nil, err
+		}
+		patch, err := jsonpatch.DecodePatch(single)
+		if err != nil {
+			return nil, fmt.Errorf("invalid patch operation %d: %v", i, err)
+		}
+		document, err = patch.ApplyWithOptions(document, options)
+		if err != nil {
+			return nil, fmt.Errorf("patch operation %d: %v", i, err)
+		}
+	}
+
+	// Decode like the API server's responses, with integers as int64.
+	var patched unstructured.Unstructured
+	if err := patched.UnmarshalJSON(document); err != nil {
+		return nil, err
+	}
+	return patched.Object, nil
+}
+
+// isParentTest reports whether operations[i] tests a path the next
+// operation adds below.
+func isParentTest(operations []map[string]interface{}, i int) bool {
+	if i+1 >= len(operations) || operations[i]["op"] != "test" || operations[i+1]["op"] != "add" {
+		return false
+	}
+	path, _ := operations[i]["path"].(string)
+	next, _ := operations[i+1]["path"].(string)
+	return strings.HasPrefix(next, path+"/")
+}
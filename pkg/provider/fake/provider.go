@@ -0,0 +1,534 @@
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// ResourceType describes a resource type the fake serves.
+type ResourceType struct {
+	GVR  schema.GroupVersionResource
+	Kind string
+	// Singular defaults to the lowercased kind.
+	Singular   string
+	ShortNames []string
+	Namespaced bool
+}
+
+// DefaultResourceTypes are the types a new Provider serves.
+var DefaultResourceTypes = []ResourceType{
+	{GVR: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, Kind: "Pod", ShortNames: []string{"po"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Version: "v1", Resource: "services"}, Kind: "Service", ShortNames: []string{"svc"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Version: "v1", Resource: "endpoints"}, Kind: "Endpoints", Singular: "endpoints", ShortNames: []string{"ep"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, Kind: "ConfigMap", ShortNames: []string{"cm"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, Kind: "Secret", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}, Kind: "ServiceAccount", ShortNames: []string{"sa"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}, Kind: "PersistentVolumeClaim", ShortNames: []string{"pvc"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumes"}, Kind: "PersistentVolume", ShortNames: []string{"pv"}},
+	{GVR: schema.GroupVersionResource{Version: "v1", Resource: "events"}, Kind: "Event", ShortNames: []string{"ev"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, Kind: "Namespace", ShortNames: []string{"ns"}},
+	{GVR: schema.GroupVersionResource{Version: "v1", Resource: "nodes"}, Kind: "Node", ShortNames: []string{"no"}},
+	{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, Kind: "Deployment", ShortNames: []string{"deploy"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}, Kind: "ReplicaSet", ShortNames: []string{"rs"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}, Kind: "StatefulSet", ShortNames: []string{"sts"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}, Kind: "DaemonSet", ShortNames: []string{"ds"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, Kind: "Job", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}, Kind: "CronJob", ShortNames: []string{"cj"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}, Kind: "Ingress", ShortNames: []string{"ing"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "networkpolicies"}, Kind: "NetworkPolicy", ShortNames: []string{"netpol"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "discovery.k8s.io", Version: "v1", Resource: "endpointslices"}, Kind: "EndpointSlice", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}, Kind: "HorizontalPodAutoscaler", ShortNames: []string{"hpa"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "policy", Version: "v1", Resource: "poddisruptionbudgets"}, Kind: "PodDisruptionBudget", ShortNames: []string{"pdb"}, Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"}, Kind: "Role", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "rolebindings"}, Kind: "RoleBinding", Namespaced: true},
+	{GVR: schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}, Kind: "ClusterRole"},
+	{GVR: schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterrolebindings"}, Kind: "ClusterRoleBinding"},
+	{GVR: schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "storageclasses"}, Kind: "StorageClass", ShortNames: []string{"sc"}},
+	{GVR: schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}, Kind: "CustomResourceDefinition", ShortNames: []string{"crd", "crds"}},
+}
+
+// Provider is an in-memory provider.Provider for tests of code that runs
+// queries. It serves the objects it is seeded with, evaluates label and
+// field selectors and JSON patches like the API server, honors dry-run and
+// records every call:
+//
+//	p := fake.NewProvider(pod, service)
+//	executor, _ := core.NewQueryExecutor(p)
+//	ast, _ := core.ParseQuery(`MATCH (p:Pod {name: "web"}) SET p.metadata.labels.tier = "frontend"`)
+//	_, err := executor.Execute(ast, "default")
+//	p.AssertCalled(t, fake.VerbPatch, "pods", "default", "web")
+//
+// Errors for missing or existing objects are the API server's, so
+// apierrors.IsNotFound and apierrors.IsAlreadyExists work on them.
+type Provider struct {
+	mu sync.Mutex
+
+	types map[schema.GroupResource]*ResourceType
+	names map[string]schema.GroupVersionResource
+	// objects holds each type's objects by namespace/name.
+	objects  map[schema.GroupResource]map[string]*unstructured.Unstructured
+	specs    map[string][]string
+	contexts map[string]*Provider
+	errors   map[errorKey]error
+	calls    []Call
+
+	resourceVersion int64
+}
+
+type errorKey struct {
+	verb     string
+	resource schema.GroupResource
+}
+
+// NewProvider returns a provider serving DefaultResourceTypes and objects,
+// which may be typed API objects, *unstructured.Unstructured or
+// map[string]interface{}. It panics if an object cannot be added, as
+// seeding a test with invalid objects is a bug in the test.
+func NewProvider(objects ...interface{}) *Provider {
+	p := &Provider{
+		types:    make(map[schema.GroupResource]*ResourceType),
+		names:    make(map[string]schema.GroupVersionResource),
+		objects:  make(map[schema.GroupResource]map[string]*unstructured.Unstructured),
+		contexts: make(map[string]*Provider),
+		errors:   make(map[errorKey]error),
+	}
+	p.AddResourceType(DefaultResourceTypes...)
+	if err := p.Add(objects...); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// AddResourceType registers resource types, such as custom resources,
+// under their kind, plural, singular and short names and resource.group.
+// Unqualified names already taken by another type stay with that type.
+func (p *Provider) AddResourceType(types ...ResourceType) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, t := range types {
+		t := t
+		if t.Singular == "" {
+			t.Singular = strings.ToLower(t.Kind)
+		}
+		p.types[t.GVR.GroupResource()] = &t
+		for _, name := range append([]string{t.Kind, t.GVR.Resource, t.Singular}, t.ShortNames...) {
+			if _, taken := p.names[name]; !taken {
+				p.names[name] = t.GVR
+			}
+		}
+		if t.GVR.Group != "" {
+			p.names[t.GVR.Resource+"."+t.GVR.Group] = t.GVR
+			p.names[t.Singular+"."+t.GVR.Group] = t.GVR
+		}
+	}
+}
+
+// Add seeds objects, replacing existing objects with the same type,
+// namespace and name. Objects are stored as given; only typed objects
+// without apiVersion and kind have them filled in from the client-go scheme.
+func (p *Provider) Add(objects ...interface{}) error {
+	for _, object := range objects {
+		resource, err := toUnstructured(object)
+		if err != nil {
+			return err
+		}
+		if resource.GetName() == "" {
+			return fmt.Errorf("cannot add %s without a name", resource.GetKind())
+		}
+		gvk := resource.GroupVersionKind()
+
+		p.mu.Lock()
+		t := p.typeForKind(gvk.GroupKind())
+		if t == nil {
+			p.mu.Unlock()
+			return fmt.Errorf("no resource type for kind %q in group %q, register it with AddResourceType", gvk.Kind, gvk.Group)
+		}
+		if !t.Namespaced {
+			resource.SetNamespace("")
+		} else if resource.GetNamespace() == "" {
+			p.mu.Unlock()
+			return fmt.Errorf("cannot add %s %q without a namespace", gvk.Kind, resource.GetName())
+		}
+		p.store(t.GVR.GroupResource(), resource)
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+func toUnstructured(object interface{}) (*unstructured.Unstructured, error) {
+	switch o := object.(type) {
+	case *unstructured.Unstructured:
+		return o.DeepCopy(), nil
+	case map[string]interface{}:
+		return (&unstructured.Unstructured{Object: o}).DeepCopy(), nil
+	case runtime.Object:
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert %T: %v", o, err)
+		}
+		resource := &unstructured.Unstructured{Object: content}
+		if resource.GetKind() == "" {
+			gvks, _, err := clientgoscheme.Scheme.ObjectKinds(o)
+			if err != nil {
+				return nil, fmt.Errorf("cannot determine the kind of %T: %v", o, err)
+			}
+			resource.SetGroupVersionKind(gvks[0])
+		}
+		return resource, nil
+	}
+	return nil, fmt.Errorf("unsupported object type %T", object)
+}
+
+// typeForKind returns the registered type of gk. Callers hold p.mu.
+func (p *Provider) typeForKind(gk schema.GroupKind) *ResourceType {
+	for _, t := range p.types {
+		if t.GVR.Group == gk.Group && t.Kind == gk.Kind {
+			return t
+		}
+	}
+	return nil
+}
+
+// store saves resource under its namespace and name. Callers hold p.mu.
+func (p *Provider) store(gr schema.GroupResource, resource *unstructured.Unstructured) {
+	if p.objects[gr] == nil {
+		p.objects[gr] = make(map[string]*unstructured.Unstructured)
+	}
+	p.objects[gr][objectKey(resource.GetNamespace(), resource.GetName())] = resource
+}
+
+func objectKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// nextResourceVersion returns a new resourceVersion. Callers hold p.mu.
+func (p *Provider) nextResourceVersion() string {
+	p.resourceVersion++
+	return strconv.FormatInt(p.resourceVersion, 10)
+}
+
+// Get returns a copy of the stored object without recording a call, for
+// assertions on the provider's state.
+func (p *Provider) Get(kind, namespace, name string) (map[string]interface{}, error) {
+	t, err := p.resolve(kind)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	resource, err := p.lookup(t, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return resource.DeepCopy().UnstructuredContent(), nil
+}
+
+func (p *Provider) resolve(kind string) (*ResourceType, error) {
+	gvr, err := p.FindGVR(kind)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.types[gvr.GroupResource()], nil
+}
+
+// lookup finds an object, ignoring namespace for cluster-scoped types.
+// Callers hold p.mu.
+func (p *Provider) lookup(t *ResourceType, namespace, name string) (*unstructured.Unstructured, error) {
+	if !t.Namespaced {
+		namespace = ""
+	}
+	resource, ok := p.objects[t.GVR.GroupResource()][objectKey(namespace, name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(t.GVR.GroupResource(), name)
+	}
+	return resource, nil
+}
+
+// SetError makes every call of verb for kind fail with err until it is
+// set to nil. An empty kind matches every kind.
+func (p *Provider) SetError(verb, kind string, err error) {
+	var gr schema.GroupResource
+	if kind != "" {
+		gvr, resolveErr := p.FindGVR(kind)
+		if resolveErr != nil {
+			panic(resolveErr)
+		}
+		gr = gvr.GroupResource()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := errorKey{verb: verb, resource: gr}
+	if err == nil {
+		delete(p.errors, key)
+		return
+	}
+	p.errors[key] = err
+}
+
+// injectedError returns the error set for verb on gr. Callers hold p.mu.
+func (p *Provider) injectedError(verb string, gr schema.GroupResource) error {
+	if err, ok := p.errors[errorKey{verb: verb, resource: gr}]; ok {
+		return err
+	}
+	return p.errors[errorKey{verb: verb}]
+}
+
+// SetResourceSpecs sets what GetOpenAPIResourceSpecs returns: the field
+// paths of each schema, keyed like io.k8s.api.core.v1.Pod.
+func (p *Provider) SetResourceSpecs(specs map[string][]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.specs = specs
+}
+
+// AddContext makes CreateProviderForContext return other for name, so
+// queries using IN can be tested.
+func (p *Provider) AddContext(name string, other *Provider) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.contexts[name] = other
+}
+
+func (p *Provider) GetK8sResources(kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	return p.GetK8sResourcesWithOptions(context.Background(), kind, fieldSelector, labelSelector, namespace, provider.ListOptions{})
+}
+
+// GetK8sResourcesWithOptions lists like GetK8sResources and returns at most
+// opts.Limit resources when it is set. With opts.MetadataOnly, resources are
+// returned with only their apiVersion, kind and metadata, like the API
+// server's metadata-only lists.
+func (p *Provider) GetK8sResourcesWithOptions(ctx context.Context, kind, fieldSelector, labelSelector, namespace string, opts provider.ListOptions) (interface{}, error) {
+	p.record(Call{Verb: VerbList, Kind: kind, Namespace: namespace, FieldSelector: fieldSelector, LabelSelector: labelSelector, Limit: opts.Limit, MetadataOnly: opts.MetadataOnly})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	labelSel, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %v", labelSelector, err)
+	}
+	fieldSel, err := fields.ParseSelector(fieldSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid field selector %q: %v", fieldSelector, err)
+	}
+	t, err := p.resolve(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.injectedError(VerbList, t.GVR.GroupResource()); err != nil {
+		return nil, err
+	}
+
+	var resources []*unstructured.Unstructured
+	for _, resource := range p.objects[t.GVR.GroupResource()] {
+		if t.Namespaced && namespace != "" && resource.GetNamespace() != namespace {
+			continue
+		}
+		if !labelSel.Matches(labels.Set(resource.GetLabels())) {
+			continue
+		}
+		if !fieldSel.Empty() && !fieldSel.Matches(provider.FieldSet(resource, fieldSel)) {
+			continue
+		}
+		resources = append(resources, resource)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].GetNamespace() != resources[j].GetNamespace() {
+			return resources[i].GetNamespace() < resources[j].GetNamespace()
+		}
+		return resources[i].GetName() < resources[j].GetName()
+	})
+	if opts.Limit > 0 && int64(len(resources)) > opts.Limit {
+		resources = resources[:opts.Limit]
+	}
+
+	converted := make([]map[string]interface{}, 0, len(resources))
+	for _, resource := range resources {
+		content := resource.DeepCopy().UnstructuredContent()
+		if opts.MetadataOnly {
+			content = map[string]interface{}{
+				"apiVersion": content["apiVersion"],
+				"kind":       content["kind"],
+				"metadata":   content["metadata"],
+			}
+		}
+		converted = append(converted, content)
+	}
+	return converted, nil
+}
+
+func (p *Provider) DeleteK8sResources(kind, name, namespace string, dryRun bool) error {
+	p.record(Call{Verb: VerbDelete, Kind: kind, Namespace: namespace, Name: name, DryRun: dryRun})
+	t, err := p.resolve(kind)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.injectedError(VerbDelete, t.GVR.GroupResource()); err != nil {
+		return err
+	}
+	resource, err := p.lookup(t, namespace, name)
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		delete(p.objects[t.GVR.GroupResource()], objectKey(resource.GetNamespace(), name))
+	}
+	return nil
+}
+
+func (p *Provider) CreateK8sResource(kind, name, namespace string, body interface{}, dryRun bool) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("invalid %s body: %v", kind, err)
+	}
+	content := map[string]interface{}{}
+	if err := json.Unmarshal(data, &content); err != nil {
+		return fmt.Errorf("invalid %s body: %v", kind, err)
+	}
+	if content == nil {
+		content = map[string]interface{}{}
+	}
+	p.record(Call{Verb: VerbCreate, Kind: kind, Namespace: namespace, Name: name, Body: content, DryRun: dryRun})
+
+	t, err := p.resolve(kind)
+	if err != nil {
+		return err
+	}
+	resource := (&unstructured.Unstructured{Object: content}).DeepCopy()
+	resource.SetAPIVersion(t.GVR.GroupVersion().String())
+	resource.SetKind(t.Kind)
+	resource.SetName(name)
+	if t.Namespaced {
+		if namespace == "" {
+			return apierrors.NewBadRequest(fmt.Sprintf("%s %q requires a namespace", t.Kind, name))
+		}
+		resource.SetNamespace(namespace)
+	} else {
+		resource.SetNamespace("")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.injectedError(VerbCreate, t.GVR.GroupResource()); err != nil {
+		return err
+	}
+	if _, err := p.lookup(t, namespace, name); err == nil {
+		return apierrors.NewAlreadyExists(t.GVR.GroupResource(), name)
+	}
+	if dryRun {
+		return nil
+	}
+	resource.SetUID(uuid.NewUUID())
+	resource.SetResourceVersion(p.nextResourceVersion())
+	resource.SetCreationTimestamp(metav1.NewTime(time.Now().UTC().Truncate(time.Second)))
+	p.store(t.GVR.GroupResource(), resource)
+	return nil
+}
+
+func (p *Provider) PatchK8sResource(kind, name, namespace string, patchJSON []byte, dryRun bool) error {
+	p.record(Call{Verb: VerbPatch, Kind: kind, Namespace: namespace, Name: name, Patch: append([]byte(nil), patchJSON...), DryRun: dryRun})
+	t, err := p.resolve(kind)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.injectedError(VerbPatch, t.GVR.GroupResource()); err != nil {
+		return err
+	}
+	resource, err := p.lookup(t, namespace, name)
+	if err != nil {
+		return err
+	}
+	if err := checkPrecondition(t.GVR.GroupResource(), resource, patchJSON); err != nil {
+		return err
+	}
+	patched, err := applyPatch(resource.UnstructuredContent(), patchJSON)
+	if err != nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("cannot patch %s %q: %v", t.Kind, name, err))
+	}
+	if dryRun {
+		return nil
+	}
+	updated := &unstructured.Unstructured{Object: patched}
+	updated.SetResourceVersion(p.nextResourceVersion())
+	p.store(t.GVR.GroupResource(), updated)
+	return nil
+}
+
+func (p *Provider) FindGVR(kind string) (schema.GroupVersionResource, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return provider.ResolveGVR(p.names, kind)
+}
+
+func (p *Provider) GetOpenAPIResourceSpecs() (map[string][]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	specs := make(map[string][]string, len(p.specs))
+	for name, fields := range p.specs {
+		specs[name] = fields
+	}
+	return specs, nil
+}
+
+func (p *Provider) CreateProviderForContext(context string) (provider.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	other, ok := p.contexts[context]
+	if !ok {
+		return nil, fmt.Errorf("context %q not found, add it with AddContext", context)
+	}
+	return other, nil
+}
+
+// GetGVRCacheSnapshot returns the names resource types resolve by.
+func (p *Provider) GetGVRCacheSnapshot() map[string]schema.GroupVersionResource {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make(map[string]schema.GroupVersionResource, len(p.names))
+	for name, gvr := range p.names {
+		names[name] = gvr
+	}
+	return names
+}
+
+// GetKnownResourceKinds returns the plural names of the registered types.
+func (p *Provider) GetKnownResourceKinds() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var kinds []string
+	for _, t := range p.types {
+		kinds = append(kinds, t.GVR.Resource)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
@@ -0,0 +1,515 @@
+package fake
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/avitaltamir/cyphernetes/pkg/core"
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+func testPod(name, namespace, app, phase string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": app}},
+		Status:     corev1.PodStatus{Phase: corev1.PodPhase(phase)},
+	}
+}
+
+func newTestProvider() *Provider {
+	replicas := int32(2)
+	return NewProvider(
+		testPod("web-1", "default", "web", "Running"),
+		testPod("web-2", "default", "web", "Pending"),
+		testPod("db-1", "data", "db", "Running"),
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			},
+		},
+		map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+			"spec":       map[string]interface{}{"selector": map[string]interface{}{"app": "web"}},
+		},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+	)
+}
+
+func names(t *testing.T, p provider.Provider, kind, fieldSelector, labelSelector, namespace string) string {
+	t.Helper()
+	result, err := p.GetK8sResources(kind, fieldSelector, labelSelector, namespace)
+	if err != nil {
+		t.Fatalf("GetK8sResources(%s) error = %v", kind, err)
+	}
+	var out []string
+	for _, resource := range result.([]map[string]interface{}) {
+		metadata := resource["metadata"].(map[string]interface{})
+		name := metadata["name"].(string)
+		if namespace, ok := metadata["namespace"].(string); ok {
+			name = namespace + "/" + name
+		}
+		out = append(out, name)
+	}
+	return fmt.Sprint(out)
+}
+
+func TestGetK8sResourcesEvaluatesSelectors(t *testing.T) {
+	p := newTestProvider()
+
+	tests := []struct {
+		kind, fieldSelector, labelSelector, namespace string
+		want                                          string
+	}{
+		{"Pod", "", "", "", "[data/db-1 default/web-1 default/web-2]"},
+		{"po", "", "", "default", "[default/web-1 default/web-2]"},
+		{"pods", "status.phase=Running", "", "", "[data/db-1 default/web-1]"},
+		{"pods", "metadata.name!=web-1", "app in (web)", "", "[default/web-2]"},
+		{"pods", "", "app notin (web)", "", "[data/db-1]"},
+		{"deploy", "", "", "default", "[default/web]"},
+		{"deployments.apps", "metadata.namespace=data", "", "", "[]"},
+		{"Node", "", "", "default", "[node-1]"},
+		{"svc", "", "", "data", "[]"},
+	}
+	for _, tt := range tests {
+		if got := names(t, p, tt.kind, tt.fieldSelector, tt.labelSelector, tt.namespace); got != tt.want {
+			t.Errorf("%s fields %q labels %q namespace %q: got %s, want %s", tt.kind, tt.fieldSelector, tt.labelSelector, tt.namespace, got, tt.want)
+		}
+	}
+
+	if _, err := p.GetK8sResources("pods", "", "app in (", ""); err == nil {
+		t.Errorf("expected an invalid label selector to fail")
+	}
+	if _, err := p.GetK8sResources("gadgets", "", "", ""); err == nil {
+		t.Errorf("expected an unknown kind to fail")
+	}
+
+	limited, err := p.GetK8sResourcesWithOptions(t.Context(), "pods", "", "", "", provider.ListOptions{Limit: 2})
+	if err != nil || len(limited.([]map[string]interface{})) != 2 {
+		t.Errorf("expected the limit to be honored, got %v, %v", limited, err)
+	}
+}
+
+func TestTypedSeedsAreConverted(t *testing.T) {
+	p := newTestProvider()
+	deployment, err := p.Get("Deployment", "default", "web")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if deployment["apiVersion"] != "apps/v1" || deployment["kind"] != "Deployment" {
+		t.Errorf("expected apiVersion and kind from the scheme, got %v %v", deployment["apiVersion"], deployment["kind"])
+	}
+	if replicas := deployment["spec"].(map[string]interface{})["replicas"]; replicas != int64(2) {
+		t.Errorf("expected replicas as int64 2, got %#v", replicas)
+	}
+
+	if err := p.Add(map[string]interface{}{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": map[string]interface{}{"name": "w", "namespace": "default"}}); err == nil {
+		t.Errorf("expected an unregistered kind to be rejected")
+	}
+	p.AddResourceType(ResourceType{GVR: schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}, Kind: "Widget", ShortNames: []string{"wd"}, Namespaced: true})
+	if err := p.Add(map[string]interface{}{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": map[string]interface{}{"name": "w", "namespace": "default"}}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if got := names(t, p, "wd", "", "", ""); got != "[default/w]" {
+		t.Errorf("expected the custom resource by short name, got %s", got)
+	}
+	if gvr := p.GetGVRCacheSnapshot()["widgets.example.com"]; gvr.Resource != "widgets" {
+		t.Errorf("expected discovery to include the custom resource, got %v", gvr)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	p := newTestProvider()
+
+	// A label is set on a pod without annotations the way SET does it: a
+	// test for the parent map followed by an add.
+	patch := `[{"op":"test","path":"/metadata/annotations","value":{}},{"op":"add","path":"/metadata/annotations/team","value":"web"},` +
+		`{"op":"replace","path":"/status/phase","value":"Failed"}]`
+	if err := p.PatchK8sResource("Pod", "web-1", "default", []byte(patch), false); err != nil {
+		t.Fatalf("PatchK8sResource() error = %v", err)
+	}
+	pod, _ := p.Get("Pod", "default", "web-1")
+	metadata := pod["metadata"].(map[string]interface{})
+	if metadata["annotations"].(map[string]interface{})["team"] != "web" {
+		t.Errorf("expected the annotation to be added, got %v", metadata)
+	}
+	if metadata["labels"].(map[string]interface{})["app"] != "web" {
+		t.Errorf("expected the other fields to be kept, got %v", metadata)
+	}
+	if pod["status"].(map[string]interface{})["phase"] != "Failed" || metadata["resourceVersion"] == nil {
+		t.Errorf("expected the phase to be replaced and the resourceVersion set, got %v", pod)
+	}
+
+	// Plain operations are applied strictly.
+	for _, patch := range []string{
+		`[{"op":"add","path":"/spec/missing/key","value":1}]`,
+		`[{"op":"test","path":"/status/phase","value":"Running"}]`,
+		`not json`,
+	} {
+		err := p.PatchK8sResource("Pod", "web-1", "default", []byte(patch), false)
+		if err == nil {
+			t.Errorf("expected patch %s to fail", patch)
+		}
+	}
+	if err := p.PatchK8sResource("Pod", "web-9", "default", []byte(`[]`), false); !apierrors.IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestDryRunDoesNotPersist(t *testing.T) {
+	p := newTestProvider()
+
+	if err := p.PatchK8sResource("Pod", "web-1", "default", []byte(`[{"op":"replace","path":"/status/phase","value":"Failed"}]`), true); err != nil {
+		t.Fatalf("dry-run patch error = %v", err)
+	}
+	if err := p.DeleteK8sResources("Pod", "web-2", "default", true); err != nil {
+		t.Fatalf("dry-run delete error = %v", err)
+	}
+	if err := p.CreateK8sResource("ConfigMap", "settings", "default", map[string]interface{}{"data": map[string]interface{}{"a": "b"}}, true); err != nil {
+		t.Fatalf("dry-run create error = %v", err)
+	}
+	if got := names(t, p, "pods", "status.phase=Running", "", "default"); got != "[default/web-1]" {
+		t.Errorf("expected dry-run changes to be discarded, got %s", got)
+	}
+	if _, err := p.Get("ConfigMap", "default", "settings"); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the dry-run create to be discarded, got %v", err)
+	}
+
+	// Dry-run calls still fail the way real ones would.
+	if err := p.DeleteK8sResources("Pod", "web-9", "default", true); !apierrors.IsNotFound(err) {
+		t.Errorf("expected a dry-run delete of a missing pod to fail, got %v", err)
+	}
+	if err := p.CreateK8sResource("Service", "web", "default", map[string]interface{}{}, true); !apierrors.IsAlreadyExists(err) {
+		t.Errorf("expected a dry-run create of an existing service to fail, got %v", err)
+	}
+}
+
+func TestCreateAndDelete(t *testing.T) {
+	p := newTestProvider()
+
+	if err := p.CreateK8sResource("cm", "settings", "default", map[string]interface{}{"metadata": map[string]interface{}{"labels": map[string]interface{}{"app": "web"}}}, false); err != nil {
+		t.Fatalf("CreateK8sResource() error = %v", err)
+	}
+	created, err := p.Get("ConfigMap", "default", "settings")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	metadata := created["metadata"].(map[string]interface{})
+	if created["apiVersion"] != "v1" || metadata["uid"] == nil || metadata["creationTimestamp"] == nil {
+		t.Errorf("expected server-set fields on the created object, got %v", created)
+	}
+	if err := p.CreateK8sResource("ConfigMap", "other", "", map[string]interface{}{}, false); err == nil {
+		t.Errorf("expected a namespaced create without a namespace to fail")
+	}
+	if err := p.CreateK8sResource("Namespace", "staging", "default", map[string]interface{}{}, false); err != nil {
+		t.Fatalf("CreateK8sResource() error = %v", err)
+	}
+	if got := names(t, p, "ns", "", "", ""); got != "[staging]" {
+		t.Errorf("expected a cluster-scoped namespace, got %s", got)
+	}
+
+	if err := p.DeleteK8sResources("Pod", "web-1", "default", false); err != nil {
+		t.Fatalf("DeleteK8sResources() error = %v", err)
+	}
+	if got := names(t, p, "pods", "", "", "default"); got != "[default/web-2]" {
+		t.Errorf("expected web-1 to be deleted, got %s", got)
+	}
+}
+
+// recordingT captures assertion failures.
+type recordingT struct {
+	errors []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestCallsAndAssertions(t *testing.T) {
+	p := newTestProvider()
+	_, _ = p.GetK8sResources("po", "", "app=web", "default")
+	_ = p.DeleteK8sResources("pods", "web-1", "default", true)
+
+	calls := p.Calls()
+	if len(calls) != 2 || calls[0].LabelSelector != "app=web" || !calls[1].DryRun {
+		t.Fatalf("unexpected calls %+v", calls)
+	}
+	if got := calls[1].String(); got != "delete pods -n default web-1 --dry-run" {
+		t.Errorf("unexpected call description %q", got)
+	}
+
+	rt := &recordingT{}
+	if !p.AssertCalled(rt, VerbDelete, "Pod", "default", "web-1") || !p.AssertCalled(rt, VerbList, "pods", "", "") {
+		t.Errorf("expected the calls to match by any kind name, got %v", rt.errors)
+	}
+	if p.AssertCalled(rt, VerbPatch, "Pod", "", "") || len(rt.errors) != 1 || !strings.Contains(rt.errors[0], "delete pods") {
+		t.Errorf("expected a failure listing the calls made, got %v", rt.errors)
+	}
+	if p.AssertNotCalled(rt, VerbDelete, "po", "", "web-1") || len(rt.errors) != 2 {
+		t.Errorf("expected AssertNotCalled to fail, got %v", rt.errors)
+	}
+
+	p.ResetCalls()
+	_, _ = p.Get("Pod", "default", "web-2")
+	if len(p.Calls()) != 0 {
+		t.Errorf("expected no calls after ResetCalls and Get, got %v", p.Calls())
+	}
+}
+
+func TestSetError(t *testing.T) {
+	p := newTestProvider()
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", errors.New("no access"))
+	p.SetError(VerbList, "Pod", forbidden)
+
+	if _, err := p.GetK8sResources("pods", "", "", ""); !apierrors.IsForbidden(err) {
+		t.Errorf("expected the injected error, got %v", err)
+	}
+	if _, err := p.GetK8sResources("services", "", "", ""); err != nil {
+		t.Errorf("expected other kinds to be unaffected, got %v", err)
+	}
+
+	p.SetError(VerbList, "Pod", nil)
+	if _, err := p.GetK8sResources("pods", "", "", ""); err != nil {
+		t.Errorf("expected the error to be cleared, got %v", err)
+	}
+
+	p.SetError(VerbDelete, "", errors.New("read-only"))
+	if err := p.DeleteK8sResources("Service", "web", "default", false); err == nil || err.Error() != "read-only" {
+		t.Errorf("expected every delete to fail, got %v", err)
+	}
+}
+
+func TestQueriesRunAgainstFake(t *testing.T) {
+	p := newTestProvider()
+	executor, err := core.NewQueryExecutor(p)
+	if err != nil {
+		t.Fatalf("NewQueryExecutor() error = %v", err)
+	}
+	run := func(query string, opts ...core.ExecuteOption) core.QueryResult {
+		t.Helper()
+		ast, err := core.ParseQuery(query)
+		if err != nil {
+			t.Fatalf("ParseQuery(%s) error = %v", query, err)
+		}
+		result, err := executor.Execute(ast, "default", opts...)
+		if err != nil {
+			t.Fatalf("Execute(%s) error = %v", query, err)
+		}
+		return result
+	}
+
+	result := run(`MATCH (s:Service)->(p:Pod) RETURN p.metadata.name`)
+	if pods, ok := result.Data["p"].([]interface{}); !ok || len(pods) != 2 {
+		t.Errorf("expected the service's two pods, got %#v", result.Data)
+	}
+
+	run(`MATCH (p:Pod {name: "web-2"}) SET p.metadata.labels.tier = "frontend"`)
+	p.AssertCalled(t, VerbPatch, "pods", "default", "web-2")
+	if got := names(t, p, "pods", "", "tier=frontend", ""); got != "[default/web-2]" {
+		t.Errorf("expected the label to be set, got %s", got)
+	}
+
+	run(`MATCH (p:Pod {name: "web-1"}) DELETE p`, core.WithDryRun(true))
+	if _, err := p.Get("pods", "default", "web-1"); err != nil {
+		t.Errorf("expected the dry-run delete to keep web-1, got %v", err)
+	}
+	run(`MATCH (p:Pod {name: "web-1"}) DELETE p`)
+	p.AssertCalled(t, VerbDelete, "Pod", "default", "web-1")
+	if _, err := p.Get("pods", "default", "web-1"); !apierrors.IsNotFound(err) {
+		t.Errorf("expected web-1 to be deleted, got %v", err)
+	}
+}
+
+func TestMetadataOnlyQueriesAgainstFake(t *testing.T) {
+	pod := testPod("web-1", "default", "web", "Running")
+	pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-abc", UID: "web-abc-uid"}}
+	pod.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationApply}}
+	p := NewProvider(pod, &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "web-abc", Namespace: "default", UID: "web-abc-uid"}})
+	executor, err := core.NewQueryExecutor(p)
+	if err != nil {
+		t.Fatalf("NewQueryExecutor() error = %v", err)
+	}
+	run := func(query string) core.QueryResult {
+		t.Helper()
+		ast, err := core.ParseQuery(query)
+		if err != nil {
+			t.Fatalf("ParseQuery(%s) error = %v", query, err)
+		}
+		result, err := executor.Execute(ast, "default")
+		if err != nil {
+			t.Fatalf("Execute(%s) error = %v", query, err)
+		}
+		return result
+	}
+
+	result := run(`MATCH (rs:ReplicaSet)->(p:Pod) RETURN p.metadata`)
+	calls := p.Calls(VerbList)
+	if len(calls) != 2 {
+		t.Errorf("expected the replicasets and pods to be listed, got %+v", calls)
+	}
+	for _, call := range calls {
+		if !call.MetadataOnly {
+			t.Errorf("expected a metadata-only list, got %+v", call)
+		}
+	}
+	got := fmt.Sprint(result.Data["p"])
+	if !strings.Contains(got, "name:web-1") || strings.Contains(got, "managedFields") {
+		t.Errorf("expected web-1's metadata without managedFields, got %s", got)
+	}
+
+	p.ResetCalls()
+	result = run(`MATCH (p:Pod) RETURN p.status.phase, p.metadata.managedFields`)
+	if calls = p.Calls(VerbList); len(calls) != 1 || calls[0].MetadataOnly {
+		t.Errorf("expected a full list, got %+v", calls)
+	}
+	if got := fmt.Sprint(result.Data["p"]); !strings.Contains(got, "phase:Running") || !strings.Contains(got, "manager:kubectl") {
+		t.Errorf("expected the phase and managedFields, got %s", got)
+	}
+}
+
+func TestSetByKeySelectorAgainstFake(t *testing.T) {
+	p := NewProvider(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app", Image: "app:1"},
+			{Name: "nginx", Image: "nginx:1", Env: []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}}},
+		}}}},
+	})
+	executor, err := core.NewQueryExecutor(p)
+	if err != nil {
+		t.Fatalf("NewQueryExecutor() error = %v", err)
+	}
+	ast, err := core.ParseQuery(`MATCH (d:Deployment {name: "web"}) SET d.spec.template.spec.containers[name="nginx"].image = "nginx:2", ` +
+		`d.spec.template.spec.containers[name="nginx"].env[name="LOG_LEVEL"].value = "debug", ` +
+		`d.spec.template.spec.containers[name="app"].env[name="MODE"]+.value = "fast"`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	if _, err := executor.Execute(ast, "default"); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	deployment, _ := p.Get("Deployment", "default", "web")
+	got := fmt.Sprint(deployment["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"])
+	want := "[map[env:[map[name:MODE value:fast]] image:app:1 name:app resources:map[]] map[env:[map[name:LOG_LEVEL value:debug]] image:nginx:2 name:nginx resources:map[]]]"
+	if got != want {
+		t.Errorf("containers = %s, want %s", got, want)
+	}
+
+	// The guards stop a patch whose list item moved since it was read.
+	guarded := `[{"op":"test","path":"/spec/template/spec/containers/0/name","value":"nginx"},{"op":"add","path":"/spec/template/spec/containers/0/image","value":"x"}]`
+	if err := p.PatchK8sResource("Deployment", "web", "default", []byte(guarded), false); err == nil {
+		t.Errorf("expected a patch with a failing guard to fail")
+	}
+}
+
+// racingProvider changes the replicas of the object it patches before the
+// first races patches, like another client writing between a query's MATCH
+// and SET.
+type racingProvider struct {
+	*Provider
+	races    int
+	replicas int
+}
+
+func (p *racingProvider) PatchK8sResource(kind, name, namespace string, patchJSON []byte, dryRun bool) error {
+	if p.races > 0 {
+		p.races--
+		p.replicas++
+		race := fmt.Sprintf(`[{"op":"replace","path":"/spec/replicas","value":%d}]`, p.replicas)
+		if err := p.Provider.PatchK8sResource(kind, name, namespace, []byte(race), false); err != nil {
+			return err
+		}
+	}
+	return p.Provider.PatchK8sResource(kind, name, namespace, patchJSON, dryRun)
+}
+
+func TestOptimisticConcurrency(t *testing.T) {
+	newRacingProvider := func(races int) *racingProvider {
+		replicas := int32(2)
+		return &racingProvider{Provider: NewProvider(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", ResourceVersion: "100"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		}), races: races, replicas: 2}
+	}
+	run := func(p provider.Provider, query string, opts ...core.ExecuteOption) error {
+		executor, err := core.NewQueryExecutor(p)
+		if err != nil {
+			t.Fatalf("NewQueryExecutor() error = %v", err)
+		}
+		ast, err := core.ParseQuery(query)
+		if err != nil {
+			t.Fatalf("ParseQuery() error = %v", err)
+		}
+		_, err = executor.Execute(ast, "default", opts...)
+		return err
+	}
+	deployment := func(p *racingProvider) (interface{}, interface{}) {
+		d, _ := p.Get("Deployment", "default", "web")
+		return d["spec"].(map[string]interface{})["paused"], d["metadata"].(map[string]interface{})["labels"]
+	}
+	const query = `MATCH (d:Deployment {name: "web"}) WHERE d.spec.replicas < 4 SET d.spec.paused = true, d.metadata.labels.tier = "web"`
+
+	// Retried against the changed deployment, which still matches.
+	p := newRacingProvider(1)
+	if err := run(p, query, core.WithOptimisticConcurrency(3)); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if paused, labels := deployment(p); paused != true || fmt.Sprint(labels) != "map[tier:web]" {
+		t.Errorf("paused = %v, labels = %v, want the SET applied", paused, labels)
+	}
+	if patches := p.Calls(VerbPatch); len(patches) != 3 {
+		t.Errorf("expected the race and two attempts, got %d patches", len(patches))
+	}
+
+	// Skipped once it no longer matches the WHERE clause.
+	p = newRacingProvider(2)
+	if err := run(p, query, core.WithOptimisticConcurrency(3)); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if paused, _ := deployment(p); paused != nil {
+		t.Errorf("paused = %v, want a deployment with 4 replicas left alone", paused)
+	}
+
+	// Reported when it keeps changing.
+	p = newRacingProvider(2)
+	err := run(p, `MATCH (d:Deployment {name: "web"}) SET d.spec.paused = true`, core.WithOptimisticConcurrency(1))
+	var conflictErr *core.ConflictError
+	if !errors.As(err, &conflictErr) || !errors.Is(err, provider.ErrConflict) {
+		t.Fatalf("Execute() error = %v, want a conflict report", err)
+	}
+	if want := "1 resource(s) kept changing and were not patched after 2 attempt(s):\n  deployment/web in namespace default (last read at resourceVersion 1)"; conflictErr.Error() != want {
+		t.Errorf("conflict report = %q, want %q", conflictErr.Error(), want)
+	}
+
+	// Wildcard updates can't be made conditional.
+	p = newRacingProvider(0)
+	err = run(p, `MATCH (d:Deployment {name: "web"}) SET d.spec.template.spec.containers[*].image = "app:2"`, core.WithOptimisticConcurrency(1))
+	if err == nil || !strings.Contains(err.Error(), "optimistic concurrency") {
+		t.Errorf("Execute() error = %v, want wildcards rejected", err)
+	}
+	if patches := p.Calls(VerbPatch); len(patches) != 0 {
+		t.Errorf("expected no patches, got %v", patches)
+	}
+
+	// Without optimistic concurrency the patch is unconditional.
+	p = newRacingProvider(1)
+	if err := run(p, query); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if paused, _ := deployment(p); paused != true {
+		t.Errorf("paused = %v, want the SET applied", paused)
+	}
+}
@@ -0,0 +1,25 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// FieldSet collects the fields a selector refers to from an unstructured
+// resource, for providers that evaluate field selectors themselves. Missing
+// fields are empty, as they are for the API server.
+func FieldSet(resource *unstructured.Unstructured, selector fields.Selector) fields.Set {
+	set := fields.Set{}
+	for _, requirement := range selector.Requirements() {
+		value, found, err := unstructured.NestedFieldNoCopy(resource.Object, strings.Split(requirement.Field, ".")...)
+		if err != nil || !found || value == nil {
+			set[requirement.Field] = ""
+			continue
+		}
+		set[requirement.Field] = fmt.Sprint(value)
+	}
+	return set
+}
@@ -0,0 +1,194 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ResolveGVR finds kind in a GVR cache keyed by kind, plural, singular and
+// short names plus "resource.group" for grouped resources. Names shared by
+// several resources resolve to an "ambiguous" error listing the qualified
+// names to use instead; a "core." prefix selects the core group.
+//
+// A "/version" suffix, as in "HorizontalPodAutoscaler.autoscaling/v2", pins
+// the API version. Only the versions in the cache are known to be served;
+// see ResolveServedGVR.
+func ResolveGVR(cache map[string]schema.GroupVersionResource, kind string) (schema.GroupVersionResource, error) {
+	return ResolveServedGVR(cache, nil, kind)
+}
+
+// ServedVersions returns the versions of group that serve resource.
+type ServedVersions func(group, resource string) []string
+
+// ResolveServedGVR is ResolveGVR for providers that know every version a
+// resource is served at, not only the preferred ones they cache.
+func ResolveServedGVR(cache map[string]schema.GroupVersionResource, served ServedVersions, kind string) (schema.GroupVersionResource, error) {
+	if kind == "" {
+		return schema.GroupVersionResource{}, fmt.Errorf("invalid resource kind: kind cannot be empty")
+	}
+
+	name, version, pinned := splitKindVersion(kind)
+	if !pinned {
+		matches, err := matchGVRs(cache, kind)
+		if err != nil {
+			return schema.GroupVersionResource{}, err
+		}
+		if len(matches) > 1 {
+			return schema.GroupVersionResource{}, ambiguousKindError(kind, matches, "")
+		}
+		return matches[0], nil
+	}
+
+	if name == "" || version == "" {
+		return schema.GroupVersionResource{}, fmt.Errorf("invalid resource kind %q: expected kind/version", kind)
+	}
+	matches, err := matchGVRs(cache, name)
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	var serving []schema.GroupVersionResource
+	for _, gvr := range matches {
+		if servesVersion(served, gvr, version) {
+			gvr.Version = version
+			serving = append(serving, gvr)
+		}
+	}
+	switch len(serving) {
+	case 0:
+		unique := make(map[string]bool)
+		for _, gvr := range matches {
+			for _, v := range servedVersions(served, gvr) {
+				unique[v] = true
+			}
+		}
+		versions := make([]string, 0, len(unique))
+		for v := range unique {
+			versions = append(versions, v)
+		}
+		sort.Strings(versions)
+		return schema.GroupVersionResource{}, fmt.Errorf("resource %q is not served at version %q, available versions: %s",
+			name, version, strings.Join(versions, ", "))
+	case 1:
+		return serving[0], nil
+	default:
+		return schema.GroupVersionResource{}, ambiguousKindError(kind, serving, "/"+version)
+	}
+}
+
+// splitKindVersion splits the "/version" suffix off kind.
+func splitKindVersion(kind string) (name, version string, pinned bool) {
+	i := strings.LastIndex(kind, "/")
+	if i < 0 {
+		return kind, "", false
+	}
+	return kind[:i], kind[i+1:], true
+}
+
+// servedVersions returns the versions served reports for gvr's resource, or
+// gvr's own version when it reports none.
+func servedVersions(served ServedVersions, gvr schema.GroupVersionResource) []string {
+	if served != nil {
+		if versions := served(gvr.Group, gvr.Resource); len(versions) > 0 {
+			return versions
+		}
+	}
+	return []string{gvr.Version}
+}
+
+func servesVersion(served ServedVersions, gvr schema.GroupVersionResource, version string) bool {
+	for _, v := range servedVersions(served, gvr) {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// ambiguousKindError lists the qualified names of matches, each followed by
+// suffix.
+func ambiguousKindError(kind string, matches []schema.GroupVersionResource, suffix string) error {
+	options := make([]string, 0, len(matches))
+	for _, gvr := range matches {
+		if gvr.Group == "" {
+			options = append(options, "core."+gvr.Resource+suffix)
+		} else {
+			options = append(options, gvr.Resource+"."+gvr.Group+suffix)
+		}
+	}
+	sort.Strings(options)
+	return fmt.Errorf("ambiguous resource kind %q found. Please specify one of:\n%s",
+		kind, strings.Join(options, "\n"))
+}
+
+// matchGVRs returns every resource in cache that kind may name, deduplicated
+// by group and resource, or a "not found" error when there is none.
+func matchGVRs(cache map[string]schema.GroupVersionResource, kind string) ([]schema.GroupVersionResource, error) {
+	// Special handling for core.* prefix
+	if strings.HasPrefix(strings.ToLower(kind), "core.") {
+		resourceName := kind[len("core."):]
+
+		// Look for exact match in core group
+		for k, gvr := range cache {
+			if gvr.Group == "" && // core group has empty string as group
+				(strings.EqualFold(k, resourceName) ||
+					strings.EqualFold(gvr.Resource, resourceName) ||
+					strings.EqualFold(strings.TrimSuffix(gvr.Resource, "s"), resourceName)) {
+				return []schema.GroupVersionResource{gvr}, nil
+			}
+		}
+		return nil, fmt.Errorf("resource %q not found in core group", resourceName)
+	}
+
+	// If kind contains dots (but not starting with core.), treat it as a fully qualified name
+	if strings.Contains(kind, ".") {
+		if gvr, ok := cache[kind]; ok {
+			return []schema.GroupVersionResource{gvr}, nil
+		}
+		// Qualified names are cached in lower case, so "Kind.group" matches
+		// the singular name's entry.
+		for k, gvr := range cache {
+			if strings.Contains(k, ".") && strings.EqualFold(k, kind) {
+				return []schema.GroupVersionResource{gvr}, nil
+			}
+		}
+		return nil, fmt.Errorf("resource %q not found", kind)
+	}
+
+	// Use a map to deduplicate matches
+	uniqueGVRs := make(map[string]schema.GroupVersionResource)
+
+	// For non-fully-qualified names, try all the matching strategies
+	// Try exact match first
+	if gvr, ok := cache[kind]; ok {
+		uniqueGVRs[fmt.Sprintf("%s/%s", gvr.Resource, gvr.Group)] = gvr
+	}
+
+	// Try case-insensitive lookup
+	lowerKind := strings.ToLower(kind)
+	for k, gvr := range cache {
+		if strings.ToLower(k) == lowerKind || // Case-insensitive kind match
+			strings.ToLower(gvr.Resource) == lowerKind || // Plural form
+			strings.ToLower(strings.TrimSuffix(gvr.Resource, "s")) == lowerKind || // Singular form
+			strings.ToLower(strings.TrimSuffix(gvr.Resource, "es")) == lowerKind || // Singular form
+			(strings.HasSuffix(gvr.Resource, "ies") && strings.ToLower(strings.TrimSuffix(gvr.Resource, "ies")+"y") == lowerKind) { // Handle -ies to -y conversion
+			uniqueGVRs[fmt.Sprintf("%s/%s", gvr.Resource, gvr.Group)] = gvr
+		}
+	}
+
+	if len(uniqueGVRs) == 0 {
+		return nil, fmt.Errorf("resource %q not found", kind)
+	}
+	keys := make([]string, 0, len(uniqueGVRs))
+	for key := range uniqueGVRs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	matches := make([]schema.GroupVersionResource, 0, len(keys))
+	for _, key := range keys {
+		matches = append(matches, uniqueGVRs[key])
+	}
+	return matches, nil
+}
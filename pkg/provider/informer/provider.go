@@ -0,0 +1,365 @@
+package informer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// defaultSyncTimeout is used when Config.SyncTimeout is zero.
+const defaultSyncTimeout = 30 * time.Second
+
+type Config struct {
+	// ResyncPeriod is passed to each informer. Zero disables resyncs.
+	ResyncPeriod time.Duration
+	// MaxStaleness bounds how long an informer's data is trusted. An informer
+	// older than this is rebuilt from a fresh list on its next read. Zero
+	// keeps informers running until a write goes through the provider.
+	MaxStaleness time.Duration
+	// SyncTimeout bounds how long a read waits for a new informer to finish
+	// its initial list. Reads that time out are served by a live list while
+	// the informer keeps syncing in the background.
+	SyncTimeout time.Duration
+}
+
+// Provider serves GetK8sResources from informers started lazily per
+// resource type and passes every other call to a base provider. Writes made
+// through the provider mark the written resource type for a fresh list, so a
+// query always reads its own writes.
+//
+// Reads of a single namespace start informers watching only that namespace
+// when the base provider implements provider.Scope. Informers denied access
+// to their resources stop, and their reads are served by the base provider.
+// Cached resources have no managedFields.
+type Provider struct {
+	base   provider.Provider
+	client dynamic.Interface
+	config Config
+
+	mu        sync.Mutex
+	informers map[informerKey]*gvrInformer
+}
+
+// informerKey identifies an informer by the resource type and namespace it
+// watches. NamespaceAll watches every namespace.
+type informerKey struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+}
+
+type gvrInformer struct {
+	informer  cache.SharedIndexInformer
+	lister    cache.GenericLister
+	stop      chan struct{}
+	stopOnce  sync.Once
+	forbidden chan struct{}
+	denyOnce  sync.Once
+	startedAt time.Time
+	stale     bool
+}
+
+// halt stops the informer.
+func (i *gvrInformer) halt() {
+	i.stopOnce.Do(func() { close(i.stop) })
+}
+
+// deny stops the informer for good after the API server forbade its list or
+// watch, rather than letting it retry with backoff.
+func (i *gvrInformer) deny() {
+	i.denyOnce.Do(func() { close(i.forbidden) })
+	i.halt()
+}
+
+func (i *gvrInformer) denied() bool {
+	select {
+	case <-i.forbidden:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitForSync waits up to timeout for the informer's initial list, returning
+// early if it is denied.
+func (i *gvrInformer) waitForSync(ctx context.Context, timeout time.Duration) bool {
+	syncCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-syncCtx.Done():
+		case <-i.forbidden:
+		}
+	}()
+	return cache.WaitForCacheSync(done, i.informer.HasSynced)
+}
+
+// NewProvider wraps base with an informer cache fed by client.
+func NewProvider(base provider.Provider, client dynamic.Interface, config Config) (*Provider, error) {
+	if base == nil {
+		return nil, fmt.Errorf("base provider cannot be nil")
+	}
+	if client == nil {
+		return nil, fmt.Errorf("dynamic client cannot be nil")
+	}
+	if config.ResyncPeriod < 0 || config.MaxStaleness < 0 || config.SyncTimeout < 0 {
+		return nil, fmt.Errorf("informer durations must not be negative")
+	}
+	if config.SyncTimeout == 0 {
+		config.SyncTimeout = defaultSyncTimeout
+	}
+	return &Provider{
+		base:      base,
+		client:    client,
+		config:    config,
+		informers: make(map[informerKey]*gvrInformer),
+	}, nil
+}
+
+// Unwrap returns the base provider.
+func (p *Provider) Unwrap() provider.Provider {
+	return p.base
+}
+
+// Close stops every informer.
+func (p *Provider) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, informer := range p.informers {
+		informer.halt()
+		delete(p.informers, key)
+	}
+}
+
+func (p *Provider) GetK8sResources(kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	return p.GetK8sResourcesContext(context.Background(), kind, fieldSelector, labelSelector, namespace)
+}
+
+// GetK8sResourcesContext lists resources from the informer of kind, starting
+// it on first use.
+func (p *Provider) GetK8sResourcesContext(ctx context.Context, kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	labelSel, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %v", labelSelector, err)
+	}
+	fieldSel, err := fields.ParseSelector(fieldSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid field selector %q: %v", fieldSelector, err)
+	}
+
+	gvr, err := p.base.FindGVR(kind)
+	if err != nil {
+		return nil, err
+	}
+	informer := p.informerFor(gvr, p.informerNamespace(gvr, namespace))
+
+	if informer.denied() || !informer.waitForSync(ctx, p.config.SyncTimeout) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return provider.GetK8sResources(ctx, p.base, kind, fieldSelector, labelSelector, namespace)
+	}
+
+	objects, err := informer.lister.List(labelSel)
+	if err != nil {
+		return nil, err
+	}
+	var resources []*unstructured.Unstructured
+	for _, object := range objects {
+		resource, ok := object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		// Cluster-scoped resources have no namespace and match any namespace.
+		if namespace != "" && resource.GetNamespace() != "" && resource.GetNamespace() != namespace {
+			continue
+		}
+		if !fieldSel.Empty() && !fieldSel.Matches(provider.FieldSet(resource, fieldSel)) {
+			continue
+		}
+		resources = append(resources, resource)
+	}
+	// Match the API server's ordering by namespace and name.
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].GetNamespace() != resources[j].GetNamespace() {
+			return resources[i].GetNamespace() < resources[j].GetNamespace()
+		}
+		return resources[i].GetName() < resources[j].GetName()
+	})
+
+	var converted []map[string]interface{}
+	for _, resource := range resources {
+		converted = append(converted, resource.DeepCopy().UnstructuredContent())
+	}
+	return converted, nil
+}
+
+// informerNamespace returns the namespace an informer serving reads of
+// namespace from gvr watches: namespace itself if gvr is known to be
+// namespaced, every namespace otherwise.
+func (p *Provider) informerNamespace(gvr schema.GroupVersionResource, namespace string) string {
+	if namespace == "" {
+		return metav1.NamespaceAll
+	}
+	scope, ok := provider.Base(p.base).(provider.Scope)
+	if !ok {
+		return metav1.NamespaceAll
+	}
+	if namespaced, err := scope.IsNamespaced(gvr); err != nil || !namespaced {
+		return metav1.NamespaceAll
+	}
+	return namespace
+}
+
+// current reports whether informer can still serve reads: it isn't stale
+// or older than MaxStaleness.
+func (p *Provider) current(informer *gvrInformer) bool {
+	expired := p.config.MaxStaleness > 0 && time.Since(informer.startedAt) > p.config.MaxStaleness
+	return !informer.stale && !expired
+}
+
+// informerFor returns the running informer of gvr watching namespace,
+// replacing it when it is stale or older than MaxStaleness. A current
+// informer watching every namespace serves any namespace.
+func (p *Provider) informerFor(gvr schema.GroupVersionResource, namespace string) *gvrInformer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if namespace != metav1.NamespaceAll {
+		if all, ok := p.informers[informerKey{gvr, metav1.NamespaceAll}]; ok && p.current(all) && !all.denied() {
+			return all
+		}
+	}
+	key := informerKey{gvr, namespace}
+	if existing, ok := p.informers[key]; ok {
+		if p.current(existing) {
+			return existing
+		}
+		existing.halt()
+	}
+
+	generic := dynamicinformer.NewFilteredDynamicInformer(p.client, gvr, namespace, p.config.ResyncPeriod,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, nil)
+	informer := &gvrInformer{
+		informer:  generic.Informer(),
+		lister:    generic.Lister(),
+		stop:      make(chan struct{}),
+		forbidden: make(chan struct{}),
+		startedAt: time.Now(),
+	}
+	// Both only fail once the informer has started
+	_ = informer.informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		if apierrors.IsForbidden(err) {
+			informer.deny()
+			return
+		}
+		cache.DefaultWatchErrorHandler(r, err)
+	})
+	_ = informer.informer.SetTransform(stripManagedFields)
+	go informer.informer.Run(informer.stop)
+	p.informers[key] = informer
+	return informer
+}
+
+// stripManagedFields drops metadata.managedFields from cached objects, which
+// are often larger than the rest of an object and rarely read.
+func stripManagedFields(object interface{}) (interface{}, error) {
+	if resource, ok := object.(*unstructured.Unstructured); ok {
+		resource.SetManagedFields(nil)
+	}
+	return object, nil
+}
+
+// invalidate marks the informers of kind for a fresh list on their next
+// read.
+func (p *Provider) invalidate(kind string) {
+	gvr, err := p.base.FindGVR(kind)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, informer := range p.informers {
+		if key.gvr == gvr {
+			informer.stale = true
+		}
+	}
+}
+
+func (p *Provider) DeleteK8sResources(kind, name, namespace string, dryRun bool) error {
+	return p.DeleteK8sResourcesContext(context.Background(), kind, name, namespace, dryRun)
+}
+
+func (p *Provider) DeleteK8sResourcesContext(ctx context.Context, kind, name, namespace string, dryRun bool) error {
+	err := provider.DeleteK8sResources(ctx, p.base, kind, name, namespace, dryRun)
+	if err == nil && !dryRun {
+		p.invalidate(kind)
+	}
+	return err
+}
+
+func (p *Provider) CreateK8sResource(kind, name, namespace string, body interface{}, dryRun bool) error {
+	return p.CreateK8sResourceContext(context.Background(), kind, name, namespace, body, dryRun)
+}
+
+func (p *Provider) CreateK8sResourceContext(ctx context.Context, kind, name, namespace string, body interface{}, dryRun bool) error {
+	err := provider.CreateK8sResource(ctx, p.base, kind, name, namespace, body, dryRun)
+	if err == nil && !dryRun {
+		p.invalidate(kind)
+	}
+	return err
+}
+
+func (p *Provider) PatchK8sResource(kind, name, namespace string, patchJSON []byte, dryRun bool) error {
+	return p.PatchK8sResourceContext(context.Background(), kind, name, namespace, patchJSON, dryRun)
+}
+
+func (p *Provider) PatchK8sResourceContext(ctx context.Context, kind, name, namespace string, patchJSON []byte, dryRun bool) error {
+	err := provider.PatchK8sResource(ctx, p.base, kind, name, namespace, patchJSON, dryRun)
+	if err == nil && !dryRun {
+		p.invalidate(kind)
+	}
+	return err
+}
+
+func (p *Provider) FindGVR(kind string) (schema.GroupVersionResource, error) {
+	return p.base.FindGVR(kind)
+}
+
+func (p *Provider) FindGVRContext(ctx context.Context, kind string) (schema.GroupVersionResource, error) {
+	return provider.FindGVR(ctx, p.base, kind)
+}
+
+func (p *Provider) GetOpenAPIResourceSpecs() (map[string][]string, error) {
+	return p.base.GetOpenAPIResourceSpecs()
+}
+
+func (p *Provider) GetOpenAPIResourceSpecsContext(ctx context.Context) (map[string][]string, error) {
+	return provider.GetOpenAPIResourceSpecs(ctx, p.base)
+}
+
+// CreateProviderForContext returns the base provider's uncached provider for
+// kubeContext, since informers started for a single query would never be
+// reused.
+func (p *Provider) CreateProviderForContext(kubeContext string) (provider.Provider, error) {
+	return p.base.CreateProviderForContext(kubeContext)
+}
+
+func (p *Provider) CreateProviderForKubeContext(ctx context.Context, kubeContext string) (provider.Provider, error) {
+	return provider.CreateProviderForContext(ctx, p.base, kubeContext)
+}
@@ -0,0 +1,257 @@
+package informer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+var podsGVR = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// baseProvider resolves pods and writes them to the fake dynamic client the
+// informers watch, counting the lists it serves itself.
+type baseProvider struct {
+	client *dynamicfake.FakeDynamicClient
+	mu     sync.Mutex
+	lists  int
+}
+
+func (p *baseProvider) GetK8sResources(kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lists++
+	return []map[string]interface{}{}, nil
+}
+
+func (p *baseProvider) DeleteK8sResources(kind, name, namespace string, dryRun bool) error {
+	return p.client.Resource(podsGVR).Namespace(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+}
+
+func (p *baseProvider) CreateK8sResource(kind, name, namespace string, body interface{}, dryRun bool) error {
+	_, err := p.client.Resource(podsGVR).Namespace(namespace).Create(context.Background(), testPod(name, namespace, "new", "Pending"), metav1.CreateOptions{})
+	return err
+}
+
+func (p *baseProvider) PatchK8sResource(kind, name, namespace string, patchJSON []byte, dryRun bool) error {
+	return nil
+}
+
+func (p *baseProvider) FindGVR(kind string) (schema.GroupVersionResource, error) {
+	if kind == "Pod" || kind == "pods" {
+		return podsGVR, nil
+	}
+	return schema.GroupVersionResource{}, fmt.Errorf("resource %q not found", kind)
+}
+
+func (p *baseProvider) IsNamespaced(gvr schema.GroupVersionResource) (bool, error) {
+	return gvr == podsGVR, nil
+}
+
+func (p *baseProvider) GetOpenAPIResourceSpecs() (map[string][]string, error) {
+	return map[string][]string{}, nil
+}
+
+func (p *baseProvider) CreateProviderForContext(context string) (provider.Provider, error) {
+	return p, nil
+}
+
+func testPod(name, namespace, app, phase string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+			"labels":    map[string]interface{}{"app": app},
+		},
+		"status": map[string]interface{}{"phase": phase},
+	}}
+}
+
+func newTestProvider(t *testing.T, config Config) (*Provider, *baseProvider) {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{podsGVR: "PodList"},
+		testPod("web-1", "default", "web", "Running"),
+		testPod("web-2", "default", "web", "Pending"),
+		testPod("db-1", "default", "db", "Running"),
+		testPod("web-3", "other", "web", "Running"),
+	)
+	base := &baseProvider{client: client}
+	p, err := NewProvider(base, client, config)
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	t.Cleanup(p.Close)
+	return p, base
+}
+
+func names(t *testing.T, p *Provider, fieldSelector, labelSelector, namespace string) []string {
+	t.Helper()
+	result, err := p.GetK8sResources("Pod", fieldSelector, labelSelector, namespace)
+	if err != nil {
+		t.Fatalf("GetK8sResources() error = %v", err)
+	}
+	var out []string
+	for _, resource := range result.([]map[string]interface{}) {
+		out = append(out, resource["metadata"].(map[string]interface{})["name"].(string))
+	}
+	return out
+}
+
+func countLists(client *dynamicfake.FakeDynamicClient) int {
+	return len(listNamespaces(client))
+}
+
+// listNamespaces returns the namespace of every list made with client.
+func listNamespaces(client *dynamicfake.FakeDynamicClient) []string {
+	var namespaces []string
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "list" {
+			namespaces = append(namespaces, action.GetNamespace())
+		}
+	}
+	return namespaces
+}
+
+func TestGetK8sResourcesServesSelectorsFromCache(t *testing.T) {
+	p, base := newTestProvider(t, Config{})
+
+	tests := []struct {
+		fieldSelector, labelSelector, namespace string
+		want                                    string
+	}{
+		{"", "", "default", "[db-1 web-1 web-2]"},
+		{"", "app=web", "", "[web-1 web-2 web-3]"},
+		{"status.phase=Running", "app in (web)", "default", "[web-1]"},
+		{"metadata.name!=web-1", "app=web", "default", "[web-2]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(names(t, p, tt.fieldSelector, tt.labelSelector, tt.namespace)); got != tt.want {
+			t.Errorf("fields %q labels %q namespace %q: got %s, want %s", tt.fieldSelector, tt.labelSelector, tt.namespace, got, tt.want)
+		}
+	}
+	if base.lists != 0 {
+		t.Errorf("expected reads to be served from the cache, base listed %d times", base.lists)
+	}
+	if got := fmt.Sprint(listNamespaces(base.client)); got != "[default ]" {
+		t.Errorf("expected one list for the namespace and one for every namespace, got %s", got)
+	}
+}
+
+func TestNamespaceReadsStartScopedInformers(t *testing.T) {
+	p, base := newTestProvider(t, Config{})
+
+	if got := fmt.Sprint(names(t, p, "", "app=web", "other")); got != "[web-3]" {
+		t.Errorf("expected the pods of the namespace, got %s", got)
+	}
+	if got := fmt.Sprint(names(t, p, "", "app=web", "")); got != "[web-1 web-2 web-3]" {
+		t.Errorf("expected the pods of every namespace, got %s", got)
+	}
+	// Served by the informer watching every namespace
+	if got := fmt.Sprint(names(t, p, "", "app=web", "default")); got != "[web-1 web-2]" {
+		t.Errorf("expected the pods of the namespace, got %s", got)
+	}
+	if got := fmt.Sprint(listNamespaces(base.client)); got != "[other ]" {
+		t.Errorf("expected a list scoped to the namespace, then one of every namespace, got %s", got)
+	}
+}
+
+func TestForbiddenInformersStop(t *testing.T) {
+	p, base := newTestProvider(t, Config{SyncTimeout: time.Minute})
+	base.client.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(podsGVR.GroupResource(), "", fmt.Errorf("denied"))
+	})
+
+	start := time.Now()
+	names(t, p, "", "", "default")
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected a forbidden informer to stop waiting for its sync, waited %v", elapsed)
+	}
+	names(t, p, "", "", "default")
+	if base.lists != 2 {
+		t.Errorf("expected reads to be served by the base provider, base listed %d times", base.lists)
+	}
+	if lists := countLists(base.client); lists != 1 {
+		t.Errorf("expected a forbidden informer not to list again, got %d lists", lists)
+	}
+}
+
+func TestCachedResourcesHaveNoManagedFields(t *testing.T) {
+	pod := testPod("web-1", "default", "web", "Running")
+	pod.SetManagedFields([]metav1.ManagedFieldsEntry{{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationApply}})
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{podsGVR: "PodList"}, pod)
+	p, err := NewProvider(&baseProvider{client: client}, client, Config{})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	t.Cleanup(p.Close)
+
+	result, err := p.GetK8sResources("Pod", "", "", "default")
+	if err != nil {
+		t.Fatalf("GetK8sResources() error = %v", err)
+	}
+	resources := result.([]map[string]interface{})
+	if len(resources) != 1 {
+		t.Fatalf("expected the pod, got %v", resources)
+	}
+	if _, ok := resources[0]["metadata"].(map[string]interface{})["managedFields"]; ok {
+		t.Errorf("expected managedFields to be stripped, got %v", resources[0]["metadata"])
+	}
+}
+
+func TestWritesInvalidateTheCache(t *testing.T) {
+	p, base := newTestProvider(t, Config{})
+	names(t, p, "", "", "default")
+
+	if err := p.CreateK8sResource("Pod", "new-1", "default", nil, false); err != nil {
+		t.Fatalf("CreateK8sResource() error = %v", err)
+	}
+	if got := fmt.Sprint(names(t, p, "", "app=new", "default")); got != "[new-1]" {
+		t.Fatalf("expected the created pod to be read back, got %s", got)
+	}
+	if lists := countLists(base.client); lists != 2 {
+		t.Errorf("expected the write to trigger a fresh list, got %d lists", lists)
+	}
+
+	if err := p.CreateK8sResource("Pod", "new-2", "default", nil, true); err != nil {
+		t.Fatalf("CreateK8sResource() error = %v", err)
+	}
+	names(t, p, "", "", "default")
+	if lists := countLists(base.client); lists != 2 {
+		t.Errorf("expected a dry-run write to keep the cache, got %d lists", lists)
+	}
+}
+
+func TestMaxStalenessRebuildsInformers(t *testing.T) {
+	p, base := newTestProvider(t, Config{MaxStaleness: time.Nanosecond})
+	names(t, p, "", "", "default")
+	time.Sleep(time.Millisecond)
+	names(t, p, "", "", "default")
+	if lists := countLists(base.client); lists != 2 {
+		t.Errorf("expected an expired informer to list again, got %d lists", lists)
+	}
+}
+
+func TestNewProviderValidatesConfig(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	if _, err := NewProvider(&baseProvider{client: client}, client, Config{MaxStaleness: -time.Second}); err == nil {
+		t.Errorf("expected error for negative MaxStaleness")
+	}
+	if _, err := NewProvider(nil, client, Config{}); err == nil {
+		t.Errorf("expected error for nil base provider")
+	}
+}
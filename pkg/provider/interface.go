@@ -21,3 +21,49 @@ type Provider interface {
 	GetOpenAPIResourceSpecs() (map[string][]string, error)
 	CreateProviderForContext(context string) (Provider, error)
 }
+
+// Unwrapper is implemented by providers that wrap another provider, such as
+// a cache in front of the API server.
+type Unwrapper interface {
+	Unwrap() Provider
+}
+
+// Base returns the provider at the bottom of a chain of wrapping providers.
+func Base(p Provider) Provider {
+	for {
+		u, ok := p.(Unwrapper)
+		if !ok {
+			return p
+		}
+		p = u.Unwrap()
+	}
+}
+
+// Discovery is implemented by providers that know the resource types of a
+// cluster up front: the names each type resolves by and the kinds that can
+// be listed. Autocompletion, custom relationships and snapshots use it.
+type Discovery interface {
+	GetGVRCacheSnapshot() map[string]schema.GroupVersionResource
+	GetKnownResourceKinds() []string
+}
+
+// Scope is implemented by providers that know whether a resource type is
+// namespaced, so reads of a single namespace can be scoped to it.
+type Scope interface {
+	IsNamespaced(gvr schema.GroupVersionResource) (bool, error)
+}
+
+// SpecCache is implemented by providers that keep what is derived from a
+// cluster's resource types, such as inferred relationship rules, across
+// runs. Entries are dropped once the resource types change.
+type SpecCache interface {
+	// LoadCached decodes the entry stored under name into v and reports
+	// whether there was one.
+	LoadCached(name string, v interface{}) bool
+	// StoreCached stores v, which must encode to JSON, under name.
+	StoreCached(name string, v interface{}) error
+}
+
+// Debugf logs a provider diagnostic. It discards them unless the query
+// engine is loaded, which logs them with its own debug output.
+var Debugf = func(format string, args ...interface{}) {}
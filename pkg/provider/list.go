@@ -0,0 +1,28 @@
+package provider
+
+import "context"
+
+// ListOptions carries optional hints for a list call.
+type ListOptions struct {
+	// Limit, when positive, is the number of resources the caller needs.
+	// Providers may stop listing once they have that many.
+	Limit int64
+	// MetadataOnly means the caller reads nothing but the apiVersion, kind
+	// and metadata of the resources. Providers may leave out the rest.
+	MetadataOnly bool
+}
+
+// OptionsLister is implemented by providers that accept list hints.
+type OptionsLister interface {
+	GetK8sResourcesWithOptions(ctx context.Context, kind, fieldSelector, labelSelector, namespace string, opts ListOptions) (interface{}, error)
+}
+
+// GetK8sResourcesWithOptions lists resources, passing opts to providers that
+// implement OptionsLister. Other providers ignore the hints, so callers must
+// still apply them to the result.
+func GetK8sResourcesWithOptions(ctx context.Context, p Provider, kind, fieldSelector, labelSelector, namespace string, opts ListOptions) (interface{}, error) {
+	if lister, ok := p.(OptionsLister); ok {
+		return lister.GetK8sResourcesWithOptions(ctx, kind, fieldSelector, labelSelector, namespace, opts)
+	}
+	return GetK8sResources(ctx, p, kind, fieldSelector, labelSelector, namespace)
+}
@@ -0,0 +1,316 @@
+package manifest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+// kindInfo describes a resource type the provider can serve.
+type kindInfo struct {
+	gvr      schema.GroupVersionResource
+	kind     string
+	singular string
+	// scope is empty for kinds known only from the manifests, whose objects
+	// keep whatever namespace they were written with.
+	scope      apiextensionsv1.ResourceScope
+	shortNames []string
+
+	// Exactly one of goType and crdSchema is set for kinds with a schema.
+	goType    reflect.Type
+	crdSchema *apiextensionsv1.JSONSchemaProps
+}
+
+func (k *kindInfo) namespaced() bool {
+	return k.scope == apiextensionsv1.NamespaceScoped
+}
+
+// schemaName names the kind's schema the way the API server's OpenAPI
+// document does, e.g. io.k8s.api.apps.v1.Deployment.
+func (k *kindInfo) schemaName() string {
+	if k.goType != nil {
+		return packageSchemaPrefix(k.goType.PkgPath()) + "." + k.kind
+	}
+	return reverseDomain(k.gvr.Group) + "." + k.gvr.Version + "." + k.kind
+}
+
+// kindTable resolves kinds by the same names the API server's discovery
+// offers: kind, plural, singular, short names and resource.group.
+type kindTable struct {
+	kinds map[schema.GroupKind]*kindInfo
+	names map[string]schema.GroupVersionResource
+}
+
+// clusterScopedKinds lists the built-in kinds that are not namespaced.
+var clusterScopedKinds = map[string]bool{
+	"APIService":                       true,
+	"CertificateSigningRequest":        true,
+	"ClusterRole":                      true,
+	"ClusterRoleBinding":               true,
+	"ClusterTrustBundle":               true,
+	"ComponentStatus":                  true,
+	"CSIDriver":                        true,
+	"CSINode":                          true,
+	"CustomResourceDefinition":         true,
+	"DeviceClass":                      true,
+	"FlowSchema":                       true,
+	"IngressClass":                     true,
+	"IPAddress":                        true,
+	"MutatingAdmissionPolicy":          true,
+	"MutatingAdmissionPolicyBinding":   true,
+	"MutatingWebhookConfiguration":     true,
+	"Namespace":                        true,
+	"Node":                             true,
+	"PersistentVolume":                 true,
+	"PriorityClass":                    true,
+	"PriorityLevelConfiguration":       true,
+	"ResourceSlice":                    true,
+	"RuntimeClass":                     true,
+	"SelfSubjectAccessReview":          true,
+	"SelfSubjectReview":                true,
+	"SelfSubjectRulesReview":           true,
+	"ServiceCIDR":                      true,
+	"StorageClass":                     true,
+	"StorageVersion":                   true,
+	"StorageVersionMigration":          true,
+	"SubjectAccessReview":              true,
+	"TokenReview":                      true,
+	"ValidatingAdmissionPolicy":        true,
+	"ValidatingAdmissionPolicyBinding": true,
+	"ValidatingWebhookConfiguration":   true,
+	"VolumeAttachment":                 true,
+	"VolumeAttributesClass":            true,
+}
+
+// builtinShortNames are the short names the API server registers for
+// built-in resources.
+var builtinShortNames = map[string][]string{
+	"certificatesigningrequests": {"csr"},
+	"configmaps":                 {"cm"},
+	"cronjobs":                   {"cj"},
+	"customresourcedefinitions":  {"crd", "crds"},
+	"daemonsets":                 {"ds"},
+	"deployments":                {"deploy"},
+	"endpoints":                  {"ep"},
+	"events":                     {"ev"},
+	"horizontalpodautoscalers":   {"hpa"},
+	"ingresses":                  {"ing"},
+	"limitranges":                {"limits"},
+	"namespaces":                 {"ns"},
+	"networkpolicies":            {"netpol"},
+	"nodes":                      {"no"},
+	"persistentvolumeclaims":     {"pvc"},
+	"persistentvolumes":          {"pv"},
+	"poddisruptionbudgets":       {"pdb"},
+	"pods":                       {"po"},
+	"priorityclasses":            {"pc"},
+	"replicasets":                {"rs"},
+	"replicationcontrollers":     {"rc"},
+	"resourcequotas":             {"quota"},
+	"serviceaccounts":            {"sa"},
+	"services":                   {"svc"},
+	"statefulsets":               {"sts"},
+	"storageclasses":             {"sc"},
+}
+
+var (
+	builtinOnce  sync.Once
+	builtinKinds map[schema.GroupKind]*kindInfo
+)
+
+// builtins returns the kinds of the Kubernetes API bundled with client-go,
+// each at the version the API server prefers.
+func builtins() map[schema.GroupKind]*kindInfo {
+	builtinOnce.Do(func() {
+		scheme := runtime.NewScheme()
+		if err := clientgoscheme.AddToScheme(scheme); err != nil {
+			panic(err)
+		}
+		if err := apiextensionsv1.AddToScheme(scheme); err != nil {
+			panic(err)
+		}
+
+		builtinKinds = make(map[schema.GroupKind]*kindInfo)
+		for gvk, t := range scheme.AllKnownTypes() {
+			// The extensions group is no longer served and would make
+			// Deployment and Ingress ambiguous.
+			if gvk.Version == runtime.APIVersionInternal || gvk.Group == "extensions" || !hasObjectMeta(t) {
+				continue
+			}
+			gk := gvk.GroupKind()
+			if existing, ok := builtinKinds[gk]; ok && version.CompareKubeAwareVersionStrings(existing.gvr.Version, gvk.Version) > 0 {
+				continue
+			}
+			plural, singular := meta.UnsafeGuessKindToResource(gvk)
+			scope := apiextensionsv1.NamespaceScoped
+			if clusterScopedKinds[gvk.Kind] {
+				scope = apiextensionsv1.ClusterScoped
+			}
+			builtinKinds[gk] = &kindInfo{
+				gvr:        plural,
+				kind:       gvk.Kind,
+				singular:   singular.Resource,
+				scope:      scope,
+				shortNames: builtinShortNames[plural.Resource],
+				goType:     t,
+			}
+		}
+	})
+	return builtinKinds
+}
+
+// hasObjectMeta reports whether t is a top-level object rather than a list,
+// an option type or a status.
+func hasObjectMeta(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	field, ok := t.FieldByName("ObjectMeta")
+	return ok && field.Anonymous && field.Type == reflect.TypeOf(metav1.ObjectMeta{})
+}
+
+// newKindTable builds the table of built-in kinds, kinds defined by the
+// CustomResourceDefinitions among objects and, failing both, kinds the
+// objects themselves use.
+func newKindTable(objects []*unstructured.Unstructured) (*kindTable, error) {
+	table := &kindTable{
+		kinds: make(map[schema.GroupKind]*kindInfo),
+		names: make(map[string]schema.GroupVersionResource),
+	}
+	for gk, info := range builtins() {
+		table.kinds[gk] = info
+	}
+
+	for _, object := range objects {
+		if object.GroupVersionKind().GroupKind() != (schema.GroupKind{Group: apiextensionsv1.GroupName, Kind: "CustomResourceDefinition"}) {
+			continue
+		}
+		info, err := crdKind(object)
+		if err != nil {
+			return nil, err
+		}
+		table.kinds[schema.GroupKind{Group: info.gvr.Group, Kind: info.kind}] = info
+	}
+
+	for _, object := range objects {
+		gvk := object.GroupVersionKind()
+		if _, ok := table.kinds[gvk.GroupKind()]; ok {
+			continue
+		}
+		plural, singular := meta.UnsafeGuessKindToResource(gvk)
+		table.kinds[gvk.GroupKind()] = &kindInfo{gvr: plural, kind: gvk.Kind, singular: singular.Resource}
+	}
+
+	for _, info := range table.sortedKinds() {
+		table.addNames(info)
+	}
+	return table, nil
+}
+
+// crdKind describes the kind a CustomResourceDefinition defines, at its
+// storage version.
+func crdKind(object *unstructured.Unstructured) (*kindInfo, error) {
+	var crd apiextensionsv1.CustomResourceDefinition
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &crd); err != nil {
+		return nil, fmt.Errorf("invalid CustomResourceDefinition %q: %v", object.GetName(), err)
+	}
+	if len(crd.Spec.Versions) == 0 {
+		return nil, fmt.Errorf("CustomResourceDefinition %q has no versions", crd.Name)
+	}
+	selected := crd.Spec.Versions[0]
+	for _, v := range crd.Spec.Versions {
+		if v.Storage {
+			selected = v
+			break
+		}
+	}
+	info := &kindInfo{
+		gvr: schema.GroupVersionResource{
+			Group:    crd.Spec.Group,
+			Version:  selected.Name,
+			Resource: crd.Spec.Names.Plural,
+		},
+		kind:       crd.Spec.Names.Kind,
+		singular:   crd.Spec.Names.Singular,
+		scope:      crd.Spec.Scope,
+		shortNames: crd.Spec.Names.ShortNames,
+	}
+	if info.singular == "" {
+		info.singular = strings.ToLower(info.kind)
+	}
+	if selected.Schema != nil {
+		info.crdSchema = selected.Schema.OpenAPIV3Schema
+	}
+	return info, nil
+}
+
+// addNames registers the names info resolves by, as the API server's
+// discovery would. Unqualified names shared by several kinds stay with the
+// first kind registered, the core group's when it has one; the others
+// remain reachable by resource.group, which also makes the shared name
+// resolve as ambiguous.
+func (t *kindTable) addNames(info *kindInfo) {
+	gvr := info.gvr
+	names := append([]string{info.kind, gvr.Resource, info.singular}, info.shortNames...)
+	for _, name := range names {
+		if _, taken := t.names[name]; !taken {
+			t.names[name] = gvr
+		}
+	}
+	if gvr.Group != "" {
+		t.names[gvr.Resource+"."+gvr.Group] = gvr
+		t.names[info.singular+"."+gvr.Group] = gvr
+	}
+}
+
+// forGVR returns the kind served at gvr's group and resource.
+func (t *kindTable) forGVR(gvr schema.GroupVersionResource) *kindInfo {
+	for _, info := range t.kinds {
+		if info.gvr.Group == gvr.Group && info.gvr.Resource == gvr.Resource {
+			return info
+		}
+	}
+	return nil
+}
+
+// sortedKinds returns the table's kinds in a stable order.
+func (t *kindTable) sortedKinds() []*kindInfo {
+	kinds := make([]*kindInfo, 0, len(t.kinds))
+	for _, info := range t.kinds {
+		kinds = append(kinds, info)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if kinds[i].gvr.Group != kinds[j].gvr.Group {
+			return kinds[i].gvr.Group < kinds[j].gvr.Group
+		}
+		return kinds[i].kind < kinds[j].kind
+	})
+	return kinds
+}
+
+// packageSchemaPrefix turns a Go package path such as k8s.io/api/apps/v1
+// into the OpenAPI schema prefix io.k8s.api.apps.v1.
+func packageSchemaPrefix(pkgPath string) string {
+	parts := strings.Split(pkgPath, "/")
+	parts[0] = reverseDomain(parts[0])
+	return strings.Join(parts, ".")
+}
+
+func reverseDomain(domain string) string {
+	parts := strings.Split(domain, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, ".")
+}
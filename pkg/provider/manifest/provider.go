@@ -0,0 +1,595 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// Stdin is the path Load reads manifests from standard input for.
+const Stdin = "-"
+
+const (
+	// stdinSource is the file manifests read from stdin are written back to.
+	stdinSource = "stdin.yaml"
+	// createdSource is the file resources created by queries are written to.
+	createdSource = "created.yaml"
+)
+
+type Config struct {
+	// Namespace is given to namespaced resources that do not set one, as
+	// kubectl apply would. Defaults to "default".
+	Namespace string
+}
+
+// Provider serves queries from Kubernetes manifests held in memory.
+// Selectors are evaluated locally and mutations change the in-memory
+// objects, which WriteDir and Write save back out.
+type Provider struct {
+	config Config
+	kinds  *kindTable
+
+	mu      sync.Mutex
+	objects []*object
+}
+
+type object struct {
+	resource *unstructured.Unstructured
+	gvr      schema.GroupVersionResource
+	// source is the file the object was read from, relative to the input.
+	source string
+	// defaultedNamespace is set when the namespace came from
+	// Config.Namespace, so it is left out again when writing.
+	defaultedNamespace bool
+	deleted            bool
+}
+
+// Load reads the manifests at path: a directory, searched recursively for
+// .yaml, .yml and .json files, a single file, or Stdin. Files may hold
+// several YAML documents, and List objects are expanded into their items.
+func Load(path string, config Config) (*Provider, error) {
+	if path == Stdin {
+		return load(config, []string{stdinSource}, func(string) (io.ReadCloser, error) {
+			return io.NopCloser(os.Stdin), nil
+		})
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Dir(path)
+	if info.IsDir() {
+		root = path
+	}
+
+	var sources []string
+	if info.IsDir() {
+		err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && isManifestFile(file) {
+				rel, err := filepath.Rel(root, file)
+				if err != nil {
+					return err
+				}
+				sources = append(sources, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		sources = []string{filepath.Base(path)}
+	}
+
+	return load(config, sources, func(source string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(root, source))
+	})
+}
+
+func isManifestFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// load decodes the sources in order, each opened with open, and builds the
+// kind table from the objects found.
+func load(config Config, sources []string, open func(source string) (io.ReadCloser, error)) (*Provider, error) {
+	if config.Namespace == "" {
+		config.Namespace = metav1.NamespaceDefault
+	}
+
+	var objects []*object
+	var resources []*unstructured.Unstructured
+	for _, source := range sources {
+		decoded, err := decodeSource(source, open)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range decoded {
+			objects = append(objects, &object{resource: resource, source: source})
+			resources = append(resources, resource)
+		}
+	}
+
+	kinds, err := newKindTable(resources)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{config: config, kinds: kinds}
+	seen := make(map[string]string)
+	for _, obj := range objects {
+		info := kinds.kinds[obj.resource.GroupVersionKind().GroupKind()]
+		obj.gvr = info.gvr
+		if info.namespaced() && obj.resource.GetNamespace() == "" {
+			obj.resource.SetNamespace(config.Namespace)
+			obj.defaultedNamespace = true
+		}
+		key := objectKey(info.gvr, obj.resource.GetNamespace(), obj.resource.GetName())
+		if previous, ok := seen[key]; ok {
+			return nil, fmt.Errorf("%s %q is defined in both %s and %s", info.kind, obj.resource.GetName(), previous, obj.source)
+		}
+		seen[key] = obj.source
+		p.objects = append(p.objects, obj)
+	}
+	return p, nil
+}
+
+func objectKey(gvr schema.GroupVersionResource, namespace, name string) string {
+	return gvr.Group + "/" + gvr.Resource + "/" + namespace + "/" + name
+}
+
+// decodeSource reads every document of source, closing it before returning
+// so that large trees of manifests don't hold a file open each.
+func decodeSource(source string, open func(source string) (io.ReadCloser, error)) ([]*unstructured.Unstructured, error) {
+	r, err := open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	decoded, err := decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", source, err)
+	}
+	return decoded, nil
+}
+
+// decode reads every document of a YAML or JSON stream.
+func decode(r io.Reader) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	var resources []*unstructured.Unstructured
+	for {
+		var document map[string]interface{}
+		if err := decoder.Decode(&document); err != nil {
+			if err == io.EOF {
+				return resources, nil
+			}
+			return nil, err
+		}
+		if len(document) == 0 {
+			continue
+		}
+		resource := &unstructured.Unstructured{Object: document}
+		if resource.IsList() {
+			list, err := resource.ToList()
+			if err != nil {
+				return nil, err
+			}
+			for i := range list.Items {
+				item := list.Items[i]
+				if err := validate(&item); err != nil {
+					return nil, err
+				}
+				resources = append(resources, &item)
+			}
+			continue
+		}
+		if err := validate(resource); err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+}
+
+func validate(resource *unstructured.Unstructured) error {
+	if resource.GetAPIVersion() == "" || resource.GetKind() == "" {
+		return fmt.Errorf("document without apiVersion or kind")
+	}
+	if resource.GetName() == "" {
+		return fmt.Errorf("%s without metadata.name", resource.GetKind())
+	}
+	return nil
+}
+
+// find returns the live object of gvr named name in namespace.
+func (p *Provider) find(gvr schema.GroupVersionResource, name, namespace string) *object {
+	for _, obj := range p.objects {
+		if obj.deleted || obj.gvr.Group != gvr.Group || obj.gvr.Resource != gvr.Resource || obj.resource.GetName() != name {
+			continue
+		}
+		if namespace == "" || obj.resource.GetNamespace() == "" || obj.resource.GetNamespace() == namespace {
+			return obj
+		}
+	}
+	return nil
+}
+
+func (p *Provider) GetK8sResources(kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	labelSel, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %v", labelSelector, err)
+	}
+	fieldSel, err := fields.ParseSelector(fieldSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid field selector %q: %v", fieldSelector, err)
+	}
+	gvr, err := p.FindGVR(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var resources []*unstructured.Unstructured
+	for _, obj := range p.objects {
+		if obj.deleted || obj.gvr.Group != gvr.Group || obj.gvr.Resource != gvr.Resource {
+			continue
+		}
+		resource := obj.resource
+		// Cluster-scoped resources have no namespace and match any namespace.
+		if namespace != "" && resource.GetNamespace() != "" && resource.GetNamespace() != namespace {
+			continue
+		}
+		if !labelSel.Matches(labels.Set(resource.GetLabels())) {
+			continue
+		}
+		if !fieldSel.Empty() && !fieldSel.Matches(provider.FieldSet(resource, fieldSel)) {
+			continue
+		}
+		resources = append(resources, resource)
+	}
+	// Match the API server's ordering by namespace and name.
+	sort.SliceStable(resources, func(i, j int) bool {
+		if resources[i].GetNamespace() != resources[j].GetNamespace() {
+			return resources[i].GetNamespace() < resources[j].GetNamespace()
+		}
+		return resources[i].GetName() < resources[j].GetName()
+	})
+
+	var converted []map[string]interface{}
+	for _, resource := range resources {
+		converted = append(converted, resource.DeepCopy().UnstructuredContent())
+	}
+	return converted, nil
+}
+
+func (p *Provider) DeleteK8sResources(kind, name, namespace string, dryRun bool) error {
+	gvr, err := p.FindGVR(kind)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	obj := p.find(gvr, name, namespace)
+	if obj == nil {
+		return fmt.Errorf("%s %q not found", kind, name)
+	}
+	if !dryRun {
+		obj.deleted = true
+	}
+	return nil
+}
+
+func (p *Provider) CreateK8sResource(kind, name, namespace string, body interface{}, dryRun bool) error {
+	gvr, err := p.FindGVR(kind)
+	if err != nil {
+		return err
+	}
+	info := p.kinds.forGVR(gvr)
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("invalid %s body: %v", kind, err)
+	}
+	content := map[string]interface{}{}
+	if err := json.Unmarshal(data, &content); err != nil {
+		return fmt.Errorf("invalid %s body: %v", kind, err)
+	}
+	if content == nil {
+		content = map[string]interface{}{}
+	}
+	resource := &unstructured.Unstructured{Object: content}
+	resource.SetAPIVersion(gvr.GroupVersion().String())
+	resource.SetKind(info.kind)
+	resource.SetName(name)
+	defaulted := false
+	if info.namespaced() {
+		if namespace == "" {
+			namespace = p.config.Namespace
+			defaulted = true
+		}
+		resource.SetNamespace(namespace)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.find(gvr, name, resource.GetNamespace()) != nil {
+		return fmt.Errorf("%s %q already exists", info.kind, name)
+	}
+	if !dryRun {
+		p.objects = append(p.objects, &object{
+			resource:           resource,
+			gvr:                gvr,
+			source:             createdSource,
+			defaultedNamespace: defaulted,
+		})
+	}
+	return nil
+}
+
+func (p *Provider) PatchK8sResource(kind, name, namespace string, patchJSON []byte, dryRun bool) error {
+	gvr, err := p.FindGVR(kind)
+	if err != nil {
+		return err
+	}
+	var operations []patchOperation
+	if err := json.Unmarshal(patchJSON, &operations); err != nil {
+		return fmt.Errorf("invalid patch JSON: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	obj := p.find(gvr, name, namespace)
+	if obj == nil {
+		return fmt.Errorf("%s %q not found", kind, name)
+	}
+	patched := obj.resource.DeepCopy()
+	for i, operation := range operations {
+		if isParentTest(operations, i) {
+			// The add that follows creates the map if it is missing.
+			continue
+		}
+		if err := operation.apply(patched.Object); err != nil {
+			return fmt.Errorf("error patching %s %q: %v", kind, name, err)
+		}
+	}
+	if !dryRun {
+		obj.resource = patched
+	}
+	return nil
+}
+
+// patchOperation is a JSON patch operation as the query engine emits them.
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// isParentTest reports whether operations[i] is the "test" the engine sends
+// ahead of an add to a map that may not exist yet.
+func isParentTest(operations []patchOperation, i int) bool {
+	if i+1 >= len(operations) || operations[i].Op != "test" || operations[i+1].Op != "add" {
+		return false
+	}
+	return strings.HasPrefix(operations[i+1].Path, operations[i].Path+"/")
+}
+
+// apply applies the operation to object. Adds and replaces create missing
+// parent maps; adds insert into arrays as RFC 6902 specifies, with "-"
+// appending, and replaces overwrite the element in place. Tests fail unless
+// the value at the path equals the operation's value.
+func (o patchOperation) apply(object map[string]interface{}) error {
+	if !strings.HasPrefix(o.Path, "/") {
+		return fmt.Errorf("invalid patch path %q", o.Path)
+	}
+	var tokens []string
+	for _, token := range strings.Split(o.Path[1:], "/") {
+		tokens = append(tokens, strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~"))
+	}
+
+	var parent, grandparent interface{} = object, nil
+	for i, token := range tokens[:len(tokens)-1] {
+		child, err := step(parent, token)
+		if err != nil {
+			return fmt.Errorf("%s: %v", o.Path, err)
+		}
+		if child == nil {
+			if o.Op != "add" && o.Op != "replace" {
+				return fmt.Errorf("%s: path not found", o.Path)
+			}
+			created := map[string]interface{}{}
+			if err := set(parent, token, created); err != nil {
+				return fmt.Errorf("%s: %v", "/"+strings.Join(tokens[:i+1], "/"), err)
+			}
+			child = created
+		}
+		grandparent, parent = parent, child
+	}
+
+	last := tokens[len(tokens)-1]
+	switch o.Op {
+	case "add":
+		if list, ok := parent.([]interface{}); ok {
+			grown, err := insert(list, last, o.Value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", o.Path, err)
+			}
+			// Inserting grows the list, which is stored back in its parent.
+			return set(grandparent, tokens[len(tokens)-2], grown)
+		}
+		return set(parent, last, o.Value)
+	case "replace":
+		return set(parent, last, o.Value)
+	case "remove":
+		return remove(parent, last)
+	case "test":
+		current, err := step(parent, last)
+		if err != nil {
+			return fmt.Errorf("%s: %v", o.Path, err)
+		}
+		if current == nil {
+			return fmt.Errorf("test failed: %s: path not found", o.Path)
+		}
+		equal, err := jsonEqual(current, o.Value)
+		if err != nil {
+			return err
+		}
+		if !equal {
+			return fmt.Errorf("test failed: %s does not equal %v", o.Path, o.Value)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported patch operation %q", o.Op)
+}
+
+// insert returns list with value inserted before index token, or appended
+// when token is "-" or the list's length.
+func insert(list []interface{}, token string, value interface{}) ([]interface{}, error) {
+	if token == "-" {
+		return append(list, value), nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > len(list) {
+		return nil, fmt.Errorf("invalid array index %q", token)
+	}
+	grown := make([]interface{}, 0, len(list)+1)
+	grown = append(grown, list[:index]...)
+	grown = append(grown, value)
+	return append(grown, list[index:]...), nil
+}
+
+// jsonEqual reports whether a and b encode to the same JSON value, so that
+// the int64s read from manifests equal the float64s decoded from patches.
+func jsonEqual(a, b interface{}) (bool, error) {
+	var decoded [2]interface{}
+	for i, value := range []interface{}{a, b} {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return false, err
+		}
+		if err := json.Unmarshal(encoded, &decoded[i]); err != nil {
+			return false, err
+		}
+	}
+	return reflect.DeepEqual(decoded[0], decoded[1]), nil
+}
+
+// step returns the child of container at token, or nil when a map has no
+// such key.
+func step(container interface{}, token string) (interface{}, error) {
+	switch c := container.(type) {
+	case map[string]interface{}:
+		return c[token], nil
+	case []interface{}:
+		index, err := strconv.Atoi(token)
+		if err != nil || index < 0 || index >= len(c) {
+			return nil, fmt.Errorf("invalid array index %q", token)
+		}
+		return c[index], nil
+	}
+	return nil, fmt.Errorf("cannot traverse %T", container)
+}
+
+// set stores value at token of container, replacing an existing array
+// element.
+func set(container interface{}, token string, value interface{}) error {
+	switch c := container.(type) {
+	case map[string]interface{}:
+		c[token] = value
+		return nil
+	case []interface{}:
+		index, err := strconv.Atoi(token)
+		if err != nil || index < 0 || index >= len(c) {
+			return fmt.Errorf("invalid array index %q", token)
+		}
+		c[index] = value
+		return nil
+	}
+	return fmt.Errorf("cannot set %q on %T", token, container)
+}
+
+func remove(container interface{}, token string) error {
+	c, ok := container.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("removing array elements is not supported")
+	}
+	if _, ok := c[token]; !ok {
+		return fmt.Errorf("%q not found", token)
+	}
+	delete(c, token)
+	return nil
+}
+
+func (p *Provider) FindGVR(kind string) (schema.GroupVersionResource, error) {
+	return provider.ResolveGVR(p.kinds.names, kind)
+}
+
+// ResourceTypes returns the types of the objects held, sorted by group and
+// resource.
+func (p *Provider) ResourceTypes() []schema.GroupVersionResource {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seen := make(map[schema.GroupVersionResource]bool)
+	var gvrs []schema.GroupVersionResource
+	for _, object := range p.objects {
+		if !object.deleted && !seen[object.gvr] {
+			seen[object.gvr] = true
+			gvrs = append(gvrs, object.gvr)
+		}
+	}
+	sort.Slice(gvrs, func(i, j int) bool {
+		if gvrs[i].Group != gvrs[j].Group {
+			return gvrs[i].Group < gvrs[j].Group
+		}
+		return gvrs[i].Resource < gvrs[j].Resource
+	})
+	return gvrs
+}
+
+// GetOpenAPIResourceSpecs returns the fields of every kind with a schema:
+// built-in kinds from the API types bundled with client-go, custom
+// resources from their CustomResourceDefinition.
+func (p *Provider) GetOpenAPIResourceSpecs() (map[string][]string, error) {
+	specs := make(map[string][]string)
+	for _, info := range p.kinds.sortedKinds() {
+		if fields := kindFields(info); len(fields) > 0 {
+			specs[info.schemaName()] = fields
+		}
+	}
+	return specs, nil
+}
+
+func (p *Provider) CreateProviderForContext(context string) (provider.Provider, error) {
+	return nil, fmt.Errorf("manifest files have no Kubernetes contexts, cannot query context %q", context)
+}
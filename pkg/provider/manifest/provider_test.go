@@ -0,0 +1,505 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/avitaltamir/cyphernetes/pkg/core"
+)
+
+const appManifests = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: nginx:1.25
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  selector:
+    app: web
+---
+# A document holding only a comment is skipped.
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: db
+  namespace: data
+  labels:
+    app: db
+spec:
+  selector:
+    app: db
+`
+
+const crdManifests = `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: crontabs.stable.example.com
+spec:
+  group: stable.example.com
+  scope: Namespaced
+  names:
+    plural: crontabs
+    singular: crontab
+    kind: CronTab
+    shortNames: ["ct"]
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            properties:
+              cronSpec:
+                type: string
+              configMapName:
+                type: string
+---
+apiVersion: stable.example.com/v1
+kind: CronTab
+metadata:
+  name: nightly
+spec:
+  cronSpec: "0 0 * * *"
+  configMapName: web-config
+`
+
+const listManifest = `{
+  "apiVersion": "v1",
+  "kind": "List",
+  "items": [
+    {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "web-config"}, "data": {"mode": "fast"}},
+    {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "data"}},
+    {"apiVersion": "widgets.example.com/v1", "kind": "Widget", "metadata": {"name": "gear"}}
+  ]
+}
+`
+
+func writeManifests(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"app.yaml":           appManifests,
+		"crds/crontab.yml":   crdManifests,
+		"list.json":          listManifest,
+		"README.md":          "not a manifest",
+		"crds/empty.yaml":    "",
+		"crds/comments.yaml": "# nothing here\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func loadManifests(t *testing.T) (*Provider, string) {
+	t.Helper()
+	dir := writeManifests(t)
+	p, err := Load(dir, Config{})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return p, dir
+}
+
+func names(t *testing.T, p *Provider, kind, fieldSelector, labelSelector, namespace string) string {
+	t.Helper()
+	result, err := p.GetK8sResources(kind, fieldSelector, labelSelector, namespace)
+	if err != nil {
+		t.Fatalf("GetK8sResources(%s) error = %v", kind, err)
+	}
+	var out []string
+	for _, resource := range result.([]map[string]interface{}) {
+		metadata := resource["metadata"].(map[string]interface{})
+		name := metadata["name"].(string)
+		if namespace, ok := metadata["namespace"].(string); ok {
+			name = namespace + "/" + name
+		}
+		out = append(out, name)
+	}
+	return fmt.Sprint(out)
+}
+
+func TestGetK8sResourcesEvaluatesSelectors(t *testing.T) {
+	p, _ := loadManifests(t)
+
+	tests := []struct {
+		kind, fieldSelector, labelSelector, namespace string
+		want                                          string
+	}{
+		{"Service", "", "", "", "[data/db default/web]"},
+		{"svc", "", "", "default", "[default/web]"},
+		{"services", "", "app in (db)", "", "[data/db]"},
+		{"Service", "metadata.name!=web", "", "", "[data/db]"},
+		{"deploy", "metadata.name=web", "", "", "[default/web]"},
+		{"ConfigMap", "", "", "default", "[default/web-config]"},
+		{"Namespace", "", "", "default", "[data]"},
+		{"ct", "spec.cronSpec=0 0 * * *", "", "default", "[default/nightly]"},
+		{"widgets", "", "", "", "[gear]"},
+	}
+	for _, tt := range tests {
+		if got := names(t, p, tt.kind, tt.fieldSelector, tt.labelSelector, tt.namespace); got != tt.want {
+			t.Errorf("%s fields %q labels %q namespace %q: got %s, want %s", tt.kind, tt.fieldSelector, tt.labelSelector, tt.namespace, got, tt.want)
+		}
+	}
+}
+
+func TestFindGVR(t *testing.T) {
+	p, _ := loadManifests(t)
+
+	tests := map[string]string{
+		"Deployment":                  "apps/v1, Resource=deployments",
+		"hpa":                         "autoscaling/v2, Resource=horizontalpodautoscalers",
+		"endpoints":                   "/v1, Resource=endpoints",
+		"crontab":                     "stable.example.com/v1, Resource=crontabs",
+		"crontabs.stable.example.com": "stable.example.com/v1, Resource=crontabs",
+		"Widget":                      "widgets.example.com/v1, Resource=widgets",
+		"core.Event":                  "/v1, Resource=events",
+		"CustomResourceDefinition":    "apiextensions.k8s.io/v1, Resource=customresourcedefinitions",
+		"ingresses.networking.k8s.io": "networking.k8s.io/v1, Resource=ingresses",
+		"clusterrolebinding":          "rbac.authorization.k8s.io/v1, Resource=clusterrolebindings",
+		"persistentvolumeclaim":       "/v1, Resource=persistentvolumeclaims",
+		"statefulsets.apps":           "apps/v1, Resource=statefulsets",
+		"validatingwebhookconfigurations.admissionregistration.k8s.io": "admissionregistration.k8s.io/v1, Resource=validatingwebhookconfigurations",
+	}
+	for kind, want := range tests {
+		gvr, err := p.FindGVR(kind)
+		if err != nil {
+			t.Errorf("FindGVR(%q) error = %v", kind, err)
+			continue
+		}
+		if gvr.String() != want {
+			t.Errorf("FindGVR(%q) = %s, want %s", kind, gvr, want)
+		}
+	}
+
+	if _, err := p.FindGVR("Event"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("expected Event to be ambiguous, got %v", err)
+	}
+	if _, err := p.FindGVR("Gadget"); err == nil {
+		t.Errorf("expected an unknown kind to fail")
+	}
+}
+
+func TestResourceTypes(t *testing.T) {
+	p, _ := loadManifests(t)
+	var got []string
+	for _, gvr := range p.ResourceTypes() {
+		got = append(got, gvr.GroupResource().String())
+	}
+	want := "[configmaps namespaces services customresourcedefinitions.apiextensions.k8s.io deployments.apps crontabs.stable.example.com widgets.widgets.example.com]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestGetOpenAPIResourceSpecs(t *testing.T) {
+	p, _ := loadManifests(t)
+	specs, err := p.GetOpenAPIResourceSpecs()
+	if err != nil {
+		t.Fatalf("GetOpenAPIResourceSpecs() error = %v", err)
+	}
+
+	tests := map[string][]string{
+		"io.k8s.api.apps.v1.Deployment": {
+			"metadata.name",
+			"metadata.labels",
+			"spec.template.spec.containers[].name",
+			"spec.template.spec.volumes[].configMap.name",
+			"spec.template.spec.containers[].resources.limits",
+		},
+		"io.k8s.api.core.v1.Pod":           {"spec.serviceAccountName", "metadata.ownerReferences[].uid"},
+		"io.k8s.api.networking.v1.Ingress": {"spec.rules[].http.paths[].backend.service.name"},
+		"com.example.stable.v1.CronTab":    {"metadata.namespace", "spec.cronSpec", "spec.configMapName"},
+		"io.k8s.apiextensions-apiserver.pkg.apis.apiextensions.v1.CustomResourceDefinition": {"spec.names.kind"},
+	}
+	for schemaName, want := range tests {
+		fields, ok := specs[schemaName]
+		if !ok {
+			t.Errorf("no spec for %s", schemaName)
+			continue
+		}
+		have := make(map[string]bool, len(fields))
+		for _, field := range fields {
+			have[field] = true
+		}
+		for _, field := range want {
+			if !have[field] {
+				t.Errorf("%s: missing field %s", schemaName, field)
+			}
+		}
+	}
+	if _, ok := specs["com.example.widgets.v1.Widget"]; ok {
+		t.Errorf("expected no spec for a kind without a schema")
+	}
+	if _, ok := specs["io.k8s.api.apps.v1.DeploymentList"]; ok {
+		t.Errorf("expected no spec for list types")
+	}
+}
+
+func TestMutationsAreWrittenBack(t *testing.T) {
+	p, dir := loadManifests(t)
+
+	patch := `[{"op":"test","path":"/metadata/labels","value":{}},{"op":"add","path":"/metadata/labels/tier","value":"frontend"},` +
+		`{"op":"add","path":"/spec/template/spec/containers/0/image","value":"nginx:1.27"},` +
+		`{"op":"add","path":"/spec/replicas","value":3}]`
+	if err := p.PatchK8sResource("Deployment", "web", "default", []byte(patch), false); err != nil {
+		t.Fatalf("PatchK8sResource() error = %v", err)
+	}
+	if err := p.PatchK8sResource("Deployment", "web", "default", []byte(`[{"op":"add","path":"/spec/replicas","value":9}]`), true); err != nil {
+		t.Fatalf("dry-run PatchK8sResource() error = %v", err)
+	}
+	if err := p.DeleteK8sResources("Service", "db", "data", false); err != nil {
+		t.Fatalf("DeleteK8sResources() error = %v", err)
+	}
+	if err := p.DeleteK8sResources("Service", "db", "data", false); err == nil {
+		t.Errorf("expected deleting a deleted resource to fail")
+	}
+	if err := p.DeleteK8sResources("Namespace", "data", "", true); err != nil {
+		t.Fatalf("dry-run DeleteK8sResources() error = %v", err)
+	}
+	body := map[string]interface{}{"data": map[string]interface{}{"mode": "slow"}}
+	if err := p.CreateK8sResource("ConfigMap", "db-config", "", body, false); err != nil {
+		t.Fatalf("CreateK8sResource() error = %v", err)
+	}
+	if err := p.CreateK8sResource("ConfigMap", "web-config", "default", body, false); err == nil {
+		t.Errorf("expected creating an existing resource to fail")
+	}
+	if err := p.CreateK8sResource("ConfigMap", "dry-config", "", body, true); err != nil {
+		t.Fatalf("dry-run CreateK8sResource() error = %v", err)
+	}
+
+	if got := names(t, p, "Service", "", "", ""); got != "[default/web]" {
+		t.Errorf("Services after delete: got %s", got)
+	}
+	if got := names(t, p, "ConfigMap", "", "", ""); got != "[default/db-config default/web-config]" {
+		t.Errorf("ConfigMaps after create: got %s", got)
+	}
+
+	if err := p.WriteDir(dir); err != nil {
+		t.Fatalf("WriteDir() error = %v", err)
+	}
+	app, err := os.ReadFile(filepath.Join(dir, "app.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"tier: frontend", "image: nginx:1.27", "replicas: 3"} {
+		if !strings.Contains(string(app), want) {
+			t.Errorf("app.yaml is missing %q:\n%s", want, app)
+		}
+	}
+	for _, unwanted := range []string{"name: db", "namespace: default"} {
+		if strings.Contains(string(app), unwanted) {
+			t.Errorf("app.yaml should not contain %q:\n%s", unwanted, app)
+		}
+	}
+	created, err := os.ReadFile(filepath.Join(dir, createdSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(created), "name: db-config") || strings.Contains(string(created), "dry-config") {
+		t.Errorf("unexpected %s:\n%s", createdSource, created)
+	}
+
+	reloaded, err := Load(dir, Config{})
+	if err != nil {
+		t.Fatalf("Load() of written manifests error = %v", err)
+	}
+	if got := names(t, reloaded, "ConfigMap", "", "mode", ""); got != "[]" {
+		t.Errorf("unexpected label match: %s", got)
+	}
+	if got := names(t, reloaded, "Namespace", "", "", ""); got != "[data]" {
+		t.Errorf("Namespaces after reload: got %s", got)
+	}
+	if got := names(t, reloaded, "CronTab", "", "", ""); got != "[default/nightly]" {
+		t.Errorf("CronTabs after reload: got %s", got)
+	}
+
+	var stream bytes.Buffer
+	if err := reloaded.Write(&stream); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if documents := strings.Count(stream.String(), "---\n") + 1; documents != 8 {
+		t.Errorf("expected 8 documents in the stream, got %d:\n%s", documents, stream.String())
+	}
+}
+
+func TestPatchOperations(t *testing.T) {
+	p, _ := loadManifests(t)
+	gvr, err := p.FindGVR("Deployment")
+	if err != nil {
+		t.Fatalf("FindGVR() error = %v", err)
+	}
+	containers := func() []string {
+		var images []string
+		list, _, _ := unstructured.NestedSlice(p.find(gvr, "web", "default").resource.Object, "spec", "template", "spec", "containers")
+		for _, item := range list {
+			images = append(images, item.(map[string]interface{})["image"].(string))
+		}
+		return images
+	}
+
+	patch := `[{"op":"add","path":"/spec/template/spec/containers/0","value":{"name":"init","image":"busybox"}},` +
+		`{"op":"add","path":"/spec/template/spec/containers/-","value":{"name":"sidecar","image":"envoy"}},` +
+		`{"op":"add","path":"/spec/template/spec/containers/3","value":{"name":"last","image":"alpine"}}]`
+	if err := p.PatchK8sResource("Deployment", "web", "default", []byte(patch), false); err != nil {
+		t.Fatalf("PatchK8sResource() error = %v", err)
+	}
+	if got, want := fmt.Sprint(containers()), "[busybox nginx:1.25 envoy alpine]"; got != want {
+		t.Errorf("containers after adds = %s, want %s", got, want)
+	}
+
+	patch = `[{"op":"test","path":"/spec/template/spec/containers/1/name","value":"web"},` +
+		`{"op":"replace","path":"/spec/template/spec/containers/1","value":{"name":"web","image":"nginx:1.27"}}]`
+	if err := p.PatchK8sResource("Deployment", "web", "default", []byte(patch), false); err != nil {
+		t.Fatalf("PatchK8sResource() with a passing test error = %v", err)
+	}
+	if got, want := fmt.Sprint(containers()), "[busybox nginx:1.27 envoy alpine]"; got != want {
+		t.Errorf("containers after replace = %s, want %s", got, want)
+	}
+
+	for _, patch := range []string{
+		`[{"op":"test","path":"/spec/template/spec/containers/0/name","value":"web"},{"op":"replace","path":"/spec/template/spec/containers/0/image","value":"x"}]`,
+		`[{"op":"test","path":"/spec/paused","value":true},{"op":"replace","path":"/spec/template/spec/containers/0/image","value":"x"}]`,
+		`[{"op":"add","path":"/spec/template/spec/containers/9","value":{}}]`,
+	} {
+		if err := p.PatchK8sResource("Deployment", "web", "default", []byte(patch), false); err == nil {
+			t.Errorf("PatchK8sResource(%s) succeeded, want an error", patch)
+		}
+	}
+	if got, want := fmt.Sprint(containers()), "[busybox nginx:1.27 envoy alpine]"; got != want {
+		t.Errorf("containers after failed patches = %s, want %s", got, want)
+	}
+}
+
+// trackedReader counts the sources left open.
+type trackedReader struct {
+	io.Reader
+	open *int
+}
+
+func (r trackedReader) Close() error {
+	*r.open--
+	return nil
+}
+
+func TestLoadClosesEachSourceBeforeTheNext(t *testing.T) {
+	open, maxOpen := 0, 0
+	sources := []string{"a.yaml", "b.yaml", "c.yaml"}
+	p, err := load(Config{}, sources, func(source string) (io.ReadCloser, error) {
+		open++
+		if open > maxOpen {
+			maxOpen = open
+		}
+		name := strings.TrimSuffix(source, ".yaml")
+		return trackedReader{Reader: strings.NewReader("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: " + name + "\n"), open: &open}, nil
+	})
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if open != 0 || maxOpen != 1 {
+		t.Errorf("expected one source open at a time and none left open, got %d at most and %d left", maxOpen, open)
+	}
+	if got := names(t, p, "ConfigMap", "", "", ""); got != "[default/a default/b default/c]" {
+		t.Errorf("ConfigMaps = %q", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "manifest.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := map[string]string{
+		"missing kind":  "apiVersion: v1\nmetadata:\n  name: x\n",
+		"missing name":  "apiVersion: v1\nkind: Pod\n",
+		"invalid yaml":  "apiVersion: [v1\n",
+		"duplicate pod": "apiVersion: v1\nkind: Pod\nmetadata:\n  name: x\n---\napiVersion: v1\nkind: Pod\nmetadata:\n  name: x\n  namespace: default\n",
+	}
+	for name, content := range tests {
+		if _, err := Load(write(content), Config{}); err == nil {
+			t.Errorf("%s: expected Load() to fail", name)
+		}
+	}
+	if _, err := Load(filepath.Join(dir, "missing"), Config{}); err == nil {
+		t.Errorf("expected Load() of a missing path to fail")
+	}
+}
+
+func TestQueriesRunAgainstManifests(t *testing.T) {
+	p, _ := loadManifests(t)
+	executor, err := core.NewQueryExecutor(p)
+	if err != nil {
+		t.Fatalf("NewQueryExecutor() error = %v", err)
+	}
+
+	ast, err := core.ParseQuery(`MATCH (d:Deployment)->(s:Service) RETURN d.metadata.name, s.metadata.name`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	result, err := executor.Execute(ast, "default")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	services, ok := result.Data["s"].([]interface{})
+	if !ok || len(services) != 1 {
+		t.Fatalf("expected the web service to be matched, got %#v", result.Data)
+	}
+	if name := services[0].(map[string]interface{})["metadata"].(map[string]interface{})["name"]; name != "web" {
+		t.Errorf("matched service %v, want web", name)
+	}
+
+	ast, err = core.ParseQuery(`MATCH (d:Deployment {name: "web"}) SET d.metadata.labels.team = "payments"`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	if _, err := executor.Execute(ast, "default"); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if got := names(t, p, "Deployment", "", "team=payments", "default"); got != "[default/web]" {
+		t.Errorf("expected the SET to update the manifest, got %s", got)
+	}
+}
@@ -0,0 +1,126 @@
+package manifest
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// kindFields lists the field paths of a kind in the format the API server
+// provider derives from OpenAPI: dotted json names, "[]" after arrays and
+// "{}" after maps.
+func kindFields(info *kindInfo) []string {
+	if info.goType != nil {
+		return typeFields(info.goType, "", map[reflect.Type]bool{})
+	}
+	if info.crdSchema == nil {
+		return nil
+	}
+	fields := []string{"apiVersion", "kind", "metadata"}
+	fields = append(fields, typeFields(reflect.TypeOf(metav1.ObjectMeta{}), "metadata", map[reflect.Type]bool{})...)
+	top := *info.crdSchema
+	top.Properties = make(map[string]apiextensionsv1.JSONSchemaProps, len(info.crdSchema.Properties))
+	for name, property := range info.crdSchema.Properties {
+		if name != "apiVersion" && name != "kind" && name != "metadata" {
+			top.Properties[name] = property
+		}
+	}
+	return append(fields, schemaFields(&top, "")...)
+}
+
+// typeFields walks the json-serialized fields of struct type t. Types that
+// marshal themselves, such as Quantity and Time, are leaves.
+func typeFields(t reflect.Type, prefix string, visiting map[reflect.Type]bool) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || visiting[t] || isLeaf(t) {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && field.Anonymous {
+			fields = append(fields, typeFields(field.Type, prefix, visiting)...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		fields = append(fields, path)
+		fields = append(fields, valueFields(field.Type, path, visiting)...)
+	}
+	return fields
+}
+
+func valueFields(t reflect.Type, path string, visiting map[reflect.Type]bool) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if isLeaf(t) {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		return append([]string{path + "[]"}, valueFields(t.Elem(), path+"[]", visiting)...)
+	case reflect.Map:
+		return valueFields(t.Elem(), path+"{}", visiting)
+	case reflect.Struct:
+		return typeFields(t, path, visiting)
+	}
+	return nil
+}
+
+func isLeaf(t reflect.Type) bool {
+	return t.Implements(jsonMarshaler) || reflect.PointerTo(t).Implements(jsonMarshaler)
+}
+
+// schemaFields walks a CRD's structural schema.
+func schemaFields(props *apiextensionsv1.JSONSchemaProps, prefix string) []string {
+	var fields []string
+	names := make([]string, 0, len(props.Properties))
+	for name := range props.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property := props.Properties[name]
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		fields = append(fields, path)
+		fields = append(fields, schemaFields(&property, path)...)
+	}
+	if props.Items != nil && props.Items.Schema != nil {
+		fields = append(fields, prefix+"[]")
+		fields = append(fields, schemaFields(props.Items.Schema, prefix+"[]")...)
+	}
+	if props.AdditionalProperties != nil && props.AdditionalProperties.Schema != nil {
+		fields = append(fields, schemaFields(props.AdditionalProperties.Schema, prefix+"{}")...)
+	}
+	return fields
+}
@@ -0,0 +1,117 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Write writes every object as one multi-document YAML stream, in the order
+// the objects were read and created.
+func (p *Provider) Write(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var documents [][]byte
+	for _, obj := range p.objects {
+		if obj.deleted {
+			continue
+		}
+		document, err := yaml.Marshal(obj.content())
+		if err != nil {
+			return err
+		}
+		documents = append(documents, document)
+	}
+	_, err := w.Write(bytes.Join(documents, []byte("---\n")))
+	return err
+}
+
+// WriteDir writes the objects to dir, mirroring the files they were read
+// from. JSON files hold a single object or a List, YAML files one document
+// per object. Files left without objects are removed from dir, so WriteDir
+// can rewrite the input directory in place. Comments in the input are not
+// preserved.
+func (p *Provider) WriteDir(dir string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var sources []string
+	contents := make(map[string][]map[string]interface{})
+	for _, obj := range p.objects {
+		if _, ok := contents[obj.source]; !ok {
+			sources = append(sources, obj.source)
+			contents[obj.source] = nil
+		}
+		if !obj.deleted {
+			contents[obj.source] = append(contents[obj.source], obj.content())
+		}
+	}
+
+	for _, source := range sources {
+		path := filepath.Join(dir, source)
+		objects := contents[source]
+		if len(objects) == 0 {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		data, err := encode(source, objects)
+		if err != nil {
+			return fmt.Errorf("error encoding %s: %v", source, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encode(source string, objects []map[string]interface{}) ([]byte, error) {
+	if strings.EqualFold(filepath.Ext(source), ".json") {
+		var content interface{} = objects[0]
+		if len(objects) > 1 {
+			items := make([]interface{}, len(objects))
+			for i, object := range objects {
+				items[i] = object
+			}
+			content = map[string]interface{}{"apiVersion": "v1", "kind": "List", "items": items}
+		}
+		data, err := json.MarshalIndent(content, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+
+	var documents [][]byte
+	for _, object := range objects {
+		document, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	return bytes.Join(documents, []byte("---\n")), nil
+}
+
+// content returns the object as it should be written, without a namespace
+// the provider defaulted.
+func (o *object) content() map[string]interface{} {
+	if !o.defaultedNamespace {
+		return o.resource.Object
+	}
+	resource := o.resource.DeepCopy()
+	resource.SetNamespace("")
+	return resource.Object
+}
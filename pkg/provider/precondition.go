@@ -0,0 +1,38 @@
+package provider
+
+import "errors"
+
+// ErrConflict is wrapped by the errors PatchK8sResource returns when a
+// patch's resourceVersion precondition fails: the resource changed after the
+// patch was computed from it.
+var ErrConflict = errors.New("resource version conflict")
+
+const resourceVersionPath = "/metadata/resourceVersion"
+
+// ResourceVersionPrecondition returns the JSON patch operation that makes a
+// patch conditional on the resource still being at resourceVersion. It goes
+// first in the patch. Providers that support preconditions fail the whole
+// patch with ErrConflict when the resource is at another version; others
+// skip it like any other "test" operation they don't act on.
+func ResourceVersionPrecondition(resourceVersion string) map[string]interface{} {
+	return map[string]interface{}{
+		"op":    "test",
+		"path":  resourceVersionPath,
+		"value": resourceVersion,
+	}
+}
+
+// SplitPrecondition returns the resourceVersion a patch is conditional on
+// and its remaining operations. The resourceVersion is empty when the patch
+// has no precondition.
+func SplitPrecondition(operations []interface{}) (string, []interface{}) {
+	if len(operations) == 0 {
+		return "", operations
+	}
+	op, ok := operations[0].(map[string]interface{})
+	if !ok || op["op"] != "test" || op["path"] != resourceVersionPath {
+		return "", operations
+	}
+	resourceVersion, _ := op["value"].(string)
+	return resourceVersion, operations[1:]
+}
@@ -0,0 +1,45 @@
+package provider
+
+import (
+	"context"
+	"time"
+)
+
+// Retry describes a request a provider sends again after it failed with a
+// transient error.
+type Retry struct {
+	// Verb is the request's verb, like "list" or "patch".
+	Verb      string
+	Resource  string
+	Namespace string
+	// Name is empty for lists.
+	Name string
+	// Attempt is the number of the attempt that failed, starting at 1.
+	Attempt int
+	// Delay is how long the provider waits before the next attempt.
+	Delay time.Duration
+	Err   error
+}
+
+type retryObserverKey struct{}
+
+// WithRetryObserver returns a context that reports the retries of the
+// provider calls it is passed to to observe, as well as to the observers of
+// ctx.
+func WithRetryObserver(ctx context.Context, observe func(Retry)) context.Context {
+	parent, _ := ctx.Value(retryObserverKey{}).(func(Retry))
+	return context.WithValue(ctx, retryObserverKey{}, func(retry Retry) {
+		if parent != nil {
+			parent(retry)
+		}
+		observe(retry)
+	})
+}
+
+// ObserveRetry reports retry to the observers of ctx. Providers call it
+// before waiting out retry.Delay.
+func ObserveRetry(ctx context.Context, retry Retry) {
+	if observe, ok := ctx.Value(retryObserverKey{}).(func(Retry)); ok {
+		observe(retry)
+	}
+}
@@ -0,0 +1,133 @@
+package snapshot
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// Provider serves queries from a snapshot. It is read-only: mutations fail
+// without changing the snapshot.
+type Provider struct {
+	snapshot  *Snapshot
+	resources map[schema.GroupResource][]*unstructured.Unstructured
+}
+
+// NewProvider returns a provider serving s.
+func NewProvider(s *Snapshot) *Provider {
+	p := &Provider{
+		snapshot:  s,
+		resources: make(map[schema.GroupResource][]*unstructured.Unstructured),
+	}
+	for _, list := range s.Resources {
+		resources := make([]*unstructured.Unstructured, 0, len(list.Items))
+		for _, item := range list.Items {
+			resources = append(resources, &unstructured.Unstructured{Object: item})
+		}
+		p.resources[list.GVR.GroupResource()] = resources
+	}
+	return p
+}
+
+// Snapshot returns the snapshot the provider serves.
+func (p *Provider) Snapshot() *Snapshot {
+	return p.snapshot
+}
+
+func (p *Provider) GetK8sResources(kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	labelSel, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %v", labelSelector, err)
+	}
+	fieldSel, err := fields.ParseSelector(fieldSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid field selector %q: %v", fieldSelector, err)
+	}
+	gvr, err := p.FindGVR(kind)
+	if err != nil {
+		return nil, err
+	}
+	captured, ok := p.resources[gvr.GroupResource()]
+	if !ok {
+		if reason, skipped := p.snapshot.Skipped[gvr.Resource]; skipped {
+			return nil, fmt.Errorf("%s were not captured in the snapshot: %s", gvr.Resource, reason)
+		}
+		return nil, fmt.Errorf("%s were not captured in the snapshot", gvr.Resource)
+	}
+
+	var resources []*unstructured.Unstructured
+	for _, resource := range captured {
+		// Cluster-scoped resources have no namespace and match any namespace.
+		if namespace != "" && resource.GetNamespace() != "" && resource.GetNamespace() != namespace {
+			continue
+		}
+		if !labelSel.Matches(labels.Set(resource.GetLabels())) {
+			continue
+		}
+		if !fieldSel.Empty() && !fieldSel.Matches(provider.FieldSet(resource, fieldSel)) {
+			continue
+		}
+		resources = append(resources, resource)
+	}
+	// Match the API server's ordering by namespace and name.
+	sort.SliceStable(resources, func(i, j int) bool {
+		if resources[i].GetNamespace() != resources[j].GetNamespace() {
+			return resources[i].GetNamespace() < resources[j].GetNamespace()
+		}
+		return resources[i].GetName() < resources[j].GetName()
+	})
+
+	var converted []map[string]interface{}
+	for _, resource := range resources {
+		converted = append(converted, resource.DeepCopy().UnstructuredContent())
+	}
+	return converted, nil
+}
+
+func (p *Provider) DeleteK8sResources(kind, name, namespace string, dryRun bool) error {
+	return fmt.Errorf("cannot delete %s %q: snapshots are read-only", kind, name)
+}
+
+func (p *Provider) CreateK8sResource(kind, name, namespace string, body interface{}, dryRun bool) error {
+	return fmt.Errorf("cannot create %s %q: snapshots are read-only", kind, name)
+}
+
+func (p *Provider) PatchK8sResource(kind, name, namespace string, patchJSON []byte, dryRun bool) error {
+	return fmt.Errorf("cannot patch %s %q: snapshots are read-only", kind, name)
+}
+
+func (p *Provider) FindGVR(kind string) (schema.GroupVersionResource, error) {
+	return provider.ResolveGVR(p.snapshot.GVRs, kind)
+}
+
+func (p *Provider) GetOpenAPIResourceSpecs() (map[string][]string, error) {
+	return p.snapshot.Specs, nil
+}
+
+// CreateProviderForContext returns p for the context the snapshot was taken
+// from; a snapshot holds no other context.
+func (p *Provider) CreateProviderForContext(context string) (provider.Provider, error) {
+	if context == p.snapshot.Context {
+		return p, nil
+	}
+	return nil, fmt.Errorf("snapshot of context %q cannot query context %q", p.snapshot.Context, context)
+}
+
+// GetGVRCacheSnapshot returns the names resource types resolve by.
+func (p *Provider) GetGVRCacheSnapshot() map[string]schema.GroupVersionResource {
+	gvrs := make(map[string]schema.GroupVersionResource, len(p.snapshot.GVRs))
+	for name, gvr := range p.snapshot.GVRs {
+		gvrs[name] = gvr
+	}
+	return gvrs
+}
+
+func (p *Provider) GetKnownResourceKinds() []string {
+	return p.snapshot.KnownResourceKinds
+}
@@ -0,0 +1,230 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+// formatVersion is the version of the snapshot file format. Load rejects
+// files written in a newer format.
+const formatVersion = 1
+
+// Snapshot is the state of a cluster captured at one point in time, with
+// the discovery and schema data queries need to run against it offline.
+type Snapshot struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Context is the kubeconfig context the snapshot was taken from.
+	Context string `json:"context,omitempty"`
+	// Namespace limits the captured namespaced resources; empty means all
+	// namespaces.
+	Namespace string `json:"namespace,omitempty"`
+
+	// GVRs maps every name a resource type resolves by to the type, as the
+	// cluster's discovery reported it.
+	GVRs               map[string]schema.GroupVersionResource `json:"gvrs"`
+	KnownResourceKinds []string                               `json:"knownResourceKinds"`
+	// Specs holds the field paths of each schema, as returned by
+	// GetOpenAPIResourceSpecs.
+	Specs map[string][]string `json:"specs"`
+
+	Resources []ResourceList `json:"resources"`
+	// Skipped records the kinds that could not be captured and why.
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// ResourceList holds the captured resources of one type.
+type ResourceList struct {
+	GVR   schema.GroupVersionResource `json:"gvr"`
+	Items []map[string]interface{}    `json:"items"`
+}
+
+// CaptureOptions selects what Capture saves.
+type CaptureOptions struct {
+	// Kinds are the kinds to capture, by any name FindGVR resolves. Empty
+	// captures every kind the cluster can list; kinds that fail to list are
+	// then recorded in Snapshot.Skipped instead of failing the capture.
+	Kinds []string
+	// Namespace limits namespaced kinds to one namespace; empty captures all
+	// namespaces.
+	Namespace string
+	// Context names the kubeconfig context p talks to.
+	Context string
+}
+
+// Capture lists the selected kinds through p and records them together with
+// p's discovery data and OpenAPI field paths. p must support discovery.
+func Capture(ctx context.Context, p provider.Provider, opts CaptureOptions) (*Snapshot, error) {
+	discovery, ok := provider.Base(p).(provider.Discovery)
+	if !ok {
+		return nil, fmt.Errorf("provider does not support discovery")
+	}
+
+	specs, err := provider.GetOpenAPIResourceSpecs(ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("error getting resource specs: %w", err)
+	}
+
+	s := &Snapshot{
+		Version:            formatVersion,
+		CreatedAt:          time.Now().UTC(),
+		Context:            opts.Context,
+		Namespace:          opts.Namespace,
+		GVRs:               discovery.GetGVRCacheSnapshot(),
+		KnownResourceKinds: append([]string(nil), discovery.GetKnownResourceKinds()...),
+		Specs:              specs,
+	}
+
+	kinds := opts.Kinds
+	skipFailures := len(kinds) == 0
+	if skipFailures {
+		kinds = s.KnownResourceKinds
+	}
+
+	captured := make(map[schema.GroupVersionResource]bool)
+	for _, kind := range kinds {
+		gvr, err := provider.FindGVR(ctx, p, kind)
+		if err != nil {
+			if skipFailures {
+				s.skip(kind, err)
+				continue
+			}
+			return nil, err
+		}
+		if captured[gvr] {
+			continue
+		}
+		captured[gvr] = true
+
+		items, err := listItems(ctx, p, gvr, opts.Namespace)
+		if err != nil {
+			if ctx.Err() != nil || !skipFailures {
+				return nil, fmt.Errorf("error capturing %s: %w", gvr.Resource, err)
+			}
+			s.skip(gvr.Resource, err)
+			continue
+		}
+		s.Resources = append(s.Resources, ResourceList{GVR: gvr, Items: items})
+	}
+
+	sort.Slice(s.Resources, func(i, j int) bool {
+		if s.Resources[i].GVR.Group != s.Resources[j].GVR.Group {
+			return s.Resources[i].GVR.Group < s.Resources[j].GVR.Group
+		}
+		return s.Resources[i].GVR.Resource < s.Resources[j].GVR.Resource
+	})
+	return s, nil
+}
+
+func (s *Snapshot) skip(kind string, err error) {
+	if s.Skipped == nil {
+		s.Skipped = make(map[string]string)
+	}
+	s.Skipped[kind] = err.Error()
+}
+
+// listItems lists gvr by its qualified name, so kinds sharing a name across
+// groups are captured separately.
+func listItems(ctx context.Context, p provider.Provider, gvr schema.GroupVersionResource, namespace string) ([]map[string]interface{}, error) {
+	var kind string
+	if gvr.Group == "" {
+		kind = "core." + gvr.Resource
+	} else {
+		kind = gvr.Resource + "." + gvr.Group
+	}
+	result, err := provider.GetK8sResources(ctx, p, kind, "", "", namespace)
+	if err != nil {
+		return nil, err
+	}
+	if items, ok := result.([]map[string]interface{}); ok {
+		return items, nil
+	}
+	// Normalize other result types through JSON.
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	var items []map[string]interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("unexpected result type %T", result)
+	}
+	return items, nil
+}
+
+// Count returns the number of captured resources.
+func (s *Snapshot) Count() int {
+	count := 0
+	for _, list := range s.Resources {
+		count += len(list.Items)
+	}
+	return count
+}
+
+// Save writes the snapshot to path as JSON, gzip-compressed when path ends
+// in ".gz".
+func (s *Snapshot) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var w io.Writer = file
+	var gz *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(file)
+		w = gz
+	}
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// Load reads a snapshot written by Save, detecting gzip compression from the
+// file's contents.
+func Load(path string) (*Snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var r io.Reader = reader
+	if magic, err := reader.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %v", path, err)
+	}
+	if s.Version < 1 || s.Version > formatVersion {
+		return nil, fmt.Errorf("snapshot %s has unsupported format version %d", path, s.Version)
+	}
+	return &s, nil
+}
@@ -0,0 +1,308 @@
+package snapshot
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/avitaltamir/cyphernetes/pkg/core"
+	"github.com/avitaltamir/cyphernetes/pkg/provider"
+)
+
+var (
+	podsGVR        = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	deploymentsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	servicesGVR    = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+	secretsGVR     = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	namespacesGVR  = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+)
+
+// liveProvider stands in for a cluster with discovery, counting the lists
+// it serves. Listing secrets is forbidden.
+type liveProvider struct {
+	gvrs      map[string]schema.GroupVersionResource
+	resources map[schema.GroupVersionResource][]map[string]interface{}
+	lists     []string
+}
+
+func newLiveProvider() *liveProvider {
+	gvrs := map[string]schema.GroupVersionResource{}
+	for _, entry := range []struct {
+		gvr   schema.GroupVersionResource
+		names []string
+	}{
+		{podsGVR, []string{"Pod", "pods", "pod", "po"}},
+		{deploymentsGVR, []string{"Deployment", "deployments", "deployment", "deploy", "deployments.apps", "deployment.apps"}},
+		{servicesGVR, []string{"Service", "services", "service", "svc"}},
+		{secretsGVR, []string{"Secret", "secrets", "secret"}},
+		{namespacesGVR, []string{"Namespace", "namespaces", "namespace", "ns"}},
+	} {
+		for _, name := range entry.names {
+			gvrs[name] = entry.gvr
+		}
+	}
+	return &liveProvider{
+		gvrs: gvrs,
+		resources: map[schema.GroupVersionResource][]map[string]interface{}{
+			podsGVR: {
+				testResource("v1", "Pod", "web-1", "default", "web"),
+				testResource("v1", "Pod", "web-2", "default", "web"),
+				testResource("v1", "Pod", "db-1", "data", "db"),
+			},
+			deploymentsGVR: {
+				testResource("apps/v1", "Deployment", "web", "default", "web"),
+			},
+			servicesGVR: {
+				testResource("v1", "Service", "web", "default", "web"),
+			},
+			namespacesGVR: {
+				testResource("v1", "Namespace", "default", "", ""),
+				testResource("v1", "Namespace", "data", "", ""),
+			},
+		},
+	}
+}
+
+func testResource(apiVersion, kind, name, namespace, app string) map[string]interface{} {
+	metadata := map[string]interface{}{"name": name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	resource := map[string]interface{}{"apiVersion": apiVersion, "kind": kind, "metadata": metadata}
+	if app != "" {
+		metadata["labels"] = map[string]interface{}{"app": app}
+	}
+	switch kind {
+	case "Deployment":
+		resource["spec"] = map[string]interface{}{
+			"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": app}},
+		}
+	case "Service":
+		resource["spec"] = map[string]interface{}{"selector": map[string]interface{}{"app": app}}
+	case "Pod":
+		resource["status"] = map[string]interface{}{"phase": "Running"}
+	}
+	return resource
+}
+
+func (p *liveProvider) GetK8sResources(kind, fieldSelector, labelSelector, namespace string) (interface{}, error) {
+	gvr, err := p.FindGVR(kind)
+	if err != nil {
+		return nil, err
+	}
+	p.lists = append(p.lists, gvr.Resource+"@"+namespace)
+	if gvr == secretsGVR {
+		return nil, fmt.Errorf("secrets is forbidden")
+	}
+	var out []map[string]interface{}
+	for _, resource := range p.resources[gvr] {
+		ns, _ := resource["metadata"].(map[string]interface{})["namespace"].(string)
+		if namespace == "" || ns == "" || ns == namespace {
+			out = append(out, resource)
+		}
+	}
+	return out, nil
+}
+
+func (p *liveProvider) DeleteK8sResources(kind, name, namespace string, dryRun bool) error {
+	return nil
+}
+
+func (p *liveProvider) CreateK8sResource(kind, name, namespace string, body interface{}, dryRun bool) error {
+	return nil
+}
+
+func (p *liveProvider) PatchK8sResource(kind, name, namespace string, patchJSON []byte, dryRun bool) error {
+	return nil
+}
+
+func (p *liveProvider) FindGVR(kind string) (schema.GroupVersionResource, error) {
+	return provider.ResolveGVR(p.gvrs, kind)
+}
+
+func (p *liveProvider) GetOpenAPIResourceSpecs() (map[string][]string, error) {
+	return map[string][]string{
+		"io.k8s.api.core.v1.Pod":        {"metadata.name", "metadata.labels", "status.phase"},
+		"io.k8s.api.apps.v1.Deployment": {"metadata.name", "spec.selector.matchLabels"},
+	}, nil
+}
+
+func (p *liveProvider) CreateProviderForContext(context string) (provider.Provider, error) {
+	return p, nil
+}
+
+func (p *liveProvider) GetGVRCacheSnapshot() map[string]schema.GroupVersionResource {
+	return p.gvrs
+}
+
+func (p *liveProvider) GetKnownResourceKinds() []string {
+	return []string{"pods", "deployments", "services", "secrets", "namespaces"}
+}
+
+func names(t *testing.T, p provider.Provider, kind, fieldSelector, labelSelector, namespace string) string {
+	t.Helper()
+	result, err := p.GetK8sResources(kind, fieldSelector, labelSelector, namespace)
+	if err != nil {
+		t.Fatalf("GetK8sResources(%s) error = %v", kind, err)
+	}
+	var out []string
+	for _, resource := range result.([]map[string]interface{}) {
+		out = append(out, resource["metadata"].(map[string]interface{})["name"].(string))
+	}
+	return fmt.Sprint(out)
+}
+
+func TestCaptureAllKinds(t *testing.T) {
+	live := newLiveProvider()
+	s, err := Capture(t.Context(), live, CaptureOptions{Context: "prod"})
+	if err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+
+	if s.Count() != 7 {
+		t.Errorf("expected 7 captured resources, got %d", s.Count())
+	}
+	if got := fmt.Sprint(live.lists); got != "[pods@ deployments@ services@ secrets@ namespaces@]" {
+		t.Errorf("unexpected lists %s", got)
+	}
+	if reason := s.Skipped["secrets"]; !strings.Contains(reason, "forbidden") {
+		t.Errorf("expected secrets to be skipped as forbidden, got %v", s.Skipped)
+	}
+	if len(s.Specs) != 2 || s.GVRs["deploy"] != deploymentsGVR || s.Context != "prod" {
+		t.Errorf("expected discovery and specs to be captured, got %+v", s)
+	}
+}
+
+func TestCaptureSelectedKinds(t *testing.T) {
+	live := newLiveProvider()
+	s, err := Capture(t.Context(), live, CaptureOptions{Kinds: []string{"po", "Pod", "deploy"}, Namespace: "default"})
+	if err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	if got := fmt.Sprint(live.lists); got != "[pods@default deployments@default]" {
+		t.Errorf("expected each selected kind to be listed once in the namespace, got %s", got)
+	}
+	if s.Count() != 3 {
+		t.Errorf("expected 3 captured resources, got %d", s.Count())
+	}
+
+	if _, err := Capture(t.Context(), live, CaptureOptions{Kinds: []string{"secrets"}}); err == nil {
+		t.Errorf("expected a selected kind that fails to list to fail the capture")
+	}
+	if _, err := Capture(t.Context(), live, CaptureOptions{Kinds: []string{"gadgets"}}); err == nil {
+		t.Errorf("expected an unknown selected kind to fail the capture")
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	s, err := Capture(t.Context(), newLiveProvider(), CaptureOptions{Context: "prod"})
+	if err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+
+	for _, name := range []string{"cluster.json", "cluster.json.gz"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := s.Save(path); err != nil {
+			t.Fatalf("Save(%s) error = %v", name, err)
+		}
+		loaded, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load(%s) error = %v", name, err)
+		}
+		if loaded.Count() != s.Count() || loaded.GVRs["po"] != podsGVR || !loaded.CreatedAt.Equal(s.CreatedAt) {
+			t.Errorf("%s: loaded snapshot differs from the saved one", name)
+		}
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("expected Load() of a missing file to fail")
+	}
+	future := &Snapshot{Version: formatVersion + 1}
+	path := filepath.Join(t.TempDir(), "future.json")
+	if err := future.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Errorf("expected Load() of a newer format version to fail")
+	}
+}
+
+func TestProviderServesSnapshot(t *testing.T) {
+	s, err := Capture(t.Context(), newLiveProvider(), CaptureOptions{Context: "prod"})
+	if err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	p := NewProvider(s)
+
+	tests := []struct {
+		kind, fieldSelector, labelSelector, namespace string
+		want                                          string
+	}{
+		{"po", "", "", "default", "[web-1 web-2]"},
+		{"pods", "", "app=db", "", "[db-1]"},
+		{"Pod", "metadata.name!=web-1", "", "default", "[web-2]"},
+		{"Pod", "status.phase=Pending", "", "", "[]"},
+		{"ns", "", "", "default", "[data default]"},
+	}
+	for _, tt := range tests {
+		if got := names(t, p, tt.kind, tt.fieldSelector, tt.labelSelector, tt.namespace); got != tt.want {
+			t.Errorf("%s fields %q labels %q namespace %q: got %s, want %s", tt.kind, tt.fieldSelector, tt.labelSelector, tt.namespace, got, tt.want)
+		}
+	}
+
+	if _, err := p.GetK8sResources("secrets", "", "", ""); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Errorf("expected skipped kinds to report why, got %v", err)
+	}
+	if err := p.DeleteK8sResources("Pod", "web-1", "default", false); err == nil {
+		t.Errorf("expected mutations to fail")
+	}
+	if _, err := p.CreateProviderForContext("prod"); err != nil {
+		t.Errorf("expected the snapshot's own context to be served, got %v", err)
+	}
+	if _, err := p.CreateProviderForContext("staging"); err == nil {
+		t.Errorf("expected other contexts to fail")
+	}
+
+	narrow, err := Capture(t.Context(), newLiveProvider(), CaptureOptions{Kinds: []string{"pods"}})
+	if err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	if _, err := NewProvider(narrow).GetK8sResources("deployments", "", "", ""); err == nil || !strings.Contains(err.Error(), "not captured") {
+		t.Errorf("expected kinds outside the snapshot to fail, got %v", err)
+	}
+}
+
+func TestQueriesRunAgainstSnapshot(t *testing.T) {
+	s, err := Capture(t.Context(), newLiveProvider(), CaptureOptions{})
+	if err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	executor, err := core.NewQueryExecutor(NewProvider(s))
+	if err != nil {
+		t.Fatalf("NewQueryExecutor() error = %v", err)
+	}
+
+	ast, err := core.ParseQuery(`MATCH (s:Service)->(p:Pod) RETURN p.metadata.name`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	result, err := executor.Execute(ast, "default")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	pods, ok := result.Data["p"].([]interface{})
+	if !ok || len(pods) != 2 {
+		t.Fatalf("expected the service's two pods, got %#v", result.Data)
+	}
+
+	ast, err = core.ParseQuery(`MATCH (p:Pod {name: "web-1"}) DELETE p`)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	if _, err := executor.Execute(ast, "default"); err == nil {
+		t.Errorf("expected DELETE against a snapshot to fail")
+	}
+}